@@ -0,0 +1,245 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// AccountExternal is the account of counterparties outside the wallet
+	AccountExternal = "external"
+
+	// AccountFees is the account of fees paid by the wallet
+	AccountFees = "fees"
+
+	// AccountCoinbase is the account of newly mined coins received by the wallet
+	AccountCoinbase = "coinbase"
+
+	// accountWalletPrefix prefixes the accounts of labeled wallet addresses
+	accountWalletPrefix = "wallet:"
+
+	// defaultLabel is the label of wallet addresses without a label
+	defaultLabel = "default"
+)
+
+// JournalLine is a debit or a credit of an account
+type JournalLine struct {
+	Account string
+	Debit   int
+	Credit  int
+}
+
+// JournalEntry is the balanced set of journal lines of one transaction
+type JournalEntry struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+	Timestamp int64
+	Lines     []JournalLine
+}
+
+// Balanced checks the debits of the entry equal its credits
+func (e JournalEntry) Balanced() bool {
+	sum := 0
+	for _, line := range e.Lines {
+		sum += line.Debit - line.Credit
+	}
+
+	return sum == 0
+}
+
+// WalletAccount returns the account name of a wallet address label
+func WalletAccount(label string) string {
+	return accountWalletPrefix + label
+}
+
+// Ledger is a double-entry accounting view over the chain activity of a
+// set of wallet addresses
+type Ledger struct {
+	bc     *Blockchain
+	labels map[string]string
+}
+
+// NewLedger creates a Ledger for the addresses, labels maps each address to
+// the label of its account
+func NewLedger(bc *Blockchain, labels map[string]string) *Ledger {
+	byPubKeyHash := make(map[string]string, len(labels))
+	for address, label := range labels {
+		if label == "" {
+			label = defaultLabel
+		}
+
+		byPubKeyHash[hex.EncodeToString(pubKeyHashFromAddress(address))] = label
+	}
+
+	return &Ledger{bc: bc, labels: byPubKeyHash}
+}
+
+// account returns the wallet account of a public key hash
+func (l *Ledger) account(pubKeyHash []byte) (string, bool) {
+	label, ok := l.labels[hex.EncodeToString(pubKeyHash)]
+	return WalletAccount(label), ok
+}
+
+// Journal returns the journal entries of the wallet transactions in chain order
+func (l *Ledger) Journal() []JournalEntry {
+	var blocks []*Block
+	bci := l.bc.Iterator()
+
+	for {
+		block := bci.Next()
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	var entries []JournalEntry
+
+	// walletOutputs are the wallet outputs seen so far by outpoint
+	walletOutputs := make(map[string]TXOutput)
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+
+		for _, tx := range block.Transactions {
+			lines := l.journalLines(tx, walletOutputs)
+			if len(lines) == 0 {
+				continue
+			}
+
+			entries = append(entries, JournalEntry{
+				TxID:      tx.ID,
+				BlockHash: block.Hash,
+				Height:    block.Height,
+				Timestamp: block.Timestamp,
+				Lines:     lines,
+			})
+		}
+	}
+
+	return entries
+}
+
+// journalLines converts a transaction into journal lines, nil if it doesn't
+// involve the wallet
+func (l *Ledger) journalLines(tx *Transaction, walletOutputs map[string]TXOutput) []JournalLine {
+	var lines []JournalLine
+	walletIn := 0
+
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.VIn {
+			key := outpointKey(vin.TxID, vin.VOut)
+			out, ok := walletOutputs[key]
+			if !ok {
+				continue
+			}
+
+			account, _ := l.account(out.PubKeyHash())
+			lines = append(lines, JournalLine{Account: account, Credit: out.Value})
+			walletIn += out.Value
+			delete(walletOutputs, key)
+		}
+	}
+
+	walletOut, externalOut := 0, 0
+	for outIdx, out := range tx.VOut {
+		if account, ok := l.account(out.PubKeyHash()); ok {
+			lines = append(lines, JournalLine{Account: account, Debit: out.Value})
+			walletOut += out.Value
+			walletOutputs[outpointKey(tx.ID, outIdx)] = out
+		} else {
+			externalOut += out.Value
+		}
+	}
+
+	if walletIn == 0 && walletOut == 0 {
+		return nil
+	}
+
+	switch {
+	case tx.IsCoinbase():
+		lines = append(lines, JournalLine{Account: AccountCoinbase, Credit: walletOut})
+	case walletIn == 0:
+		// received from outside, the wallet doesn't pay for the external outputs
+		lines = append(lines, JournalLine{Account: AccountExternal, Credit: walletOut})
+	default:
+		// spent by the wallet, foreign inputs are only known as a negative remainder
+		if externalOut > 0 {
+			lines = append(lines, JournalLine{Account: AccountExternal, Debit: externalOut})
+		}
+
+		if fee := walletIn - walletOut - externalOut; fee > 0 {
+			lines = append(lines, JournalLine{Account: AccountFees, Debit: fee})
+		} else if fee < 0 {
+			lines = append(lines, JournalLine{Account: AccountExternal, Credit: -fee})
+		}
+	}
+
+	return lines
+}
+
+// Balances returns the balance of each account, debits are positive
+func (l *Ledger) Balances() map[string]int {
+	balances := make(map[string]int)
+
+	for _, entry := range l.Journal() {
+		for _, line := range entry.Lines {
+			balances[line.Account] += line.Debit - line.Credit
+		}
+	}
+
+	return balances
+}
+
+// Reconcile checks the balance of each wallet account equals the value of
+// the unspent outputs of its addresses in the UTXO set
+func (l *Ledger) Reconcile(utxoSet UTXOSet) error {
+	balances := l.Balances()
+	expected := make(map[string]int)
+
+	for pubKeyHashHex := range l.labels {
+		pubKeyHash, err := hex.DecodeString(pubKeyHashHex)
+		if err != nil {
+			return err
+		}
+
+		account, _ := l.account(pubKeyHash)
+		for _, out := range utxoSet.FindUTXO(pubKeyHash) {
+			expected[account] += out.Value
+		}
+	}
+
+	for account := range balances {
+		if strings.HasPrefix(account, accountWalletPrefix) {
+			if _, ok := expected[account]; !ok {
+				expected[account] = 0
+			}
+		} else {
+			delete(balances, account)
+		}
+	}
+
+	accounts := make([]string, 0, len(expected))
+	for account := range expected {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	for _, account := range accounts {
+		if balances[account] != expected[account] {
+			return fmt.Errorf("account %s has balance %d, utxo set holds %d",
+				account, balances[account], expected[account])
+		}
+	}
+
+	return nil
+}
+
+// outpointKey returns the map key of a transaction output
+func outpointKey(txID []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txID, vout)
+}
@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// addressHashLen is the length of the hash an address pays to
+const addressHashLen = 20
+
+var (
+	// ErrInvalidAddress is returned for strings which aren't base58 or bech32 addresses
+	ErrInvalidAddress = errors.New("invalid address")
+
+	// ErrAddressChecksum is returned for addresses whose checksum doesn't match, usually a typo
+	ErrAddressChecksum = errors.New("address checksum mismatch")
+
+	// ErrAddressLength is returned for addresses of a hash of the wrong length
+	ErrAddressLength = errors.New("address has a bad length")
+
+	// ErrWrongNetwork is returned for addresses of another chain
+	ErrWrongNetwork = errors.New("address is for another network")
+)
+
+// Address is a parsed pay to public key hash or script hash address
+type Address struct {
+	// Version is the base58 version byte, bech32 addresses have the version
+	// of the base58 addresses of the same output type
+	Version byte
+
+	// PubKeyHash is the public key hash or script hash paid to
+	PubKeyHash []byte
+
+	// Network is the name of the chain of the address
+	Network string
+
+	// Bech32 is whether the address was in bech32 rather than base58
+	Bech32 bool
+}
+
+// IsScriptHash returns whether the address pays to a script hash
+func (a Address) IsScriptHash() bool {
+	return a.Version == scriptHashVersion
+}
+
+// String returns the base58 form of the address
+func (a Address) String() string {
+	return string(encodeAddress(a.Version, a.PubKeyHash))
+}
+
+// ParseAddress parses a base58 or bech32 address of the main chain
+func ParseAddress(address string) (Address, error) {
+	return MainNetParams.ParseAddress(address)
+}
+
+// ParseAddress parses a base58 or bech32 address of the chain, the version
+// byte or the human-readable prefix must be those of the chain
+func (p *ChainParams) ParseAddress(address string) (Address, error) {
+	a, hrp, err := decodeAddress(address)
+	if err != nil {
+		return Address{}, err
+	}
+
+	if a.Bech32 && hrp != p.bech32HRP() {
+		return Address{}, fmt.Errorf("%w: prefix %q of %s isn't %q of %s", ErrWrongNetwork, hrp, address, p.bech32HRP(), p.Name)
+	}
+
+	if !a.Bech32 && a.Version != p.AddressVersion && a.Version != p.ScriptHashAddressVersion {
+		return Address{}, fmt.Errorf("%w: version %d of %s isn't one of %s", ErrWrongNetwork, a.Version, address, p.Name)
+	}
+
+	a.Network = p.Name
+	return a, nil
+}
+
+// decodeAddress decodes a base58 or bech32 address of any network, the
+// human-readable prefix of bech32 addresses is returned with it
+func decodeAddress(address string) (Address, string, error) {
+	if address == "" {
+		return Address{}, "", fmt.Errorf("%w: empty", ErrInvalidAddress)
+	}
+
+	if _, _, _, err := DecodeBech32(address); err == nil || !isBase58(address) {
+		return decodeBech32AddressHash(address)
+	}
+
+	payload := Base58Decode([]byte(address))
+	if len(payload) <= addressChecksumLen {
+		return Address{}, "", fmt.Errorf("%w: %d bytes in %s", ErrAddressLength, len(payload), address)
+	}
+
+	versioned := payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(payload[len(versioned):], checksum(versioned)) {
+		return Address{}, "", fmt.Errorf("%w: %s", ErrAddressChecksum, address)
+	}
+
+	if len(versioned) != 1+addressHashLen {
+		return Address{}, "", fmt.Errorf("%w: hash of %d bytes in %s", ErrAddressLength, len(versioned)-1, address)
+	}
+
+	return Address{Version: versioned[0], PubKeyHash: versioned[1:]}, "", nil
+}
+
+// decodeBech32AddressHash decodes a bech32 pay to public key hash or script hash address
+func decodeBech32AddressHash(address string) (Address, string, error) {
+	if !strings.ContainsRune(address, '1') {
+		return Address{}, "", fmt.Errorf("%w: %s is neither base58 nor bech32", ErrInvalidAddress, address)
+	}
+
+	hrp, ver, program, err := DecodeBech32Address(address)
+	if errors.Is(err, errBech32Checksum) {
+		return Address{}, "", fmt.Errorf("%w: %s", ErrAddressChecksum, address)
+	}
+	if err != nil {
+		return Address{}, "", fmt.Errorf("%w: %s", ErrInvalidAddress, err)
+	}
+
+	if len(program) != addressHashLen {
+		return Address{}, "", fmt.Errorf("%w: hash of %d bytes in %s", ErrAddressLength, len(program), address)
+	}
+
+	switch ver {
+	case Bech32PubKeyHashVersion:
+		return Address{Version: version, PubKeyHash: program, Bech32: true}, hrp, nil
+	case Bech32ScriptHashVersion:
+		return Address{Version: scriptHashVersion, PubKeyHash: program, Bech32: true}, hrp, nil
+	}
+
+	return Address{}, "", fmt.Errorf("%w: unknown bech32 version %d in %s", ErrInvalidAddress, ver, address)
+}
+
+// isBase58 returns whether a string only has base58 characters
+func isBase58(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if bytes.IndexByte(b58Alphabet, s[i]) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,131 @@
+package blockchain
+
+// AddressEntry is the label and category of an address in the address book
+// of the wallets, e.g. a contact payments are sent to or an own address
+type AddressEntry struct {
+	// Address is the base58 form of the address
+	Address  string
+	Label    string
+	Category string
+}
+
+// bookEntry returns the index of the entry of an address in the address
+// book, -1 when it has none, ws.mu must be held
+func (ws *Wallets) bookEntry(address string) int {
+	for i, entry := range ws.book {
+		if entry.Address == address {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// SetAddressLabel sets the label and category of an address in the address
+// book, bech32 and base58 forms of an address share their entry
+func (ws *Wallets) SetAddressLabel(address, label, category string) error {
+	address, err := Base58Address(address)
+	if err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	entry := AddressEntry{Address: address, Label: label, Category: category}
+	book := append([]AddressEntry{}, ws.book...)
+	if i := ws.bookEntry(address); i >= 0 {
+		ws.book[i] = entry
+	} else {
+		ws.book = append(ws.book, entry)
+	}
+
+	if err = ws.save(); err != nil {
+		ws.book = book
+		return err
+	}
+
+	return nil
+}
+
+// RemoveAddressLabel removes an address from the address book
+func (ws *Wallets) RemoveAddressLabel(address string) error {
+	address, err := Base58Address(address)
+	if err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	i := ws.bookEntry(address)
+	if i < 0 {
+		return nil
+	}
+
+	book := ws.book
+	ws.book = append(append([]AddressEntry{}, book[:i]...), book[i+1:]...)
+	if err = ws.save(); err != nil {
+		ws.book = book
+		return err
+	}
+
+	return nil
+}
+
+// AddressLabel returns the address book entry of an address
+func (ws *Wallets) AddressLabel(address string) (AddressEntry, bool) {
+	address, err := Base58Address(address)
+	if err != nil {
+		return AddressEntry{}, false
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if i := ws.bookEntry(address); i >= 0 {
+		return ws.book[i], true
+	}
+
+	return AddressEntry{}, false
+}
+
+// AddressBook returns the address book entries with a label and in a
+// category, in the order they were added. An empty label or category
+// matches every entry.
+func (ws *Wallets) AddressBook(label, category string) []AddressEntry {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	entries := []AddressEntry{}
+	for _, entry := range ws.book {
+		if (label == "" || entry.Label == label) && (category == "" || entry.Category == category) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// txLabels returns the address book entries of an address and of the
+// addresses a transaction pays to
+func (ws *Wallets) txLabels(tx *Transaction, address string) []AddressEntry {
+	addresses := []string{address}
+	for _, out := range tx.VOut {
+		if outAddress := OutputAddress(out); outAddress != "" {
+			addresses = append(addresses, outAddress)
+		}
+	}
+
+	var labels []AddressEntry
+	seen := make(map[string]bool)
+	for _, address := range addresses {
+		entry, ok := ws.AddressLabel(address)
+		if ok && !seen[entry.Address] {
+			seen[entry.Address] = true
+			labels = append(labels, entry)
+		}
+	}
+
+	return labels
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	// maxAddrPerMessage is the largest number of records of an addr message
+	maxAddrPerMessage = 1000
+
+	// oversizedAddrScore is the misbehaviour score of an addr message with
+	// more than maxAddrPerMessage records
+	oversizedAddrScore = 20
+
+	// maxKnownNodes is the largest number of known nodes
+	maxKnownNodes = 2000
+
+	// addrMaxAge is how long after a node was last seen its address is relayed
+	addrMaxAge = 7 * 24 * time.Hour
+
+	// addrGossipInterval is how often random address records are sent to the peers
+	addrGossipInterval = 10 * time.Minute
+
+	// addrGossipSize is the number of records sent to each peer per gossip
+	addrGossipSize = 10
+)
+
+// NetAddress is the address record of a node, relayed in addr messages
+type NetAddress struct {
+	Addr     string
+	Services ServiceFlag
+
+	// Timestamp is when the node was last seen, zero for seeds which were
+	// never connected to
+	Timestamp time.Time
+}
+
+// selfAddress returns the address record of the node
+func (s *Server) selfAddress() NetAddress {
+	return NetAddress{Addr: s.address, Services: defaultServices, Timestamp: time.Now()}
+}
+
+// randomAddresses returns at most n random records of the known nodes seen
+// within addrMaxAge
+func (s *Server) randomAddresses(n int) []NetAddress {
+	s.mu.Lock()
+	var fresh []NetAddress
+	for _, node := range s.knownNodes {
+		if time.Since(node.Timestamp) <= addrMaxAge {
+			fresh = append(fresh, node.NetAddress)
+		}
+	}
+	s.mu.Unlock()
+
+	rand.Shuffle(len(fresh), func(i, j int) {
+		fresh[i], fresh[j] = fresh[j], fresh[i]
+	})
+
+	if len(fresh) > n {
+		fresh = fresh[:n]
+	}
+
+	return fresh
+}
+
+// handleAddr handles CommandAddr request by adding the records to the
+// known nodes, records from the future are taken as seen now
+func (s *Server) handleAddr(p *peerConn, request []byte) {
+	var payload addrData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	if len(payload.AddrList) > maxAddrPerMessage {
+		s.misbehaving(p, oversizedAddrScore, "oversized addr")
+		return
+	}
+
+	now := time.Now()
+	for _, record := range payload.AddrList {
+		if record.Timestamp.After(now) {
+			record.Timestamp = now
+		}
+
+		s.addToKnownNodes(record, "addr")
+	}
+}
+
+// handleGetAddr handles CommandGetAddr request by sending random records of
+// the known nodes
+func (s *Server) handleGetAddr(p *peerConn, request []byte) {
+	var payload getAddrData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	p.send(CommandAddr, addrData{AddrFrom: s.address, AddrList: s.randomAddresses(maxAddrPerMessage)})
+}
+
+// gossipAddresses sends random records of the known nodes and the record of
+// the node to every peer each addrGossipInterval, until done is closed
+func (s *Server) gossipAddresses(done <-chan struct{}) {
+	ticker := time.NewTicker(addrGossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.gossip()
+		}
+	}
+}
+
+// gossip sends random records of the known nodes and the record of the node
+// to every peer
+func (s *Server) gossip() {
+	for _, p := range s.manager.ready() {
+		records := s.randomAddresses(addrGossipSize)
+		if s.address != "" {
+			records = append(records, s.selfAddress())
+		}
+
+		if len(records) > 0 {
+			p.send(CommandAddr, addrData{AddrFrom: s.address, AddrList: records})
+		}
+	}
+}
+
+// resolveDNSSeeds adds the addresses of the DNS seeds to the known nodes
+func (s *Server) resolveDNSSeeds() {
+	for _, seed := range s.cfg.DNSSeeds {
+		host, port, err := net.SplitHostPort(seed)
+		if err != nil {
+			log.Printf("DNS seed %s is invalid: %s\n", seed, err)
+			continue
+		}
+
+		ips, err := net.LookupHost(host)
+		if err != nil {
+			log.Printf("resolving DNS seed %s failed: %s\n", seed, err)
+			continue
+		}
+
+		for _, ip := range ips {
+			s.addToKnownNodes(NetAddress{Addr: net.JoinHostPort(ip, port)}, "dns seed")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrInvalidAllowlist is returned when an entry of the inbound allowlist
+// is neither an IP address nor a CIDR range
+var ErrInvalidAllowlist = errors.New("invalid inbound allowlist entry")
+
+// parseAllowlist parses IP addresses, with or without a port, and CIDR
+// ranges as networks
+func parseAllowlist(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidAllowlist, err)
+			}
+
+			networks = append(networks, network)
+			continue
+		}
+
+		host := entry
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			host = h
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAllowlist, entry)
+		}
+
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return networks, nil
+}
+
+// allowsInbound returns whether the inbound allowlist accepts a connection,
+// every connection is accepted without allowlist and local Unix socket
+// connections always are
+func (s *Server) allowsInbound(conn net.Conn) bool {
+	if len(s.allowlist) == 0 {
+		return true
+	}
+
+	var ip net.IP
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UnixAddr:
+		return true
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+
+	for _, network := range s.allowlist {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isConfiguredPeer returns whether an address is one of the seeds, the
+// only nodes a ConnectOnly node dials
+func (s *Server) isConfiguredPeer(addr string) bool {
+	for _, seed := range s.cfg.Seeds {
+		if seed == addr {
+			return true
+		}
+	}
+
+	return false
+}
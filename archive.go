@@ -0,0 +1,253 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/boltdb/bolt"
+	"log"
+)
+
+const (
+	// balanceIndexBucket is the bucket of the balance of each public key
+	// hash after each main chain block paying to or spending from it
+	balanceIndexBucket = "balances"
+
+	// balanceTipDbKey is the key in the blocks bucket of the block hash the balance index is built on
+	balanceTipDbKey = "a"
+)
+
+// ErrNotArchival is returned for historical queries of a node not in archival mode
+var ErrNotArchival = errors.New("node is not archival, enable it with WithArchival")
+
+// balanceKey returns the balance index key of a public key hash at a height,
+// the entries of a public key hash sort by height
+func balanceKey(pubKeyHash []byte, height int) []byte {
+	return append(append([]byte{}, pubKeyHash...), heightKey(height)...)
+}
+
+// balanceBefore returns the indexed balance of a public key hash after the
+// last block below height changing it
+func balanceBefore(index *bolt.Bucket, pubKeyHash []byte, height int) int {
+	c := index.Cursor()
+
+	k, v := c.Seek(balanceKey(pubKeyHash, height))
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+
+	if k == nil || len(k) != len(pubKeyHash)+8 || !bytes.HasPrefix(k, pubKeyHash) {
+		return 0
+	}
+
+	return int(int64(binary.BigEndian.Uint64(v)))
+}
+
+// updateBalanceIndex brings the balance index to the main chain tip, the
+// entries of blocks removed by a reorg are dropped first. Blocks without
+// undo data, e.g. after a UTXO set reindex, rebuild the index from genesis.
+func updateBalanceIndex(tx *bolt.Tx) error {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	tip := DeserializeBlock(blocks.Get(blocks.Get([]byte(tipDbKey))))
+
+	from, indexedHeight := 0, -1
+	if indexed := blocks.Get([]byte(balanceTipDbKey)); indexed != nil {
+		from = balanceIndexForkHeight(tx, indexed)
+		if data := blocks.Get(indexed); data != nil {
+			indexedHeight = DeserializeBlock(data).Height
+		}
+	}
+
+	if from > tip.Height {
+		return nil
+	}
+
+	err := indexBalancesFrom(tx, from, from <= indexedHeight)
+	if errors.Is(err, ErrNoUndoData) && from != 0 {
+		log.Printf("rebuilding the balance index: %s\n", err)
+		err = indexBalancesFrom(tx, 0, true)
+	}
+
+	return err
+}
+
+// balanceIndexForkHeight returns the height above the last main chain block
+// the balance index was built on
+func balanceIndexForkHeight(tx *bolt.Tx, indexed []byte) int {
+	blocks := tx.Bucket([]byte(blocksBucket))
+
+	hash := indexed
+	for len(hash) != 0 {
+		data := blocks.Get(hash)
+		if data == nil {
+			return 0
+		}
+
+		block := DeserializeBlock(data)
+		if main, err := blockAtHeight(tx, block.Height); err == nil && bytes.Equal(main.Hash, block.Hash) {
+			return block.Height + 1
+		}
+
+		hash = block.PrevBlockHash
+	}
+
+	return 0
+}
+
+// indexBalancesFrom indexes the balances of the main chain blocks from a
+// height, dropping the entries from it first when stale. The values of the
+// outputs spent are read from the undo data, or followed from genesis when
+// indexing from it.
+func indexBalancesFrom(tx *bolt.Tx, from int, stale bool) error {
+	if from == 0 && tx.Bucket([]byte(balanceIndexBucket)) != nil {
+		if err := tx.DeleteBucket([]byte(balanceIndexBucket)); err != nil {
+			return err
+		}
+	}
+
+	index, err := tx.CreateBucketIfNotExists([]byte(balanceIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	if stale && from != 0 {
+		var keys [][]byte
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if int(binary.BigEndian.Uint64(k[len(k)-8:])) >= from {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+
+		for _, k := range keys {
+			if err = index.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	// outputs are the unspent outputs by outpoint when indexing from genesis
+	var outputs map[string]TXOutput
+	if from == 0 {
+		outputs = make(map[string]TXOutput)
+	}
+
+	blocks := tx.Bucket([]byte(blocksBucket))
+	tip := DeserializeBlock(blocks.Get(blocks.Get([]byte(tipDbKey))))
+
+	for height := from; height <= tip.Height; height++ {
+		block, err := blockAtHeight(tx, height)
+		if err != nil {
+			return err
+		}
+
+		deltas := make(map[string]int)
+		if outputs != nil {
+			for _, t := range block.Transactions {
+				if !t.IsCoinbase() {
+					for _, vin := range t.VIn {
+						key := outpointKey(vin.TxID, vin.VOut)
+						if out, ok := outputs[key]; ok {
+							deltas[hex.EncodeToString(out.PubKeyHash())] -= out.Value
+							delete(outputs, key)
+						}
+					}
+				}
+
+				for i, out := range t.VOut {
+					if out.PubKeyHash() != nil {
+						outputs[outpointKey(t.ID, i)] = out
+					}
+				}
+			}
+		} else {
+			undo, err := getBlockUndo(tx, block.Hash)
+			if err != nil {
+				return err
+			}
+
+			for _, spent := range undo.Spent {
+				if pubKeyHash := spent.Output.PubKeyHash(); pubKeyHash != nil {
+					deltas[hex.EncodeToString(pubKeyHash)] -= spent.Output.Value
+				}
+			}
+		}
+
+		for _, t := range block.Transactions {
+			for _, out := range t.VOut {
+				if pubKeyHash := out.PubKeyHash(); pubKeyHash != nil {
+					deltas[hex.EncodeToString(pubKeyHash)] += out.Value
+				}
+			}
+		}
+
+		for id, delta := range deltas {
+			pubKeyHash, err := hex.DecodeString(id)
+			if err != nil {
+				return err
+			}
+
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(int64(balanceBefore(index, pubKeyHash, height)+delta)))
+			if err = index.Put(balanceKey(pubKeyHash, height), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return blocks.Put([]byte(balanceTipDbKey), tip.Hash)
+}
+
+// indexBalances brings the balance index to the tip
+func (bc *Blockchain) indexBalances() error {
+	return bc.db.Update(updateBalanceIndex)
+}
+
+// startArchival indexes the balances in archival mode and keeps the index
+// on the tip as blocks are connected
+func (bc *Blockchain) startArchival() {
+	if !bc.opts.archival {
+		return
+	}
+
+	if err := bc.indexBalances(); err != nil {
+		log.Panic(err)
+	}
+
+	bc.Subscribe(func(ChainEvent) {
+		if err := bc.indexBalances(); err != nil {
+			log.Printf("balance index is behind: %s\n", err)
+		}
+	})
+}
+
+// GetBalanceAt returns the balance of an address after the main chain block
+// at a height, the node must be in archival mode
+func (bc *Blockchain) GetBalanceAt(address string, height int) (int, error) {
+	if !bc.opts.archival {
+		return 0, ErrNotArchival
+	}
+
+	if _, _, err := decodeAddress(address); err != nil {
+		return 0, err
+	}
+
+	if err := bc.indexBalances(); err != nil {
+		return 0, err
+	}
+
+	var balance int
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		if _, err := blockAtHeight(tx, height); err != nil {
+			return err
+		}
+
+		balance = balanceBefore(tx.Bucket([]byte(balanceIndexBucket)), pubKeyHashFromAddress(address), height+1)
+		return nil
+	})
+
+	return balance, err
+}
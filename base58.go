@@ -22,8 +22,9 @@ func Base58Encode(input []byte) []byte {
 		result = append(result, b58Alphabet[mod.Int64()])
 	}
 
-	// https://en.bitcoin.it/wiki/Base58Check_encoding#Version_bytes
-	if input[0] == 0x00 {
+	// https://en.bitcoin.it/wiki/Base58Check_encoding#Version_bytes, every
+	// leading zero byte is a leading 1
+	for i := 0; i < len(input) && input[i] == 0x00; i++ {
 		result = append(result, b58Alphabet[0])
 	}
 
@@ -44,9 +45,12 @@ func Base58Decode(input []byte) []byte {
 
 	decoded := result.Bytes()
 
-	if input[0] == b58Alphabet[0] {
-		decoded = append([]byte{0x00}, decoded...)
+	zeros := 0
+	for zeros < len(input) && input[zeros] == b58Alphabet[0] {
+		zeros++
 	}
 
+	decoded = append(make([]byte, zeros), decoded...)
+
 	return decoded
 }
@@ -0,0 +1,332 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32Variant is the checksum variant of a bech32 string
+type Bech32Variant int
+
+const (
+	// Bech32 is the BIP173 checksum, used by version 0 addresses
+	Bech32 Bech32Variant = iota + 1
+
+	// Bech32m is the BIP350 checksum, used by addresses of later versions
+	Bech32m
+)
+
+const (
+	// Bech32PubKeyHashVersion is the version of bech32 pay to public key hash addresses
+	Bech32PubKeyHashVersion = byte(0)
+
+	// Bech32ScriptHashVersion is the version of bech32 pay to script hash addresses
+	Bech32ScriptHashVersion = byte(1)
+
+	// DefaultBech32HRP is the human-readable prefix of the bech32 addresses of the main chain
+	DefaultBech32HRP = "blk"
+)
+
+const (
+	// bech32Charset are the characters of the 5 bit values of the data part
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	// bech32MaxLen is the length of the longest bech32 string
+	bech32MaxLen = 90
+
+	// bech32ChecksumLen is the number of characters of the checksum
+	bech32ChecksumLen = 6
+
+	// bech32Const and bech32mConst are the constants the checksums of the variants end with
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+
+	// bech32MaxVersion is the highest address version
+	bech32MaxVersion = 16
+)
+
+var (
+	// ErrInvalidBech32 is returned for malformed bech32 strings and addresses
+	ErrInvalidBech32 = errors.New("invalid bech32")
+
+	// errBech32Checksum is returned for bech32 strings whose checksum is neither variant
+	errBech32Checksum = fmt.Errorf("%w: bad checksum", ErrInvalidBech32)
+)
+
+// String returns the name of the variant
+func (v Bech32Variant) String() string {
+	switch v {
+	case Bech32:
+		return "bech32"
+	case Bech32m:
+		return "bech32m"
+	}
+
+	return fmt.Sprintf("Bech32Variant(%d)", int(v))
+}
+
+// checksumConst returns the constant the checksums of the variant end with
+func (v Bech32Variant) checksumConst() uint32 {
+	if v == Bech32m {
+		return bech32mConst
+	}
+
+	return bech32Const
+}
+
+// bech32Polymod returns the checksum polynomial of 5 bit values
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+// bech32HRPExpand returns the values of the prefix in the checksum
+func bech32HRPExpand(hrp string) []byte {
+	values := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+
+	return values
+}
+
+// EncodeBech32 encodes a human-readable prefix and 5 bit values with the
+// checksum of the variant
+func EncodeBech32(hrp string, data []byte, variant Bech32Variant) (string, error) {
+	if variant != Bech32 && variant != Bech32m {
+		return "", fmt.Errorf("%w: unknown variant %d", ErrInvalidBech32, int(variant))
+	}
+
+	if len(hrp) == 0 || len(hrp)+1+len(data)+bech32ChecksumLen > bech32MaxLen {
+		return "", fmt.Errorf("%w: %d characters of prefix and %d of data", ErrInvalidBech32, len(hrp), len(data))
+	}
+
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 || (hrp[i] >= 'A' && hrp[i] <= 'Z') {
+			return "", fmt.Errorf("%w: prefix %q", ErrInvalidBech32, hrp)
+		}
+	}
+
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ variant.checksumConst()
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range data {
+		if v >= 32 {
+			return "", fmt.Errorf("%w: value %d doesn't fit 5 bits", ErrInvalidBech32, v)
+		}
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < bech32ChecksumLen; i++ {
+		b.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+
+	return b.String(), nil
+}
+
+// DecodeBech32 decodes a bech32 or bech32m string into its lower case
+// human-readable prefix and its 5 bit values, it returns the variant of
+// its checksum
+func DecodeBech32(s string) (string, []byte, Bech32Variant, error) {
+	if len(s) > bech32MaxLen {
+		return "", nil, 0, fmt.Errorf("%w: %d characters", ErrInvalidBech32, len(s))
+	}
+
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, fmt.Errorf("%w: mixed case", ErrInvalidBech32)
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+1+bech32ChecksumLen > len(s) {
+		return "", nil, 0, fmt.Errorf("%w: no separator", ErrInvalidBech32)
+	}
+
+	hrp := s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, fmt.Errorf("%w: prefix character %d", ErrInvalidBech32, hrp[i])
+		}
+	}
+
+	values := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, 0, fmt.Errorf("%w: character %q", ErrInvalidBech32, s[i])
+		}
+		values = append(values, byte(v))
+	}
+
+	var variant Bech32Variant
+	switch bech32Polymod(append(bech32HRPExpand(hrp), values...)) {
+	case bech32Const:
+		variant = Bech32
+	case bech32mConst:
+		variant = Bech32m
+	default:
+		return "", nil, 0, errBech32Checksum
+	}
+
+	return hrp, values[:len(values)-bech32ChecksumLen], variant, nil
+}
+
+// ConvertBits regroups values of fromBits bits into values of toBits bits.
+// With pad the last value is padded with zeros, otherwise the values must
+// fill the last one exactly up to less than fromBits zero bits.
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	if fromBits < 1 || fromBits > 8 || toBits < 1 || toBits > 8 {
+		return nil, fmt.Errorf("%w: can't convert %d to %d bits", ErrInvalidBech32, fromBits, toBits)
+	}
+
+	var converted []byte
+	acc, bits := uint32(0), uint(0)
+	maxValue := uint32(1)<<toBits - 1
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, fmt.Errorf("%w: value %d doesn't fit %d bits", ErrInvalidBech32, v, fromBits)
+		}
+
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(acc>>bits&maxValue))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, fmt.Errorf("%w: bad padding", ErrInvalidBech32)
+	}
+
+	return converted, nil
+}
+
+// EncodeBech32Address encodes an address of a version and program, the
+// hash it pays to. Version 0 addresses use the bech32 checksum and later
+// versions bech32m.
+func EncodeBech32Address(hrp string, ver byte, program []byte) (string, error) {
+	if err := checkBech32Program(ver, program); err != nil {
+		return "", err
+	}
+
+	data, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	variant := Bech32m
+	if ver == 0 {
+		variant = Bech32
+	}
+
+	return EncodeBech32(hrp, append([]byte{ver}, data...), variant)
+}
+
+// DecodeBech32Address decodes the prefix, version and program of a bech32
+// address and checks its checksum is the variant of its version
+func DecodeBech32Address(address string) (string, byte, []byte, error) {
+	hrp, data, variant, err := DecodeBech32(address)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	if len(data) == 0 {
+		return "", 0, nil, fmt.Errorf("%w: no version", ErrInvalidBech32)
+	}
+
+	ver := data[0]
+	if (ver == 0) != (variant == Bech32) {
+		return "", 0, nil, fmt.Errorf("%w: version %d address with a %s checksum", ErrInvalidBech32, ver, variant)
+	}
+
+	program, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	if err = checkBech32Program(ver, program); err != nil {
+		return "", 0, nil, err
+	}
+
+	return hrp, ver, program, nil
+}
+
+// checkBech32Program checks the version and program length of an address
+func checkBech32Program(ver byte, program []byte) error {
+	if ver > bech32MaxVersion {
+		return fmt.Errorf("%w: version %d", ErrInvalidBech32, ver)
+	}
+
+	if len(program) < 2 || len(program) > 40 {
+		return fmt.Errorf("%w: program of %d bytes", ErrInvalidBech32, len(program))
+	}
+
+	return nil
+}
+
+// Bech32Address returns the bech32 form of a pay to public key hash or
+// script hash address with the human-readable prefix of the chain
+func (p *ChainParams) Bech32Address(address string) (string, error) {
+	a, _, err := decodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	ver := Bech32PubKeyHashVersion
+	if a.IsScriptHash() {
+		ver = Bech32ScriptHashVersion
+	}
+
+	return EncodeBech32Address(p.bech32HRP(), ver, a.PubKeyHash)
+}
+
+// Base58Address returns the base58 form of an address
+func Base58Address(address string) (string, error) {
+	a, _, err := decodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	return a.String(), nil
+}
+
+// ValidateAddress checks an address is valid on the chain, bech32
+// addresses must have its human-readable prefix
+func (p *ChainParams) ValidateAddress(address string) bool {
+	_, err := p.ParseAddress(address)
+	return err == nil
+}
+
+// bech32HRP returns the human-readable prefix of the bech32 addresses of the chain
+func (p *ChainParams) bech32HRP() string {
+	if p.Bech32HRP == "" {
+		return DefaultBech32HRP
+	}
+
+	return p.Bech32HRP
+}
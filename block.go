@@ -15,6 +15,10 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+
+	// UTXOCommitment is an optional commitment to the UTXO set the block
+	// is built on, see UTXOSet.Commitment
+	UTXOCommitment []byte
 }
 
 // NewBlock creates and returns Block
@@ -25,14 +29,18 @@ func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Bl
 		PrevBlockHash: prevBlockHash,
 		Height:        height,
 	}
+	blk.mine(targetBits)
 
-	pow := NewProofOfWork(blk)
-	nonce, hash := pow.Run()
+	return blk
+}
 
-	blk.Hash = hash[:]
-	blk.Nonce = nonce
+// mine runs the proof of work at a difficulty and sets the block's nonce and hash
+func (b *Block) mine(bits int) {
+	pow := NewProofOfWorkWithBits(b, bits)
+	nonce, hash := pow.Run()
 
-	return blk
+	b.Hash = hash[:]
+	b.Nonce = nonce
 }
 
 // NewGenesisBlock creates and returns genesis Block
@@ -40,12 +48,25 @@ func NewGenesisBlock(coinbase *Transaction) *Block {
 	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
 }
 
+// newGenesisBlock creates and returns genesis Block with the options' timestamp
+func newGenesisBlock(coinbase *Transaction, o *options) *Block {
+	blk := &Block{
+		Timestamp:     blockTimestamp(o, 0),
+		Transactions:  []*Transaction{coinbase},
+		PrevBlockHash: []byte{},
+		Height:        0,
+	}
+	blk.mine(o.params.TargetBits)
+
+	return blk
+}
+
 // HashTransactions returns a hash of the transactions in the block
 func (b *Block) HashTransactions() []byte {
 	var transactions [][]byte
 
 	for _, transaction := range b.Transactions {
-		transactions = append(transactions, transaction.Serialize())
+		transactions = append(transactions, transaction.merkleLeaf())
 	}
 
 	mTree := NewMerkleTree(transactions)
@@ -68,13 +89,22 @@ func (b *Block) Serialize() []byte {
 
 // DeserializeBlock deserializes a block
 func DeserializeBlock(d []byte) *Block {
+	blk, err := TryDeserializeBlock(d)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return blk
+}
+
+// TryDeserializeBlock deserializes a block and returns an error for malformed data
+func TryDeserializeBlock(d []byte) (*Block, error) {
 	var blk Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&blk)
-	if err != nil {
-		log.Panic(err)
+	if err := decoder.Decode(&blk); err != nil {
+		return nil, err
 	}
 
-	return &blk
+	return &blk, nil
 }
@@ -2,13 +2,13 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"log"
 	"os"
+	"sync"
 )
 
 const (
@@ -28,10 +28,41 @@ const (
 	tipDbKey = "l"
 )
 
+var (
+	// ErrBlockNotFound is returned when a block is not in the database
+	ErrBlockNotFound = errors.New("block is not found")
+
+	// ErrTransactionNotFound is returned when a transaction is not in the chain
+	ErrTransactionNotFound = errors.New("transaction is not found")
+)
+
 // Blockchain implements interactions with a DB
 type Blockchain struct {
-	tip []byte
-	db  *bolt.DB
+	// tipMu guards tip, the tip of the database read back after commits
+	tipMu sync.RWMutex
+	tip   []byte
+
+	db   *bolt.DB
+	opts *options
+	meta ChainMetadata
+
+	metrics *ValidationMetrics
+
+	utxoFilter utxoFilter
+
+	subscribersMu sync.Mutex
+	subscribers   []func(ChainEvent)
+
+	// syncMu guards unsynced and bulkImport, set between BeginBulkImport
+	// and EndBulkImport
+	syncMu     sync.Mutex
+	unsynced   int
+	bulkImport bool
+
+	// proofTree is the Merkle tree of the UTXO set serving UTXO proofs,
+	// rebuilt when the UTXO set moves to another tip
+	proofTreeMu sync.Mutex
+	proofTree   *utxoProofTree
 }
 
 // getDBFile returns a bolt database file name
@@ -40,32 +71,38 @@ func getDBFile(nodeID string) string {
 }
 
 // CreateBlockchain creates a new blockchain db
-func CreateBlockchain(address, nodeID string) *Blockchain {
+func CreateBlockchain(address, nodeID string, opts ...Option) *Blockchain {
+	o := newOptions(opts)
 	dbFileName := getDBFile(nodeID)
 	if dbExists(dbFileName) {
 		log.Println("blockchain already exists.")
 		os.Exit(1)
 	}
 
-	cbTx := NewCoinbaseTX(address, genesisCoinbaseData)
-	genesisBlock := NewGenesisBlock(cbTx)
+	cbTx := newCoinbaseTX(address, o.params.GenesisCoinbaseData, o.params.BlockSubsidy(0))
+	genesisBlock := newGenesisBlock(cbTx, o)
 
-	db, err := bolt.Open(dbFileName, dbFileMode, nil)
-	if err != nil {
-		log.Panic(err)
-	}
+	db := openDB(dbFileName, o.storage)
 
-	err = db.Update(createDatabaseFunc(genesisBlock))
+	meta := newChainMetadata(o.params)
+	err := db.Update(createDatabaseFunc(genesisBlock, meta))
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return &Blockchain{tip: genesisBlock.Hash, db: db}
+	bc := &Blockchain{tip: genesisBlock.Hash, db: db, opts: o, meta: meta, metrics: newValidationMetrics()}
+	bc.startArchival()
+
+	return bc
 }
 
 // createDatabaseFunc is a function to create a new bolt database
-func createDatabaseFunc(genesis *Block) func(tx *bolt.Tx) error {
+func createDatabaseFunc(genesis *Block, meta ChainMetadata) func(tx *bolt.Tx) error {
 	return func(tx *bolt.Tx) error {
+		if err := putChainMetadata(tx, meta); err != nil {
+			log.Panic(err)
+		}
+
 		b, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
 			log.Panic(err)
@@ -81,77 +118,209 @@ func createDatabaseFunc(genesis *Block) func(tx *bolt.Tx) error {
 			log.Panic(err)
 		}
 
+		if err = updateUTXOSet(tx, genesis); err != nil {
+			log.Panic(err)
+		}
+
+		if _, err = tx.CreateBucket([]byte(txIndexBucket)); err != nil {
+			log.Panic(err)
+		}
+
+		if err = indexMainChain(tx, genesis); err != nil {
+			log.Panic(err)
+		}
+
 		return nil
 	}
 }
 
 // NewBlockchain creates a new Blockchain with genesis Block
-func NewBlockchain(nodeID string) *Blockchain {
+func NewBlockchain(nodeID string, opts ...Option) *Blockchain {
+	o := newOptions(opts)
 	dbFileName := getDBFile(nodeID)
 	if !dbExists(dbFileName) {
 		log.Println("no existing blockchain found, create it first.")
 	}
 
 	var tip []byte
-	db, err := bolt.Open(dbFileName, dbFileMode, nil)
-	if err != nil {
-		log.Panic(err)
-	}
+	var meta ChainMetadata
+	db := openDB(dbFileName, o.storage)
+
+	err := migrateParamsHash(db, o.params)
+	if err == nil {
+		err = db.View(func(tx *bolt.Tx) error {
+			var metaErr error
+			if meta, metaErr = loadChainMetadata(tx, o.params); metaErr != nil {
+				return metaErr
+			}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte(tipDbKey))
+			b := tx.Bucket([]byte(blocksBucket))
+			tip = append([]byte{}, b.Get([]byte(tipDbKey))...)
 
-		return nil
-	})
+			return nil
+		})
+	}
 
 	if err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
 		log.Panic(err)
 	}
 
-	return &Blockchain{tip: tip, db: db}
+	bc := &Blockchain{tip: tip, db: db, opts: o, meta: meta, metrics: newValidationMetrics()}
+	UTXOSet{bc}.RepairUTXOSet()
+	bc.RepairHeightIndex()
+	bc.RepairTxIndex()
+	bc.RepairFilterIndex()
+	bc.startArchival()
+
+	return bc
+}
+
+// Params returns the chain parameters
+func (bc *Blockchain) Params() *ChainParams {
+	return bc.opts.params
+}
+
+// Metadata returns the metadata the chain database was created with
+func (bc *Blockchain) Metadata() ChainMetadata {
+	return bc.meta
+}
+
+// AddBlock saves the block into the blockchain database. A block extending
+// the tip the UTXO set is built on has its UTXO commitment validated and is
+// applied to the UTXO set, a block of a longer branch reorganizes the UTXO
+// set onto its branch. A longer branch can't be applied to a UTXO set which
+// isn't at the tip and is rejected with ErrUTXOSetStale. Subscribers are
+// notified of the blocks connected and disconnected.
+func (bc *Blockchain) AddBlock(block *Block) error {
+	return bc.addBlock(block, newBlockTimer())
 }
 
-// AddBlock saves the block into the blockchain database
-func (bc *Blockchain) AddBlock(block *Block) {
-	if err := bc.db.Update(func(tx *bolt.Tx) error {
+// addBlock adds a block on a known previous block, at the height after it.
+// The scripts of the blocks it connects are verified in the same
+// transaction and timed by the timer.
+func (bc *Blockchain) addBlock(block *Block, timer *blockTimer) error {
+	var events []ChainEvent
+	var connected, reorganized bool
+
+	// the function may run more than once in a batch, its effects outside
+	// the transaction are applied after the commit
+	err := bc.update(func(tx *bolt.Tx) error {
+		events, connected, reorganized = nil, false, false
 		b := tx.Bucket([]byte(blocksBucket))
 		blockInDB := b.Get(block.Hash)
 
-		if blockInDB == nil {
+		if blockInDB != nil {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		if err := b.Put(block.Hash, blockData); err != nil {
-			log.Panic(err)
+		parentData := b.Get(block.PrevBlockHash)
+		if parentData == nil {
+			return fmt.Errorf("%w: %x of block %x", ErrOrphanBlock, block.PrevBlockHash, block.Hash)
+		}
+
+		if parent := DeserializeBlock(parentData); block.Height != parent.Height+1 {
+			return fmt.Errorf("%w: block %x has height %d on a block of height %d", ErrInvalidBlock, block.Hash, block.Height, parent.Height)
+		}
+
+		extendsUTXOSet := bytes.Equal(utxoTip(tx), block.PrevBlockHash)
+		if extendsUTXOSet && len(block.UTXOCommitment) != 0 {
+			if commitment := utxoCommitment(tx); !bytes.Equal(commitment, block.UTXOCommitment) {
+				return fmt.Errorf("%w: block %x", ErrUTXOCommitmentMismatch, block.Hash)
+			}
 		}
 
 		lastHash := b.Get([]byte(tipDbKey))
 		lastBlockData := b.Get(lastHash)
 		lastBlock := DeserializeBlock(lastBlockData)
 
+		if extendsUTXOSet && block.Height > lastBlock.Height {
+			if err := timer.time(StageScripts, func() error { return bc.checkConnectBlock(tx, block) }); err != nil {
+				return err
+			}
+		}
+
+		blockData := block.Serialize()
+		if err := b.Put(block.Hash, blockData); err != nil {
+			log.Panic(err)
+		}
+
 		if block.Height > lastBlock.Height {
 			if err := b.Put([]byte(tipDbKey), block.Hash); err != nil {
 				log.Panic(err)
 			}
 
-			bc.tip = block.Hash
+			if err := indexMainChain(tx, block); err != nil {
+				return err
+			}
+
+			if extendsUTXOSet {
+				if err := updateUTXOSet(tx, block); err != nil {
+					return err
+				}
+				connected = true
+				events = []ChainEvent{{Type: BlockConnected, Block: block}}
+			} else if bytes.Equal(utxoTip(tx), lastHash) {
+				var err error
+				if events, err = bc.reorganize(tx, lastBlock, block); err != nil {
+					return err
+				}
+				reorganized = true
+			} else {
+				return fmt.Errorf("%w: utxo set is at %x, block %x can't be connected", ErrUTXOSetStale, utxoTip(tx), block.Hash)
+			}
 		}
 
 		return nil
-	}); err != nil {
-		log.Panic(err)
+	})
+	if err != nil {
+		return err
 	}
+
+	bc.reloadTip()
+	switch {
+	case connected:
+		bc.utxoFilter.addBlock(block)
+	case reorganized:
+		bc.utxoFilter.reset()
+	}
+
+	bc.blockCommitted()
+	bc.notify(events)
+	return nil
 }
 
 // Iterator returns a BlockchainIterator
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.db}
+	bci := &BlockchainIterator{bc.currentTip(), bc.db}
 
 	return bci
 }
 
+// currentTip returns the hash of the tip block
+func (bc *Blockchain) currentTip() []byte {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	return bc.tip
+}
+
+// reloadTip reads the tip back from the database after a commit, under
+// tipMu so concurrent commits leave the newest one
+func (bc *Blockchain) reloadTip() {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	if err := bc.db.View(func(tx *bolt.Tx) error {
+		bc.tip = append([]byte{}, tx.Bucket([]byte(blocksBucket)).Get([]byte(tipDbKey))...)
+		return nil
+	}); err != nil {
+		log.Panic(err)
+	}
+}
+
 // GetBestHeight returns the height of the last block
 func (bc *Blockchain) GetBestHeight() int {
 	var lastBlock Block
@@ -175,7 +344,7 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockData := b.Get(blockHash)
 		if blockData == nil {
-			return errors.New("block is not found")
+			return fmt.Errorf("%w: %x", ErrBlockNotFound, blockHash)
 		}
 
 		block = *DeserializeBlock(blockData)
@@ -204,69 +373,162 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
-// MineBlock mines a new block with the provided transaction
+// MineBlock mines a new block with the provided transaction, a coinbase
+// with a single output is awarded the subsidy and the fees of the other
+// transactions
 func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
+	newBlock, err := bc.TryMineBlock(transactions)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return newBlock
+}
+
+// TryMineBlock mines a new block like MineBlock and returns an error when
+// the transactions are invalid or the tip moved while mining
+func (bc *Blockchain) TryMineBlock(transactions []*Transaction) (*Block, error) {
+	newBlock, err := bc.NewBlockTemplate(transactions)
+	if err != nil {
+		return nil, err
+	}
+	newBlock.mine(bc.opts.params.TargetBits)
+
+	if err = bc.connectMinedBlock(newBlock); err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// NewBlockTemplate returns the unmined block on top of the tip with the
+// transactions, its nonce can be searched by external miners
+func (bc *Blockchain) NewBlockTemplate(transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
+	transactions, err := orderTransactions(transactions, bc.opts.deterministic != nil)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, tx := range transactions {
-		// TODO: ignore transaction which is not valid
-		if !bc.VerifyTransaction(tx) {
-			log.Panic("ERROR: Invalid transaction")
-		}
+	fees, err := bc.checkTransactionsFees(transactions)
+	if err != nil {
+		return nil, err
 	}
 
-	err := bc.db.View(func(tx *bolt.Tx) error {
+	var commitment []byte
+
+	err = bc.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte(tipDbKey))
+		lastHash = append([]byte{}, b.Get([]byte(tipDbKey))...)
 
 		blockData := b.Get(lastHash)
 		block := DeserializeBlock(blockData)
 		lastHeight = block.Height
 
+		if bc.opts.utxoCommitments && bytes.Equal(utxoTip(tx), lastHash) {
+			commitment = utxoCommitment(tx)
+		}
+
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transactions) > 0 && transactions[0].IsCoinbase() {
+		coinbase := transactions[0]
+		if coinbase.Version == TxVersionLegacy && bc.opts.params.IsActive(FeatureCanonicalTx, lastHeight+1) {
+			coinbase.Version = TxVersionCanonical
+		}
+		if len(coinbase.VOut) == 1 {
+			coinbase.VOut[0].Value = bc.opts.params.BlockSubsidy(lastHeight+1) + fees
+		}
+		coinbase.ID = coinbase.Hash()
+	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	timestamp := bc.blockTimestamp(lastHeight + 1)
+	if err := bc.checkTransactionLocks(transactions, lastHeight+1, timestamp); err != nil {
+		return nil, err
+	}
 
-	if err = bc.db.Update(func(tx *bolt.Tx) error {
+	return &Block{
+		Timestamp:      timestamp,
+		Transactions:   transactions,
+		PrevBlockHash:  lastHash,
+		Height:         lastHeight + 1,
+		UTXOCommitment: commitment,
+	}, nil
+}
+
+// connectMinedBlock stores a block mined on the tip and makes it the new tip
+func (bc *Blockchain) connectMinedBlock(newBlock *Block) error {
+	var events []ChainEvent
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		if err = b.Put(newBlock.Hash, newBlock.Serialize()); err != nil {
-			log.Panic(err)
+		if !bytes.Equal(b.Get([]byte(tipDbKey)), newBlock.PrevBlockHash) {
+			return fmt.Errorf("%w: block %x is not built on the tip", ErrInvalidBlock, newBlock.Hash)
 		}
 
-		if err = b.Put([]byte(tipDbKey), newBlock.Hash); err != nil {
-			log.Panic(err)
+		if err := b.Put(newBlock.Hash, newBlock.Serialize()); err != nil {
+			return err
+		}
+
+		if err := b.Put([]byte(tipDbKey), newBlock.Hash); err != nil {
+			return err
+		}
+
+		if err := indexMainChain(tx, newBlock); err != nil {
+			return err
+		}
+
+		if bytes.Equal(utxoTip(tx), newBlock.PrevBlockHash) {
+			if err := updateUTXOSet(tx, newBlock); err != nil {
+				return err
+			}
+			events = []ChainEvent{{Type: BlockConnected, Block: newBlock}}
 		}
 
-		bc.tip = newBlock.Hash
 		return nil
-	}); err != nil {
-		log.Panic(err)
+	})
+	if err != nil {
+		return err
 	}
 
-	return newBlock
+	bc.reloadTip()
+	if len(events) > 0 {
+		bc.utxoFilter.addBlock(newBlock)
+	}
+
+	bc.blockCommitted()
+	bc.notify(events)
+	return nil
 }
 
-// FindTransaction finds a transaction by its id
+// FindTransaction finds a main chain transaction by its id with the tx index
 func (bc *Blockchain) FindTransaction(id []byte) (Transaction, error) {
-	bci := bc.Iterator()
-
-	for {
-		b := bci.Next()
+	var found *Transaction
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		block, err := txBlock(tx, id)
+		if err != nil {
+			return err
+		}
 
-		for _, tx := range b.Transactions {
-			if bytes.Compare(tx.ID, id) == 0 {
-				return *tx, nil
+		for _, t := range block.Transactions {
+			if bytes.Equal(t.ID, id) {
+				found = t
+				return nil
 			}
 		}
 
-		if len(b.PrevBlockHash) == 0 {
-			break
-		}
+		return fmt.Errorf("%w: %x isn't in block %x", ErrTransactionNotFound, id, block.Hash)
+	})
+	if err != nil {
+		return Transaction{}, err
 	}
 
-	return Transaction{}, errors.New("transaction is not found")
+	return *found, nil
 }
 
 // FindUTXO finds all unspent transactions
@@ -278,11 +540,18 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	for {
 		b := bci.Next()
 
-		for _, tx := range b.Transactions {
+		// the transactions are walked backwards like the blocks, so the
+		// outputs spent later in the same block are known to be spent
+		for i := len(b.Transactions) - 1; i >= 0; i-- {
+			tx := b.Transactions[i]
 			txID := hex.EncodeToString(tx.ID)
 
 		Outputs:
 			for outIdx, out := range tx.VOut {
+				if out.IsUnspendable() {
+					continue
+				}
+
 				// check the output is spent?
 				if spentTXOs[txID] != nil {
 					for _, spentOutIdx := range spentTXOs[txID] {
@@ -294,6 +563,8 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 
 				outs := utxo[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
+				outs.Height, outs.Timestamp = b.Height, b.Timestamp
 				utxo[txID] = outs
 			}
 
@@ -313,40 +584,190 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	return utxo
 }
 
-// SignTransaction signs inputs of a Transaction
-func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+// SignTransaction signs the inputs of a Transaction spending outputs of the keys of the signer
+func (bc *Blockchain) SignTransaction(tx *Transaction, signer Signer) error {
+	return bc.SignTransactionWithHashType(tx, signer, SigHashAll)
+}
+
+// SignTransactionWithHashType signs inputs of a Transaction with a signature hash type
+func (bc *Blockchain) SignTransactionWithHashType(tx *Transaction, signer Signer, hashType SigHashType) error {
+	prevTXs, err := bc.findPrevTransactions(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.SignWithHashType(signer, prevTXs, hashType)
+}
+
+// findPrevTransactions finds the transactions spent by the inputs of tx in
+// pending, e.g. unconfirmed transactions, or in the chain
+func (bc *Blockchain) findPrevTransactions(tx *Transaction, pending map[string]*Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.VIn {
-		prevTx, err := bc.FindTransaction(vin.TxID)
-		if err != nil {
-			log.Panic(err)
+		var prevTX Transaction
+		if pendingTX, ok := pending[hex.EncodeToString(vin.TxID)]; ok {
+			prevTX = *pendingTX
+		} else {
+			var err error
+			if prevTX, err = bc.FindTransaction(vin.TxID); err != nil {
+				return nil, fmt.Errorf("%x spends %x: %w", tx.ID, vin.TxID, err)
+			}
+		}
+
+		if vin.VOut < 0 || vin.VOut >= len(prevTX.VOut) {
+			return nil, fmt.Errorf("%x spends unknown output %x:%d", tx.ID, vin.TxID, vin.VOut)
 		}
 
-		prevTXs[hex.EncodeToString(prevTx.ID)] = prevTx
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return prevTXs, nil
 }
 
-// VerifyTransaction verifies transaction input signatures
-func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+// TransactionFee returns the fee paid by a transaction
+func (bc *Blockchain) TransactionFee(tx *Transaction) (int, error) {
 	if tx.IsCoinbase() {
-		return true
+		return 0, nil
 	}
 
-	prevTXs := make(map[string]Transaction)
+	prevTXs, err := bc.findPrevTransactions(tx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return tx.Fee(prevTXs), nil
+}
+
+// checkTransaction verifies a transaction spending outputs of the chain or
+// of pending transactions, and returns its fee or why it is invalid
+func (bc *Blockchain) checkTransaction(tx *Transaction, pending map[string]*Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	prevTXs, err := bc.findPrevTransactions(tx, pending)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+
+	if err := checkOutputValues(tx); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+
+	if err = bc.checkUnspent(tx, pending); err != nil {
+		return 0, err
+	}
+
+	fee := tx.Fee(prevTXs)
+	if fee < 0 {
+		return 0, fmt.Errorf("%w: %x outputs exceed inputs by %d", ErrInvalidTransaction, tx.ID, -fee)
+	}
+
+	if !tx.Verify(prevTXs, bc.opts.params.KeyCurve) {
+		return 0, fmt.Errorf("%w: %x has an invalid signature", ErrInvalidTransaction, tx.ID)
+	}
+
+	return fee, nil
+}
 
+// checkUnspent checks the outputs spent by tx are in the UTXO set or are
+// outputs of pending transactions
+func (bc *Blockchain) checkUnspent(tx *Transaction, pending map[string]*Transaction) error {
+	return bc.db.View(func(btx *bolt.Tx) error {
+		if !bytes.Equal(utxoTip(btx), btx.Bucket([]byte(blocksBucket)).Get([]byte(tipDbKey))) {
+			return ErrUTXOSetStale
+		}
+
+		b := btx.Bucket([]byte(utxoBucket))
+		for _, vin := range tx.VIn {
+			if _, ok := pending[hex.EncodeToString(vin.TxID)]; ok {
+				continue
+			}
+
+			if !hasUnspentOutput(b, vin.TxID, vin.VOut) {
+				return fmt.Errorf("%w: %x spends %x:%d", ErrDoubleSpend, tx.ID, vin.TxID, vin.VOut)
+			}
+		}
+
+		return nil
+	})
+}
+
+// checkNoDuplicateInputs checks a transaction spends no output spent by a
+// previous transaction of the same block, and records its inputs in spent
+func checkNoDuplicateInputs(tx *Transaction, spent map[string]bool) error {
 	for _, vin := range tx.VIn {
-		prevTX, err := bc.FindTransaction(vin.TxID)
+		key := outpointKey(vin.TxID, vin.VOut)
+		if spent[key] {
+			return fmt.Errorf("%w: %x spends %s twice", ErrDoubleSpend, tx.ID, key)
+		}
+
+		spent[key] = true
+	}
+
+	return nil
+}
+
+// checkTransactionsFees verifies the transactions of a block in order,
+// they may spend outputs of the transactions before them but no output
+// twice, and returns the sum of their fees
+func (bc *Blockchain) checkTransactionsFees(transactions []*Transaction) (int, error) {
+	fees := 0
+	pending := make(map[string]*Transaction, len(transactions))
+	spent := make(map[string]bool)
+
+	for _, tx := range transactions {
+		if !tx.IsCoinbase() {
+			if err := checkNoDuplicateInputs(tx, spent); err != nil {
+				return 0, err
+			}
+		}
+
+		fee, err := bc.checkTransaction(tx, pending)
 		if err != nil {
-			log.Panic(err)
+			return 0, err
 		}
 
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		fees += fee
+		pending[hex.EncodeToString(tx.ID)] = tx
 	}
 
-	return tx.Verify(prevTXs)
+	return fees, nil
+}
+
+// checkTransactionLocks checks the transactions of a block at height with
+// the timestamp are final and their inputs aren't sequence locked
+func (bc *Blockchain) checkTransactionLocks(transactions []*Transaction, height int, timestamp int64) error {
+	return bc.db.View(func(btx *bolt.Tx) error {
+		return transactionLocks(btx.Bucket([]byte(utxoBucket)), transactions, height, timestamp)
+	})
+}
+
+// transactionLocks checks the locks of the transactions of a block against
+// the outputs of the UTXO set bucket
+func transactionLocks(utxos *bolt.Bucket, transactions []*Transaction, height int, timestamp int64) error {
+	pending := make(map[string]*Transaction, len(transactions))
+
+	for _, tx := range transactions {
+		if err := checkTxFinal(tx, height, timestamp); err != nil {
+			return err
+		}
+
+		if err := sequenceLocks(utxos, tx, pending, height, timestamp); err != nil {
+			return err
+		}
+
+		pending[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	return nil
+}
+
+// VerifyTransaction verifies transaction input signatures
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+	_, err := bc.checkTransaction(tx, nil)
+	return err == nil
 }
 
 // dbExists returns whether database file is exists
@@ -1,10 +1,15 @@
 package blockchain
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/boltdb/bolt"
 	"log"
 )
 
+// BlockchainIterator iterates the blocks from a hash to the genesis block,
+// each Next reads in its own transaction, see PinnedIterator for scans
+// which must not follow reorgs
 type BlockchainIterator struct {
 	currentHash []byte
 	db          *bolt.DB
@@ -28,3 +33,144 @@ func (i *BlockchainIterator) Next() *Block {
 	i.currentHash = b.PrevBlockHash
 	return b
 }
+
+// PinnedIterator iterates the blocks of the branch ending at the tip
+// observed at its creation, blocks added or reorganized meanwhile don't
+// change the blocks it returns
+type PinnedIterator struct {
+	db     *bolt.DB
+	tip    []byte
+	hashes [][]byte
+	pos    int
+	step   int
+}
+
+// PinnedIterator returns a PinnedIterator from the current tip to the
+// genesis block, the hashes of the branch are read in a single transaction
+func (bc *Blockchain) PinnedIterator() (*PinnedIterator, error) {
+	var hashes [][]byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		hashes, err = branchHashes(tx, tx.Bucket([]byte(blocksBucket)).Get([]byte(tipDbKey)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PinnedIterator{db: bc.db, tip: hashes[0], hashes: hashes, step: 1}, nil
+}
+
+// branchHashes returns the hashes of the blocks from tip to the genesis
+// block, from the height index when it is on the tip
+func branchHashes(tx *bolt.Tx, tip []byte) ([][]byte, error) {
+	blocks := tx.Bucket([]byte(blocksBucket))
+
+	data := blocks.Get(tip)
+	if data == nil {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, tip)
+	}
+	block := DeserializeBlock(data)
+
+	hashes := make([][]byte, 0, block.Height+1)
+	if index := tx.Bucket([]byte(heightIndexBucket)); index != nil && bytes.Equal(index.Get(heightKey(block.Height)), tip) {
+		for height := block.Height; height >= 0; height-- {
+			hash := index.Get(heightKey(height))
+			if hash == nil {
+				return nil, fmt.Errorf("%w: at height %d", ErrBlockNotFound, height)
+			}
+
+			hashes = append(hashes, append([]byte{}, hash...))
+		}
+
+		return hashes, nil
+	}
+
+	for {
+		hashes = append(hashes, append([]byte{}, block.Hash...))
+		if len(block.PrevBlockHash) == 0 {
+			return hashes, nil
+		}
+
+		if data = blocks.Get(block.PrevBlockHash); data == nil {
+			return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, block.PrevBlockHash)
+		}
+		block = DeserializeBlock(data)
+	}
+}
+
+// Ascending makes the iterator return the remaining blocks from the
+// genesis block to the pinned tip
+func (i *PinnedIterator) Ascending() *PinnedIterator {
+	if i.step > 0 {
+		i.hashes = i.hashes[i.pos:]
+		i.pos, i.step = len(i.hashes)-1, -1
+	}
+
+	return i
+}
+
+// Tip returns the hash of the pinned tip
+func (i *PinnedIterator) Tip() []byte {
+	return i.tip
+}
+
+// Len returns the number of blocks of the pinned branch left to iterate
+func (i *PinnedIterator) Len() int {
+	if i.step > 0 {
+		return len(i.hashes) - i.pos
+	}
+
+	return i.pos + 1
+}
+
+// HasNext returns whether blocks are left
+func (i *PinnedIterator) HasNext() bool {
+	return i.Len() > 0
+}
+
+// Next returns the next block of the pinned branch, or nil after the last
+func (i *PinnedIterator) Next() (*Block, error) {
+	if !i.HasNext() {
+		return nil, nil
+	}
+
+	hash := i.hashes[i.pos]
+	i.pos += i.step
+
+	var block *Block
+	err := i.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+		if data == nil {
+			return fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+		}
+
+		block = DeserializeBlock(data)
+		return nil
+	})
+
+	return block, err
+}
+
+// IsMainChain returns whether the pinned tip is still on the main chain
+func (i *PinnedIterator) IsMainChain() (bool, error) {
+	tip := i.tip
+	var main bool
+	err := i.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(blocksBucket)).Get(tip)
+		if data == nil {
+			return fmt.Errorf("%w: %x", ErrBlockNotFound, tip)
+		}
+
+		block, err := blockAtHeight(tx, DeserializeBlock(data).Height)
+		if err != nil {
+			return nil
+		}
+
+		main = bytes.Equal(block.Hash, tip)
+		return nil
+	})
+
+	return main, err
+}
@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	// bloomHashSeedStep separates the seeds of the hash functions
+	bloomHashSeedStep = 0xFBA4C795
+
+	// maxBloomHashFuncs is the maximum number of hash functions of a filter
+	maxBloomHashFuncs = 50
+)
+
+// BloomFilter is a probabilistic set, it may report items it doesn't contain
+// but never misses an added item
+type BloomFilter struct {
+	Bits      []byte
+	HashFuncs uint32
+	Tweak     uint32
+}
+
+// NewBloomFilter creates a BloomFilter sized for n items at a false positive rate
+func NewBloomFilter(n int, fpRate float64, tweak uint32) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+
+	bits := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	size := int(math.Ceil(bits / 8))
+	if size < 1 {
+		size = 1
+	}
+
+	hashFuncs := uint32(math.Round(float64(size*8) / float64(n) * math.Ln2))
+	if hashFuncs < 1 {
+		hashFuncs = 1
+	} else if hashFuncs > maxBloomHashFuncs {
+		hashFuncs = maxBloomHashFuncs
+	}
+
+	return &BloomFilter{Bits: make([]byte, size), HashFuncs: hashFuncs, Tweak: tweak}
+}
+
+// bitIndex returns the bit set by the i-th hash function for data
+func (f *BloomFilter) bitIndex(i uint32, data []byte) uint32 {
+	return murmur3(i*bloomHashSeedStep+f.Tweak, data) % uint32(len(f.Bits)*8)
+}
+
+// Add adds an item to the filter
+func (f *BloomFilter) Add(data []byte) {
+	if len(f.Bits) == 0 {
+		return
+	}
+
+	for i := uint32(0); i < f.HashFuncs; i++ {
+		idx := f.bitIndex(i, data)
+		f.Bits[idx/8] |= 1 << (idx % 8)
+	}
+}
+
+// MayContain returns false if the item was certainly not added
+func (f *BloomFilter) MayContain(data []byte) bool {
+	if len(f.Bits) == 0 {
+		return true
+	}
+
+	for i := uint32(0); i < f.HashFuncs; i++ {
+		idx := f.bitIndex(i, data)
+		if f.Bits[idx/8]&(1<<(idx%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchTransaction returns whether the ID of a transaction, the public key
+// hash or the script of one of its outputs or an outpoint it spends may be in
+// the filter. The outpoints of the matching outputs are added to the filter
+// so the transactions spending them match too.
+func (f *BloomFilter) MatchTransaction(tx *Transaction) bool {
+	matched := f.MayContain(tx.ID)
+
+	for i, out := range tx.VOut {
+		pubKeyHash := out.PubKeyHash()
+		if (pubKeyHash != nil && f.MayContain(pubKeyHash)) || f.MayContain(out.ScriptPubKey) {
+			f.Add(Outpoint{TxID: tx.ID, VOut: i}.Bytes())
+			matched = true
+		}
+	}
+
+	if matched || tx.IsCoinbase() {
+		return matched
+	}
+
+	for _, vin := range tx.VIn {
+		if f.MayContain(Outpoint{TxID: vin.TxID, VOut: vin.VOut}.Bytes()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// murmur3 is the 32 bit MurmurHash3 of data
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	blocks := len(data) / 4
+
+	for i := 0; i < blocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+
+		h ^= k
+		h = h<<13 | h>>19
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[blocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+
+	return h
+}
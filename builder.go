@@ -0,0 +1,430 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrUnknownTxVersion is returned for a transaction version this node doesn't know
+	ErrUnknownTxVersion = errors.New("unknown transaction version")
+
+	// ErrInvalidTxVersion is returned for a transaction version which can't be encoded
+	ErrInvalidTxVersion = errors.New("invalid transaction version")
+
+	// ErrFeatureNotActive is returned when a transaction uses a feature not active yet
+	ErrFeatureNotActive = errors.New("feature is not active")
+
+	// ErrLegacyTxVersion is returned for a legacy transaction once canonical
+	// transactions are active
+	ErrLegacyTxVersion = errors.New("legacy transaction version")
+
+	// ErrInsufficientFunds is returned when the inputs don't cover the outputs and the fee
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrMissingKey is returned when a signer has no key for an input
+	ErrMissingKey = errors.New("missing signing key")
+)
+
+// Keystore finds the private keys of local wallets, KeystoreSigner signs
+// the inputs of a transaction with them
+type Keystore interface {
+	// KeyFor returns the private key of a public key hash
+	KeyFor(pubKeyHash []byte) (*ecdsa.PrivateKey, bool)
+}
+
+// TxBuilder builds transactions valid on a chain at a target height
+type TxBuilder struct {
+	params        *ChainParams
+	height        int
+	version       int
+	lockTime      uint32
+	inputs        []TXInput
+	outputs       []TXOutput
+	prevTXs       map[string]Transaction
+	fee           int
+	feeSet        bool
+	changeAddress string
+	signer        Signer
+	candidates    []SpendableOutput
+	selector      CoinSelector
+	err           error
+}
+
+// NewTxBuilder creates a TxBuilder for a transaction to be included in the
+// block at height, it uses the highest transaction version active there
+func NewTxBuilder(params *ChainParams, height int) *TxBuilder {
+	b := &TxBuilder{params: params, height: height, version: TxVersionLegacy, prevTXs: make(map[string]Transaction)}
+
+	for version := range txVersionFeatures {
+		if version > b.version && checkTxVersion(version, params, height) == nil {
+			b.version = version
+		}
+	}
+
+	return b
+}
+
+// SetVersion sets the transaction version
+func (b *TxBuilder) SetVersion(version int) *TxBuilder {
+	b.version = version
+	return b
+}
+
+// SetLockTime sets the block height or unix timestamp before which the
+// transaction can't be mined, inputs left with the final sequence are
+// changed so that the locktime is enforced
+func (b *TxBuilder) SetLockTime(lockTime uint32) *TxBuilder {
+	b.lockTime = lockTime
+	return b
+}
+
+// AddInput adds an input spending an output, it is unlocked when the transaction is signed
+func (b *TxBuilder) AddInput(txID []byte, vout int) *TxBuilder {
+	b.inputs = append(b.inputs, TXInput{TxID: txID, VOut: vout, ScriptSig: nil, Sequence: SequenceFinal})
+	return b
+}
+
+// AddInputFrom adds an input spending an output of a previous transaction,
+// its value and script are known to the builder to compute the change and
+// sign the input
+func (b *TxBuilder) AddInputFrom(prevTX *Transaction, vout int) *TxBuilder {
+	if vout < 0 || vout >= len(prevTX.VOut) {
+		b.fail(fmt.Errorf("output %x:%d doesn't exist", prevTX.ID, vout))
+		return b
+	}
+
+	b.prevTXs[hex.EncodeToString(prevTX.ID)] = *prevTX
+	return b.AddInput(prevTX.ID, vout)
+}
+
+// AddCandidate adds an output of a previous transaction the coin selector
+// may spend to fund the outputs and the fee
+func (b *TxBuilder) AddCandidate(prevTX *Transaction, vout int) *TxBuilder {
+	if vout < 0 || vout >= len(prevTX.VOut) {
+		b.fail(fmt.Errorf("output %x:%d doesn't exist", prevTX.ID, vout))
+		return b
+	}
+
+	b.prevTXs[hex.EncodeToString(prevTX.ID)] = *prevTX
+	b.candidates = append(b.candidates, SpendableOutput{TxID: prevTX.ID, VOut: vout, Value: prevTX.VOut[vout].Value})
+	return b
+}
+
+// AddCandidates adds the unspent outputs locked with the public key hash as
+// candidates of the coin selector
+func (b *TxBuilder) AddCandidates(utxoSet *UTXOSet, pubKeyHash []byte) *TxBuilder {
+	for _, out := range utxoSet.SpendableOutputs(pubKeyHash) {
+		prevTX, ok := b.prevTXs[hex.EncodeToString(out.TxID)]
+		if !ok {
+			var err error
+			if prevTX, err = utxoSet.Blockchain.FindTransaction(out.TxID); err != nil {
+				b.fail(err)
+				return b
+			}
+		}
+
+		b.AddCandidate(&prevTX, out.VOut)
+	}
+
+	return b
+}
+
+// SetCoinSelector sets how the candidates are selected, FirstFitSelector by default
+func (b *TxBuilder) SetCoinSelector(selector CoinSelector) *TxBuilder {
+	b.selector = selector
+	return b
+}
+
+// SetSequence sets the sequence of the input at index
+func (b *TxBuilder) SetSequence(index int, sequence uint32) *TxBuilder {
+	if index < 0 || index >= len(b.inputs) {
+		b.fail(fmt.Errorf("input %d doesn't exist", index))
+	} else {
+		b.inputs[index].Sequence = sequence
+	}
+
+	return b
+}
+
+// AddOutput adds an output paying value to an address
+func (b *TxBuilder) AddOutput(value int, address string) *TxBuilder {
+	if value <= 0 {
+		b.fail(fmt.Errorf("output value %d is not positive", value))
+	} else if _, _, err := decodeAddress(address); err != nil {
+		b.fail(err)
+	} else {
+		b.outputs = append(b.outputs, *NewTXOutput(value, address))
+	}
+
+	return b
+}
+
+// AddScriptOutput adds an output paying value to a locking script
+func (b *TxBuilder) AddScriptOutput(value int, script []byte) *TxBuilder {
+	if value <= 0 {
+		b.fail(fmt.Errorf("output value %d is not positive", value))
+	} else {
+		b.outputs = append(b.outputs, TXOutput{Value: value, ScriptPubKey: script})
+	}
+
+	return b
+}
+
+// AddDataOutput adds a provably unspendable output carrying data
+func (b *TxBuilder) AddDataOutput(data []byte) *TxBuilder {
+	script, err := NullDataScript(data)
+	if err != nil {
+		b.fail(err)
+	} else {
+		b.outputs = append(b.outputs, TXOutput{Value: 0, ScriptPubKey: script})
+	}
+
+	return b
+}
+
+// AddMultiSigOutput adds an output paying value to required signatures of the public keys
+func (b *TxBuilder) AddMultiSigOutput(value, required int, pubKeys [][]byte) *TxBuilder {
+	script, err := MultiSigScript(required, pubKeys)
+	if err != nil {
+		b.fail(err)
+		return b
+	}
+
+	return b.AddScriptOutput(value, script)
+}
+
+// SetFee sets the fee left to the miner, the inputs exceeding the outputs
+// and the fee are paid to the change address. The inputs must be added with
+// AddInputFrom.
+func (b *TxBuilder) SetFee(fee int) *TxBuilder {
+	if fee < 0 {
+		b.fail(fmt.Errorf("fee %d is negative", fee))
+	} else {
+		b.fee, b.feeSet = fee, true
+	}
+
+	return b
+}
+
+// SetChangeAddress sets the address receiving the change when a fee is set
+func (b *TxBuilder) SetChangeAddress(address string) *TxBuilder {
+	if _, _, err := decodeAddress(address); err != nil {
+		b.fail(err)
+	} else {
+		b.changeAddress = address
+	}
+
+	return b
+}
+
+// Sign signs the inputs with the keys of the signer when the transaction
+// is built, the inputs must be added with AddInputFrom
+func (b *TxBuilder) Sign(signer Signer) *TxBuilder {
+	b.signer = signer
+	return b
+}
+
+// fail records the first error
+func (b *TxBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Build returns the transaction, signed when a signer is given, it
+// refuses versions whose features are not active at the target height
+func (b *TxBuilder) Build() (*Transaction, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if err := checkTxVersion(b.version, b.params, b.height); err != nil {
+		return nil, err
+	}
+
+	tx := &Transaction{
+		ID:       nil,
+		VIn:      append([]TXInput{}, b.inputs...),
+		VOut:     append([]TXOutput{}, b.outputs...),
+		Version:  b.version,
+		LockTime: b.lockTime,
+	}
+
+	if len(b.candidates) != 0 {
+		if err := b.selectInputs(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tx.VIn) == 0 || len(tx.VOut) == 0 {
+		return nil, errors.New("transaction needs inputs and outputs")
+	}
+
+	if b.feeSet {
+		if err := b.addChange(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if tx.LockTime != 0 {
+		for i := range tx.VIn {
+			if tx.VIn[i].Sequence == SequenceFinal {
+				tx.VIn[i].Sequence = SequenceFinal - 1
+			}
+		}
+	}
+	tx.ID = tx.Hash()
+
+	if b.signer != nil {
+		if err := b.sign(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// selectInputs adds the candidates chosen by the coin selector to fund the
+// outputs and the fee not funded by the inputs
+func (b *TxBuilder) selectInputs(tx *Transaction) error {
+	if !b.feeSet {
+		return errors.New("coin selection needs a fee, set it with SetFee")
+	}
+
+	in, err := b.inputValue(tx.VIn)
+	if err != nil {
+		return err
+	}
+
+	target := tx.OutputValue() + b.fee - in
+	if target <= 0 {
+		return nil
+	}
+
+	selector := b.selector
+	if selector == nil {
+		selector = FirstFitSelector{}
+	}
+
+	selected, err := selector.Select(b.candidates, target)
+	if err != nil {
+		return err
+	}
+
+	for _, out := range selected {
+		tx.VIn = append(tx.VIn, TXInput{TxID: out.TxID, VOut: out.VOut, ScriptSig: nil, Sequence: SequenceFinal})
+	}
+
+	return nil
+}
+
+// inputValue returns the value of the inputs, all of them must be added
+// with AddInputFrom
+func (b *TxBuilder) inputValue(inputs []TXInput) (int, error) {
+	value := 0
+	for i, vin := range inputs {
+		prevTX, ok := b.prevTXs[hex.EncodeToString(vin.TxID)]
+		if !ok {
+			return 0, fmt.Errorf("input %d spends an unknown output, add it with AddInputFrom", i)
+		}
+
+		value += prevTX.VOut[vin.VOut].Value
+	}
+
+	return value, nil
+}
+
+// addChange pays the inputs exceeding the outputs and the fee to the change address
+func (b *TxBuilder) addChange(tx *Transaction) error {
+	in, err := b.inputValue(tx.VIn)
+	if err != nil {
+		return err
+	}
+
+	change := in - tx.OutputValue() - b.fee
+	if change < 0 {
+		return fmt.Errorf("%w: inputs %d, outputs %d, fee %d", ErrInsufficientFunds, in, tx.OutputValue(), b.fee)
+	}
+
+	if change > 0 {
+		if b.changeAddress == "" {
+			return fmt.Errorf("change of %d has no change address", change)
+		}
+
+		tx.VOut = append(tx.VOut, *NewTXOutput(change, b.changeAddress))
+	}
+
+	return nil
+}
+
+// sign signs the inputs with the keys of the signer, pay to public key
+// hash inputs must all be signed while multisig inputs are signed with the
+// keys the signer has
+func (b *TxBuilder) sign(tx *Transaction) error {
+	if _, err := b.inputValue(tx.VIn); err != nil {
+		return err
+	}
+
+	for i, vin := range tx.VIn {
+		prevOut := prevOutput(vin, b.prevTXs)
+		pubKeyHash := prevOut.PubKeyHash()
+		if pubKeyHash == nil {
+			continue
+		}
+
+		pubKey, err := signerKey(b.signer, string(encodeAddress(version, pubKeyHash)))
+		if err != nil {
+			return err
+		}
+		if pubKey == nil {
+			return fmt.Errorf("%w: input %d is locked to %x", ErrMissingKey, i, pubKeyHash)
+		}
+	}
+
+	return tx.Sign(b.signer, b.prevTXs)
+}
+
+// checkBlockTxVersion checks the version of a transaction of the block at
+// height. Versions above the known ones are accepted once the features of
+// the highest known version are active, so later versions can be deployed
+// without splitting the nodes which don't know them.
+func checkBlockTxVersion(version int, params *ChainParams, height int) error {
+	if version < 0 || int64(version) > math.MaxUint32 {
+		return fmt.Errorf("%w: %d", ErrInvalidTxVersion, version)
+	}
+
+	if version > maxKnownTxVersion {
+		return checkTxVersion(maxKnownTxVersion, params, height)
+	}
+
+	return checkTxVersion(version, params, height)
+}
+
+// checkTxVersion checks a transaction version is known and its features are
+// active in the block at height, the mempool and the builder only take known
+// versions. Legacy transactions are refused from the activation of canonical
+// transactions.
+func checkTxVersion(version int, params *ChainParams, height int) error {
+	if version == TxVersionLegacy {
+		if params.IsActive(FeatureCanonicalTx, height) {
+			return fmt.Errorf("%w: canonical transactions are active at height %d", ErrLegacyTxVersion, height)
+		}
+
+		return nil
+	}
+
+	feature, ok := txVersionFeatures[version]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownTxVersion, version)
+	}
+
+	if !params.IsActive(feature, height) {
+		return fmt.Errorf("%w: transaction version %d at height %d", ErrFeatureNotActive, version, height)
+	}
+
+	return nil
+}
@@ -0,0 +1,587 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// cfiltersBucket is the bucket name of the compact filters and filter
+	// headers of the main chain blocks by block hash
+	cfiltersBucket = "filters"
+
+	// gcsP is the number of remainder bits of the Golomb-Rice coding
+	gcsP = 19
+
+	// gcsM is the inverse false positive rate of the compact filters
+	gcsM = 784931
+
+	// filterKeySize is the size of the SipHash key taken from the block hash
+	filterKeySize = 16
+)
+
+// ErrMalformedFilter is returned for compact filter data which can't be decoded
+var ErrMalformedFilter = errors.New("malformed compact filter")
+
+// CompactFilter is a Golomb-coded set of the scripts of a block, the output
+// scripts of its transactions and the scripts of the outputs they spend. Light
+// clients test their scripts against it and download the block on a match,
+// the node doesn't learn which scripts they watch.
+type CompactFilter struct {
+	// N is the number of items
+	N uint32
+
+	// Data are the Golomb-Rice coded differences of the sorted item hashes
+	Data []byte
+}
+
+// NewCompactFilter creates the filter of items keyed by a block hash
+func NewCompactFilter(blockHash []byte, items [][]byte) *CompactFilter {
+	unique := make(map[string]bool, len(items))
+	for _, item := range items {
+		unique[string(item)] = true
+	}
+
+	k0, k1 := filterKey(blockHash)
+	modulus := uint64(len(unique)) * gcsM
+
+	values := make([]uint64, 0, len(unique))
+	for item := range unique {
+		values = append(values, hashToRange(k0, k1, []byte(item), modulus))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	var w bitWriter
+	last := uint64(0)
+	for _, v := range values {
+		delta := v - last
+		last = v
+
+		for q := delta >> gcsP; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, gcsP)
+	}
+
+	return &CompactFilter{N: uint32(len(unique)), Data: w.bytes}
+}
+
+// ParseCompactFilter decodes a filter serialized by Bytes
+func ParseCompactFilter(data []byte) (*CompactFilter, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(^uint32(0)) {
+		return nil, fmt.Errorf("%w: bad item count", ErrMalformedFilter)
+	}
+
+	return &CompactFilter{N: uint32(n), Data: data[size:]}, nil
+}
+
+// Bytes serializes the filter as its number of items, a uvarint, followed
+// by its data
+func (f *CompactFilter) Bytes() []byte {
+	data := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(f.Data))
+	data = data[:binary.PutUvarint(data, uint64(f.N))]
+
+	return append(data, f.Data...)
+}
+
+// MatchAny returns whether one of the items may be in the filter of a
+// block hash, false positives happen once in gcsM items
+func (f *CompactFilter) MatchAny(blockHash []byte, items [][]byte) bool {
+	if f.N == 0 || len(items) == 0 {
+		return false
+	}
+
+	k0, k1 := filterKey(blockHash)
+	modulus := uint64(f.N) * gcsM
+
+	targets := make([]uint64, 0, len(items))
+	for _, item := range items {
+		targets = append(targets, hashToRange(k0, k1, item, modulus))
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	r := bitReader{data: f.Data}
+	value := uint64(0)
+	for i := uint32(0); i < f.N; i++ {
+		delta, ok := r.readGolomb()
+		if !ok {
+			return false
+		}
+		value += delta
+
+		for len(targets) > 0 && targets[0] < value {
+			targets = targets[1:]
+		}
+		if len(targets) == 0 {
+			return false
+		}
+		if targets[0] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterHeader returns the header of a serialized filter, it commits to the
+// filter and the header of the previous block so light clients can check
+// the filters of a node against the filter headers of others
+func FilterHeader(filter, prevHeader []byte) []byte {
+	filterHash := sha256.Sum256(filter)
+	header := sha256.Sum256(append(filterHash[:], prevHeader...))
+
+	return header[:]
+}
+
+// filterKey returns the SipHash key of the filter of a block
+func filterKey(blockHash []byte) (uint64, uint64) {
+	var key [filterKeySize]byte
+	copy(key[:], blockHash)
+
+	return binary.LittleEndian.Uint64(key[:8]), binary.LittleEndian.Uint64(key[8:])
+}
+
+// hashToRange maps the SipHash of an item uniformly to [0, modulus)
+func hashToRange(k0, k1 uint64, item []byte, modulus uint64) uint64 {
+	hi, _ := bits.Mul64(sipHash24(k0, k1, item), modulus)
+	return hi
+}
+
+// sipHash24 is the SipHash-2-4 of data
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	blocks := len(data) / 8
+	for i := 0; i < blocks; i++ {
+		m := binary.LittleEndian.Uint64(data[i*8:])
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	var last [8]byte
+	copy(last[:], data[blocks*8:])
+	last[7] = byte(len(data))
+	m := binary.LittleEndian.Uint64(last[:])
+
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		round()
+	}
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// bitWriter appends bits most significant first
+type bitWriter struct {
+	bytes []byte
+	n     uint
+}
+
+// writeBit appends a bit
+func (w *bitWriter) writeBit(bit bool) {
+	if w.n%8 == 0 {
+		w.bytes = append(w.bytes, 0)
+	}
+
+	if bit {
+		w.bytes[len(w.bytes)-1] |= 0x80 >> (w.n % 8)
+	}
+	w.n++
+}
+
+// writeBits appends the count low bits of v
+func (w *bitWriter) writeBits(v uint64, count uint) {
+	for i := count; i > 0; i-- {
+		w.writeBit(v&(1<<(i-1)) != 0)
+	}
+}
+
+// bitReader reads the bits of a bitWriter
+type bitReader struct {
+	data []byte
+	n    uint
+}
+
+// readBit reads a bit, false when the data is exhausted
+func (r *bitReader) readBit() (bit, ok bool) {
+	if r.n/8 >= uint(len(r.data)) {
+		return false, false
+	}
+
+	bit = r.data[r.n/8]&(0x80>>(r.n%8)) != 0
+	r.n++
+
+	return bit, true
+}
+
+// readGolomb reads a Golomb-Rice coded value
+func (r *bitReader) readGolomb() (uint64, bool) {
+	var q uint64
+	for {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if !bit {
+			break
+		}
+		q++
+	}
+
+	v := q << gcsP
+	for i := gcsP - 1; i >= 0; i-- {
+		bit, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		if bit {
+			v |= 1 << uint(i)
+		}
+	}
+
+	return v, true
+}
+
+// filterEntry is the compact filter of a block and its filter header
+type filterEntry struct {
+	Filter []byte
+	Header []byte
+}
+
+// blockFilterItems returns the output scripts of the transactions of a
+// main chain block and the scripts of the outputs they spend, found with
+// the tx index
+func blockFilterItems(tx *bolt.Tx, block *Block) ([][]byte, error) {
+	var items [][]byte
+
+	for _, t := range block.Transactions {
+		for _, out := range t.VOut {
+			if len(out.ScriptPubKey) != 0 && !out.IsUnspendable() {
+				items = append(items, out.ScriptPubKey)
+			}
+		}
+
+		if t.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range t.VIn {
+			prevBlock, err := txBlock(tx, vin.TxID)
+			if err != nil {
+				return nil, err
+			}
+
+			script, err := outputScript(prevBlock, vin.TxID, vin.VOut)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, script)
+		}
+	}
+
+	return items, nil
+}
+
+// outputScript returns the script of an output of a transaction of a block
+func outputScript(block *Block, txID []byte, vout int) ([]byte, error) {
+	for _, t := range block.Transactions {
+		if bytes.Equal(t.ID, txID) && vout >= 0 && vout < len(t.VOut) {
+			return t.VOut[vout].ScriptPubKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("input %x:%d spends a missing output", txID, vout)
+}
+
+// getFilterEntry loads the filter entry of a block, nil when it has none
+func getFilterEntry(filters *bolt.Bucket, blockHash []byte) (*filterEntry, error) {
+	data := filters.Get(blockHash)
+	if data == nil {
+		return nil, nil
+	}
+
+	var entry filterEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// indexBlockFilters builds the filters of the blocks of the main chain
+// ending at tip which have none yet, oldest first so every filter header
+// commits to the previous one. Nothing is built until the tx index exists.
+func indexBlockFilters(tx *bolt.Tx, tip *Block) error {
+	if tx.Bucket([]byte(txIndexBucket)) == nil {
+		return nil
+	}
+
+	filters, err := tx.CreateBucketIfNotExists([]byte(cfiltersBucket))
+	if err != nil {
+		return err
+	}
+
+	blocks := tx.Bucket([]byte(blocksBucket))
+	var missing []*Block
+	prevHeader := make([]byte, sha256.Size)
+
+	for block := tip; ; {
+		entry, err := getFilterEntry(filters, block.Hash)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			prevHeader = entry.Header
+			break
+		}
+
+		missing = append(missing, block)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+
+		data := blocks.Get(block.PrevBlockHash)
+		if data == nil {
+			return fmt.Errorf("%w: %x", ErrBlockNotFound, block.PrevBlockHash)
+		}
+		block = DeserializeBlock(data)
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		block := missing[i]
+		items, err := blockFilterItems(tx, block)
+		if err != nil {
+			return err
+		}
+
+		filter := NewCompactFilter(block.Hash, items).Bytes()
+		entry := filterEntry{Filter: filter, Header: FilterHeader(filter, prevHeader)}
+
+		var buff bytes.Buffer
+		if err = gob.NewEncoder(&buff).Encode(entry); err != nil {
+			return err
+		}
+		if err = filters.Put(block.Hash, buff.Bytes()); err != nil {
+			return err
+		}
+
+		prevHeader = entry.Header
+	}
+
+	return nil
+}
+
+// RepairFilterIndex builds the compact filters of the main chain blocks of
+// databases created before the filter index existed
+func (bc *Blockchain) RepairFilterIndex() {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		return indexBlockFilters(tx, DeserializeBlock(b.Get(b.Get([]byte(tipDbKey)))))
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// BlockFilter is the compact filter of a main chain block
+type BlockFilter struct {
+	BlockHash []byte
+	Height    int
+	Filter    []byte
+}
+
+// GetBlockFilters returns the compact filters of at most max main chain
+// blocks from a height
+func (bc *Blockchain) GetBlockFilters(fromHeight, max int) ([]BlockFilter, error) {
+	var filters []BlockFilter
+	err := bc.mainChainFilters(fromHeight, max, func(block *Block, entry *filterEntry) {
+		filters = append(filters, BlockFilter{BlockHash: block.Hash, Height: block.Height, Filter: entry.Filter})
+	})
+
+	return filters, err
+}
+
+// GetFilterHeaders returns the filter headers of at most max main chain
+// blocks from a height, and the header they follow, zero before the genesis
+func (bc *Blockchain) GetFilterHeaders(fromHeight, max int) ([]byte, [][]byte, error) {
+	var prevHeader []byte
+	var headers [][]byte
+
+	err := bc.mainChainFilters(fromHeight-1, max+1, func(block *Block, entry *filterEntry) {
+		if block.Height < fromHeight {
+			prevHeader = entry.Header
+		} else {
+			headers = append(headers, entry.Header)
+		}
+	})
+	if prevHeader == nil {
+		prevHeader = make([]byte, sha256.Size)
+	}
+
+	return prevHeader, headers, err
+}
+
+// mainChainFilters calls fn with the filter entries of at most max main
+// chain blocks from a height, negative heights are skipped
+func (bc *Blockchain) mainChainFilters(fromHeight, max int, fn func(*Block, *filterEntry)) error {
+	return bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte(tipDbKey))))
+
+		filters := tx.Bucket([]byte(cfiltersBucket))
+		if filters == nil {
+			return fmt.Errorf("%w: no compact filters", ErrBlockNotFound)
+		}
+
+		for height := fromHeight; height <= tip.Height && height < fromHeight+max; height++ {
+			if height < 0 {
+				continue
+			}
+
+			block, err := blockAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			entry, err := getFilterEntry(filters, block.Hash)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				return fmt.Errorf("%w: no compact filter for block %x", ErrBlockNotFound, block.Hash)
+			}
+
+			fn(block, entry)
+		}
+
+		return nil
+	})
+}
+
+const (
+	// CommandGetCFilters get compact filters
+	CommandGetCFilters = "getcfilters"
+
+	// CommandCFilters compact filters
+	CommandCFilters = "cfilters"
+
+	// CommandGetCFHeaders get compact filter headers
+	CommandGetCFHeaders = "getcfheaders"
+
+	// CommandCFHeaders compact filter headers
+	CommandCFHeaders = "cfheaders"
+
+	// MaxCFiltersPerMessage is the maximum number of filters of a cfilters message
+	MaxCFiltersPerMessage = 1000
+
+	// MaxCFHeadersPerMessage is the maximum number of filter headers of a
+	// cfheaders message
+	MaxCFHeadersPerMessage = 2000
+)
+
+// GetCFiltersMessage asks a node for the compact filters of the main chain
+// blocks from a height
+type GetCFiltersMessage struct {
+	AddrFrom   string
+	FromHeight int
+}
+
+// CFiltersMessage is the response to a GetCFiltersMessage
+type CFiltersMessage struct {
+	AddrFrom string
+	Filters  []BlockFilter
+	Error    string
+}
+
+// GetCFHeadersMessage asks a node for the filter headers of the main chain
+// blocks from a height
+type GetCFHeadersMessage struct {
+	AddrFrom   string
+	FromHeight int
+}
+
+// CFHeadersMessage is the response to a GetCFHeadersMessage, Headers follow
+// PrevHeader, the filter header of the block before FromHeight
+type CFHeadersMessage struct {
+	AddrFrom   string
+	FromHeight int
+	PrevHeader []byte
+	Headers    [][]byte
+	Error      string
+}
+
+// handleGetCFilters handles CommandGetCFilters request
+func (s *Server) handleGetCFilters(p *peerConn, request []byte) {
+	var payload GetCFiltersMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	response := CFiltersMessage{AddrFrom: s.address}
+	filters, err := s.bc.GetBlockFilters(payload.FromHeight, MaxCFiltersPerMessage)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Filters = filters
+	}
+
+	p.send(CommandCFilters, response)
+}
+
+// handleGetCFHeaders handles CommandGetCFHeaders request
+func (s *Server) handleGetCFHeaders(p *peerConn, request []byte) {
+	var payload GetCFHeadersMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	response := CFHeadersMessage{AddrFrom: s.address, FromHeight: payload.FromHeight}
+	prevHeader, headers, err := s.bc.GetFilterHeaders(payload.FromHeight, MaxCFHeadersPerMessage)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.PrevHeader, response.Headers = prevHeader, headers
+	}
+
+	p.send(CommandCFHeaders, response)
+}
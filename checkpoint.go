@@ -0,0 +1,130 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"sync"
+	"time"
+)
+
+// CheckpointStatement is a statement signed by a node that a block was the
+// tip of its chain with a UTXO set commitment at a time, downstream systems
+// archive them as an audit trail of the chain the node considered canonical
+type CheckpointStatement struct {
+	Height         int
+	BlockHash      []byte
+	UTXOCommitment []byte
+	Timestamp      int64
+	PubKey         []byte
+	Signature      []byte
+}
+
+// digest returns the hash signed by the statement in the DomainCheckpoint domain
+func (s *CheckpointStatement) digest() []byte {
+	data := bytes.Join([][]byte{
+		IntToHex(int64(s.Height)),
+		s.BlockHash,
+		s.UTXOCommitment,
+		IntToHex(s.Timestamp),
+		s.PubKey,
+	}, []byte{})
+
+	return domainHash(DomainCheckpoint, data)
+}
+
+// Verify verifies the statement is signed by the key in PubKey
+func (s *CheckpointStatement) Verify() bool {
+	pubKey, ok := identityPublicKey(s.PubKey)
+
+	return ok && ecdsa.VerifyASN1(pubKey, s.digest(), s.Signature)
+}
+
+// String returns a human-readable representation of the statement
+func (s *CheckpointStatement) String() string {
+	return fmt.Sprintf("checkpoint height=%d block=%x utxo=%x time=%s signer=%x",
+		s.Height, s.BlockHash, s.UTXOCommitment, time.Unix(s.Timestamp, 0).UTC().Format(time.RFC3339), s.PubKey)
+}
+
+// SignCheckpoint returns a statement of the current tip and UTXO set
+// commitment signed with the node identity
+func (bc *Blockchain) SignCheckpoint(identity *NodeIdentity) (*CheckpointStatement, error) {
+	statement := &CheckpointStatement{Timestamp: time.Now().Unix(), PubKey: identity.PublicKey}
+
+	if err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := b.Get([]byte(tipDbKey))
+
+		if !bytes.Equal(utxoTip(tx), tip) {
+			return fmt.Errorf("%w: utxo set is at %x, tip is %x", ErrUTXOSetStale, utxoTip(tx), tip)
+		}
+
+		statement.Height = DeserializeBlock(b.Get(tip)).Height
+		statement.BlockHash = tip
+		statement.UTXOCommitment = utxoCommitment(tx)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	signature, err := ecdsa.SignASN1(rand.Reader, &identity.PrivateKey, statement.digest())
+	if err != nil {
+		return nil, err
+	}
+	statement.Signature = signature
+
+	return statement, nil
+}
+
+// CheckpointSubscription periodically emits signed checkpoint statements
+type CheckpointSubscription struct {
+	// C receives the statements, they are dropped while the receiver is behind
+	C <-chan *CheckpointStatement
+
+	done chan struct{}
+	once sync.Once
+}
+
+// SubscribeCheckpoints emits a signed checkpoint statement every interval
+// until the subscription is stopped
+func (bc *Blockchain) SubscribeCheckpoints(identity *NodeIdentity, interval time.Duration) *CheckpointSubscription {
+	ch := make(chan *CheckpointStatement, 1)
+	sub := &CheckpointSubscription{C: ch, done: make(chan struct{})}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer close(ch)
+
+		for {
+			select {
+			case <-sub.done:
+				return
+			case <-ticker.C:
+			}
+
+			statement, err := bc.SignCheckpoint(identity)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			select {
+			case ch <- statement:
+			default:
+				log.Printf("Dropping checkpoint statement at height %d, subscriber is behind\n", statement.Height)
+			}
+		}
+	}()
+
+	return sub
+}
+
+// Stop stops emitting statements and closes C
+func (s *CheckpointSubscription) Stop() {
+	s.once.Do(func() { close(s.done) })
+}
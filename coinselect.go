@@ -0,0 +1,242 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// defaultBranchAndBoundTries is the number of branches a BranchAndBoundSelector explores by default
+const defaultBranchAndBoundTries = 100000
+
+// SpendableOutput is an unspent output a CoinSelector may spend
+type SpendableOutput struct {
+	TxID  []byte
+	VOut  int
+	Value int
+}
+
+// CoinSelector chooses the outputs funding a transaction
+type CoinSelector interface {
+	// Select returns outputs whose values reach target, or an error
+	// wrapping ErrInsufficientFunds
+	Select(outputs []SpendableOutput, target int) ([]SpendableOutput, error)
+}
+
+// FirstFitSelector spends the outputs in the given order until the target
+// is reached, it is how FindSpendableOutputs selects
+type FirstFitSelector struct{}
+
+// Select implements CoinSelector
+func (FirstFitSelector) Select(outputs []SpendableOutput, target int) ([]SpendableOutput, error) {
+	return accumulateOutputs(outputs, target)
+}
+
+// LargestFirstSelector spends the largest outputs first, it spends few
+// inputs and keeps transactions small
+type LargestFirstSelector struct{}
+
+// Select implements CoinSelector
+func (LargestFirstSelector) Select(outputs []SpendableOutput, target int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput{}, outputs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value > sorted[j].Value })
+
+	return accumulateOutputs(sorted, target)
+}
+
+// SmallestFirstSelector spends the smallest outputs first, it consolidates
+// the UTXOs of a wallet while fees are low
+type SmallestFirstSelector struct{}
+
+// Select implements CoinSelector
+func (SmallestFirstSelector) Select(outputs []SpendableOutput, target int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput{}, outputs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+
+	return accumulateOutputs(sorted, target)
+}
+
+// RandomSelector spends outputs in random order, it hides which outputs a
+// wallet prefers
+type RandomSelector struct {
+	// Rand is the source of randomness, a time seeded source when nil
+	Rand *rand.Rand
+}
+
+// Select implements CoinSelector
+func (s RandomSelector) Select(outputs []SpendableOutput, target int) ([]SpendableOutput, error) {
+	r := s.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	shuffled := append([]SpendableOutput{}, outputs...)
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	return accumulateOutputs(shuffled, target)
+}
+
+// BranchAndBoundSelector searches the outputs whose values sum to the
+// target up to CostOfChange more, so the transaction needs no change
+// output, preferring the smallest excess. Fallback selects when there is
+// no such outputs.
+type BranchAndBoundSelector struct {
+	// CostOfChange is the excess left to the miner rather than paid as change
+	CostOfChange int
+
+	// MaxTries bounds the branches explored, 100000 when zero
+	MaxTries int
+
+	// Fallback selects when no changeless spend is found, LargestFirstSelector when nil
+	Fallback CoinSelector
+}
+
+// Select implements CoinSelector
+func (s BranchAndBoundSelector) Select(outputs []SpendableOutput, target int) ([]SpendableOutput, error) {
+	if selected, ok := s.search(outputs, target); ok {
+		return selected, nil
+	}
+
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = LargestFirstSelector{}
+	}
+
+	return fallback.Select(outputs, target)
+}
+
+// search explores including or excluding each output from the largest,
+// pruning branches over the target plus the cost of change or unable to
+// reach the target
+func (s BranchAndBoundSelector) search(outputs []SpendableOutput, target int) ([]SpendableOutput, bool) {
+	sorted := append([]SpendableOutput{}, outputs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value > sorted[j].Value })
+
+	// remaining[i] is the value of the outputs from i on
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+
+	maxTries := s.MaxTries
+	if maxTries == 0 {
+		maxTries = defaultBranchAndBoundTries
+	}
+
+	tries := 0
+	best, bestExcess := []int(nil), -1
+	var included []int
+
+	var explore func(i, sum int)
+	explore = func(i, sum int) {
+		if tries >= maxTries || bestExcess == 0 {
+			return
+		}
+		tries++
+
+		if sum > target+s.CostOfChange || sum+remaining[i] < target {
+			return
+		}
+
+		if sum >= target {
+			if excess := sum - target; bestExcess < 0 || excess < bestExcess {
+				best, bestExcess = append([]int{}, included...), excess
+			}
+			return
+		}
+
+		if i == len(sorted) {
+			return
+		}
+
+		included = append(included, i)
+		explore(i+1, sum+sorted[i].Value)
+		included = included[:len(included)-1]
+
+		explore(i+1, sum)
+	}
+	explore(0, 0)
+
+	if bestExcess < 0 {
+		return nil, false
+	}
+
+	selected := make([]SpendableOutput, 0, len(best))
+	for _, i := range best {
+		selected = append(selected, sorted[i])
+	}
+
+	return selected, true
+}
+
+// accumulateOutputs spends the outputs in order until their value reaches target
+func accumulateOutputs(outputs []SpendableOutput, target int) ([]SpendableOutput, error) {
+	var selected []SpendableOutput
+	accumulated := 0
+
+	for _, out := range outputs {
+		if accumulated >= target {
+			break
+		}
+
+		selected = append(selected, out)
+		accumulated += out.Value
+	}
+
+	if accumulated < target {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, accumulated, target)
+	}
+
+	return selected, nil
+}
+
+// SpendableOutputs returns the unspent outputs locked with the public key hash
+func (u UTXOSet) SpendableOutputs(pubKeyHash []byte) []SpendableOutput {
+	var outputs []SpendableOutput
+
+	if !u.Blockchain.utxoFilter.mayContain(u.Blockchain.db, pubKeyHash) {
+		return outputs
+	}
+
+	if err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(utxoBucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := DeserializeOutputs(v)
+
+			for outIdx, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					outputs = append(outputs, SpendableOutput{TxID: append([]byte{}, k...), VOut: outs.Index(outIdx), Value: out.Value})
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		log.Panic(err)
+	}
+
+	return outputs
+}
+
+// SelectSpendableOutputs selects unspent outputs locked with the public key
+// hash reaching amount, they are returned like by FindSpendableOutputs
+func (u UTXOSet) SelectSpendableOutputs(pubKeyHash []byte, amount int, selector CoinSelector) (int, map[string][]int, error) {
+	selected, err := selector.Select(u.SpendableOutputs(pubKeyHash), amount)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	accumulated := 0
+	unspentOutputs := make(map[string][]int)
+	for _, out := range selected {
+		txID := hex.EncodeToString(out.TxID)
+		unspentOutputs[txID] = append(unspentOutputs[txID], out.VOut)
+		accumulated += out.Value
+	}
+
+	return accumulated, unspentOutputs, nil
+}
@@ -0,0 +1,382 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// minColdSeedLen is the minimum length of a cold wallet seed
+const minColdSeedLen = 16
+
+// coldKeyDomain separates the cold wallet key derivation from other uses of the seed
+const coldKeyDomain = "blockchain cold wallet key"
+
+var (
+	// ErrInvalidDescriptor is returned when a watch-only descriptor can't be parsed
+	ErrInvalidDescriptor = errors.New("invalid watch-only descriptor")
+
+	// ErrInvalidSpendRequest is returned when a spend request doesn't match its previous transactions
+	ErrInvalidSpendRequest = errors.New("invalid spend request")
+)
+
+// ColdWallet derives indexed receive keys from a seed, it is kept on an
+// offline machine which only exports the public keys and signs spends
+type ColdWallet struct {
+	seed  []byte
+	curve KeyCurve
+	keys  []*Wallet
+}
+
+// NewColdWallet creates a ColdWallet from a seed of at least 16 bytes
+func NewColdWallet(seed []byte, curve KeyCurve) (*ColdWallet, error) {
+	if len(seed) < minColdSeedLen {
+		return nil, fmt.Errorf("seed has %d bytes, needs at least %d", len(seed), minColdSeedLen)
+	}
+
+	return &ColdWallet{seed: append([]byte{}, seed...), curve: curve}, nil
+}
+
+// GenerateColdWallet creates a ColdWallet from a random seed
+func GenerateColdWallet(curve KeyCurve) *ColdWallet {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		log.Panic(err)
+	}
+
+	return &ColdWallet{seed: seed, curve: curve}
+}
+
+// Seed returns the seed, it must be backed up to restore the keys
+func (cw *ColdWallet) Seed() []byte {
+	return append([]byte{}, cw.seed...)
+}
+
+// Len returns the number of generated keys
+func (cw *ColdWallet) Len() int {
+	return len(cw.keys)
+}
+
+// Key returns the key at index, deriving the keys up to it
+func (cw *ColdWallet) Key(index uint32) *Wallet {
+	for uint32(len(cw.keys)) <= index {
+		cw.keys = append(cw.keys, deriveColdKey(cw.seed, cw.curve, uint32(len(cw.keys))))
+	}
+
+	return cw.keys[index]
+}
+
+// Pregenerate derives the next n keys and returns their addresses
+func (cw *ColdWallet) Pregenerate(n int) []string {
+	addresses := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		addresses = append(addresses, string(cw.Key(uint32(len(cw.keys))).GetAddress()))
+	}
+
+	return addresses
+}
+
+// deriveColdKey derives the private key at index from the seed
+func deriveColdKey(seed []byte, curve KeyCurve, index uint32) *Wallet {
+	c := curve.Curve()
+	n := new(big.Int).Sub(c.Params().N, big.NewInt(1))
+
+	mac := hmac.New(sha512.New, []byte(coldKeyDomain))
+	mac.Write(seed)
+	if err := binary.Write(mac, binary.BigEndian, index); err != nil {
+		log.Panic(err)
+	}
+
+	d := new(big.Int).SetBytes(mac.Sum(nil))
+	d.Mod(d, n)
+	d.Add(d, big.NewInt(1))
+
+	private := ecdsa.PrivateKey{D: d}
+	private.PublicKey.Curve = c
+	private.PublicKey.X, private.PublicKey.Y = c.ScalarBaseMult(d.Bytes())
+
+	return &Wallet{PrivateKey: private, PublicKey: encodePublicKey(&private.PublicKey)}
+}
+
+// KeyFor returns the private key of a generated key hashing to pubKeyHash
+func (cw *ColdWallet) KeyFor(pubKeyHash []byte) (*ecdsa.PrivateKey, bool) {
+	for _, key := range cw.keys {
+		if private, ok := key.KeyFor(pubKeyHash); ok {
+			return private, true
+		}
+	}
+
+	return nil, false
+}
+
+// WatchOnlyKey is a public key of a watch-only descriptor
+type WatchOnlyKey struct {
+	Index  uint32
+	PubKey []byte
+}
+
+// WatchOnlyDescriptor lists the public keys of a ColdWallet, the online node
+// uses it to watch payments and prepare spends without the private keys
+type WatchOnlyDescriptor struct {
+	Keys []WatchOnlyKey
+}
+
+// WatchOnly exports the public keys generated so far
+func (cw *ColdWallet) WatchOnly() *WatchOnlyDescriptor {
+	d := &WatchOnlyDescriptor{}
+	for i, key := range cw.keys {
+		d.Keys = append(d.Keys, WatchOnlyKey{Index: uint32(i), PubKey: key.PublicKey})
+	}
+
+	return d
+}
+
+// String encodes the descriptor one key per line as pkh(<public key>)/<index>
+func (d *WatchOnlyDescriptor) String() string {
+	lines := make([]string, 0, len(d.Keys))
+	for _, key := range d.Keys {
+		lines = append(lines, fmt.Sprintf("pkh(%x)/%d", key.PubKey, key.Index))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ParseWatchOnlyDescriptor parses a descriptor encoded by String
+func ParseWatchOnlyDescriptor(s string) (*WatchOnlyDescriptor, error) {
+	d := &WatchOnlyDescriptor{}
+
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		sep := strings.LastIndex(line, ")/")
+		if !strings.HasPrefix(line, "pkh(") || sep < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDescriptor, line)
+		}
+
+		pubKey, err := hex.DecodeString(line[len("pkh("):sep])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrInvalidDescriptor, line, err)
+		}
+
+		if _, err = parsePublicKey(publicKeyCurve(pubKey), pubKey); err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrInvalidDescriptor, line, err)
+		}
+
+		index, err := strconv.ParseUint(line[sep+2:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrInvalidDescriptor, line, err)
+		}
+
+		d.Keys = append(d.Keys, WatchOnlyKey{Index: uint32(index), PubKey: pubKey})
+	}
+
+	return d, scanner.Err()
+}
+
+// Addresses returns the addresses of the keys
+func (d *WatchOnlyDescriptor) Addresses() []string {
+	addresses := make([]string, 0, len(d.Keys))
+	for _, key := range d.Keys {
+		addresses = append(addresses, string(encodeAddress(version, HashPubKey(key.PubKey))))
+	}
+
+	return addresses
+}
+
+// PubKeyHashes returns the public key hashes of the keys
+func (d *WatchOnlyDescriptor) PubKeyHashes() [][]byte {
+	hashes := make([][]byte, 0, len(d.Keys))
+	for _, key := range d.Keys {
+		hashes = append(hashes, HashPubKey(key.PubKey))
+	}
+
+	return hashes
+}
+
+// SpendRequest is an unsigned transaction with the transactions it spends,
+// prepared online and carried to the cold machine to be signed
+type SpendRequest struct {
+	Tx      *Transaction
+	PrevTXs map[string]Transaction
+}
+
+// NewSpendRequest prepares an unsigned transaction paying amount to an
+// address from the outputs of the descriptor keys, the change goes to the
+// change address or to the first key when it is empty
+func (d *WatchOnlyDescriptor) NewSpendRequest(to string, amount, fee int, changeAddress string, utxoSet *UTXOSet) (*SpendRequest, error) {
+	if len(d.Keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrInvalidDescriptor)
+	}
+
+	if changeAddress == "" {
+		changeAddress = d.Addresses()[0]
+	}
+
+	return newSpendRequest(d.PubKeyHashes(), []Payment{{Address: to, Amount: amount}}, fee, changeAddress, utxoSet)
+}
+
+// newSpendRequest prepares an unsigned transaction paying every payment
+// from the outputs locked with the public key hashes, the change goes to
+// the change address
+func newSpendRequest(pubKeyHashes [][]byte, payments []Payment, fee int, changeAddress string, utxoSet *UTXOSet) (*SpendRequest, error) {
+	if len(payments) == 0 || fee < 0 {
+		return nil, fmt.Errorf("invalid payments or fee %d", fee)
+	}
+
+	amount := 0
+	for _, payment := range payments {
+		if payment.Amount <= 0 {
+			return nil, fmt.Errorf("invalid amount %d to %s", payment.Amount, payment.Address)
+		}
+		amount += payment.Amount
+	}
+
+	bc := utxoSet.Blockchain
+	builder := NewTxBuilder(bc.Params(), bc.GetBestHeight()+1)
+
+	acc := 0
+	for _, pubKeyHash := range pubKeyHashes {
+		found, outputs := utxoSet.FindSpendableOutputs(pubKeyHash, amount+fee-acc)
+		for txID, outs := range outputs {
+			id, err := hex.DecodeString(txID)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, out := range outs {
+				builder.AddInput(id, out)
+			}
+		}
+
+		if acc += found; acc >= amount+fee {
+			break
+		}
+	}
+
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, acc, amount+fee)
+	}
+
+	for _, payment := range payments {
+		builder.AddOutput(payment.Amount, payment.Address)
+	}
+
+	if change := acc - amount - fee; change > 0 {
+		builder.AddOutput(change, changeAddress)
+	}
+
+	tx, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	prevTXs, err := bc.findPrevTransactions(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpendRequest{Tx: tx, PrevTXs: prevTXs}, nil
+}
+
+// NewPartiallySignedSpend prepares a spend like NewSpendRequest as a
+// PartiallySignedTx, the cold machine signs it with SignKeystore without
+// the chain and the online node finalizes and broadcasts it
+func (d *WatchOnlyDescriptor) NewPartiallySignedSpend(to string, amount, fee int, changeAddress string, utxoSet *UTXOSet) (*PartiallySignedTx, error) {
+	r, err := d.NewSpendRequest(to, amount, fee, changeAddress, utxoSet)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPartiallySignedTx(r.Tx, r.PrevTXs, utxoSet.Blockchain.opts.params.KeyCurve)
+}
+
+// Serialize encodes the spend request
+func (r *SpendRequest) Serialize() []byte {
+	var buff bytes.Buffer
+
+	if err := gob.NewEncoder(&buff).Encode(r); err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeSpendRequest decodes a spend request
+func DeserializeSpendRequest(data []byte) (*SpendRequest, error) {
+	var r SpendRequest
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// SignSpend signs the inputs of a spend request locked to the cold wallet
+// keys and returns how many were signed. The previous transactions are
+// checked against their ids so the online machine can't misstate the
+// amounts being spent.
+func (cw *ColdWallet) SignSpend(r *SpendRequest) (int, error) {
+	if r.Tx == nil || r.Tx.IsCoinbase() {
+		return 0, fmt.Errorf("%w: no transaction to sign", ErrInvalidSpendRequest)
+	}
+
+	if err := r.Tx.validatePrevTXs(r.PrevTXs); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidSpendRequest, err)
+	}
+
+	for id, prevTX := range r.PrevTXs {
+		if hex.EncodeToString(prevTX.Hash()) != id {
+			return 0, fmt.Errorf("%w: previous transaction %s doesn't match its id", ErrInvalidSpendRequest, id)
+		}
+	}
+
+	for _, vin := range r.Tx.VIn {
+		if vin.VOut < 0 || vin.VOut >= len(r.PrevTXs[hex.EncodeToString(vin.TxID)].VOut) {
+			return 0, fmt.Errorf("%w: input spends unknown output %x:%d", ErrInvalidSpendRequest, vin.TxID, vin.VOut)
+		}
+	}
+
+	keys := make(map[string]*Wallet, len(cw.keys))
+	for _, key := range cw.keys {
+		keys[hex.EncodeToString(HashPubKey(key.PublicKey))] = key
+	}
+
+	signed := 0
+	signers := make(map[string]bool)
+	for _, vin := range r.Tx.VIn {
+		prevOut := prevOutput(vin, r.PrevTXs)
+		pubKeyHash := prevOut.PubKeyHash()
+		if pubKeyHash == nil {
+			continue
+		}
+
+		id := hex.EncodeToString(pubKeyHash)
+		if key, ok := keys[id]; ok {
+			if !signers[id] {
+				if err := r.Tx.Sign(KeystoreSigner(key), r.PrevTXs); err != nil {
+					return 0, err
+				}
+				signers[id] = true
+			}
+			signed++
+		}
+	}
+
+	return signed, nil
+}
@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// derSequenceTag is the ASN.1 tag of the signature sequence
+	derSequenceTag = 0x30
+
+	// derIntegerTag is the ASN.1 tag of the r and s integers
+	derIntegerTag = 0x02
+
+	// minDERSignatureLen is the length of a signature with one byte integers
+	minDERSignatureLen = 8
+
+	// maxDERSignatureLen is the length of a signature with 33 byte integers
+	maxDERSignatureLen = 72
+)
+
+// ErrInvalidSignatureEncoding is returned when a signature isn't strictly DER encoded
+var ErrInvalidSignatureEncoding = errors.New("invalid signature encoding")
+
+// encodeDERSignature encodes r and s as a DER sequence of two integers
+func encodeDERSignature(r, s *big.Int) []byte {
+	rb, sb := derInteger(r), derInteger(s)
+
+	sig := make([]byte, 0, 6+len(rb)+len(sb))
+	sig = append(sig, derSequenceTag, byte(4+len(rb)+len(sb)))
+	sig = append(sig, derIntegerTag, byte(len(rb)))
+	sig = append(sig, rb...)
+	sig = append(sig, derIntegerTag, byte(len(sb)))
+
+	return append(sig, sb...)
+}
+
+// derInteger returns the minimal big endian bytes of a positive integer,
+// prefixed with a zero byte when its high bit is set
+func derInteger(x *big.Int) []byte {
+	b := x.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0x00}, b...)
+	}
+
+	return b
+}
+
+// parseDERSignature parses a strictly DER encoded signature, the encoding
+// must be the only one of r and s: minimal lengths, positive integers
+// without padding and no trailing data
+func parseDERSignature(sig []byte) (r, s *big.Int, err error) {
+	if len(sig) < minDERSignatureLen || len(sig) > maxDERSignatureLen {
+		return nil, nil, fmt.Errorf("%w: length %d", ErrInvalidSignatureEncoding, len(sig))
+	}
+
+	if sig[0] != derSequenceTag || int(sig[1]) != len(sig)-2 {
+		return nil, nil, fmt.Errorf("%w: bad sequence", ErrInvalidSignatureEncoding)
+	}
+
+	rb, rest, err := parseDERInteger(sig[2:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sb, rest, err := parseDERInteger(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("%w: trailing data", ErrInvalidSignatureEncoding)
+	}
+
+	return new(big.Int).SetBytes(rb), new(big.Int).SetBytes(sb), nil
+}
+
+// parseDERInteger parses a positive minimally encoded integer and returns
+// its bytes and the remaining data
+func parseDERInteger(data []byte) ([]byte, []byte, error) {
+	if len(data) < 3 || data[0] != derIntegerTag {
+		return nil, nil, fmt.Errorf("%w: bad integer", ErrInvalidSignatureEncoding)
+	}
+
+	length := int(data[1])
+	if length == 0 || 2+length > len(data) {
+		return nil, nil, fmt.Errorf("%w: bad integer length", ErrInvalidSignatureEncoding)
+	}
+
+	b := data[2 : 2+length]
+	if b[0]&0x80 != 0 {
+		return nil, nil, fmt.Errorf("%w: negative integer", ErrInvalidSignatureEncoding)
+	}
+
+	if length > 1 && b[0] == 0x00 && b[1]&0x80 == 0 {
+		return nil, nil, fmt.Errorf("%w: padded integer", ErrInvalidSignatureEncoding)
+	}
+
+	return b, data[2+length:], nil
+}
+
+// checkSignatureEncoding checks a script signature is empty or strictly DER
+// encoded followed by its hash type, a badly encoded signature fails the
+// script instead of being a failed check so it can't be malleated
+func checkSignatureEncoding(sig []byte) error {
+	if len(sig) == 0 {
+		return nil
+	}
+
+	_, _, err := parseDERSignature(sig[:len(sig)-1])
+	return err
+}
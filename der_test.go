@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParseDERSignature(t *testing.T) {
+	tests := []struct {
+		name  string
+		sig   string
+		r, s  int64
+		valid bool
+	}{
+		{"one byte integers", "3006020101020102", 1, 2, true},
+		{"padded high bit", "30080202008002020081", 0x80, 0x81, true},
+		{"two byte integers", "300802020100020201ff", 0x100, 0x1ff, true},
+		{"too short", "30050201010200", 0, 0, false},
+		{"not a sequence", "3106020101020102", 0, 0, false},
+		{"sequence length too long", "3007020101020102", 0, 0, false},
+		{"sequence length too short", "3005020101020102", 0, 0, false},
+		{"r not an integer", "3006030101020102", 0, 0, false},
+		{"s not an integer", "3006020101030102", 0, 0, false},
+		{"empty r", "3006020002020102", 0, 0, false},
+		{"empty s", "300702020101020001", 0, 0, false},
+		{"r past the end", "3006020501020102", 0, 0, false},
+		{"s past the end", "3006020101020302", 0, 0, false},
+		{"negative r", "3006020181020102", 0, 0, false},
+		{"negative s", "3006020101020182", 0, 0, false},
+		{"padded r", "300702020001020102", 0, 0, false},
+		{"padded s", "300702010102020002", 0, 0, false},
+		{"trailing data", "300902010102010200", 0, 0, false},
+		{"trailing data after the sequence", "300602010102010200", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sig, err := hex.DecodeString(test.sig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, s, err := parseDERSignature(sig)
+			if !test.valid {
+				if !errors.Is(err, ErrInvalidSignatureEncoding) {
+					t.Fatalf("error %v, want %v", err, ErrInvalidSignatureEncoding)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Int64() != test.r || s.Int64() != test.s {
+				t.Fatalf("r %d s %d, want %d %d", r, s, test.r, test.s)
+			}
+			if encoded := encodeDERSignature(r, s); !bytes.Equal(encoded, sig) {
+				t.Errorf("encoded %x, want %x", encoded, sig)
+			}
+		})
+	}
+
+	long := append([]byte{derSequenceTag, maxDERSignatureLen - 1}, make([]byte, maxDERSignatureLen-1)...)
+	if _, _, err := parseDERSignature(long); !errors.Is(err, ErrInvalidSignatureEncoding) {
+		t.Errorf("signature of %d bytes: error %v, want %v", len(long), err, ErrInvalidSignatureEncoding)
+	}
+}
+
+// highS returns a DER signature with the s of sig replaced by n - s, it
+// verifies with ECDSA but isn't canonical
+func highS(t *testing.T, key *ecdsa.PublicKey, hash, sig []byte) []byte {
+	t.Helper()
+
+	r, s, err := parseDERSignature(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := key.Curve.Params().N
+	if isHighS(n, s) {
+		t.Fatalf("signed with a high s %x", s)
+	}
+
+	s = new(big.Int).Sub(n, s)
+	if !ecdsa.Verify(key, hash, r, s) {
+		t.Fatal("the high s signature doesn't verify")
+	}
+
+	return encodeDERSignature(r, s)
+}
+
+func TestHighSSignaturesAreRejected(t *testing.T) {
+	wallet := NewWallet()
+	key, err := parsePublicKey(CurveP256, wallet.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("transaction", func(t *testing.T) {
+		prevOut := *NewTXOutput(10, string(wallet.GetAddress()))
+		tx := newSigHashTestTX()
+		if err := tx.SignPrevOutputs(KeystoreSigner(wallet), []TXOutput{prevOut, prevOut}, SigHashAll); err != nil {
+			t.Fatal(err)
+		}
+		checker := &txSigChecker{tx: tx, index: 0, curve: CurveP256}
+
+		if err := VerifyScript(tx.VIn[0].ScriptSig, prevOut.ScriptPubKey, checker); err != nil {
+			t.Fatalf("low s signature rejected: %v", err)
+		}
+
+		ops, err := parseScript(tx.VIn[0].ScriptSig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := ops[0].data[:len(ops[0].data)-1]
+		hash := tx.signatureHash(0, prevOut.ScriptPubKey, SigHashAll)
+
+		high := append(highS(t, key, hash, sig), byte(SigHashAll))
+		scriptSig := NewScriptBuilder().AddData(high).AddData(wallet.PublicKey).Script()
+		if err := VerifyScript(scriptSig, prevOut.ScriptPubKey, checker); !errors.Is(err, ErrScriptFailed) {
+			t.Fatalf("high s signature: error %v, want %v", err, ErrScriptFailed)
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		address, message := string(wallet.GetAddress()), "hello"
+		signature := wallet.SignMessage(message)
+		if err := VerifyMessage(CurveP256, address, message, signature); err != nil {
+			t.Fatalf("low s signature rejected: %v", err)
+		}
+
+		prefix := 1 + int(signature[0])
+		high := append(signature[:prefix:prefix], highS(t, key, messageHash(message), signature[prefix:])...)
+		if err := VerifyMessage(CurveP256, address, message, high); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("high s signature: error %v, want %v", err, ErrInvalidSignature)
+		}
+	})
+}
+
+func TestSignaturesAreLowS(t *testing.T) {
+	for i := 0; i < 32; i++ {
+		wallet := NewWallet()
+		hash := messageHash(string(rune('a' + i)))
+
+		r, s, err := parseDERSignature(signHash(wallet.PrivateKey, hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isHighS(wallet.PrivateKey.Curve.Params().N, s) {
+			t.Fatalf("signature with a high s %x", s)
+		}
+		if !ecdsa.Verify(&wallet.PrivateKey.PublicKey, hash, r, s) {
+			t.Fatal("signature doesn't verify")
+		}
+	}
+}
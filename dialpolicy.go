@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+// DialPolicy configures how a server dials its outbound peers and when it
+// gives up on a known node
+type DialPolicy struct {
+	// Timeout is how long connecting to a node may take
+	Timeout time.Duration
+
+	// Retries is the number of times a failed dial is retried at once,
+	// RetryDelay after the first failure and twice the delay after each
+	// next one
+	Retries    int
+	RetryDelay time.Duration
+
+	// Backoff is how long a node isn't dialed again after a failed dial,
+	// doubled on every failure in a row up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction the delays are randomized by, 0.25 spreads
+	// them over ±25%, so nodes don't retry in lockstep
+	Jitter float64
+
+	// MaxFailures is the number of failed dials in a row after which a
+	// node is removed from the known nodes
+	MaxFailures int
+}
+
+// DefaultDialPolicy is the dial policy of servers configured without one
+var DefaultDialPolicy = DialPolicy{
+	Timeout:     peerDialTimeout,
+	Retries:     2,
+	RetryDelay:  time.Second,
+	Backoff:     peerDialInterval,
+	MaxBackoff:  30 * time.Minute,
+	Jitter:      0.25,
+	MaxFailures: 5,
+}
+
+// retryDelay returns the delay before a retry of a dial, the first one
+// is 1
+func (p DialPolicy) retryDelay(retry int) time.Duration {
+	return p.jitter(doubled(p.RetryDelay, retry-1, 0))
+}
+
+// backoff returns how long a node isn't dialed after failures in a row
+func (p DialPolicy) backoff(failures int) time.Duration {
+	return p.jitter(doubled(p.Backoff, failures-1, p.MaxBackoff))
+}
+
+// jitter randomizes a delay by the Jitter fraction of it
+func (p DialPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+
+	return d + time.Duration((rand.Float64()*2-1)*p.Jitter*float64(d))
+}
+
+// doubled returns d doubled n times, capped at max unless max is 0
+func doubled(d time.Duration, n int, max time.Duration) time.Duration {
+	for i := 0; i < n; i++ {
+		if max > 0 && d >= max/2 {
+			return max
+		}
+		d *= 2
+	}
+
+	if max > 0 && d > max {
+		return max
+	}
+
+	return d
+}
+
+// dialWithRetry dials a node with the transport, retrying failed dials
+// with the delays of the dial policy. It gives up when the server stops.
+func (s *Server) dialWithRetry(addr string) (net.Conn, error) {
+	policy := s.cfg.DialPolicy
+
+	conn, err := s.transport.Dial(addr, policy.Timeout)
+	for retry := 1; err != nil && retry <= policy.Retries; retry++ {
+		select {
+		case <-s.done:
+			return nil, err
+		case <-time.After(policy.retryDelay(retry)):
+		}
+
+		conn, err = s.transport.Dial(addr, policy.Timeout)
+	}
+
+	return conn, err
+}
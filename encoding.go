@@ -0,0 +1,320 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// ErrMalformedTransaction is returned for data which isn't a canonical transaction
+var ErrMalformedTransaction = errors.New("malformed transaction")
+
+// The hashed data is encoded with fixed-width integers of a defined byte
+// order, so hashes don't depend on the architecture or the Go version:
+//   - block headers hash big endian 64-bit integers, see IntToHex
+//   - signature hashes and canonical transactions use little endian
+//     integers and length-prefixed byte strings, see SerializeCanonical
+//
+// Legacy transactions are hashed from their gob encoding, which is kept for
+// the transactions already in chains. The gob encoding is only stable for a
+// fixed set of types and field order, the golden vectors of encoding_test.go
+// pin it. Chains activating FeatureCanonicalTx refuse legacy transactions
+// from the activation height, coinbases included, so new transactions never
+// depend on it there.
+
+// init encodes the hashed gob types before anything else, gob numbers the
+// types in the order a process first encodes them and legacy transaction
+// hashes and UTXO commitments would otherwise depend on what the process
+// encoded before
+func init() {
+	for _, v := range []interface{}{Transaction{}, TXOutputs{}} {
+		if err := gob.NewEncoder(ioutil.Discard).Encode(v); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// writeVarInt writes an unsigned integer in 1, 3, 5 or 9 bytes
+func writeVarInt(w io.Writer, n uint64) {
+	var buf []byte
+
+	switch {
+	case n < 0xfd:
+		buf = []byte{byte(n)}
+	case n <= 0xffff:
+		buf = make([]byte, 3)
+		buf[0] = 0xfd
+		binary.LittleEndian.PutUint16(buf[1:], uint16(n))
+	case n <= 0xffffffff:
+		buf = make([]byte, 5)
+		buf[0] = 0xfe
+		binary.LittleEndian.PutUint32(buf[1:], uint32(n))
+	default:
+		buf = make([]byte, 9)
+		buf[0] = 0xff
+		binary.LittleEndian.PutUint64(buf[1:], n)
+	}
+
+	writeBytes(w, buf)
+}
+
+// writeVarBytes writes data prefixed with its length
+func writeVarBytes(w io.Writer, data []byte) {
+	writeVarInt(w, uint64(len(data)))
+	writeBytes(w, data)
+}
+
+// writeUint32 writes a little endian uint32
+func writeUint32(w io.Writer, n uint32) {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, n)
+	writeBytes(w, buf)
+}
+
+// writeInt64 writes a little endian int64 in two's complement
+func writeInt64(w io.Writer, n int64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(n))
+	writeBytes(w, buf)
+}
+
+// writeBytes writes data as is
+func writeBytes(w io.Writer, data []byte) {
+	if _, err := w.Write(data); err != nil {
+		log.Panic(err)
+	}
+}
+
+// readVarInt reads an unsigned integer written by writeVarInt, only the
+// shortest encoding of a value is accepted
+func readVarInt(r *bytes.Reader) (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var n, min uint64
+	switch prefix {
+	case 0xfd:
+		buf, err := readBytes(r, 2)
+		if err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.LittleEndian.Uint16(buf)), 0xfd
+	case 0xfe:
+		buf, err := readBytes(r, 4)
+		if err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.LittleEndian.Uint32(buf)), 0x10000
+	case 0xff:
+		buf, err := readBytes(r, 8)
+		if err != nil {
+			return 0, err
+		}
+		n, min = binary.LittleEndian.Uint64(buf), 0x100000000
+	default:
+		return uint64(prefix), nil
+	}
+
+	if n < min {
+		return 0, fmt.Errorf("varint %d isn't minimally encoded", n)
+	}
+
+	return n, nil
+}
+
+// readVarBytes reads data written by writeVarBytes
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return readBytes(r, int(n))
+}
+
+// readUint32 reads a little endian uint32
+func readUint32(r *bytes.Reader) (uint32, error) {
+	buf, err := readBytes(r, 4)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+// readInt64 reads a little endian int64 in two's complement
+func readInt64(r *bytes.Reader) (int64, error) {
+	buf, err := readBytes(r, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// readBytes reads n bytes
+func readBytes(r *bytes.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return buf, nil
+}
+
+// SerializeCanonical returns the canonical encoding of the transaction:
+//   - the version as a uint32
+//   - the number of inputs as a varint, then for each input its txid as
+//     var bytes, its vout as a uint32 (0xffffffff for coinbase), its
+//     scriptSig as var bytes and its sequence as a uint32
+//   - the number of outputs as a varint, then for each output its value as
+//     an int64 and its scriptPubKey as var bytes
+//   - the locktime as a uint32
+//
+// Integers are little endian, varints are written like in signature hashes.
+func (tx *Transaction) SerializeCanonical() []byte {
+	return tx.serializeCanonical(true)
+}
+
+// serializeCanonical returns the canonical encoding of the transaction,
+// the scriptSigs of non coinbase inputs are left empty unless withScriptSigs
+func (tx *Transaction) serializeCanonical(withScriptSigs bool) []byte {
+	var buf bytes.Buffer
+	coinbase := tx.IsCoinbase()
+
+	writeUint32(&buf, uint32(tx.Version))
+
+	writeVarInt(&buf, uint64(len(tx.VIn)))
+	for _, vin := range tx.VIn {
+		writeVarBytes(&buf, vin.TxID)
+		writeUint32(&buf, uint32(vin.VOut))
+		if withScriptSigs || coinbase {
+			writeVarBytes(&buf, vin.ScriptSig)
+		} else {
+			writeVarBytes(&buf, nil)
+		}
+		writeUint32(&buf, vin.Sequence)
+	}
+
+	writeVarInt(&buf, uint64(len(tx.VOut)))
+	for _, out := range tx.VOut {
+		writeInt64(&buf, int64(out.Value))
+		writeVarBytes(&buf, out.ScriptPubKey)
+	}
+
+	writeUint32(&buf, tx.LockTime)
+
+	return buf.Bytes()
+}
+
+// DeserializeCanonical decodes a transaction encoded by SerializeCanonical,
+// its id is computed from the decoded fields
+func DeserializeCanonical(data []byte) (*Transaction, error) {
+	tx, err := deserializeCanonical(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedTransaction, err)
+	}
+
+	tx.ID = tx.Hash()
+	return tx, nil
+}
+
+// deserializeCanonical reads the fields of a canonical transaction, the
+// whole data must be consumed
+func deserializeCanonical(r *bytes.Reader) (*Transaction, error) {
+	version, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	tx := &Transaction{Version: int(version)}
+
+	count, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > uint64(r.Len()) {
+		return nil, fmt.Errorf("%d inputs past the end", count)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		var vin TXInput
+		if vin.TxID, err = readVarBytes(r); err != nil {
+			return nil, err
+		}
+
+		vout, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		vin.VOut = int(int32(vout))
+
+		if vin.ScriptSig, err = readVarBytes(r); err != nil {
+			return nil, err
+		}
+
+		if vin.Sequence, err = readUint32(r); err != nil {
+			return nil, err
+		}
+
+		tx.VIn = append(tx.VIn, vin)
+	}
+
+	if count, err = readVarInt(r); err != nil {
+		return nil, err
+	}
+
+	if count > uint64(r.Len()) {
+		return nil, fmt.Errorf("%d outputs past the end", count)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		value, err := readInt64(r)
+		if err != nil {
+			return nil, err
+		}
+
+		out := TXOutput{Value: int(value)}
+		if out.ScriptPubKey, err = readVarBytes(r); err != nil {
+			return nil, err
+		}
+
+		tx.VOut = append(tx.VOut, out)
+	}
+
+	if tx.LockTime, err = readUint32(r); err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", r.Len())
+	}
+
+	return tx, nil
+}
+
+// isCanonical returns whether the transaction is hashed from its canonical encoding
+func (tx *Transaction) isCanonical() bool {
+	return tx.Version >= TxVersionCanonical
+}
+
+// merkleLeaf returns the data of the transaction's leaf in the merkle tree
+// of its block, canonical transactions commit to their txid and wtxid
+func (tx *Transaction) merkleLeaf() []byte {
+	if tx.isCanonical() {
+		return append(tx.Hash(), tx.WitnessHash()...)
+	}
+
+	return tx.Serialize()
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// goldenTx is the transaction of the golden vectors
+func goldenTx(version int) *Transaction {
+	return &Transaction{
+		VIn: []TXInput{
+			{TxID: bytes.Repeat([]byte{0x11}, 32), VOut: 1, ScriptSig: []byte{0xaa, 0xbb}, Sequence: 0xfffffffe},
+			{TxID: bytes.Repeat([]byte{0x22}, 32), VOut: 0, ScriptSig: []byte{0xcc}, Sequence: SequenceFinal},
+		},
+		VOut: []TXOutput{
+			{Value: 5000, ScriptPubKey: []byte{0x76, 0xa9}},
+			{Value: 1, ScriptPubKey: nil},
+		},
+		Version:  version,
+		LockTime: 300,
+	}
+}
+
+func TestWriteVarIntGolden(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "00"},
+		{0xfc, "fc"},
+		{0xfd, "fdfd00"},
+		{0xffff, "fdffff"},
+		{0x10000, "fe00000100"},
+		{0xffffffff, "feffffffff"},
+		{0x100000000, "ff0000000001000000"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeVarInt(&buf, test.n)
+		if got := hex.EncodeToString(buf.Bytes()); got != test.want {
+			t.Errorf("varint %#x encoded as %s, want %s", test.n, got, test.want)
+		}
+
+		n, err := readVarInt(bytes.NewReader(buf.Bytes()))
+		if err != nil || n != test.n {
+			t.Errorf("varint %s decoded as %#x, %v", test.want, n, err)
+		}
+	}
+}
+
+func TestCanonicalTransactionGolden(t *testing.T) {
+	tx := goldenTx(TxVersionCanonical)
+
+	const encoded = "01000000" + "02" +
+		"20" + "1111111111111111111111111111111111111111111111111111111111111111" + "01000000" + "02aabb" + "feffffff" +
+		"20" + "2222222222222222222222222222222222222222222222222222222222222222" + "00000000" + "01cc" + "ffffffff" +
+		"02" + "8813000000000000" + "0276a9" + "0100000000000000" + "00" +
+		"2c010000"
+
+	if got := hex.EncodeToString(tx.SerializeCanonical()); got != encoded {
+		t.Errorf("canonical encoding\n%s, want\n%s", got, encoded)
+	}
+
+	decoded, err := DeserializeCanonical(tx.SerializeCanonical())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.SerializeCanonical(), tx.SerializeCanonical()) {
+		t.Error("decoded transaction encodes differently")
+	}
+
+	vectors := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"txid", tx.Hash(), "2975a3e89c3716df5f19ebd2bdd4d2bc153d5735614a396eee049dc7d0f2e63a"},
+		{"wtxid", tx.WitnessHash(), "3a404425e6f5517e7b8d7ed2f02b876f7079388d7cf5f6fbfcb4652f5cdf4878"},
+		{"signature hash", tx.signatureHash(0, []byte{0x51}, SigHashAll), "30307fca1c88e96bd79404f34a755157b0383fe4b666c48b0c36e24dd9eb794d"},
+	}
+	for _, v := range vectors {
+		if got := hex.EncodeToString(v.got); got != v.want {
+			t.Errorf("%s %s, want %s", v.name, got, v.want)
+		}
+	}
+}
+
+func TestLegacyTransactionGolden(t *testing.T) {
+	tx := goldenTx(TxVersionLegacy)
+
+	vectors := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"txid", tx.Hash(), "5a4111f84535394e61bbafa07bc64476a2ff2f8b58a25528e1974d484afb5c78"},
+		{"wtxid", tx.WitnessHash(), "6db382d22fa25eebc8588dec36d8e2be3f09cf25d16a7b10089f419b597118e2"},
+		{"signature hash", tx.signatureHash(0, []byte{0x51}, SigHashAll), "88be7b64e47ad303ba2698a0ba0f2e535038ae74eab7e50704c8b27f7570c9c4"},
+	}
+	for _, v := range vectors {
+		if got := hex.EncodeToString(v.got); got != v.want {
+			t.Errorf("%s %s, want %s", v.name, got, v.want)
+		}
+	}
+}
+
+func TestBlockHeaderGolden(t *testing.T) {
+	block := &Block{
+		Timestamp:      1600000000,
+		Transactions:   []*Transaction{goldenTx(TxVersionCanonical)},
+		PrevBlockHash:  bytes.Repeat([]byte{0x33}, 32),
+		UTXOCommitment: []byte{0x44},
+		Height:         300,
+	}
+
+	const header = "3333333333333333333333333333333333333333333333333333333333333333" +
+		"038508ef40db8d4cd8804aab838a7e2896ea020c5991890a3df8f239c4e3ae19" +
+		"44" + "000000000000012c" + "000000005f5e1000" + "0000000000000008" + "0000000000000007"
+
+	if got := hex.EncodeToString(NewProofOfWorkWithBits(block, 8).prepareData(7)); got != header {
+		t.Errorf("header\n%s, want\n%s", got, header)
+	}
+}
+
+func TestLegacyTransactionsRefusedAfterCanonicalActivation(t *testing.T) {
+	params := MainNetParams
+	params.Activations = map[Feature]int{FeatureCanonicalTx: 10}
+
+	if err := checkBlockTxVersion(TxVersionLegacy, &params, 9); err != nil {
+		t.Errorf("legacy transaction refused before the activation: %v", err)
+	}
+	if err := checkBlockTxVersion(TxVersionLegacy, &params, 10); !errors.Is(err, ErrLegacyTxVersion) {
+		t.Errorf("error %v, want %v", err, ErrLegacyTxVersion)
+	}
+	if err := checkBlockTxVersion(TxVersionCanonical, &params, 10); err != nil {
+		t.Errorf("canonical transaction refused: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package blockchain
+
+// ChainEventType is the type of a ChainEvent
+type ChainEventType int
+
+const (
+	// BlockConnected is sent when a block becomes part of the main chain
+	BlockConnected ChainEventType = iota + 1
+
+	// BlockDisconnected is sent when a block is removed from the main chain by a reorg
+	BlockDisconnected
+)
+
+// ChainEvent notifies a change of the main chain, during a reorg the old
+// blocks are disconnected from the tip down before the new ones are
+// connected from the fork point up
+type ChainEvent struct {
+	Type  ChainEventType
+	Block *Block
+}
+
+// Subscribe registers fn to be called with the chain events, in order and
+// after the changes are committed
+func (bc *Blockchain) Subscribe(fn func(ChainEvent)) {
+	bc.subscribersMu.Lock()
+	defer bc.subscribersMu.Unlock()
+
+	bc.subscribers = append(bc.subscribers, fn)
+}
+
+// notify sends the events to the subscribers
+func (bc *Blockchain) notify(events []ChainEvent) {
+	bc.subscribersMu.Lock()
+	subscribers := append([]func(ChainEvent){}, bc.subscribers...)
+	bc.subscribersMu.Unlock()
+
+	for _, event := range events {
+		for _, fn := range subscribers {
+			fn(event)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package faucet dispenses small amounts of coins of a test network to the
+// addresses requested over HTTP, with per IP and per address rate limits
+// and a captcha hook.
+package faucet
+
+import (
+	"blockchain"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultInterval is the default time between two payouts to an IP or an address
+	defaultInterval = 24 * time.Hour
+
+	// addressParam is the request parameter of the address to pay
+	addressParam = "address"
+)
+
+var (
+	// ErrRateLimited is returned when an IP or an address was paid too recently
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrInvalidAddress is returned for a request of an invalid address
+	ErrInvalidAddress = errors.New("invalid address")
+
+	// ErrCaptchaFailed is returned when the captcha hook rejects a request
+	ErrCaptchaFailed = errors.New("captcha failed")
+)
+
+// Sender sends the payouts, e.g. a lightclient.Client of the faucet wallet
+type Sender interface {
+	Send(to string, amount, fee int) (*blockchain.Transaction, error)
+}
+
+// CaptchaVerifier checks the captcha solution of a request
+type CaptchaVerifier interface {
+	Verify(r *http.Request) error
+}
+
+// CaptchaVerifierFunc is a function verifying captchas
+type CaptchaVerifierFunc func(r *http.Request) error
+
+// Verify implements CaptchaVerifier
+func (f CaptchaVerifierFunc) Verify(r *http.Request) error {
+	return f(r)
+}
+
+// Config configures a Faucet
+type Config struct {
+	// Amount is paid to each requested address
+	Amount int
+
+	// Fee is left to the miner by each payout
+	Fee int
+
+	// IPInterval is the time between two payouts to an IP, 24 hours when zero
+	IPInterval time.Duration
+
+	// AddressInterval is the time between two payouts to an address, 24 hours when zero
+	AddressInterval time.Duration
+
+	// Captcha verifies the requests when set
+	Captcha CaptchaVerifier
+
+	// TrustProxy takes the IP of a request from the X-Forwarded-For header
+	// set by a reverse proxy
+	TrustProxy bool
+}
+
+// Faucet dispenses coins to requested addresses
+type Faucet struct {
+	sender Sender
+	cfg    Config
+
+	mu        sync.Mutex
+	byIP      map[string]time.Time
+	byAddress map[string]time.Time
+}
+
+// New creates a Faucet paying with the sender
+func New(sender Sender, cfg Config) *Faucet {
+	if cfg.IPInterval == 0 {
+		cfg.IPInterval = defaultInterval
+	}
+
+	if cfg.AddressInterval == 0 {
+		cfg.AddressInterval = defaultInterval
+	}
+
+	return &Faucet{sender: sender, cfg: cfg, byIP: make(map[string]time.Time), byAddress: make(map[string]time.Time)}
+}
+
+// Dispense pays the configured amount to an address requested from an IP
+func (f *Faucet) Dispense(ip, address string) (*blockchain.Transaction, error) {
+	if _, err := blockchain.ParseAddress(address); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, err)
+	}
+
+	now := time.Now()
+	if err := f.reserve(ip, address, now); err != nil {
+		return nil, err
+	}
+
+	tx, err := f.sender.Send(address, f.cfg.Amount, f.cfg.Fee)
+	if err != nil {
+		f.release(ip, address, now)
+		return nil, err
+	}
+
+	log.Printf("faucet paid %d to %s requested from %s in %x\n", f.cfg.Amount, address, ip, tx.ID)
+	return tx, nil
+}
+
+// reserve records a payout to an IP and an address unless one of them was
+// paid within its interval
+func (f *Faucet) reserve(ip, address string, now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.prune(now)
+
+	if last, ok := f.byIP[ip]; ok {
+		return &RateLimitError{Key: ip, RetryAfter: last.Add(f.cfg.IPInterval).Sub(now)}
+	}
+
+	if last, ok := f.byAddress[address]; ok {
+		return &RateLimitError{Key: address, RetryAfter: last.Add(f.cfg.AddressInterval).Sub(now)}
+	}
+
+	f.byIP[ip] = now
+	f.byAddress[address] = now
+
+	return nil
+}
+
+// release forgets a reserved payout which failed
+func (f *Faucet) release(ip, address string, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.byIP[ip].Equal(at) {
+		delete(f.byIP, ip)
+	}
+
+	if f.byAddress[address].Equal(at) {
+		delete(f.byAddress, address)
+	}
+}
+
+// prune forgets the payouts older than their interval
+func (f *Faucet) prune(now time.Time) {
+	for ip, last := range f.byIP {
+		if now.Sub(last) >= f.cfg.IPInterval {
+			delete(f.byIP, ip)
+		}
+	}
+
+	for address, last := range f.byAddress {
+		if now.Sub(last) >= f.cfg.AddressInterval {
+			delete(f.byAddress, address)
+		}
+	}
+}
+
+// RateLimitError is returned when an IP or an address was paid too recently
+type RateLimitError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+// Error implements error
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s can request again in %s", ErrRateLimited, e.Key, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap returns ErrRateLimited
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// response is the JSON body of a faucet HTTP response
+type response struct {
+	TxID   string `json:"txid,omitempty"`
+	Amount int    `json:"amount,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ServeHTTP dispenses coins to the address parameter of POST requests
+func (f *Faucet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeResponse(w, http.StatusMethodNotAllowed, response{Error: "use POST"})
+		return
+	}
+
+	if f.cfg.Captcha != nil {
+		if err := f.cfg.Captcha.Verify(r); err != nil {
+			writeResponse(w, http.StatusForbidden, response{Error: fmt.Sprintf("%s: %s", ErrCaptchaFailed, err)})
+			return
+		}
+	}
+
+	tx, err := f.Dispense(f.remoteIP(r), strings.TrimSpace(r.FormValue(addressParam)))
+	if err != nil {
+		var rateLimit *RateLimitError
+		switch {
+		case errors.As(err, &rateLimit):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimit.RetryAfter.Seconds()))))
+			writeResponse(w, http.StatusTooManyRequests, response{Error: err.Error()})
+		case errors.Is(err, ErrInvalidAddress):
+			writeResponse(w, http.StatusBadRequest, response{Error: err.Error()})
+		default:
+			log.Printf("faucet payout failed: %s\n", err)
+			writeResponse(w, http.StatusInternalServerError, response{Error: "payout failed"})
+		}
+		return
+	}
+
+	writeResponse(w, http.StatusOK, response{TxID: hex.EncodeToString(tx.ID), Amount: f.cfg.Amount})
+}
+
+// remoteIP returns the IP a request comes from
+func (f *Faucet) remoteIP(r *http.Request) string {
+	if f.cfg.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// writeResponse writes a JSON response with a status code
+func writeResponse(w http.ResponseWriter, status int, body response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println(err)
+	}
+}
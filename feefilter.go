@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"math"
+)
+
+const (
+	// CommandFeeFilter fee filter, the minimum fee rate of the transactions
+	// relayed to the sender
+	CommandFeeFilter = "feefilter"
+
+	// invalidFeeFilterScore is the misbehaviour score of a negative or not
+	// finite fee filter
+	invalidFeeFilterScore = 20
+)
+
+type feeFilterData struct {
+	AddrFrom string
+	FeeRate  float64
+}
+
+// sendFeeFilter sends the minimum relay fee rate of the mempool to a peer
+func (s *Server) sendFeeFilter(p *peerConn, rate float64) {
+	p.send(CommandFeeFilter, feeFilterData{AddrFrom: s.address, FeeRate: rate})
+}
+
+// announceFeeFilter sends the minimum relay fee rate to every peer when it
+// changed since it was last announced
+func (s *Server) announceFeeFilter() {
+	rate := s.mempool.Policy().MinRelayFeeRate
+
+	s.mu.Lock()
+	changed := rate != s.feeFilter
+	s.feeFilter = rate
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	for _, p := range s.manager.ready() {
+		s.sendFeeFilter(p, rate)
+	}
+}
+
+// handleFeeFilter handles CommandFeeFilter request, transactions paying a
+// lower fee rate aren't relayed to the peer anymore
+func (s *Server) handleFeeFilter(p *peerConn, request []byte) {
+	var payload feeFilterData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	if payload.FeeRate < 0 || math.IsNaN(payload.FeeRate) || math.IsInf(payload.FeeRate, 0) {
+		s.misbehaving(p, invalidFeeFilterScore, "invalid fee filter")
+		return
+	}
+
+	s.manager.setFeeFilter(p, payload.FeeRate)
+}
+
+// txFeeRate returns the fee rate of a transaction, false when the outputs
+// it spends aren't known
+func (s *Server) txFeeRate(tx *Transaction) (float64, bool) {
+	if entry, ok := s.mempool.Entry(tx.ID); ok {
+		return entry.FeeRate(), true
+	}
+
+	fee, err := s.bc.TransactionFee(tx)
+	if err != nil {
+		return 0, false
+	}
+
+	return feeRate(fee, len(tx.Serialize())), true
+}
@@ -0,0 +1,45 @@
+package blockchain
+
+import "sort"
+
+// DefaultFeeHistogramBands are the lower fee rate bounds of the default
+// histogram bands, in fee units per byte
+var DefaultFeeHistogramBands = []float64{0, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89, 144}
+
+// FeeBand is a fee rate band of the mempool fee histogram, it holds the
+// transactions paying at least MinFeeRate and less than the next band
+type FeeBand struct {
+	MinFeeRate float64
+	Count      int
+	Size       int
+	Fees       int
+}
+
+// FeeHistogram returns the mempool transactions grouped by fee rate in bands
+// starting at the lower bounds, the bands are sorted by increasing fee rate.
+// Transactions paying less than the lowest bound are not counted.
+func (mp *Mempool) FeeHistogram(bounds []float64) []FeeBand {
+	if len(bounds) == 0 {
+		bounds = DefaultFeeHistogramBands
+	}
+
+	bands := make([]FeeBand, len(bounds))
+	for i, bound := range bounds {
+		bands[i].MinFeeRate = bound
+	}
+	sort.Slice(bands, func(i, j int) bool { return bands[i].MinFeeRate < bands[j].MinFeeRate })
+
+	for _, entry := range mp.Entries() {
+		rate := entry.FeeRate()
+		i := sort.Search(len(bands), func(i int) bool { return bands[i].MinFeeRate > rate }) - 1
+		if i < 0 {
+			continue
+		}
+
+		bands[i].Count++
+		bands[i].Size += entry.Size
+		bands[i].Fees += entry.Fee
+	}
+
+	return bands
+}
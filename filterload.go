@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"fmt"
+)
+
+const (
+	// CommandFilterLoad filter load, only the transactions matching the
+	// bloom filter are relayed to the sender
+	CommandFilterLoad = "filterload"
+
+	// CommandFilterAdd filter add, adds an item to the loaded bloom filter
+	CommandFilterAdd = "filteradd"
+
+	// CommandFilterClear filter clear, removes the bloom filter
+	CommandFilterClear = "filterclear"
+
+	// CommandMerkleBlock merkle block, the header of a block with the proofs
+	// of its transactions matching the bloom filter
+	CommandMerkleBlock = "merkleblock"
+
+	// CommandGetDataTypeFilteredBlock is the inventory type of blocks
+	// requested as CommandMerkleBlock
+	CommandGetDataTypeFilteredBlock = "filteredblock"
+
+	// MaxFilterLoadSize is the maximum size of the bits of a loaded bloom filter
+	MaxFilterLoadSize = 36000
+
+	// MaxFilterAddSize is the maximum size of an item added to a bloom filter
+	MaxFilterAddSize = 520
+
+	// filterLoadMessagePayload is the largest payload of CommandFilterLoad
+	filterLoadMessagePayload = MaxFilterLoadSize + smallMessagePayload
+)
+
+// FilterLoadMessage loads the bloom filter of a connection
+type FilterLoadMessage struct {
+	AddrFrom string
+	Filter   BloomFilter
+}
+
+// FilterAddMessage adds an item to the bloom filter of a connection, e.g.
+// the public key hash of a new address
+type FilterAddMessage struct {
+	AddrFrom string
+	Data     []byte
+}
+
+// FilterClearMessage removes the bloom filter of a connection
+type FilterClearMessage struct {
+	AddrFrom string
+}
+
+// MerkleBlockMessage is the response to a CommandGetDataTypeFilteredBlock
+// request, the proofs of the transactions of a block matching the filter
+type MerkleBlockMessage struct {
+	AddrFrom string
+	Header   BlockHeader
+	Matches  []TxProof
+}
+
+// Verify checks the proof of work of the header at a difficulty and the
+// proofs of the matched transactions, which are returned
+func (m *MerkleBlockMessage) Verify(bits int) ([]*Transaction, error) {
+	if err := m.Header.Validate(bits); err != nil {
+		return nil, err
+	}
+
+	var txs []*Transaction
+	for i := range m.Matches {
+		tx, err := m.Matches[i].Verify(m.Header)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+// handleFilterLoad handles CommandFilterLoad request, a filter too large
+// or with too many hash functions is misbehaviour
+func (s *Server) handleFilterLoad(p *peerConn, request []byte) {
+	var payload FilterLoadMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	filter := payload.Filter
+	if len(filter.Bits) > MaxFilterLoadSize || filter.HashFuncs > maxBloomHashFuncs {
+		s.misbehaving(p, banThreshold, fmt.Sprintf("bloom filter of %d bytes and %d hash functions", len(filter.Bits), filter.HashFuncs))
+		return
+	}
+
+	s.manager.setFilter(p, &filter)
+}
+
+// handleFilterAdd handles CommandFilterAdd request, adding to a filter
+// which wasn't loaded or an item too large is misbehaviour
+func (s *Server) handleFilterAdd(p *peerConn, request []byte) {
+	var payload FilterAddMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	if len(payload.Data) > MaxFilterAddSize {
+		s.misbehaving(p, banThreshold, fmt.Sprintf("bloom filter item of %d bytes", len(payload.Data)))
+		return
+	}
+
+	if !s.manager.addToFilter(p, payload.Data) {
+		s.misbehaving(p, banThreshold, "bloom filter item without filter")
+	}
+}
+
+// handleFilterClear handles CommandFilterClear request, every transaction
+// is relayed to the peer again
+func (s *Server) handleFilterClear(p *peerConn, request []byte) {
+	var payload FilterClearMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	s.manager.setFilter(p, nil)
+}
+
+// sendMerkleBlock sends the header of a block and the proofs of its
+// transactions matching the filter of a peer
+func (s *Server) sendMerkleBlock(p *peerConn, block *Block) {
+	var data [][]byte
+	for _, t := range block.Transactions {
+		data = append(data, t.merkleLeaf())
+	}
+
+	response := MerkleBlockMessage{AddrFrom: s.address, Header: block.Header()}
+	for i, t := range block.Transactions {
+		if s.manager.matchesFilter(p, t) {
+			response.Matches = append(response.Matches, TxProof{
+				BlockHash:   block.Hash,
+				Height:      block.Height,
+				Transaction: t.Serialize(),
+				Proof:       NewMerkleProof(data, i),
+			})
+		}
+	}
+
+	p.send(CommandMerkleBlock, response)
+}
@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package blockchain
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rtfGateway is the flag of the routes through a gateway
+const rtfGateway = 0x2
+
+// defaultGateway returns the gateway of the default IPv4 route, read from
+// the kernel routing table
+func defaultGateway() (net.IP, error) {
+	raw, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(raw), "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[1] != "00000000" {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&rtfGateway == 0 {
+			continue
+		}
+
+		// the address is printed in host byte order, little endian on
+		// the platforms the node runs on
+		gateway, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			continue
+		}
+
+		ip := make(net.IP, net.IPv4len)
+		binary.LittleEndian.PutUint32(ip, uint32(gateway))
+		return ip, nil
+	}
+
+	return nil, ErrNoGateway
+}
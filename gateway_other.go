@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package blockchain
+
+import "net"
+
+// defaultGateway isn't available without the Linux routing table, NAT-PMP
+// needs NewNATPMPMapper with the gateway address
+func defaultGateway() (net.IP, error) {
+	return nil, ErrNoGateway
+}
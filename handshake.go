@@ -0,0 +1,304 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// minProtocolVersion is the oldest protocol version of the peers, those
+	// before it don't answer versions with veracks
+	minProtocolVersion = 2
+
+	// DefaultUserAgent is the user agent of nodes configured without one
+	DefaultUserAgent = "/blockchain:0.2.0/"
+
+	// handshakeTimeout is how long a peer may take to complete the handshake
+	handshakeTimeout = 10 * time.Second
+)
+
+// ErrIncompatibleVersion is returned when a node speaks an older protocol version
+var ErrIncompatibleVersion = errors.New("incompatible protocol version")
+
+// ServiceFlag is a bitfield of the services a node offers its peers
+type ServiceFlag uint64
+
+const (
+	// ServiceNetwork is set by nodes serving the full blocks
+	ServiceNetwork ServiceFlag = 1 << iota
+
+	// ServiceLight is set by nodes serving headers and proofs to light clients
+	ServiceLight
+
+	// ServiceCompactFilters is set by nodes serving compact block filters
+	ServiceCompactFilters
+
+	// defaultServices are the services of a full node
+	defaultServices = ServiceNetwork | ServiceLight | ServiceCompactFilters
+)
+
+// Has returns whether the services include all of the services of s
+func (f ServiceFlag) Has(s ServiceFlag) bool {
+	return f&s == s
+}
+
+// String returns the names of the services
+func (f ServiceFlag) String() string {
+	var names []string
+	if f.Has(ServiceNetwork) {
+		names = append(names, "network")
+	}
+	if f.Has(ServiceLight) {
+		names = append(names, "light")
+	}
+	if f.Has(ServiceCompactFilters) {
+		names = append(names, "cfilters")
+	}
+	if unknown := f &^ defaultServices; unknown != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint64(unknown)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// newNonce returns a random version nonce, a node receiving its own nonce
+// is connected to itself
+func newNonce() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Panic(err)
+	}
+
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// sendVersion sends the version, services and current height of the node
+// to a peer
+func (s *Server) sendVersion(p *peerConn) {
+	p.send(CommandVersion, versionData{
+		Version:    nodeVersion,
+		Services:   defaultServices,
+		UserAgent:  s.cfg.UserAgent,
+		BestHeight: s.bc.GetBestHeight(),
+		Nonce:      s.nonce,
+		AddrFrom:   s.address,
+	})
+}
+
+// handleVersion handles CommandVersion request. A peer of an older protocol
+// version, an outbound peer not serving blocks or the node itself is
+// disconnected, as is one of two connections to the same node. Others are
+// answered with CommandVerack, preceded by the version of the node for
+// inbound peers.
+func (s *Server) handleVersion(p *peerConn, request []byte) {
+	var payload versionData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	if p.version != nil {
+		s.misbehaving(p, unknownCommandScore, "duplicate version")
+		return
+	}
+
+	switch {
+	case payload.Nonce == s.nonce:
+		log.Printf("Disconnecting %s, it is the node itself\n", p)
+		s.markSelf(p)
+		p.close()
+		return
+	case payload.Version < minProtocolVersion:
+		log.Printf("Disconnecting %s, protocol version %d is older than %d\n", p, payload.Version, minProtocolVersion)
+		p.close()
+		return
+	case p.addr != "" && !payload.Services.Has(ServiceNetwork):
+		log.Printf("Disconnecting %s, services %s don't include network\n", p, payload.Services)
+		p.close()
+		return
+	case payload.AddrFrom != "" && s.peers.isBanned(payload.AddrFrom):
+		p.close()
+		return
+	}
+
+	if other := s.manager.setVersion(p, payload); other != nil {
+		if !s.replacesConnection(p, other, payload.Nonce) {
+			log.Printf("Disconnecting %s, the node is already connected as %s\n", p, other)
+			p.close()
+			return
+		}
+
+		log.Printf("Disconnecting %s, the node is connected again as %s\n", other, p)
+		other.close()
+	}
+	p.version = &payload
+
+	if p.addr == "" {
+		s.sendVersion(p)
+	}
+	p.send(CommandVerack, verackData{AddrFrom: s.address})
+
+	if p.handshaken() {
+		s.completeHandshake(p)
+	}
+}
+
+// handleVerack handles CommandVerack request
+func (s *Server) handleVerack(p *peerConn, request []byte) {
+	if p.verack {
+		s.misbehaving(p, unknownCommandScore, "duplicate verack")
+		return
+	}
+
+	p.verack = true
+	if p.handshaken() {
+		s.completeHandshake(p)
+	}
+}
+
+// replacesConnection returns whether a new connection p to the node of a
+// nonce replaces the connection other to it. The connection dialed by the
+// node of the lower nonce is kept, so both ends of crossed connections
+// keep the same one, the older one when the same node dialed both.
+func (s *Server) replacesConnection(p, other *peerConn, nonce uint64) bool {
+	dialer := func(c *peerConn) uint64 {
+		if c.addr != "" {
+			return s.nonce
+		}
+
+		return nonce
+	}
+
+	return dialer(p) < dialer(other)
+}
+
+// markSelf finds the outbound connection of the node to itself an inbound
+// connection p comes from, by its local address, and forgets the address
+// it dialed so it isn't dialed again
+func (s *Server) markSelf(p *peerConn) {
+	remote, ok := p.conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+
+	for _, out := range s.manager.connected() {
+		local, ok := out.conn.LocalAddr().(*net.TCPAddr)
+		if out.addr == "" || !ok || local.String() != remote.String() {
+			continue
+		}
+
+		log.Printf("%s is an address of the node itself\n", out.addr)
+		s.mu.Lock()
+		s.selfAddrs[out.addr] = true
+		s.mu.Unlock()
+
+		s.removeFromKnownNodes(out.addr)
+		out.close()
+	}
+}
+
+// isSelf returns whether an address is the advertised address of the node
+// or one it connected to itself at
+func (s *Server) isSelf(addr string) bool {
+	if addr == s.address {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.selfAddrs[addr]
+}
+
+// completeHandshake starts relaying with a peer, the peer becomes a known
+// node, is pinged to measure its latency, is sent the minimum relay fee
+// rate of the node and asked to announce new blocks with their headers. An outbound peer over Noise
+// must authenticate with the identity it had before. When its chain is
+// longer blocks are requested from the fastest peer with a longer chain.
+// The address records of an outbound peer are requested and the record of
+// the node is advertised to it. Its mempool is requested when the chain of
+// the node is as long as its chain, after the missing blocks arrived
+// otherwise.
+func (s *Server) completeHandshake(p *peerConn) {
+	if conn, ok := p.conn.(*noiseConn); ok {
+		identity := conn.RemoteIdentity()
+		if p.addr != "" && !s.pinIdentity(p.addr, identity) {
+			log.Printf("Disconnecting %s, its identity %x isn't the one it had\n", p, identity)
+			p.close()
+			return
+		}
+		s.manager.setIdentity(p, identity)
+	}
+
+	log.Printf("Handshake with %s %s completed, services %s\n", p, p.version.UserAgent, p.version.Services)
+	s.manager.setHandshaken(p)
+
+	if p.version.AddrFrom != "" {
+		s.addToKnownNodes(NetAddress{Addr: p.version.AddrFrom, Services: p.version.Services, Timestamp: time.Now()}, "version")
+	}
+
+	if p.addr != "" {
+		s.markSuccess(p.addr)
+		p.send(CommandGetAddr, getAddrData{AddrFrom: s.address})
+		if s.address != "" {
+			p.send(CommandAddr, addrData{AddrFrom: s.address, AddrList: []NetAddress{s.selfAddress()}})
+		}
+	}
+
+	s.ping(p)
+	s.sendFeeFilter(p, s.mempool.Policy().MinRelayFeeRate)
+	p.send(CommandSendHeaders, sendHeadersData{AddrFrom: s.address})
+
+	if height := s.bc.GetBestHeight(); height < p.version.BestHeight {
+		if sync := s.manager.syncPeer(height); sync != nil {
+			sync.send(CommandGetBlocks, getBlocksData{AddrFrom: s.address})
+		}
+	} else if p.addr != "" {
+		s.requestMempool(p)
+	}
+}
+
+// clientHandshake exchanges versions with a node of the network of a magic
+// as a client serving no services, before requests are sent on the
+// connection
+func clientHandshake(conn net.Conn, magic uint32) error {
+	version := versionData{Version: nodeVersion, UserAgent: DefaultUserAgent, Nonce: newNonce()}
+	if err := WriteNetworkMessage(conn, magic, EncodeMessage(CommandVersion, version)); err != nil {
+		return err
+	}
+
+	var gotVersion, gotVerack bool
+	for !gotVersion || !gotVerack {
+		message, err := ReadNetworkMessage(conn, magic)
+		if err != nil {
+			return err
+		}
+
+		switch command, _ := MessageCommand(message); command {
+		case CommandVersion:
+			var node versionData
+			if err = DecodeMessagePayload(message, &node); err != nil {
+				return err
+			}
+
+			if node.Version < minProtocolVersion {
+				return fmt.Errorf("%w: protocol version %d of %s is older than %d", ErrIncompatibleVersion, node.Version, conn.RemoteAddr(), minProtocolVersion)
+			}
+
+			if err = WriteNetworkMessage(conn, magic, EncodeMessage(CommandVerack, verackData{})); err != nil {
+				return err
+			}
+			gotVersion = true
+		case CommandVerack:
+			gotVerack = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,436 @@
+// Package harness runs several full nodes as child processes on local
+// ports for end-to-end tests of the networking code. It drives wallet
+// payments and mining, kills and restarts nodes, and waits for the tips and
+// UTXO sets of the running nodes to converge.
+//
+// The nodes are started by running the current executable again, so the
+// program using the harness, e.g. a test binary from its TestMain, must call
+// Main before anything else.
+package harness
+
+import (
+	"blockchain"
+	"blockchain/lightclient"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// envNodeID is the environment variable holding the id of a child node
+	envNodeID = "BLOCKCHAIN_HARNESS_NODE"
+
+	// envListen is the environment variable holding the address a child node listens on
+	envListen = "BLOCKCHAIN_HARNESS_LISTEN"
+
+	// envMiner is the environment variable holding the address a child node mines to
+	envMiner = "BLOCKCHAIN_HARNESS_MINER"
+
+	// envSeed is the environment variable holding the address of the first
+	// node, every node announces itself to it
+	envSeed = "BLOCKCHAIN_HARNESS_SEED"
+
+	// envGenesis is the environment variable holding the address the genesis
+	// block pays, a child creates the chain of its node and exits when set
+	envGenesis = "BLOCKCHAIN_HARNESS_GENESIS"
+
+	// defaultTimeout is the default wait for the nodes to converge
+	defaultTimeout = 30 * time.Second
+
+	// pollInterval is the time between two status checks of the nodes
+	pollInterval = 200 * time.Millisecond
+
+	// startPollInterval is the time between two checks of a starting node,
+	// short so it is queried as soon as it listens, before catching up
+	startPollInterval = 10 * time.Millisecond
+)
+
+// genesisTime is the timestamp of the genesis block of every node, so the
+// nodes share the genesis block
+var genesisTime = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	// ErrInconsistent is returned when the nodes don't converge in time
+	ErrInconsistent = errors.New("nodes are inconsistent")
+
+	// ErrNotRunning is returned when querying a node which isn't running
+	ErrNotRunning = errors.New("node is not running")
+)
+
+// Config configures a Harness
+type Config struct {
+	// Nodes is the number of nodes, 2 when zero
+	Nodes int
+
+	// Miners is the number of nodes, from the first, mining blocks once
+	// enough transactions are waiting
+	Miners int
+
+	// Dir is the working directory of the nodes, a temporary directory
+	// removed by Close when empty
+	Dir string
+
+	// Timeout is the wait for the nodes to converge or respond, 30 seconds when zero
+	Timeout time.Duration
+
+	// Executable is the program run as node, the current executable when
+	// empty, it must call Main
+	Executable string
+}
+
+// Harness runs the nodes and the wallet paid by the genesis block and the miners
+type Harness struct {
+	cfg     Config
+	tempDir bool
+	wallet  *blockchain.Wallet
+	nodes   []*Node
+	seed    string
+	probe   *probe
+
+	mu      sync.Mutex
+	client  *lightclient.Client
+	pending []*blockchain.Transaction
+}
+
+// Main runs a node and exits when the process was started by a Harness,
+// it returns immediately otherwise
+func Main() {
+	nodeID := os.Getenv(envNodeID)
+	if nodeID == "" {
+		return
+	}
+
+	if genesis := os.Getenv(envGenesis); genesis != "" {
+		blockchain.CreateBlockchain(genesis, nodeID,
+			blockchain.WithDeterministicMining(blockchain.DeterministicMining{GenesisTime: genesisTime}))
+		os.Exit(0)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	s := blockchain.NewServer(blockchain.ServerConfig{
+		NodeID:       nodeID,
+		MinerAddress: os.Getenv(envMiner),
+		Listeners:    []blockchain.ListenerConfig{{Network: "tcp", Address: os.Getenv(envListen)}},
+		Seeds:        []string{os.Getenv(envSeed)},
+	})
+	if err := s.Start(ctx); err != nil {
+		log.Panic(err)
+	}
+
+	if err := s.Wait(); err != nil {
+		log.Panic(err)
+	}
+	os.Exit(0)
+}
+
+// New creates the chains of the nodes and starts them. Each node listens on
+// a port picked by the system, kept across restarts, and the first node is
+// the seed of the others.
+func New(cfg Config) (*Harness, error) {
+	if cfg.Nodes == 0 {
+		cfg.Nodes = 2
+	}
+
+	if cfg.Miners > cfg.Nodes {
+		return nil, fmt.Errorf("%d miners for %d nodes", cfg.Miners, cfg.Nodes)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	if cfg.Executable == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Executable = executable
+	}
+
+	h := &Harness{cfg: cfg, wallet: blockchain.NewWallet(), probe: &probe{timeout: cfg.Timeout}}
+	if h.cfg.Dir == "" {
+		dir, err := ioutil.TempDir("", "blockchain-harness")
+		if err != nil {
+			return nil, err
+		}
+		h.cfg.Dir, h.tempDir = dir, true
+	}
+
+	for i := 0; i < cfg.Nodes; i++ {
+		address, err := freeAddress()
+		if err != nil {
+			_ = h.Close()
+			return nil, err
+		}
+
+		if i == 0 {
+			h.seed = address
+		}
+
+		node := &Node{ID: strconv.Itoa(i), Address: address, h: h}
+		if i < cfg.Miners {
+			node.miner = h.Address()
+		}
+
+		if err := node.create(); err != nil {
+			_ = h.Close()
+			return nil, err
+		}
+		h.nodes = append(h.nodes, node)
+	}
+
+	for _, node := range h.nodes {
+		if err := node.Start(); err != nil {
+			_ = h.Close()
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// Nodes returns the nodes
+func (h *Harness) Nodes() []*Node {
+	return h.nodes
+}
+
+// Node returns the node at index
+func (h *Harness) Node(i int) *Node {
+	return h.nodes[i]
+}
+
+// Address returns the address of the wallet paid by the genesis block and the miners
+func (h *Harness) Address() string {
+	return string(h.wallet.GetAddress())
+}
+
+// Send pays an address from the harness wallet through the running nodes.
+// The wallet is synced with the nodes first, and spends the change of its
+// unconfirmed payments so consecutive payments reach the miners together.
+func (h *Harness) Send(to string, amount, fee int) (*blockchain.Transaction, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client == nil {
+		peers := make([]string, 0, len(h.nodes))
+		for _, node := range h.nodes {
+			peers = append(peers, node.Address)
+		}
+
+		var err error
+		h.client, err = lightclient.New(h.wallet, lightclient.Config{Peers: peers, Timeout: h.cfg.Timeout})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.client.Sync(); err != nil {
+		return nil, err
+	}
+
+	builder := blockchain.NewTxBuilder(&blockchain.MainNetParams, h.client.Height()+1)
+	for _, out := range h.unspent() {
+		builder.AddCandidate(out.tx, out.vout)
+	}
+
+	tx, err := builder.AddOutput(amount, to).SetFee(fee).SetChangeAddress(h.Address()).Sign(blockchain.KeystoreSigner(h.wallet)).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	sent := 0
+	for _, node := range h.running() {
+		if err = blockchain.SendTransaction(node.Address, "", tx); err != nil {
+			log.Printf("sending %x to node %s failed: %s\n", tx.ID, node.ID, err)
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 {
+		return nil, fmt.Errorf("%w: %x wasn't sent", ErrNotRunning, tx.ID)
+	}
+
+	h.pending = append(h.pending, tx)
+	return tx, nil
+}
+
+// output is an output of a transaction
+type output struct {
+	tx   *blockchain.Transaction
+	vout int
+}
+
+// unspent returns the outputs of the harness wallet unspent by its synced
+// and pending transactions, the pending transactions synced in a block are
+// forgotten
+func (h *Harness) unspent() []output {
+	var txs []*blockchain.Transaction
+	confirmed := make(map[string]bool)
+	for _, tx := range h.client.Transactions() {
+		txs = append(txs, tx.Tx)
+		confirmed[hex.EncodeToString(tx.Tx.ID)] = true
+	}
+
+	pending := h.pending[:0]
+	for _, tx := range h.pending {
+		if !confirmed[hex.EncodeToString(tx.ID)] {
+			pending = append(pending, tx)
+			txs = append(txs, tx)
+		}
+	}
+	h.pending = pending
+
+	spent := make(map[string]bool)
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.VIn {
+			spent[fmt.Sprintf("%x:%d", vin.TxID, vin.VOut)] = true
+		}
+	}
+
+	pubKeyHash := blockchain.HashPubKey(h.wallet.PublicKey)
+	var outs []output
+	for _, tx := range txs {
+		for i := range tx.VOut {
+			if tx.VOut[i].IsLockedWithKey(pubKeyHash) && !spent[fmt.Sprintf("%x:%d", tx.ID, i)] {
+				outs = append(outs, output{tx: tx, vout: i})
+			}
+		}
+	}
+
+	return outs
+}
+
+// WaitConsistent waits until the running nodes have the same tip and UTXO
+// set, with the UTXO set on the tip, and returns their status
+func (h *Harness) WaitConsistent() ([]*Status, error) {
+	return h.wait(func(statuses []*Status) error {
+		for _, status := range statuses {
+			if !bytes.Equal(status.UTXOTip, status.Tip) {
+				return fmt.Errorf("node %s utxo set is at %x, tip is %x", status.ID, status.UTXOTip, status.Tip)
+			}
+
+			if !bytes.Equal(status.Tip, statuses[0].Tip) || !bytes.Equal(status.UTXOCommitment, statuses[0].UTXOCommitment) {
+				return fmt.Errorf("%s, %s", statuses[0], status)
+			}
+		}
+
+		return nil
+	})
+}
+
+// WaitHeight waits until the running nodes reach a height and returns their status
+func (h *Harness) WaitHeight(height int) ([]*Status, error) {
+	return h.wait(func(statuses []*Status) error {
+		for _, status := range statuses {
+			if status.Height < height {
+				return fmt.Errorf("node %s is at height %d", status.ID, status.Height)
+			}
+		}
+
+		return nil
+	})
+}
+
+// wait polls the status of the running nodes until check accepts them
+func (h *Harness) wait(check func(statuses []*Status) error) ([]*Status, error) {
+	deadline := time.Now().Add(h.cfg.Timeout)
+
+	for {
+		statuses, err := h.statuses()
+		if err == nil {
+			if err = check(statuses); err == nil {
+				return statuses, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrInconsistent, err)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// running returns the running nodes
+func (h *Harness) running() []*Node {
+	var running []*Node
+	for _, node := range h.nodes {
+		if node.Running() {
+			running = append(running, node)
+		}
+	}
+
+	return running
+}
+
+// statuses returns the status of the running nodes
+func (h *Harness) statuses() ([]*Status, error) {
+	running := h.running()
+	if len(running) == 0 {
+		return nil, ErrNotRunning
+	}
+
+	statuses := make([]*Status, 0, len(running))
+	for _, node := range running {
+		status, err := node.Status()
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.ID, err)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Close kills the nodes and removes the temporary directory
+func (h *Harness) Close() error {
+	var errs []string
+
+	for _, node := range h.nodes {
+		if node.Running() {
+			if err := node.Kill(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+
+	h.mu.Lock()
+	if h.client != nil {
+		if err := h.client.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	h.mu.Unlock()
+
+	if h.tempDir {
+		if err := os.RemoveAll(h.cfg.Dir); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	log.Printf("harness of %d nodes closed\n", len(h.nodes))
+	return nil
+}
@@ -0,0 +1,135 @@
+package harness
+
+import (
+	"blockchain"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	Main()
+	os.Exit(m.Run())
+}
+
+// pay sends two payments, enough for a miner to mine a block, and waits
+// for the running nodes to reach a height
+func pay(t *testing.T, h *Harness, to string, height int) {
+	t.Helper()
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.Send(to, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := h.WaitHeight(height); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.WaitConsistent(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNodesConvergeAfterKillAndRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs several nodes")
+	}
+
+	h, err := New(Config{Nodes: 3, Miners: 1, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for i, node := range h.Nodes() {
+		for _, other := range h.Nodes()[:i] {
+			if node.Address == other.Address {
+				t.Fatalf("nodes %s and %s share address %s", other.ID, node.ID, node.Address)
+			}
+		}
+	}
+
+	to := string(blockchain.NewWallet().GetAddress())
+	pay(t, h, to, 1)
+
+	killed := h.Node(2)
+	if err := killed.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := killed.Status(); err == nil {
+		t.Fatal("a killed node responded")
+	}
+
+	pay(t, h, to, 2)
+
+	if err := killed.Start(); err != nil {
+		t.Fatal(err)
+	}
+	status, err := killed.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Height < 1 {
+		t.Fatalf("restarted node at height %d, its chain wasn't kept", status.Height)
+	}
+
+	pay(t, h, to, 3)
+}
+
+func TestNodesConvergeAfterKillWhileCatchingUp(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs several nodes")
+	}
+
+	h, err := New(Config{Nodes: 3, Miners: 1, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	to := string(blockchain.NewWallet().GetAddress())
+	pay(t, h, to, 1)
+
+	killed := h.Node(2)
+	if err := killed.Kill(); err != nil {
+		t.Fatal(err)
+	}
+
+	// enough blocks for the restarted node to be caught while syncing them
+	const behind = 20
+	for height := 2; height <= 1+behind; height++ {
+		pay(t, h, to, height)
+	}
+
+	if err := killed.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		status, err := killed.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Height >= 1+behind {
+			t.Fatalf("restarted node caught up to height %d before it was killed", status.Height)
+		}
+		if status.Height > 1 {
+			break
+		}
+	}
+	if err := killed.Kill(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := killed.Start(); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := h.WaitConsistent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 || statuses[0].Height != 1+behind {
+		t.Fatalf("statuses %v, want 3 nodes at height %d", statuses, 1+behind)
+	}
+
+	pay(t, h, to, 2+behind)
+}
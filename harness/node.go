@@ -0,0 +1,269 @@
+package harness
+
+import (
+	"blockchain"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when a node doesn't respond in time
+var ErrTimeout = errors.New("node response timed out")
+
+// Node is a full node run as a child process, its chain is kept across restarts
+type Node struct {
+	ID      string
+	Address string
+
+	h     *Harness
+	miner string
+
+	mu   sync.Mutex
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// Status is the state of a node as seen through the network protocol
+type Status struct {
+	// ID is the id of the node
+	ID string
+
+	// Height is the height of the main chain tip
+	Height int
+
+	// Tip is the hash of the main chain tip
+	Tip []byte
+
+	// UTXOTip is the block the UTXO set was last updated to
+	UTXOTip []byte
+
+	// UTXOCommitment is the commitment to the UTXO set
+	UTXOCommitment []byte
+}
+
+// String returns the node, height and hashes of the status
+func (s *Status) String() string {
+	return fmt.Sprintf("node %s at height %d tip %x utxo set %x at %x", s.ID, s.Height, s.Tip, s.UTXOCommitment, s.UTXOTip)
+}
+
+// utxoProofMessage mirrors the response of a node to a getutxoproof command
+type utxoProofMessage struct {
+	AddrFrom   string
+	Tip        []byte
+	Commitment []byte
+	Proofs     []blockchain.UTXOProof
+	Error      string
+}
+
+// getUTXOProofMessage mirrors the getutxoproof command
+type getUTXOProofMessage struct {
+	AddrFrom string
+	TxIDs    [][]byte
+}
+
+// create creates the chain of the node with the genesis block shared by every node
+func (n *Node) create() error {
+	cmd := n.command()
+	cmd.Env = append(cmd.Env, envGenesis+"="+n.h.Address())
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("create node %s: %w", n.ID, err)
+	}
+
+	return nil
+}
+
+// command returns the command running the node, logging to node_<id>.log
+func (n *Node) command() *exec.Cmd {
+	cmd := exec.Command(n.h.cfg.Executable)
+	cmd.Dir = n.h.cfg.Dir
+	cmd.Env = append(os.Environ(),
+		envNodeID+"="+n.ID,
+		envListen+"="+n.Address,
+		envMiner+"="+n.miner,
+		envSeed+"="+n.h.seed,
+	)
+
+	return cmd
+}
+
+// Start starts the node and waits until it responds
+func (n *Node) Start() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.cmd != nil {
+		return fmt.Errorf("node %s is already running", n.ID)
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(n.h.cfg.Dir, "node_"+n.ID+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	cmd := n.command()
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		_ = logFile.Close()
+		return fmt.Errorf("start node %s: %w", n.ID, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("node %s exited: %s\n", n.ID, err)
+		}
+		_ = logFile.Close()
+		close(done)
+	}()
+	n.cmd, n.done = cmd, done
+
+	deadline := time.Now().Add(n.h.cfg.Timeout)
+	for {
+		if _, err = n.status(); err == nil {
+			return nil
+		}
+
+		select {
+		case <-done:
+			n.cmd = nil
+			return fmt.Errorf("node %s exited on start, see its log", n.ID)
+		case <-time.After(startPollInterval):
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node %s: %w", n.ID, err)
+		}
+	}
+}
+
+// Kill kills the node process without letting it shut down, the node can be started again
+func (n *Node) Kill() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.cmd == nil {
+		return fmt.Errorf("%w: %s", ErrNotRunning, n.ID)
+	}
+
+	if err := n.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-n.done
+
+	n.cmd = nil
+	return nil
+}
+
+// Restart kills the node and starts it again
+func (n *Node) Restart() error {
+	if err := n.Kill(); err != nil {
+		return err
+	}
+
+	return n.Start()
+}
+
+// Running returns whether the node process is running
+func (n *Node) Running() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.cmd == nil {
+		return false
+	}
+
+	select {
+	case <-n.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Status queries the tip and UTXO set of the node
+func (n *Node) Status() (*Status, error) {
+	if !n.Running() {
+		return nil, fmt.Errorf("%w: %s", ErrNotRunning, n.ID)
+	}
+
+	return n.status()
+}
+
+// status queries the main chain headers and the UTXO commitment of the node
+func (n *Node) status() (*Status, error) {
+	status := &Status{ID: n.ID, Height: -1}
+
+	for {
+		var headers blockchain.HeadersMessage
+		request := blockchain.GetHeadersMessage{FromHeight: status.Height + 1}
+		if err := n.h.probe.request(n.Address, blockchain.CommandGetHeaders, &request, blockchain.CommandHeaders, &headers); err != nil {
+			return nil, err
+		}
+
+		if headers.Error != "" {
+			return nil, errors.New(headers.Error)
+		}
+
+		if len(headers.Headers) > 0 {
+			last := headers.Headers[len(headers.Headers)-1]
+			status.Height, status.Tip = last.Height, last.Hash
+		}
+
+		if len(headers.Headers) < blockchain.MaxHeadersPerMessage {
+			break
+		}
+	}
+
+	var utxos utxoProofMessage
+	if err := n.h.probe.request(n.Address, blockchain.CommandGetUTXOProof, &getUTXOProofMessage{}, blockchain.CommandUTXOProof, &utxos); err != nil {
+		return nil, err
+	}
+
+	if utxos.Error != "" {
+		return nil, errors.New(utxos.Error)
+	}
+	status.UTXOTip, status.UTXOCommitment = utxos.Tip, utxos.Commitment
+
+	return status, nil
+}
+
+// probe sends the status queries of the harness to the nodes
+type probe struct {
+	timeout time.Duration
+}
+
+// request sends a message to a node and decodes its response of a command into response
+func (p *probe) request(addr, command string, payload interface{}, responseCommand string, response interface{}) error {
+	message, err := blockchain.Request(addr, blockchain.EncodeMessage(command, payload), responseCommand, p.timeout)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %s", ErrTimeout, addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return blockchain.DecodeMessagePayload(message, response)
+}
+
+// freeAddress returns a local address free to listen on, the one the
+// system binds for port 0
+func freeAddress() (string, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	return net.JoinHostPort("localhost", strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)), nil
+}
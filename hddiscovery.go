@@ -0,0 +1,228 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+// DefaultGapLimit is the number of consecutive unused addresses ending
+// the discovery of an account chain
+const DefaultGapLimit = 20
+
+// DiscoveredAccount is an HD account with the addresses found in use
+type DiscoveredAccount struct {
+	// Index is the index of the account, Path its derivation path
+	Index uint32
+	Path  string
+
+	// Receive and Change are the number of addresses of the chains up to
+	// the last one used
+	Receive int
+	Change  int
+
+	// Balance is the value of the unspent outputs of the account
+	Balance int
+}
+
+// Discovery is the result of scanning the chain for the HD accounts of a
+// restored wallet
+type Discovery struct {
+	Accounts []DiscoveredAccount
+
+	// Balance is the value of the unspent outputs of every account
+	Balance int
+
+	// History are the main chain transactions paying to or spending from
+	// the accounts in chain order
+	History []WalletTx
+}
+
+// usedPubKeyHashes returns whether public key hashes were paid by main
+// chain outputs, looked up in the balance index of archival nodes and
+// otherwise collected by one scan of the main chain
+func (bc *Blockchain) usedPubKeyHashes() (func(pubKeyHash []byte) bool, error) {
+	if bc.opts.archival {
+		if err := bc.indexBalances(); err != nil {
+			return nil, err
+		}
+
+		return func(pubKeyHash []byte) bool {
+			used := false
+			err := bc.db.View(func(tx *bolt.Tx) error {
+				k, _ := tx.Bucket([]byte(balanceIndexBucket)).Cursor().Seek(pubKeyHash)
+				used = k != nil && len(k) == len(pubKeyHash)+8 && bytes.HasPrefix(k, pubKeyHash)
+				return nil
+			})
+
+			return err == nil && used
+		}, nil
+	}
+
+	used := make(map[string]bool)
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte(tipDbKey))))
+
+		for height := 0; height <= tip.Height; height++ {
+			block, err := blockAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			for _, t := range block.Transactions {
+				for _, out := range t.VOut {
+					if pubKeyHash := out.PubKeyHash(); pubKeyHash != nil {
+						used[hex.EncodeToString(pubKeyHash)] = true
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(pubKeyHash []byte) bool {
+		return used[hex.EncodeToString(pubKeyHash)]
+	}, nil
+}
+
+// RecoverFromMnemonic restores the HD seed of a mnemonic and its
+// passphrase and discovers the used addresses of its accounts on the chain
+func (ws *Wallets) RecoverFromMnemonic(bc *Blockchain, mnemonic, passphrase string, gapLimit int) (*Discovery, error) {
+	if err := ws.RestoreMnemonic(mnemonic, passphrase); err != nil {
+		return nil, err
+	}
+
+	return ws.Discover(bc, gapLimit)
+}
+
+// discoverChain derives the keys of an account chain up to the last one
+// used before gapLimit consecutive unused ones and returns how many there are
+func discoverChain(account *HDWallet, chain uint32, gapLimit int, used func([]byte) bool) (int, error) {
+	next, gap := uint32(0), 0
+	for index := uint32(0); gap < gapLimit && index < HardenedKeyStart; index++ {
+		key, err := account.Key(chain, index)
+		if errors.Is(err, ErrInvalidChild) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if used(HashPubKey(key.PublicKey)) {
+			next, gap = index+1, 0
+		} else {
+			gap++
+		}
+	}
+
+	if err := account.deriveUpTo(chain, next); err != nil {
+		return 0, err
+	}
+
+	return len(account.keys[chain]), nil
+}
+
+// Discover scans the chain for the addresses used by the HD accounts of a
+// wallet restored from its seed. The chains of each account are scanned
+// until gapLimit consecutive addresses are unused. The accounts following a
+// BIP44 default account are scanned in order until one is unused, the used
+// ones are added to the wallets. Encrypted wallets must be unlocked.
+func (ws *Wallets) Discover(bc *Blockchain, gapLimit int) (*Discovery, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if len(ws.accounts) == 0 {
+		return nil, ErrNoMasterKey
+	}
+
+	if ws.isLocked() {
+		return nil, ErrWalletLocked
+	}
+
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+
+	used, err := bc.usedPubKeyHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	discovery := &Discovery{}
+	coinType, first, bip44 := bip44Account(ws.accounts[0].path)
+	for i := 0; ; i++ {
+		var account *HDWallet
+		added := false
+		if i < len(ws.accounts) {
+			account = ws.accounts[i]
+		} else if bip44 {
+			if account, err = NewHDAccount(ws.accounts[0].master, BIP44AccountPath(coinType, first+uint32(i))); err != nil {
+				return nil, err
+			}
+			added = true
+		} else {
+			break
+		}
+
+		receive, err := discoverChain(account, ExternalChain, gapLimit, used)
+		if err != nil {
+			return nil, err
+		}
+
+		change, err := discoverChain(account, InternalChain, gapLimit, used)
+		if err != nil {
+			return nil, err
+		}
+
+		if added && receive == 0 && change == 0 {
+			break
+		}
+
+		if added {
+			ws.accounts = append(ws.accounts, account)
+		}
+
+		index := uint32(i)
+		if _, accountIndex, ok := bip44Account(account.path); ok {
+			index = accountIndex
+		}
+
+		discovered := DiscoveredAccount{Index: index, Path: account.path.String(), Receive: receive, Change: change}
+		for _, keys := range account.keys {
+			for _, key := range keys {
+				for _, out := range NewUTXOSet(bc).FindUTXO(HashPubKey(key.PublicKey)) {
+					discovered.Balance += out.Value
+				}
+			}
+		}
+
+		discovery.Accounts = append(discovery.Accounts, discovered)
+		discovery.Balance += discovered.Balance
+	}
+
+	ws.addHDKeys()
+	if err = ws.save(); err != nil {
+		return nil, err
+	}
+
+	var pubKeyHashes [][]byte
+	for _, account := range ws.accounts {
+		for _, keys := range account.keys {
+			for _, key := range keys {
+				pubKeyHashes = append(pubKeyHashes, HashPubKey(key.PublicKey))
+			}
+		}
+	}
+
+	if discovery.History, err = bc.addressHistory(pubKeyHashes); err != nil {
+		return nil, err
+	}
+
+	return discovery, nil
+}
@@ -0,0 +1,402 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// HardenedKeyStart is the first hardened child index, hardened children
+	// can only be derived from private extended keys
+	HardenedKeyStart = uint32(0x80000000)
+
+	// minSeedLen and maxSeedLen bound the length of HD wallet seeds
+	minSeedLen = 16
+	maxSeedLen = 64
+
+	// extendedKeyLen is the length of a serialized extended key
+	extendedKeyLen = 78
+
+	// maxKeyDepth is the depth of the deepest derivable extended key
+	maxKeyDepth = 255
+)
+
+var (
+	// masterKeyDomain is the HMAC key deriving master keys from seeds
+	masterKeyDomain = []byte("Bitcoin seed")
+
+	// xprvVersion and xpubVersion are the versions of serialized private and public extended keys
+	xprvVersion = []byte{0x04, 0x88, 0xad, 0xe4}
+	xpubVersion = []byte{0x04, 0x88, 0xb2, 0x1e}
+)
+
+var (
+	// ErrInvalidSeed is returned for seeds too short or too long to derive a master key
+	ErrInvalidSeed = errors.New("invalid seed")
+
+	// ErrInvalidChild is returned for the rare indices without a valid child
+	// key, the next index should be used instead
+	ErrInvalidChild = errors.New("invalid child key, use the next index")
+
+	// ErrHardenedFromPublic is returned when deriving a hardened child from a public extended key
+	ErrHardenedFromPublic = errors.New("cannot derive a hardened child from a public key")
+
+	// ErrInvalidExtendedKey is returned when an extended key can't be parsed
+	ErrInvalidExtendedKey = errors.New("invalid extended key")
+
+	// ErrInvalidDerivationPath is returned when a derivation path can't be parsed
+	ErrInvalidDerivationPath = errors.New("invalid derivation path")
+)
+
+// ExtendedKey is a BIP32 secp256k1 key with the chain code deriving its
+// children, either private or public
+type ExtendedKey struct {
+	// key is the 32 byte private key or the 33 byte compressed public key
+	key       []byte
+	chainCode []byte
+	depth     uint8
+	parentFP  []byte
+	childNum  uint32
+	private   bool
+}
+
+// NewMasterKey derives the master private key of a seed of 16 to 64 bytes
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < minSeedLen || len(seed) > maxSeedLen {
+		return nil, fmt.Errorf("%w: %d bytes, needs %d to %d", ErrInvalidSeed, len(seed), minSeedLen, maxSeedLen)
+	}
+
+	mac := hmac.New(sha512.New, masterKeyDomain)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	k := new(big.Int).SetBytes(sum[:32])
+	if k.Sign() == 0 || k.Cmp(secp256k1.S256().Params().N) >= 0 {
+		return nil, fmt.Errorf("%w: seed has no valid master key", ErrInvalidSeed)
+	}
+
+	return &ExtendedKey{key: sum[:32], chainCode: sum[32:], parentFP: make([]byte, 4), private: true}, nil
+}
+
+// IsPrivate returns whether the extended key holds a private key
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+// Depth returns the number of derivations from the master key
+func (k *ExtendedKey) Depth() int {
+	return int(k.depth)
+}
+
+// ChildNumber returns the index the key was derived at, zero for the master key
+func (k *ExtendedKey) ChildNumber() uint32 {
+	return k.childNum
+}
+
+// PublicKey returns the compressed public key
+func (k *ExtendedKey) PublicKey() []byte {
+	if !k.private {
+		return append([]byte{}, k.key...)
+	}
+
+	return encodePublicKey(&k.ecPrivateKey().PublicKey)
+}
+
+// Fingerprint returns the first four bytes of the public key hash, the
+// children of the key refer to it
+func (k *ExtendedKey) Fingerprint() []byte {
+	return HashPubKey(k.PublicKey())[:4]
+}
+
+// ecPrivateKey returns the private key of a private extended key
+func (k *ExtendedKey) ecPrivateKey() *ecdsa.PrivateKey {
+	curve := secp256k1.S256()
+
+	private := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(k.key)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(k.key)
+
+	return private
+}
+
+// Child derives the child key at index, indices from HardenedKeyStart are
+// hardened. Private keys derive private children, public keys public ones.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == maxKeyDepth {
+		return nil, fmt.Errorf("%w: depth %d", ErrInvalidChild, k.depth)
+	}
+
+	hardened := index >= HardenedKeyStart
+	if hardened && !k.private {
+		return nil, ErrHardenedFromPublic
+	}
+
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		data = k.PublicKey()
+	}
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-4:], index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	curve := secp256k1.S256()
+	n := curve.Params().N
+
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidChild, index)
+	}
+
+	child := &ExtendedKey{
+		chainCode: sum[32:],
+		depth:     k.depth + 1,
+		parentFP:  k.Fingerprint(),
+		childNum:  index,
+		private:   k.private,
+	}
+
+	if k.private {
+		d := il.Add(il, new(big.Int).SetBytes(k.key))
+		d.Mod(d, n)
+		if d.Sign() == 0 {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidChild, index)
+		}
+
+		child.key = make([]byte, 32)
+		d.FillBytes(child.key)
+
+		return child, nil
+	}
+
+	parent, err := secp256k1.ParsePubKey(k.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidExtendedKey, err)
+	}
+
+	parentKey := parent.ToECDSA()
+	x, y := curve.ScalarBaseMult(sum[:32])
+	x, y = curve.Add(x, y, parentKey.X, parentKey.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidChild, index)
+	}
+
+	child.key = encodePublicKey(&ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+
+	return child, nil
+}
+
+// Derive derives the descendant key at a path relative to the key
+func (k *ExtendedKey) Derive(path DerivationPath) (*ExtendedKey, error) {
+	key := k
+	for _, index := range path {
+		var err error
+		if key, err = key.Child(index); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Neuter returns the public extended key of the key
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+
+	return &ExtendedKey{
+		key:       k.PublicKey(),
+		chainCode: k.chainCode,
+		depth:     k.depth,
+		parentFP:  k.parentFP,
+		childNum:  k.childNum,
+	}
+}
+
+// Wallet returns the key pair of the key, only the public key is set for
+// public extended keys
+func (k *ExtendedKey) Wallet() *Wallet {
+	if !k.private {
+		return &Wallet{PublicKey: k.PublicKey()}
+	}
+
+	private := k.ecPrivateKey()
+	return &Wallet{PrivateKey: *private, PublicKey: encodePublicKey(&private.PublicKey)}
+}
+
+// Address returns the pay to public key hash address of the key
+func (k *ExtendedKey) Address() string {
+	return string(encodeAddress(version, HashPubKey(k.PublicKey())))
+}
+
+// zero wipes the private key from memory
+func (k *ExtendedKey) zero() {
+	if !k.private {
+		return
+	}
+
+	for i := range k.key {
+		k.key[i] = 0
+	}
+}
+
+// serialize encodes the key in the 78 byte BIP32 format
+func (k *ExtendedKey) serialize() []byte {
+	var buff bytes.Buffer
+	if k.private {
+		buff.Write(xprvVersion)
+	} else {
+		buff.Write(xpubVersion)
+	}
+
+	buff.WriteByte(k.depth)
+	buff.Write(k.parentFP)
+
+	childNum := make([]byte, 4)
+	binary.BigEndian.PutUint32(childNum, k.childNum)
+	buff.Write(childNum)
+	buff.Write(k.chainCode)
+
+	if k.private {
+		buff.WriteByte(0x00)
+	}
+	buff.Write(k.key)
+
+	return buff.Bytes()
+}
+
+// String encodes the key as a base58 xprv or xpub string
+func (k *ExtendedKey) String() string {
+	payload := k.serialize()
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// ParseExtendedKey parses a base58 xprv or xpub string
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidExtendedKey)
+	}
+
+	for i := 0; i < len(s); i++ {
+		if bytes.IndexByte(b58Alphabet, s[i]) < 0 {
+			return nil, fmt.Errorf("%w: not base58", ErrInvalidExtendedKey)
+		}
+	}
+
+	data := Base58Decode([]byte(s))
+	if len(data) != extendedKeyLen+addressChecksumLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidExtendedKey, len(data))
+	}
+
+	payload := data[:extendedKeyLen]
+	if !bytes.Equal(checksum(payload), data[extendedKeyLen:]) {
+		return nil, fmt.Errorf("%w: bad checksum", ErrInvalidExtendedKey)
+	}
+
+	return parseExtendedKey(payload)
+}
+
+// parseExtendedKey decodes a key in the 78 byte BIP32 format
+func parseExtendedKey(data []byte) (*ExtendedKey, error) {
+	if len(data) != extendedKeyLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidExtendedKey, len(data))
+	}
+
+	k := &ExtendedKey{
+		depth:     data[4],
+		parentFP:  append([]byte{}, data[5:9]...),
+		childNum:  binary.BigEndian.Uint32(data[9:13]),
+		chainCode: append([]byte{}, data[13:45]...),
+	}
+
+	if k.depth == 0 && (!bytes.Equal(k.parentFP, []byte{0, 0, 0, 0}) || k.childNum != 0) {
+		return nil, fmt.Errorf("%w: master key with a parent", ErrInvalidExtendedKey)
+	}
+
+	switch {
+	case bytes.Equal(data[:4], xprvVersion):
+		if data[45] != 0x00 {
+			return nil, fmt.Errorf("%w: bad private key prefix", ErrInvalidExtendedKey)
+		}
+
+		d := new(big.Int).SetBytes(data[46:])
+		if d.Sign() == 0 || d.Cmp(secp256k1.S256().Params().N) >= 0 {
+			return nil, fmt.Errorf("%w: private key out of range", ErrInvalidExtendedKey)
+		}
+
+		k.key, k.private = append([]byte{}, data[46:]...), true
+	case bytes.Equal(data[:4], xpubVersion):
+		if _, err := secp256k1.ParsePubKey(data[45:]); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidExtendedKey, err)
+		}
+
+		k.key = append([]byte{}, data[45:]...)
+	default:
+		return nil, fmt.Errorf("%w: unknown version %x", ErrInvalidExtendedKey, data[:4])
+	}
+
+	return k, nil
+}
+
+// DerivationPath is a list of child indices from a key, hardened indices
+// are from HardenedKeyStart
+type DerivationPath []uint32
+
+// ParseDerivationPath parses a path like m/0'/1/2, ' or h marks hardened indices
+func ParseDerivationPath(s string) (DerivationPath, error) {
+	elements := strings.Split(strings.TrimSpace(s), "/")
+	if elements[0] != "m" {
+		return nil, fmt.Errorf("%w: %q doesn't start with m", ErrInvalidDerivationPath, s)
+	}
+
+	path := DerivationPath{}
+	for _, element := range elements[1:] {
+		hardened := strings.HasSuffix(element, "'") || strings.HasSuffix(element, "h")
+		if hardened {
+			element = element[:len(element)-1]
+		}
+
+		index, err := strconv.ParseUint(element, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrInvalidDerivationPath, s, err)
+		}
+
+		if hardened {
+			index += uint64(HardenedKeyStart)
+		}
+		path = append(path, uint32(index))
+	}
+
+	return path, nil
+}
+
+// String formats the path like m/0'/1/2
+func (p DerivationPath) String() string {
+	var b strings.Builder
+	b.WriteString("m")
+
+	for _, index := range p {
+		b.WriteString("/")
+		if index >= HardenedKeyStart {
+			b.WriteString(strconv.FormatUint(uint64(index-HardenedKeyStart), 10) + "'")
+		} else {
+			b.WriteString(strconv.FormatUint(uint64(index), 10))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,245 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ExternalChain is the chain of the receive addresses of an HD account
+	ExternalChain = uint32(0)
+
+	// InternalChain is the chain of the change addresses of an HD account
+	InternalChain = uint32(1)
+)
+
+const (
+	// bip44Purpose is the first index of BIP44 account paths
+	bip44Purpose = 44
+
+	// DefaultHDCoinType is the BIP44 coin type of the accounts of new HD wallets
+	DefaultHDCoinType = 0
+)
+
+// DefaultHDAccountPath is the path of the default account of new HD
+// wallets, its keys are at m/44'/0'/0'/<chain>/<index>
+var DefaultHDAccountPath = BIP44AccountPath(DefaultHDCoinType, 0)
+
+// ErrNoMasterKey is returned when an HD wallet only knows its account public key
+var ErrNoMasterKey = errors.New("hd wallet has no master private key")
+
+// HDWallet derives the receive and change keys of an account from one
+// master key, receive keys on the external chain and change keys on the
+// internal chain of the account
+type HDWallet struct {
+	// master is nil when only the account public key is known
+	master  *ExtendedKey
+	path    DerivationPath
+	account *ExtendedKey
+	chains  [2]*ExtendedKey
+
+	// next is the next index to derive on each chain
+	next [2]uint32
+	keys [2][]*Wallet
+}
+
+// BIP44AccountPath returns the path m/44'/<coin type>'/<account>' of a BIP44 account
+func BIP44AccountPath(coinType, account uint32) DerivationPath {
+	return DerivationPath{HardenedKeyStart + bip44Purpose, HardenedKeyStart + coinType, HardenedKeyStart + account}
+}
+
+// bip44Account returns the coin type and account of a BIP44 account path
+func bip44Account(path DerivationPath) (uint32, uint32, bool) {
+	if len(path) != 3 || path[0] != HardenedKeyStart+bip44Purpose || path[1] < HardenedKeyStart || path[2] < HardenedKeyStart {
+		return 0, 0, false
+	}
+
+	return path[1] - HardenedKeyStart, path[2] - HardenedKeyStart, true
+}
+
+// NewHDWallet creates an HD wallet of the default account from a seed
+func NewHDWallet(seed []byte) (*HDWallet, error) {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHDAccount(master, DefaultHDAccountPath)
+}
+
+// NewHDAccount creates an HD wallet of the account at path from a master private key
+func NewHDAccount(master *ExtendedKey, path DerivationPath) (*HDWallet, error) {
+	if !master.IsPrivate() {
+		return nil, ErrNoMasterKey
+	}
+
+	account, err := master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := newHDWallet(account, path)
+	if err != nil {
+		return nil, err
+	}
+	w.master = master
+
+	return w, nil
+}
+
+// newHDWallet creates an HD wallet of an account key, private keys are
+// only derived from private account keys
+func newHDWallet(account *ExtendedKey, path DerivationPath) (*HDWallet, error) {
+	w := &HDWallet{path: append(DerivationPath{}, path...), account: account}
+
+	for _, chain := range []uint32{ExternalChain, InternalChain} {
+		key, err := account.Child(chain)
+		if err != nil {
+			return nil, err
+		}
+		w.chains[chain] = key
+	}
+
+	return w, nil
+}
+
+// MasterKey returns the master private key, nil when only the account public key is known
+func (w *HDWallet) MasterKey() *ExtendedKey {
+	return w.master
+}
+
+// AccountPath returns the path of the account from the master key
+func (w *HDWallet) AccountPath() DerivationPath {
+	return append(DerivationPath{}, w.path...)
+}
+
+// AccountKey returns the public extended key of the account, it derives
+// every address of the wallet without the private keys
+func (w *HDWallet) AccountKey() *ExtendedKey {
+	return w.account.Neuter()
+}
+
+// Key derives the key at an index of a chain of the account
+func (w *HDWallet) Key(chain, index uint32) (*Wallet, error) {
+	if chain != ExternalChain && chain != InternalChain {
+		return nil, fmt.Errorf("%w: chain %d", ErrInvalidDerivationPath, chain)
+	}
+
+	key, err := w.chains[chain].Child(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Wallet(), nil
+}
+
+// nextKey derives the next key of a chain, skipping indices without a valid key
+func (w *HDWallet) nextKey(chain uint32) (*Wallet, error) {
+	for {
+		index := w.next[chain]
+		if index >= HardenedKeyStart {
+			return nil, fmt.Errorf("%w: chain %d is exhausted", ErrInvalidChild, chain)
+		}
+		w.next[chain]++
+
+		key, err := w.Key(chain, index)
+		if errors.Is(err, ErrInvalidChild) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		w.keys[chain] = append(w.keys[chain], key)
+		return key, nil
+	}
+}
+
+// NewReceiveKey derives the next receive key
+func (w *HDWallet) NewReceiveKey() (*Wallet, error) {
+	return w.nextKey(ExternalChain)
+}
+
+// NewChangeKey derives the next change key
+func (w *HDWallet) NewChangeKey() (*Wallet, error) {
+	return w.nextKey(InternalChain)
+}
+
+// deriveUpTo derives the keys of a chain up to next, restoring a wallet
+func (w *HDWallet) deriveUpTo(chain, next uint32) error {
+	for w.next[chain] < next {
+		if _, err := w.nextKey(chain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Keys returns the keys derived so far on a chain
+func (w *HDWallet) Keys(chain uint32) []*Wallet {
+	return append([]*Wallet{}, w.keys[chain]...)
+}
+
+// Addresses returns the addresses derived so far on a chain
+func (w *HDWallet) Addresses(chain uint32) []string {
+	addresses := make([]string, 0, len(w.keys[chain]))
+	for _, key := range w.keys[chain] {
+		addresses = append(addresses, string(key.GetAddress()))
+	}
+
+	return addresses
+}
+
+// KeyFor returns the private key of a derived key hashing to pubKeyHash
+func (w *HDWallet) KeyFor(pubKeyHash []byte) (*ecdsa.PrivateKey, bool) {
+	if w.master == nil {
+		return nil, false
+	}
+
+	for _, keys := range w.keys {
+		for _, key := range keys {
+			if private, ok := key.KeyFor(pubKeyHash); ok {
+				return private, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Neuter returns the wallet with the account public key only, it keeps
+// deriving addresses but no private keys
+func (w *HDWallet) Neuter() *HDWallet {
+	watch := &HDWallet{path: w.AccountPath(), account: w.account.Neuter(), next: w.next}
+
+	for chain, key := range w.chains {
+		watch.chains[chain] = key.Neuter()
+		for _, derived := range w.keys[chain] {
+			watch.keys[chain] = append(watch.keys[chain], &Wallet{PublicKey: derived.PublicKey})
+		}
+	}
+
+	return watch
+}
+
+// zero wipes the private keys from memory
+func (w *HDWallet) zero() {
+	if w.master != nil {
+		w.master.zero()
+	}
+
+	w.account.zero()
+	for _, chain := range w.chains {
+		chain.zero()
+	}
+
+	for _, keys := range w.keys {
+		for _, key := range keys {
+			if key.PrivateKey.D != nil {
+				key.PrivateKey.D.SetInt64(0)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"sort"
+	"time"
+)
+
+// heightIndexBucket is the bucket name of the main chain block hashes by height
+const heightIndexBucket = "heights"
+
+// heightKey returns the index key of a height, big endian so keys sort by height
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+// indexMainChain points the height index at the main chain ending at tip,
+// heights are rewritten down to the fork point with the previous main chain
+// and heights above the tip are removed. The tx index, once created, follows
+// the blocks entering and leaving the main chain, then the compact filters
+// of the new main chain blocks are built.
+func indexMainChain(tx *bolt.Tx, tip *Block) error {
+	index, err := tx.CreateBucketIfNotExists([]byte(heightIndexBucket))
+	if err != nil {
+		return err
+	}
+	txs := tx.Bucket([]byte(txIndexBucket))
+
+	var above [][]byte
+	c := index.Cursor()
+	for k, _ := c.Seek(heightKey(tip.Height + 1)); k != nil; k, _ = c.Next() {
+		above = append(above, append([]byte{}, k...))
+	}
+
+	for _, k := range above {
+		if txs != nil {
+			if err = unindexHash(tx, txs, index.Get(k)); err != nil {
+				return err
+			}
+		}
+
+		if err = index.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	blocks := tx.Bucket([]byte(blocksBucket))
+	block := tip
+	for {
+		key := heightKey(block.Height)
+		replaced := index.Get(key)
+		if bytes.Equal(replaced, block.Hash) {
+			return indexBlockFilters(tx, tip)
+		}
+
+		if txs != nil {
+			if replaced != nil {
+				if err = unindexHash(tx, txs, replaced); err != nil {
+					return err
+				}
+			}
+
+			if err = indexBlockTxs(txs, block); err != nil {
+				return err
+			}
+		}
+
+		if err = index.Put(key, block.Hash); err != nil {
+			return err
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			return indexBlockFilters(tx, tip)
+		}
+
+		data := blocks.Get(block.PrevBlockHash)
+		if data == nil {
+			return fmt.Errorf("%w: %x", ErrBlockNotFound, block.PrevBlockHash)
+		}
+		block = DeserializeBlock(data)
+	}
+}
+
+// blockAtHeight returns the main chain block at a height from the height index
+func blockAtHeight(tx *bolt.Tx, height int) (*Block, error) {
+	var hash []byte
+	if index := tx.Bucket([]byte(heightIndexBucket)); index != nil && height >= 0 {
+		hash = index.Get(heightKey(height))
+	}
+
+	if hash == nil {
+		return nil, fmt.Errorf("%w: at height %d", ErrBlockNotFound, height)
+	}
+
+	data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+	if data == nil {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+
+	return DeserializeBlock(data), nil
+}
+
+// RepairHeightIndex indexes the main chain of databases created before the
+// height index existed or whose index is behind the tip
+func (bc *Blockchain) RepairHeightIndex() {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		return indexMainChain(tx, DeserializeBlock(b.Get(b.Get([]byte(tipDbKey)))))
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// GetBlockByHeight returns the main chain block at a height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		found, err := blockAtHeight(tx, height)
+		if err != nil {
+			return err
+		}
+
+		block = *found
+		return nil
+	})
+
+	return block, err
+}
+
+// GetBlockByTime returns the last main chain block whose timestamp isn't
+// after t, found by binary search over the height index. Timestamps of
+// consecutive blocks are assumed to increase.
+func (bc *Blockchain) GetBlockByTime(t time.Time) (Block, error) {
+	var block Block
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte(tipDbKey))))
+
+		var searchErr error
+		height := sort.Search(tip.Height+1, func(height int) bool {
+			found, err := blockAtHeight(tx, height)
+			if err != nil {
+				searchErr = err
+				return true
+			}
+
+			return found.Timestamp > t.Unix()
+		}) - 1
+		if searchErr != nil {
+			return searchErr
+		}
+
+		if height < 0 {
+			return fmt.Errorf("%w: before %s", ErrBlockNotFound, t.Format(time.RFC3339))
+		}
+
+		found, err := blockAtHeight(tx, height)
+		if err != nil {
+			return err
+		}
+
+		block = *found
+		return nil
+	})
+
+	return block, err
+}
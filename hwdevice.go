@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DeviceEmulator answers the requests of a HardwareSigner with the keys of
+// a master key, it is the reference of the device side of the protocol and
+// stands in for a device in development
+type DeviceEmulator struct {
+	master *ExtendedKey
+
+	// confirm asks the user of the device to approve a prompt
+	confirm func(prompt string) bool
+}
+
+// NewDeviceEmulator creates a DeviceEmulator of a master private key,
+// confirm approves the signatures and addresses shown, every prompt is
+// approved when it is nil
+func NewDeviceEmulator(master *ExtendedKey, confirm func(prompt string) bool) (*DeviceEmulator, error) {
+	if !master.IsPrivate() {
+		return nil, ErrNoMasterKey
+	}
+
+	if confirm == nil {
+		confirm = func(string) bool { return true }
+	}
+
+	return &DeviceEmulator{master: master, confirm: confirm}, nil
+}
+
+// ServeSerial answers the requests of a serial stream until it is closed
+func (d *DeviceEmulator) ServeSerial(rw io.ReadWriter) error {
+	return d.serve(&hwConn{rw: rw})
+}
+
+// ServeHID answers the requests carried in HID reports until the stream is closed
+func (d *DeviceEmulator) ServeHID(rw io.ReadWriter) error {
+	return d.serve(&hwConn{rw: rw, reportLen: HIDReportLen})
+}
+
+// serve answers the requests of a connection until it is closed
+func (d *DeviceEmulator) serve(conn *hwConn) error {
+	for {
+		request, err := conn.readMessage()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = conn.writeMessage(d.handle(request)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle returns the response to a request
+func (d *DeviceEmulator) handle(request []byte) []byte {
+	if len(request) == 0 {
+		return append([]byte{hwStatusError}, "empty request"...)
+	}
+
+	r := bytes.NewReader(request[1:])
+	path, err := readDerivationPath(r)
+	if err != nil {
+		return append([]byte{hwStatusError}, fmt.Sprintf("bad path: %s", err)...)
+	}
+
+	key, err := d.master.Derive(path)
+	if err != nil {
+		return append([]byte{hwStatusError}, err.Error()...)
+	}
+
+	switch request[0] {
+	case hwCmdExtendedKey:
+		return append([]byte{hwStatusOK}, key.Neuter().String()...)
+
+	case hwCmdSign:
+		digest, err := readVarBytes(r)
+		if err != nil || len(digest) != 32 {
+			return append([]byte{hwStatusError}, "bad signature hash"...)
+		}
+
+		if !d.confirm(fmt.Sprintf("Sign %s with %s", hex.EncodeToString(digest), path)) {
+			return []byte{hwStatusRejected}
+		}
+
+		return append([]byte{hwStatusOK}, signHash(*key.ecPrivateKey(), digest)...)
+
+	case hwCmdShowAddress:
+		if !d.confirm(fmt.Sprintf("Address %s at %s", key.Address(), path)) {
+			return []byte{hwStatusRejected}
+		}
+
+		return append([]byte{hwStatusOK}, key.Address()...)
+	}
+
+	return append([]byte{hwStatusError}, fmt.Sprintf("unknown command %d", request[0])...)
+}
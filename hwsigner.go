@@ -0,0 +1,421 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Commands of the hardware signer protocol. A request is a command byte
+// followed by its payload, a response a status byte followed by its payload.
+// Paths are a var int count followed by the little endian indices.
+const (
+	// hwCmdExtendedKey asks for the extended public key of a path, the
+	// response is the key in base58
+	hwCmdExtendedKey = byte(0x01)
+
+	// hwCmdSign asks for the signature of a signature hash, given as var
+	// bytes after the path, by the key of the path. The response is the DER
+	// encoded signature.
+	hwCmdSign = byte(0x02)
+
+	// hwCmdShowAddress asks the device to show the address of a path for
+	// the user to confirm, the response is the address shown
+	hwCmdShowAddress = byte(0x03)
+)
+
+// Statuses of the responses of the hardware signer protocol, the payload of
+// an error is its message
+const (
+	hwStatusOK       = byte(0x00)
+	hwStatusRejected = byte(0x01)
+	hwStatusError    = byte(0x02)
+)
+
+const (
+	// HIDReportLen is the length of the HID reports carrying the messages
+	HIDReportLen = 64
+
+	// maxHWMessageLen is the length of the longest message of the protocol
+	maxHWMessageLen = 4096
+)
+
+var (
+	// ErrDeviceRejected is returned when the user rejects a request on the device
+	ErrDeviceRejected = errors.New("rejected on the device")
+
+	// ErrDeviceAddressMismatch is returned when the device shows another address than the wallet
+	ErrDeviceAddressMismatch = errors.New("device shows a different address")
+
+	// ErrDeviceProtocol is returned for malformed messages and errors reported by the device
+	ErrDeviceProtocol = errors.New("hardware signer protocol error")
+)
+
+// hwConn carries the messages of the protocol, each prefixed with its big
+// endian 2 byte length. Serial streams carry them as is, HID devices in
+// reports of reportLen bytes padded with zeros. The host prefixes the
+// reports it writes with the report ID 0, as hidraw expects.
+type hwConn struct {
+	rw        io.ReadWriter
+	reportLen int
+	host      bool
+}
+
+// writeMessage writes a message
+func (c *hwConn) writeMessage(body []byte) error {
+	if len(body) > maxHWMessageLen {
+		return fmt.Errorf("%w: message of %d bytes", ErrDeviceProtocol, len(body))
+	}
+
+	frame := make([]byte, 2, 2+len(body))
+	binary.BigEndian.PutUint16(frame, uint16(len(body)))
+	frame = append(frame, body...)
+
+	if c.reportLen == 0 {
+		_, err := c.rw.Write(frame)
+		return err
+	}
+
+	for len(frame) > 0 {
+		report := make([]byte, c.reportLen)
+		frame = frame[copy(report, frame):]
+		if c.host {
+			report = append([]byte{0}, report...)
+		}
+
+		if _, err := c.rw.Write(report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readMessage reads a message, dropping the padding of its last report
+func (c *hwConn) readMessage() ([]byte, error) {
+	if c.reportLen == 0 {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(c.rw, header); err != nil {
+			return nil, err
+		}
+
+		body := make([]byte, binary.BigEndian.Uint16(header))
+		if len(body) > maxHWMessageLen {
+			return nil, fmt.Errorf("%w: message of %d bytes", ErrDeviceProtocol, len(body))
+		}
+
+		if _, err := io.ReadFull(c.rw, body); err != nil {
+			return nil, err
+		}
+
+		return body, nil
+	}
+
+	var frame []byte
+	for len(frame) < 2 || len(frame) < 2+int(binary.BigEndian.Uint16(frame)) {
+		if len(frame) >= 2 && int(binary.BigEndian.Uint16(frame)) > maxHWMessageLen {
+			return nil, fmt.Errorf("%w: message of %d bytes", ErrDeviceProtocol, binary.BigEndian.Uint16(frame))
+		}
+
+		report := make([]byte, c.reportLen)
+		if !c.host {
+			report = make([]byte, 1+c.reportLen)
+		}
+
+		if _, err := io.ReadFull(c.rw, report); err != nil {
+			return nil, err
+		}
+
+		if !c.host {
+			report = report[1:]
+		}
+		frame = append(frame, report...)
+	}
+
+	return frame[2 : 2+int(binary.BigEndian.Uint16(frame))], nil
+}
+
+// request sends a command and returns the payload of its response
+func (c *hwConn) request(command byte, payload []byte) ([]byte, error) {
+	if err := c.writeMessage(append([]byte{command}, payload...)); err != nil {
+		return nil, err
+	}
+
+	response, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) == 0 {
+		return nil, fmt.Errorf("%w: empty response", ErrDeviceProtocol)
+	}
+
+	switch response[0] {
+	case hwStatusOK:
+		return response[1:], nil
+	case hwStatusRejected:
+		return nil, ErrDeviceRejected
+	case hwStatusError:
+		return nil, fmt.Errorf("%w: device: %s", ErrDeviceProtocol, response[1:])
+	}
+
+	return nil, fmt.Errorf("%w: unknown status %d", ErrDeviceProtocol, response[0])
+}
+
+// writeDerivationPath writes a path as its length followed by its indices
+func writeDerivationPath(w io.Writer, path DerivationPath) {
+	writeVarInt(w, uint64(len(path)))
+	for _, index := range path {
+		writeUint32(w, index)
+	}
+}
+
+// readDerivationPath reads a path written by writeDerivationPath
+func readDerivationPath(r *bytes.Reader) (DerivationPath, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > uint64(r.Len()/4) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	path := make(DerivationPath, n)
+	for i := range path {
+		if path[i], err = readUint32(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return path, nil
+}
+
+// HardwareSigner is a Signer whose keys stay on a hardware device. It asks
+// the device for the extended public keys of BIP32 paths, the addresses of
+// the keys are then signed by the device for the signature hashes sent to it.
+type HardwareSigner struct {
+	mu     sync.Mutex
+	conn   *hwConn
+	closer io.Closer
+
+	// paths are the paths of the keys of the addresses, pubKeys their public keys
+	paths     map[string]DerivationPath
+	pubKeys   map[string][]byte
+	addresses []string
+}
+
+// NewSerialSigner creates a HardwareSigner of a device on a serial stream
+func NewSerialSigner(rw io.ReadWriter) *HardwareSigner {
+	return newHardwareSigner(&hwConn{rw: rw, host: true}, rw)
+}
+
+// NewHIDSigner creates a HardwareSigner of a HID device, e.g. a hidraw
+// device file, exchanging reports of HIDReportLen bytes
+func NewHIDSigner(rw io.ReadWriter) *HardwareSigner {
+	return newHardwareSigner(&hwConn{rw: rw, reportLen: HIDReportLen, host: true}, rw)
+}
+
+// newHardwareSigner creates a HardwareSigner on a connection, closing rw when it is a Closer
+func newHardwareSigner(conn *hwConn, rw io.ReadWriter) *HardwareSigner {
+	hs := &HardwareSigner{conn: conn, paths: make(map[string]DerivationPath), pubKeys: make(map[string][]byte)}
+	if closer, ok := rw.(io.Closer); ok {
+		hs.closer = closer
+	}
+
+	return hs
+}
+
+// OpenHardwareSigner opens the device file of a hardware signer, hidraw
+// devices are HID devices and others serial ports set to raw mode
+func OpenHardwareSigner(device string) (*HardwareSigner, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(filepath.Base(device), "hidraw") {
+		return NewHIDSigner(f), nil
+	}
+
+	return NewSerialSigner(f), nil
+}
+
+// Close closes the connection to the device
+func (hs *HardwareSigner) Close() error {
+	if hs.closer == nil {
+		return nil
+	}
+
+	return hs.closer.Close()
+}
+
+// ExtendedKey asks the device for the extended public key of a path
+func (hs *HardwareSigner) ExtendedKey(path DerivationPath) (*ExtendedKey, error) {
+	var payload bytes.Buffer
+	writeDerivationPath(&payload, path)
+
+	hs.mu.Lock()
+	response, err := hs.conn.request(hwCmdExtendedKey, payload.Bytes())
+	hs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseExtendedKey(string(response))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceProtocol, err)
+	}
+
+	if key.IsPrivate() || key.Depth() != len(path) {
+		return nil, fmt.Errorf("%w: device sent an unexpected key for %s", ErrDeviceProtocol, path)
+	}
+
+	return key, nil
+}
+
+// add records the key of an address, hs.mu must be held
+func (hs *HardwareSigner) add(path DerivationPath, pubKey []byte) string {
+	address := keyAddress(pubKey)
+	if _, ok := hs.paths[address]; !ok {
+		hs.addresses = append(hs.addresses, address)
+	}
+
+	hs.paths[address] = append(DerivationPath{}, path...)
+	hs.pubKeys[address] = pubKey
+
+	return address
+}
+
+// AddKey adds the key of a path of the device and returns its address
+func (hs *HardwareSigner) AddKey(path DerivationPath) (string, error) {
+	key, err := hs.ExtendedKey(path)
+	if err != nil {
+		return "", err
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	return hs.add(path, key.PublicKey()), nil
+}
+
+// AddAccount adds the first lookahead receive and change keys of the HD
+// account at path, DefaultGapLimit when lookahead isn't positive. Only the
+// account public key is asked to the device, its keys are derived here.
+func (hs *HardwareSigner) AddAccount(path DerivationPath, lookahead int) ([]string, error) {
+	if lookahead <= 0 {
+		lookahead = DefaultGapLimit
+	}
+
+	key, err := hs.ExtendedKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := newHDWallet(key, path)
+	if err != nil {
+		return nil, err
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var addresses []string
+	for _, chain := range []uint32{ExternalChain, InternalChain} {
+		for index := uint32(0); index < uint32(lookahead); index++ {
+			key, err := account.Key(chain, index)
+			if errors.Is(err, ErrInvalidChild) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			keyPath := append(account.AccountPath(), chain, index)
+			addresses = append(addresses, hs.add(keyPath, key.PublicKey))
+		}
+	}
+
+	return addresses, nil
+}
+
+// Addresses returns the addresses of the keys added in order
+func (hs *HardwareSigner) Addresses() []string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	return append([]string{}, hs.addresses...)
+}
+
+// Path returns the path of the key of an address on the device
+func (hs *HardwareSigner) Path(address string) (DerivationPath, bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	path, ok := hs.paths[address]
+	return append(DerivationPath{}, path...), ok
+}
+
+// PublicKey returns the public key of an added address
+func (hs *HardwareSigner) PublicKey(address string) ([]byte, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	pubKey, ok := hs.pubKeys[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingKey, address)
+	}
+
+	return append([]byte{}, pubKey...), nil
+}
+
+// Sign asks the device to sign a digest with the key of an address
+func (hs *HardwareSigner) Sign(address string, digest []byte) ([]byte, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	path, ok := hs.paths[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingKey, address)
+	}
+
+	var payload bytes.Buffer
+	writeDerivationPath(&payload, path)
+	writeVarBytes(&payload, digest)
+
+	return hs.conn.request(hwCmdSign, payload.Bytes())
+}
+
+// VerifyAddress shows an address on the device for the user to check it
+// against the one the wallet shows, e.g. before receiving a payment. The
+// device derives the address itself, so a compromised host can't swap it.
+func (hs *HardwareSigner) VerifyAddress(address string) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	path, ok := hs.paths[address]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMissingKey, address)
+	}
+
+	var payload bytes.Buffer
+	writeDerivationPath(&payload, path)
+
+	shown, err := hs.conn.request(hwCmdShowAddress, payload.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if string(shown) != address {
+		return fmt.Errorf("%w: %s instead of %s", ErrDeviceAddressMismatch, shown, address)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+const (
+	// identityFileNameFormat is the node identity key file name
+	identityFileNameFormat = "identity_%s.dat"
+
+	// identityFileMode is the file mode of node identity key files
+	identityFileMode = 0600
+)
+
+// NodeIdentity is the long-lived key pair identifying a node
+type NodeIdentity struct {
+	PrivateKey ecdsa.PrivateKey
+	PublicKey  []byte
+}
+
+// NewNodeIdentity creates a new node identity
+func NewNodeIdentity() *NodeIdentity {
+	private, public := newKeyPair(elliptic.P256())
+
+	return &NodeIdentity{PrivateKey: private, PublicKey: public}
+}
+
+// getIdentityFile returns the identity key file name of the node
+func getIdentityFile(nodeID string) string {
+	return fmt.Sprintf(identityFileNameFormat, nodeID)
+}
+
+// LoadNodeIdentity loads the identity of the node, it is created on first use
+func LoadNodeIdentity(nodeID string) (*NodeIdentity, error) {
+	path := getIdentityFile(nodeID)
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		identity := NewNodeIdentity()
+		if err := saveNodeIdentity(path, identity); err != nil {
+			return nil, err
+		}
+
+		return identity, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var key walletKey
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&key); err != nil {
+		return nil, fmt.Errorf("identity file %s: %w", path, err)
+	}
+
+	w, err := key.wallet()
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeIdentity{PrivateKey: w.PrivateKey, PublicKey: w.PublicKey}, nil
+}
+
+// saveNodeIdentity writes the identity key file
+func saveNodeIdentity(path string, identity *NodeIdentity) error {
+	var buff bytes.Buffer
+
+	key := newWalletKey(&Wallet{PrivateKey: identity.PrivateKey, PublicKey: identity.PublicKey})
+	if err := gob.NewEncoder(&buff).Encode(key); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, buff.Bytes(), identityFileMode)
+}
+
+// Sign signs data for peers in the DomainNodeIdentity domain
+func (identity *NodeIdentity) Sign(data []byte) ([]byte, error) {
+	return ecdsa.SignASN1(rand.Reader, &identity.PrivateKey, domainHash(DomainNodeIdentity, data))
+}
+
+// VerifyNodeSignature checks data was signed by the node identity with the public key
+func VerifyNodeSignature(pubKey, data, signature []byte) bool {
+	key, ok := identityPublicKey(pubKey)
+
+	return ok && ecdsa.VerifyASN1(key, domainHash(DomainNodeIdentity, data), signature)
+}
+
+// identityPublicKey parses the raw X||Y P-256 public key of a node identity
+func identityPublicKey(pubKey []byte) (*ecdsa.PublicKey, bool) {
+	if len(pubKey) != 2*p256CoordLen {
+		return nil, false
+	}
+
+	x, y := new(big.Int).SetBytes(pubKey[:p256CoordLen]), new(big.Int).SetBytes(pubKey[p256CoordLen:])
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, true
+}
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"math/big"
+)
+
+// KeyCurve is the elliptic curve of the wallet and transaction keys of a chain
+type KeyCurve int
+
+const (
+	// CurveP256 keys are NIST P-256 keys with raw X||Y public keys
+	CurveP256 KeyCurve = iota
+
+	// CurveSecp256k1 keys are secp256k1 keys with compressed 33 byte public
+	// keys, as used by Bitcoin
+	CurveSecp256k1
+)
+
+const (
+	// compressedPubKeyLen is the length of a compressed public key
+	compressedPubKeyLen = 33
+
+	// p256CoordLen is the length of a coordinate of a raw P-256 public key,
+	// zero padded
+	p256CoordLen = 32
+)
+
+// ErrInvalidPublicKey is returned when a public key can't be parsed
+var ErrInvalidPublicKey = errors.New("invalid public key")
+
+// Curve returns the elliptic curve
+func (c KeyCurve) Curve() elliptic.Curve {
+	if c == CurveSecp256k1 {
+		return secp256k1.S256()
+	}
+
+	return elliptic.P256()
+}
+
+// String returns the name of the curve
+func (c KeyCurve) String() string {
+	return c.Curve().Params().Name
+}
+
+// encodePublicKey returns the public key as stored in scripts: compressed
+// for secp256k1 keys, raw X||Y for P-256 keys
+func encodePublicKey(pub *ecdsa.PublicKey) []byte {
+	if pub.Curve.Params().Name != secp256k1.S256().Params().Name {
+		raw := make([]byte, 2*p256CoordLen)
+		pub.X.FillBytes(raw[:p256CoordLen])
+		pub.Y.FillBytes(raw[p256CoordLen:])
+
+		return raw
+	}
+
+	compressed := make([]byte, compressedPubKeyLen)
+	compressed[0] = 0x02
+	if pub.Y.Bit(0) == 1 {
+		compressed[0] = 0x03
+	}
+	pub.X.FillBytes(compressed[1:])
+
+	return compressed
+}
+
+// parsePublicKey parses a public key of a script on the curve of the
+// chain, compressed for secp256k1 and raw X||Y for P-256. Keys encoded for
+// the other curve are rejected.
+func parsePublicKey(curve KeyCurve, data []byte) (*ecdsa.PublicKey, error) {
+	if curve == CurveSecp256k1 {
+		if len(data) != compressedPubKeyLen || (data[0] != 0x02 && data[0] != 0x03) {
+			return nil, fmt.Errorf("%w: not a compressed %s key", ErrInvalidPublicKey, curve)
+		}
+
+		key, err := secp256k1.ParsePubKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPublicKey, err)
+		}
+
+		return key.ToECDSA(), nil
+	}
+
+	if len(data) != 2*p256CoordLen {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidPublicKey, len(data))
+	}
+
+	x := new(big.Int).SetBytes(data[:p256CoordLen])
+	y := new(big.Int).SetBytes(data[p256CoordLen:])
+
+	c := curve.Curve()
+	if !c.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("%w: not on %s", ErrInvalidPublicKey, curve)
+	}
+
+	return &ecdsa.PublicKey{Curve: c, X: x, Y: y}, nil
+}
+
+// publicKeyCurve returns the curve a public key is encoded for, for the
+// keys of wallets and signers which aren't tied to a chain
+func publicKeyCurve(data []byte) KeyCurve {
+	if len(data) == compressedPubKeyLen && (data[0] == 0x02 || data[0] == 0x03) {
+		return CurveSecp256k1
+	}
+
+	return CurveP256
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"errors"
+	"testing"
+)
+
+func TestP256PublicKeyFixedWidth(t *testing.T) {
+	// about one key in 128 has a coordinate with a leading zero byte
+	for i := 0; i < 2048; i++ {
+		private, pubKey := newKeyPair(elliptic.P256())
+		if len(pubKey) != 2*p256CoordLen {
+			t.Fatalf("public key of %d bytes, want %d", len(pubKey), 2*p256CoordLen)
+		}
+
+		key, err := parsePublicKey(CurveP256, pubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.X.Cmp(private.X) != 0 || key.Y.Cmp(private.Y) != 0 {
+			t.Fatalf("parsed key %x differs from %x", encodePublicKey(key), pubKey)
+		}
+	}
+}
+
+func TestParsePublicKeyRejectsWrongLength(t *testing.T) {
+	_, pubKey := newKeyPair(elliptic.P256())
+
+	for _, data := range [][]byte{nil, pubKey[1:], append(pubKey, 0), append([]byte{0}, pubKey[:2*p256CoordLen-1]...)} {
+		if _, err := parsePublicKey(CurveP256, data); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("key of %d bytes parsed with error %v", len(data), err)
+		}
+	}
+}
+
+func TestParsePublicKeyRejectsOtherCurve(t *testing.T) {
+	p256 := NewWalletOnCurve(CurveP256).PublicKey
+	secp256k1 := NewWalletOnCurve(CurveSecp256k1).PublicKey
+	uncompressed := append([]byte{0x04}, secp256k1[1:]...)
+
+	tests := []struct {
+		name   string
+		curve  KeyCurve
+		pubKey []byte
+		valid  bool
+	}{
+		{"P-256 key on P-256", CurveP256, p256, true},
+		{"secp256k1 key on secp256k1", CurveSecp256k1, secp256k1, true},
+		{"secp256k1 key on P-256", CurveP256, secp256k1, false},
+		{"P-256 key on secp256k1", CurveSecp256k1, p256, false},
+		{"uncompressed prefix on secp256k1", CurveSecp256k1, uncompressed, false},
+		{"point off P-256", CurveP256, append(append([]byte{}, p256[:p256CoordLen]...), make([]byte, p256CoordLen)...), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := parsePublicKey(test.curve, test.pubKey)
+			if !test.valid {
+				if !errors.Is(err, ErrInvalidPublicKey) {
+					t.Fatalf("error %v, want %v", err, ErrInvalidPublicKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name := key.Curve.Params().Name; name != test.curve.String() {
+				t.Fatalf("key on %s, want %s", name, test.curve)
+			}
+			if encoded := encodePublicKey(key); !bytes.Equal(encoded, test.pubKey) {
+				t.Fatalf("encoded %x, want %x", encoded, test.pubKey)
+			}
+		})
+	}
+}
+
+func TestSignaturesOfOtherCurveAreRejected(t *testing.T) {
+	for _, curve := range []KeyCurve{CurveP256, CurveSecp256k1} {
+		other := CurveSecp256k1
+		if curve == CurveSecp256k1 {
+			other = CurveP256
+		}
+
+		t.Run(curve.String(), func(t *testing.T) {
+			wallet := NewWalletOnCurve(curve)
+			prevOut := *NewTXOutput(10, string(wallet.GetAddress()))
+			tx := newSigHashTestTX()
+			if err := tx.SignPrevOutputs(KeystoreSigner(wallet), []TXOutput{prevOut, prevOut}, SigHashAll); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := VerifyScript(tx.VIn[0].ScriptSig, prevOut.ScriptPubKey, &txSigChecker{tx: tx, index: 0, curve: curve}); err != nil {
+				t.Fatalf("signature rejected on its curve: %v", err)
+			}
+			if err := VerifyScript(tx.VIn[0].ScriptSig, prevOut.ScriptPubKey, &txSigChecker{tx: tx, index: 0, curve: other}); !errors.Is(err, ErrScriptFailed) {
+				t.Fatalf("signature on %s: error %v, want %v", other, err, ErrScriptFailed)
+			}
+
+			address, message := string(wallet.GetAddress()), "hello"
+			signature := wallet.SignMessage(message)
+			if err := VerifyMessage(curve, address, message, signature); err != nil {
+				t.Fatalf("message signature rejected on its curve: %v", err)
+			}
+			if err := VerifyMessage(other, address, message, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("message signature on %s: error %v, want %v", other, err, ErrInvalidSignature)
+			}
+		})
+	}
+}
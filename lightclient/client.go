@@ -0,0 +1,467 @@
+// Package lightclient is an in-process wallet client of remote full nodes,
+// it syncs and checks the block headers, verifies the Merkle proofs of the
+// wallet transactions against them and sends the wallet payments, so an
+// application can embed a wallet without running a full node.
+package lightclient
+
+import (
+	"blockchain"
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTimeout is the default wait for a node response
+	defaultTimeout = 10 * time.Second
+
+	// headerOverlap is the number of known headers requested again to detect reorgs
+	headerOverlap = 6
+)
+
+var (
+	// ErrNoPeers is returned when no node could be synced with
+	ErrNoPeers = errors.New("no node responded")
+
+	// ErrInvalidHeader is returned when a node sends a header not connecting to the chain
+	ErrInvalidHeader = errors.New("invalid header")
+
+	// ErrGenesisMismatch is returned when a node is on another chain than the pinned genesis
+	ErrGenesisMismatch = errors.New("genesis block mismatch")
+
+	// ErrTimeout is returned when a node doesn't respond in time
+	ErrTimeout = errors.New("node response timed out")
+)
+
+// Config configures a Client
+type Config struct {
+	// ListenAddress is sent to the nodes as the address of the client, their
+	// responses are read from the connection of the request
+	ListenAddress string
+
+	// Peers are the addresses of the full nodes
+	Peers []string
+
+	// Params are the chain parameters, MainNetParams when nil
+	Params *blockchain.ChainParams
+
+	// GenesisHash pins the genesis block when set
+	GenesisHash []byte
+
+	// Timeout is the wait for a node response, 10 seconds when zero
+	Timeout time.Duration
+
+	// TLS connects to the nodes over TLS when set, it must hold a client
+	// certificate for nodes requiring mutual authentication
+	TLS *tls.Config
+}
+
+// Tx is a wallet transaction proven to be in a block of the header chain
+type Tx struct {
+	Tx        *blockchain.Transaction
+	BlockHash []byte
+	Height    int
+}
+
+// utxo is an unspent output of the wallet
+type utxo struct {
+	tx   *blockchain.Transaction
+	vout int
+}
+
+// Client is a light wallet client of full nodes
+type Client struct {
+	cfg    Config
+	wallet *blockchain.Wallet
+
+	mu      sync.Mutex
+	headers []blockchain.BlockHeader
+	synced  int
+	txs     []Tx
+	utxos   map[string]utxo
+	spent   map[string]bool
+}
+
+// New creates a Client of the wallet
+func New(wallet *blockchain.Wallet, cfg Config) (*Client, error) {
+	if cfg.Params == nil {
+		params := blockchain.MainNetParams
+		cfg.Params = &params
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	c := &Client{
+		cfg:    cfg,
+		wallet: wallet,
+		synced: -1,
+		utxos:  make(map[string]utxo),
+		spent:  make(map[string]bool),
+	}
+
+	return c, nil
+}
+
+// Close releases the client, no connection outlives its request so there
+// is nothing left to close
+func (c *Client) Close() error {
+	return nil
+}
+
+// Address returns the wallet address
+func (c *Client) Address() string {
+	return string(c.wallet.GetAddress())
+}
+
+// request sends a message to a node and decodes its response of a command into response
+func (c *Client) request(peer, command string, payload interface{}, responseCommand string, response interface{}) error {
+	message, err := blockchain.RequestTLS(peer, blockchain.EncodeMessage(command, payload), responseCommand, c.cfg.Timeout, c.cfg.TLS)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %s", ErrTimeout, peer)
+	}
+	if err != nil {
+		return err
+	}
+
+	return blockchain.DecodeMessagePayload(message, response)
+}
+
+// Sync syncs the headers with every node and adopts the longest valid
+// chain, then fetches and verifies the wallet transactions of the new
+// blocks from the node it was synced with
+func (c *Client) Sync() error {
+	var synced string
+	var lastErr error
+
+	for _, peer := range c.cfg.Peers {
+		if err := c.syncHeaders(peer); err != nil {
+			log.Printf("header sync with %s failed: %s\n", peer, err)
+			lastErr = err
+			continue
+		}
+
+		synced = peer
+	}
+
+	if synced == "" {
+		if lastErr != nil {
+			return fmt.Errorf("%w: %s", ErrNoPeers, lastErr)
+		}
+		return ErrNoPeers
+	}
+
+	return c.syncTransactions(synced)
+}
+
+// syncHeaders fetches the headers of a node until its tip
+func (c *Client) syncHeaders(peer string) error {
+	for {
+		c.mu.Lock()
+		from := len(c.headers) - headerOverlap
+		c.mu.Unlock()
+		if from < 0 {
+			from = 0
+		}
+
+		var response blockchain.HeadersMessage
+		payload := blockchain.GetHeadersMessage{AddrFrom: c.cfg.ListenAddress, FromHeight: from}
+		if err := c.request(peer, blockchain.CommandGetHeaders, payload, blockchain.CommandHeaders, &response); err != nil {
+			return err
+		}
+
+		if response.Error != "" {
+			return errors.New(response.Error)
+		}
+
+		extended, err := c.connectHeaders(from, response.Headers)
+		if err != nil {
+			return err
+		}
+
+		if !extended || len(response.Headers) < blockchain.MaxHeadersPerMessage {
+			return nil
+		}
+	}
+}
+
+// connectHeaders checks headers starting at a height and replaces the
+// chain from the first differing header when they make it longer, it
+// returns whether the chain changed
+func (c *Client) connectHeaders(from int, headers []blockchain.BlockHeader) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fork := from
+	for _, header := range headers {
+		if fork >= len(c.headers) || !bytes.Equal(c.headers[fork].Hash, header.Hash) {
+			break
+		}
+		fork++
+	}
+
+	branch := headers[fork-from:]
+	if len(branch) == 0 || fork+len(branch) <= len(c.headers) {
+		return false, nil
+	}
+
+	for i := range branch {
+		header := &branch[i]
+		if header.Height != fork+i {
+			return false, fmt.Errorf("%w: %x has height %d, expected %d", ErrInvalidHeader, header.Hash, header.Height, fork+i)
+		}
+
+		if header.Height == 0 {
+			if len(c.cfg.GenesisHash) != 0 && !bytes.Equal(header.Hash, c.cfg.GenesisHash) {
+				return false, fmt.Errorf("%w: %x", ErrGenesisMismatch, header.Hash)
+			}
+		} else {
+			prev := c.prevHeader(fork, branch, i)
+			if !bytes.Equal(header.PrevBlockHash, prev.Hash) {
+				return false, fmt.Errorf("%w: %x doesn't connect to %x", ErrInvalidHeader, header.Hash, prev.Hash)
+			}
+		}
+
+		if err := header.Validate(c.cfg.Params.TargetBits); err != nil {
+			return false, fmt.Errorf("%w: %s", ErrInvalidHeader, err)
+		}
+	}
+
+	if fork < len(c.headers) {
+		log.Printf("reorganizing the headers from height %d\n", fork)
+		c.resetTransactions()
+	}
+
+	c.headers = append(c.headers[:fork], branch...)
+
+	return true, nil
+}
+
+// prevHeader returns the header before the header at index i of a branch
+// replacing the chain from the fork height
+func (c *Client) prevHeader(fork int, branch []blockchain.BlockHeader, i int) blockchain.BlockHeader {
+	if i > 0 {
+		return branch[i-1]
+	}
+
+	return c.headers[fork-1]
+}
+
+// resetTransactions forgets the wallet transactions so they are fetched again
+func (c *Client) resetTransactions() {
+	c.synced = -1
+	c.txs = nil
+	c.utxos = make(map[string]utxo)
+	c.spent = make(map[string]bool)
+}
+
+// syncTransactions fetches the wallet transactions of the blocks not synced
+// yet and verifies their proofs against the headers
+func (c *Client) syncTransactions(peer string) error {
+	c.mu.Lock()
+	payload := blockchain.GetTxProofsMessage{
+		AddrFrom:     c.cfg.ListenAddress,
+		PubKeyHashes: [][]byte{blockchain.HashPubKey(c.wallet.PublicKey)},
+		FromHeight:   c.synced + 1,
+	}
+	for _, u := range c.utxos {
+		payload.Outpoints = append(payload.Outpoints, blockchain.Outpoint{TxID: u.tx.ID, VOut: u.vout})
+	}
+	c.mu.Unlock()
+
+	var response blockchain.TxProofsMessage
+	if err := c.request(peer, blockchain.CommandGetTxProofs, payload, blockchain.CommandTxProofs, &response); err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.synced+1 != payload.FromHeight {
+		return fmt.Errorf("headers reorganized during the sync with %s", peer)
+	}
+
+	var txs []Tx
+	for i := range response.Proofs {
+		proof := &response.Proofs[i]
+		if proof.Height < payload.FromHeight || proof.Height >= len(c.headers) {
+			return fmt.Errorf("%w: height %d is outside the synced headers", blockchain.ErrInvalidTxProof, proof.Height)
+		}
+
+		tx, err := proof.Verify(c.headers[proof.Height])
+		if err != nil {
+			return err
+		}
+
+		txs = append(txs, Tx{Tx: tx, BlockHash: proof.BlockHash, Height: proof.Height})
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].Height < txs[j].Height })
+	for _, tx := range txs {
+		c.applyTransaction(tx)
+	}
+	c.synced = len(c.headers) - 1
+
+	return nil
+}
+
+// applyTransaction records a wallet transaction and updates the unspent outputs
+func (c *Client) applyTransaction(tx Tx) {
+	c.txs = append(c.txs, tx)
+
+	if !tx.Tx.IsCoinbase() {
+		for _, vin := range tx.Tx.VIn {
+			key := outpointKey(vin.TxID, vin.VOut)
+			delete(c.utxos, key)
+			delete(c.spent, key)
+		}
+	}
+
+	pubKeyHash := blockchain.HashPubKey(c.wallet.PublicKey)
+	for i := range tx.Tx.VOut {
+		if tx.Tx.VOut[i].IsLockedWithKey(pubKeyHash) {
+			c.utxos[outpointKey(tx.Tx.ID, i)] = utxo{tx: tx.Tx, vout: i}
+		}
+	}
+}
+
+// Height returns the height of the synced header chain, -1 before the first sync
+func (c *Client) Height() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.headers) - 1
+}
+
+// Header returns the synced header at a height
+func (c *Client) Header(height int) (blockchain.BlockHeader, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if height < 0 || height >= len(c.headers) {
+		return blockchain.BlockHeader{}, false
+	}
+
+	return c.headers[height], true
+}
+
+// Balance returns the value of the confirmed wallet outputs not spent by a
+// transaction sent by the client
+func (c *Client) Balance() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	balance := 0
+	for key, u := range c.utxos {
+		if !c.spent[key] {
+			balance += u.tx.VOut[u.vout].Value
+		}
+	}
+
+	return balance
+}
+
+// Transactions returns the proven wallet transactions in chain order
+func (c *Client) Transactions() []Tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Tx{}, c.txs...)
+}
+
+// Send pays amount to an address with a fee, the change goes back to the
+// wallet. The transaction is sent to every node and its outputs are
+// spendable once it is synced in a block.
+func (c *Client) Send(to string, amount, fee int) (*blockchain.Transaction, error) {
+	return c.SendMany([]blockchain.Payment{{Address: to, Amount: amount}}, fee)
+}
+
+// SendMany pays every payment in a single transaction with a fee, the
+// change goes back to the wallet like with Send
+func (c *Client) SendMany(payments []blockchain.Payment, fee int) (*blockchain.Transaction, error) {
+	if len(payments) == 0 {
+		return nil, errors.New("no payments")
+	}
+
+	amount := 0
+	for _, payment := range payments {
+		amount += payment.Amount
+	}
+
+	c.mu.Lock()
+
+	keys := make([]string, 0, len(c.utxos))
+	for key := range c.utxos {
+		if !c.spent[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	builder := blockchain.NewTxBuilder(c.cfg.Params, len(c.headers))
+	acc := 0
+	var selected []string
+	for _, key := range keys {
+		if acc >= amount+fee {
+			break
+		}
+
+		u := c.utxos[key]
+		builder.AddInputFrom(u.tx, u.vout)
+		acc += u.tx.VOut[u.vout].Value
+		selected = append(selected, key)
+	}
+	c.mu.Unlock()
+
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: have %d, need %d", blockchain.ErrInsufficientFunds, acc, amount+fee)
+	}
+
+	for _, payment := range payments {
+		builder.AddOutput(payment.Amount, payment.Address)
+	}
+
+	tx, err := builder.SetFee(fee).SetChangeAddress(c.Address()).Sign(blockchain.KeystoreSigner(c.wallet)).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	sent := 0
+	for _, peer := range c.cfg.Peers {
+		if err = blockchain.SendTransactionTLS(peer, c.cfg.ListenAddress, tx, c.cfg.TLS); err != nil {
+			log.Printf("sending %x to %s failed: %s\n", tx.ID, peer, err)
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoPeers, err)
+	}
+
+	c.mu.Lock()
+	for _, key := range selected {
+		c.spent[key] = true
+	}
+	c.mu.Unlock()
+
+	return tx, nil
+}
+
+// outpointKey returns the map key of a transaction output
+func outpointKey(txID []byte, vout int) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(txID), vout)
+}
@@ -0,0 +1,401 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"time"
+)
+
+const (
+	// CommandGetHeaders get block headers
+	CommandGetHeaders = "getheaders"
+
+	// CommandHeaders block headers
+	CommandHeaders = "headers"
+
+	// CommandGetTxProofs get wallet transaction proofs
+	CommandGetTxProofs = "gettxproofs"
+
+	// CommandTxProofs wallet transaction proofs
+	CommandTxProofs = "txproofs"
+)
+
+// MaxHeadersPerMessage is the maximum number of headers of a headers message
+const MaxHeadersPerMessage = 2000
+
+var (
+	// ErrInvalidTxProof is returned when a transaction proof doesn't match its block header
+	ErrInvalidTxProof = errors.New("invalid transaction proof")
+
+	// ErrMalformedMessage is returned for a message too short to carry a
+	// command or a corrupted frame
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// BlockHeader is a block without its transactions, it commits to them with
+// their Merkle root so light clients can check its proof of work
+type BlockHeader struct {
+	Timestamp      int64
+	PrevBlockHash  []byte
+	MerkleRoot     []byte
+	Hash           []byte
+	Nonce          int
+	Height         int
+	UTXOCommitment []byte
+}
+
+// Header returns the header of the block
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Timestamp:      b.Timestamp,
+		PrevBlockHash:  b.PrevBlockHash,
+		MerkleRoot:     b.HashTransactions(),
+		Hash:           b.Hash,
+		Nonce:          b.Nonce,
+		Height:         b.Height,
+		UTXOCommitment: b.UTXOCommitment,
+	}
+}
+
+// Validate checks the header hash and its proof of work at a difficulty
+func (h *BlockHeader) Validate(bits int) error {
+	block := &Block{
+		Timestamp:      h.Timestamp,
+		PrevBlockHash:  h.PrevBlockHash,
+		Hash:           h.Hash,
+		Nonce:          h.Nonce,
+		Height:         h.Height,
+		UTXOCommitment: h.UTXOCommitment,
+	}
+
+	pow := NewProofOfWorkWithBits(block, bits)
+	pow.txHash = h.MerkleRoot
+	if !pow.Validate() {
+		return fmt.Errorf("%w: header %x", ErrInvalidProofOfWork, h.Hash)
+	}
+
+	return nil
+}
+
+// GetHeaders returns the headers of at most max main chain blocks from a height
+func (bc *Blockchain) GetHeaders(fromHeight, max int) ([]BlockHeader, error) {
+	var headers []BlockHeader
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte(tipDbKey))))
+
+		for height := fromHeight; height <= tip.Height && len(headers) < max; height++ {
+			block, err := blockAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			headers = append(headers, block.Header())
+		}
+
+		return nil
+	})
+
+	return headers, err
+}
+
+// Outpoint is a transaction output
+type Outpoint struct {
+	TxID []byte
+	VOut int
+}
+
+// Bytes returns the transaction ID followed by the output index as a
+// little endian 32-bit integer, the item of the outpoint in bloom filters
+func (o Outpoint) Bytes() []byte {
+	data := make([]byte, len(o.TxID)+4)
+	copy(data, o.TxID)
+	binary.LittleEndian.PutUint32(data[len(o.TxID):], uint32(o.VOut))
+
+	return data
+}
+
+// TxProof proves a transaction is in a main chain block
+type TxProof struct {
+	BlockHash   []byte
+	Height      int
+	Transaction []byte
+	Proof       MerkleProof
+}
+
+// Verify checks the proof against the header of its block and returns the
+// proven transaction
+func (p *TxProof) Verify(header BlockHeader) (*Transaction, error) {
+	if !bytes.Equal(p.BlockHash, header.Hash) || p.Height != header.Height {
+		return nil, fmt.Errorf("%w: block %x at height %d isn't the header's", ErrInvalidTxProof, p.BlockHash, p.Height)
+	}
+
+	tx, err := TryDeserializeTransaction(p.Transaction)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTxProof, err)
+	}
+
+	leaf := p.Transaction
+	if tx.isCanonical() {
+		leaf = tx.merkleLeaf()
+	}
+
+	if !p.Proof.Verify(header.MerkleRoot, leaf) {
+		return nil, fmt.Errorf("%w: not in block %x", ErrInvalidTxProof, p.BlockHash)
+	}
+
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return nil, fmt.Errorf("%w: transaction %x doesn't match its id", ErrInvalidTxProof, tx.ID)
+	}
+
+	return tx, nil
+}
+
+// FindTxProofs returns the proofs of the main chain transactions from a
+// height paying to the public key hashes or spending the outpoints, the
+// transactions spending their outputs are included too
+func (bc *Blockchain) FindTxProofs(pubKeyHashes [][]byte, outpoints []Outpoint, fromHeight int) ([]TxProof, error) {
+	watched := make(map[string]bool, len(pubKeyHashes))
+	for _, pubKeyHash := range pubKeyHashes {
+		watched[hex.EncodeToString(pubKeyHash)] = true
+	}
+
+	owned := make(map[string]bool, len(outpoints))
+	for _, outpoint := range outpoints {
+		owned[outpointKey(outpoint.TxID, outpoint.VOut)] = true
+	}
+
+	var proofs []TxProof
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte(tipDbKey))))
+
+		for height := fromHeight; height <= tip.Height; height++ {
+			block, err := blockAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			var data [][]byte
+			for _, t := range block.Transactions {
+				data = append(data, t.merkleLeaf())
+			}
+
+			for i, t := range block.Transactions {
+				relevant := false
+				if !t.IsCoinbase() {
+					for _, vin := range t.VIn {
+						relevant = relevant || owned[outpointKey(vin.TxID, vin.VOut)]
+					}
+				}
+
+				for outIdx, out := range t.VOut {
+					if watched[hex.EncodeToString(out.PubKeyHash())] {
+						owned[outpointKey(t.ID, outIdx)] = true
+						relevant = true
+					}
+				}
+
+				if relevant {
+					proofs = append(proofs, TxProof{
+						BlockHash:   block.Hash,
+						Height:      block.Height,
+						Transaction: t.Serialize(),
+						Proof:       NewMerkleProof(data, i),
+					})
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return proofs, err
+}
+
+// GetHeadersMessage asks a node for the main chain headers from a height
+type GetHeadersMessage struct {
+	AddrFrom   string
+	FromHeight int
+}
+
+// HeadersMessage is the response to a GetHeadersMessage
+type HeadersMessage struct {
+	AddrFrom string
+	Headers  []BlockHeader
+	Error    string
+}
+
+// GetTxProofsMessage asks a node for the proofs of the transactions of a
+// wallet from a height, see Blockchain.FindTxProofs
+type GetTxProofsMessage struct {
+	AddrFrom     string
+	PubKeyHashes [][]byte
+	Outpoints    []Outpoint
+	FromHeight   int
+}
+
+// TxProofsMessage is the response to a GetTxProofsMessage
+type TxProofsMessage struct {
+	AddrFrom string
+	Proofs   []TxProof
+	Error    string
+}
+
+// EncodeMessage encodes a command and its payload as sent to nodes
+func EncodeMessage(command string, payload interface{}) []byte {
+	return append(commandToBytes(command), gobEncode(payload)...)
+}
+
+// MessageCommand returns the command of a message
+func MessageCommand(message []byte) (string, error) {
+	if len(message) < commandLength {
+		return "", fmt.Errorf("%w: %d bytes", ErrMalformedMessage, len(message))
+	}
+
+	return bytesToCommand(message[:commandLength]), nil
+}
+
+// DecodeMessagePayload decodes the payload of a message
+func DecodeMessagePayload(message []byte, payload interface{}) error {
+	if len(message) < commandLength {
+		return fmt.Errorf("%w: %d bytes", ErrMalformedMessage, len(message))
+	}
+
+	return gob.NewDecoder(bytes.NewReader(message[commandLength:])).Decode(payload)
+}
+
+// SendMessage sends a message to a node of the main chain after a handshake
+func SendMessage(addr string, message []byte) error {
+	return SendMessageTLS(addr, message, nil)
+}
+
+// SendMessageTLS sends a message to a node after a handshake, over TLS
+// when config is set
+func SendMessageTLS(addr string, message []byte, config *tls.Config) error {
+	conn, err := DialNode(addr, peerDialTimeout, config)
+	if err != nil {
+		return err
+	}
+
+	if err = conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	if err = clientHandshake(conn, DefaultNetworkMagic); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	if err = WriteMessage(conn, message); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	// closing with messages of the node unread resets the connection, and
+	// the node may drop the message before reading it, so the node closes
+	// first once it reads the end of the stream
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok && closer.CloseWrite() == nil {
+		_, _ = io.Copy(io.Discard, conn)
+	}
+
+	return conn.Close()
+}
+
+// Request sends a message to a node of the main chain after a handshake
+// and returns its response of a command, read from the same connection.
+// Other messages of the node are skipped.
+func Request(addr string, message []byte, responseCommand string, timeout time.Duration) ([]byte, error) {
+	return RequestTLS(addr, message, responseCommand, timeout, nil)
+}
+
+// RequestTLS is Request over TLS when config is set
+func RequestTLS(addr string, message []byte, responseCommand string, timeout time.Duration, config *tls.Config) ([]byte, error) {
+	conn, err := DialNode(addr, timeout, config)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	if err = clientHandshake(conn, DefaultNetworkMagic); err != nil {
+		return nil, err
+	}
+
+	if err = WriteMessage(conn, message); err != nil {
+		return nil, err
+	}
+
+	for {
+		response, err := ReadMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if command, _ := MessageCommand(response); command == responseCommand {
+			return response, nil
+		}
+	}
+}
+
+// SendTransaction sends a transaction to a node, addrFrom is the address
+// of the sender
+func SendTransaction(addr, addrFrom string, tx *Transaction) error {
+	return SendTransactionTLS(addr, addrFrom, tx, nil)
+}
+
+// SendTransactionTLS sends a transaction to a node, over TLS when config is set
+func SendTransactionTLS(addr, addrFrom string, tx *Transaction, config *tls.Config) error {
+	return SendMessageTLS(addr, EncodeMessage(CommandTx, txData{AddrFrom: addrFrom, Transaction: tx.Serialize()}), config)
+}
+
+// handleGetHeaders handles CommandGetHeaders request
+func (s *Server) handleGetHeaders(p *peerConn, request []byte) {
+	var payload GetHeadersMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	response := HeadersMessage{AddrFrom: s.address}
+	headers, err := s.bc.GetHeaders(payload.FromHeight, MaxHeadersPerMessage)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Headers = headers
+	}
+
+	p.send(CommandHeaders, response)
+}
+
+// handleGetTxProofs handles CommandGetTxProofs request
+func (s *Server) handleGetTxProofs(p *peerConn, request []byte) {
+	var payload GetTxProofsMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	response := TxProofsMessage{AddrFrom: s.address}
+	proofs, err := s.bc.FindTxProofs(payload.PubKeyHashes, payload.Outpoints, payload.FromHeight)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Proofs = proofs
+	}
+
+	p.send(CommandTxProofs, response)
+}
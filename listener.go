@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// peerSocketMode is the file mode of Unix sockets for local peers
+const peerSocketMode = 0600
+
+// ErrNoExternalAddress is returned when other nodes can't dial the address
+// a node advertises
+var ErrNoExternalAddress = errors.New("no external address")
+
+// ListenerPolicy is the policy applied to the connections of a listener
+type ListenerPolicy struct {
+	// MaxConnections limits the concurrent connections, 0 is unlimited
+	MaxConnections int
+
+	// RateLimitExempt exempts the connections from rate limits, e.g. for
+	// trusted local peers
+	RateLimitExempt bool
+}
+
+// ListenerConfig is an endpoint the server listens on
+type ListenerConfig struct {
+	// Network is "tcp", "tcp4", "tcp6", "unix" or "ws" for WebSocket peers,
+	// e.g. browser dashboards and JavaScript light clients, or a network of
+	// ServerConfig.Transport like "libp2p"
+	Network string
+
+	// Address is the host:port to bind, the socket path for "unix" or the
+	// multiaddr for "libp2p"
+	Address string
+
+	// Path is the HTTP path of "ws" listeners, "/" when empty
+	Path string
+
+	Policy ListenerPolicy
+}
+
+// String returns the network and address of the listener
+func (c ListenerConfig) String() string {
+	if c.Network == "ws" {
+		return fmt.Sprintf("ws://%s%s", c.Address, c.path())
+	}
+
+	return fmt.Sprintf("%s://%s", c.Network, c.Address)
+}
+
+// path returns the HTTP path of a "ws" listener
+func (c ListenerConfig) path() string {
+	if c.Path == "" {
+		return "/"
+	}
+
+	return c.Path
+}
+
+// DefaultListeners returns the listeners of a node, the localhost TCP port
+// named by the node id
+func DefaultListeners(nodeID string) []ListenerConfig {
+	return []ListenerConfig{{Network: protocol, Address: fmt.Sprintf("localhost:%s", nodeID)}}
+}
+
+// listen opens the listener
+func (c ListenerConfig) listen() (net.Listener, error) {
+	switch c.Network {
+	case "tcp", "tcp4", "tcp6":
+		return net.Listen(c.Network, c.Address)
+	case "ws":
+		return net.Listen(protocol, c.Address)
+	case "unix":
+		if err := checkSocketDir(filepath.Dir(c.Address)); err != nil {
+			return nil, err
+		}
+
+		if err := os.Remove(c.Address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		ln, err := net.Listen(c.Network, c.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = os.Chmod(c.Address, peerSocketMode); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+
+		return ln, nil
+	default:
+		return nil, fmt.Errorf("unsupported listener network %q", c.Network)
+	}
+}
+
+// advertisedAddress returns the address other nodes reach the node at, the
+// first TCP listener
+func advertisedAddress(listeners []ListenerConfig) string {
+	for _, l := range listeners {
+		if l.Network != "unix" && l.Network != "ws" {
+			return l.Address
+		}
+	}
+
+	return ""
+}
+
+// checkAdvertisedAddress checks other nodes can dial an advertised address,
+// a listener bound to every interface or a random port can't be advertised
+func checkAdvertisedAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNoExternalAddress, err)
+	}
+
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() || port == "0" {
+		return fmt.Errorf("%w: %s can't be dialed, set ServerConfig.ExternalAddress", ErrNoExternalAddress, addr)
+	}
+
+	return nil
+}
+
+// checkAddress checks other nodes can dial the advertised address, with
+// the check of the transport when it doesn't name nodes by host:port
+func (s *Server) checkAddress(addr string) error {
+	if addresser, ok := s.transport.(Addresser); ok {
+		return addresser.CheckAddress(addr)
+	}
+
+	return checkAdvertisedAddress(addr)
+}
+
+// openListeners opens every listener, none is left open on error. The TCP
+// listeners only accept TLS when tlsConfig is set, or Noise when noise is.
+// The WebSocket listeners are served over TLS when tlsConfig is set, never
+// over Noise which browsers don't speak.
+func openListeners(configs []ListenerConfig, tlsConfig *tls.Config, noise *noiseKey) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	for _, config := range configs {
+		ln, err := config.listen()
+		if err != nil {
+			for _, open := range listeners {
+				_ = open.Close()
+			}
+
+			return nil, fmt.Errorf("listen on %s: %w", config, err)
+		}
+
+		switch {
+		case config.Network == "unix":
+			log.Printf("Listening on %s\n", config)
+		case config.Network == "ws" && tlsConfig != nil:
+			ln = newWSListener(tls.NewListener(ln, tlsConfig), config.path())
+			log.Printf("Listening on %s with TLS\n", config)
+		case config.Network == "ws":
+			ln = newWSListener(ln, config.path())
+			log.Printf("Listening on %s\n", config)
+		case tlsConfig != nil:
+			ln = tls.NewListener(ln, tlsConfig)
+			log.Printf("Listening on %s with TLS\n", config)
+		case noise != nil:
+			ln = noiseListener{Listener: ln, key: noise}
+			log.Printf("Listening on %s with Noise\n", config)
+		default:
+			log.Printf("Listening on %s\n", config)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
+
+// acceptConnections serves the connections of a listener with its policy
+// until it is closed
+func acceptConnections(ln net.Listener, policy ListenerPolicy, handle func(net.Conn)) error {
+	var slots chan struct{}
+	if policy.MaxConnections > 0 {
+		slots = make(chan struct{}, policy.MaxConnections)
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				log.Printf("Rejecting connection from %s, listener %s is full\n", conn.RemoteAddr(), ln.Addr())
+				_ = conn.Close()
+				continue
+			}
+		}
+
+		go func() {
+			defer func() {
+				if slots != nil {
+					<-slots
+				}
+
+				if closeErr := conn.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+					log.Println(closeErr)
+				}
+			}()
+
+			handle(conn)
+		}()
+	}
+}
@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+const (
+	// SequenceFinal is the sequence of inputs that don't enable the transaction locktime
+	SequenceFinal = 0xffffffff
+
+	// LockTimeThreshold separates block heights from unix timestamps in transaction locktimes
+	LockTimeThreshold = 500000000
+
+	// SequenceLockTimeDisabled is the sequence flag disabling the relative locktime of an input
+	SequenceLockTimeDisabled = 1 << 31
+
+	// SequenceLockTimeTypeFlag is the sequence flag of relative locktimes in time units instead of blocks
+	SequenceLockTimeTypeFlag = 1 << 22
+
+	// SequenceLockTimeMask extracts the relative locktime from a sequence
+	SequenceLockTimeMask = 0x0000ffff
+
+	// SequenceLockTimeGranularity is the log2 of the seconds in a relative locktime unit
+	SequenceLockTimeGranularity = 9
+)
+
+var (
+	// ErrNonFinalTx is returned for a transaction whose locktime isn't reached yet
+	ErrNonFinalTx = errors.New("transaction is not final")
+
+	// ErrSequenceLocked is returned for a transaction spending an output before its relative locktime
+	ErrSequenceLocked = errors.New("input is sequence locked")
+)
+
+// IsFinal returns whether the transaction can be included in a block at
+// height with the timestamp. The locktime is a block height below
+// LockTimeThreshold and a unix timestamp otherwise, it is ignored when every
+// input has the final sequence.
+func (tx *Transaction) IsFinal(height int, timestamp int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	limit := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		limit = timestamp
+	}
+
+	if int64(tx.LockTime) < limit {
+		return true
+	}
+
+	for _, vin := range tx.VIn {
+		if vin.Sequence != SequenceFinal {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkTxFinal checks the transaction can be included in a block at height with the timestamp
+func checkTxFinal(tx *Transaction, height int, timestamp int64) error {
+	if !tx.IsFinal(height, timestamp) {
+		return fmt.Errorf("%w: %x is locked until %d", ErrNonFinalTx, tx.ID, tx.LockTime)
+	}
+
+	return nil
+}
+
+// RelativeLockBlocks returns the input sequence requiring the spent output
+// to have blocks confirmations
+func RelativeLockBlocks(blocks uint16) uint32 {
+	return uint32(blocks)
+}
+
+// RelativeLockTime returns the input sequence requiring the spent output to
+// be confirmed for at least d, rounded up to 512 seconds units
+func RelativeLockTime(d time.Duration) (uint32, error) {
+	units := (int64(d/time.Second) + 1<<SequenceLockTimeGranularity - 1) >> SequenceLockTimeGranularity
+	if units < 0 || units > SequenceLockTimeMask {
+		return 0, fmt.Errorf("relative locktime %s is out of range", d)
+	}
+
+	return SequenceLockTimeTypeFlag | uint32(units), nil
+}
+
+// sequenceLocked returns whether an input with the sequence can't spend an
+// output confirmed at confHeight and confTime in a block at height with the timestamp
+func sequenceLocked(sequence uint32, confHeight int, confTime int64, height int, timestamp int64) bool {
+	if sequence&SequenceLockTimeDisabled != 0 {
+		return false
+	}
+
+	value := int64(sequence & SequenceLockTimeMask)
+	if sequence&SequenceLockTimeTypeFlag != 0 {
+		return timestamp-confTime < value<<SequenceLockTimeGranularity
+	}
+
+	return int64(height-confHeight) < value
+}
+
+// checkSequenceLocks checks the relative locktimes of the inputs of a
+// transaction for a block at height with the timestamp, outputs of pending
+// transactions are confirmed by that block
+func (bc *Blockchain) checkSequenceLocks(tx *Transaction, pending map[string]*Transaction, height int, timestamp int64) error {
+	return bc.db.View(func(btx *bolt.Tx) error {
+		return sequenceLocks(btx.Bucket([]byte(utxoBucket)), tx, pending, height, timestamp)
+	})
+}
+
+// sequenceLocks checks the relative locktimes of the inputs of a
+// transaction against the outputs of the UTXO set bucket
+func sequenceLocks(utxos *bolt.Bucket, tx *Transaction, pending map[string]*Transaction, height int, timestamp int64) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	for _, vin := range tx.VIn {
+		if vin.Sequence&SequenceLockTimeDisabled != 0 {
+			continue
+		}
+
+		confHeight, confTime := height, timestamp
+		if _, ok := pending[hex.EncodeToString(vin.TxID)]; !ok {
+			outsData := utxos.Get(vin.TxID)
+			if outsData == nil {
+				return fmt.Errorf("%w: %x spends %x:%d", ErrDoubleSpend, tx.ID, vin.TxID, vin.VOut)
+			}
+
+			outs := DeserializeOutputs(outsData)
+			confHeight, confTime = outs.Height, outs.Timestamp
+		}
+
+		if sequenceLocked(vin.Sequence, confHeight, confTime, height, timestamp) {
+			return fmt.Errorf("%w: %x spends %x:%d with sequence %#x", ErrSequenceLocked, tx.ID, vin.TxID, vin.VOut, vin.Sequence)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,511 @@
+package blockchain
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTxInMempool is returned when a transaction is already in the mempool
+	ErrTxInMempool = errors.New("transaction is already in the mempool")
+
+	// ErrCoinbaseInMempool is returned when a coinbase transaction is added to the mempool
+	ErrCoinbaseInMempool = errors.New("coinbase transaction can't be in the mempool")
+)
+
+// MempoolEntry is a transaction waiting in the mempool
+type MempoolEntry struct {
+	Tx    *Transaction
+	Fee   int
+	Size  int
+	Added time.Time
+
+	// parents and children are the ids of the in-mempool transactions this
+	// one spends and is spent by
+	parents  map[string]bool
+	children map[string]bool
+}
+
+// FeeRate returns the fee paid per byte
+func (e *MempoolEntry) FeeRate() float64 {
+	return feeRate(e.Fee, e.Size)
+}
+
+// feeRate returns the fee paid per byte
+func feeRate(fee, size int) float64 {
+	if size == 0 {
+		return 0
+	}
+
+	return float64(fee) / float64(size)
+}
+
+// Mempool keeps valid transactions until they are mined, transactions may
+// spend outputs of other transactions in the mempool but no output is spent
+// twice
+type Mempool struct {
+	mu  sync.RWMutex
+	bc  *Blockchain
+	txs map[string]*MempoolEntry
+
+	// spent maps the outpoints spent by mempool transactions to their spender
+	spent map[string]string
+
+	policy Policy
+}
+
+// NewMempool creates an empty Mempool with the DefaultPolicy following the chain events of bc
+func NewMempool(bc *Blockchain) *Mempool {
+	mp := &Mempool{bc: bc, txs: make(map[string]*MempoolEntry), spent: make(map[string]string), policy: DefaultPolicy}
+	bc.Subscribe(mp.handleChainEvent)
+
+	return mp
+}
+
+// handleChainEvent removes the transactions of connected blocks and returns
+// the transactions of disconnected blocks to the mempool
+func (mp *Mempool) handleChainEvent(event ChainEvent) {
+	switch event.Type {
+	case BlockConnected:
+		mp.RemoveBlock(event.Block)
+	case BlockDisconnected:
+		mp.AddDisconnected(event.Block)
+	}
+}
+
+// AddDisconnected returns the transactions of a block disconnected by a reorg
+// to the mempool, those no longer valid on the new chain are dropped
+func (mp *Mempool) AddDisconnected(block *Block) {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		if err := mp.Add(tx); err != nil {
+			log.Printf("transaction %x of disconnected block %x is dropped: %s\n", tx.ID, block.Hash, err)
+		}
+	}
+}
+
+// Add validates a transaction and adds it to the mempool
+func (mp *Mempool) Add(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return ErrCoinbaseInMempool
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := mp.txs[id]; ok {
+		return fmt.Errorf("%w: %s", ErrTxInMempool, id)
+	}
+
+	height := mp.bc.GetBestHeight() + 1
+	if err := checkTxVersion(tx.Version, mp.bc.Params(), height); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+
+	if err := checkTxFinal(tx, height, mp.bc.blockTimestamp(height)); err != nil {
+		return err
+	}
+
+	if err := checkStandard(tx, &mp.policy); err != nil {
+		return err
+	}
+
+	conflicts := make(map[string]*MempoolEntry)
+	for _, vin := range tx.VIn {
+		key := outpointKey(vin.TxID, vin.VOut)
+		if spender, ok := mp.spent[key]; ok {
+			if !mp.policy.ReplaceByFee {
+				return fmt.Errorf("%w: %s spends %s, already spent by %s", ErrDoubleSpend, id, key, spender)
+			}
+			conflicts[spender] = mp.txs[spender]
+		}
+	}
+
+	// replaced are the conflicting transactions and their descendants
+	replaced := make(map[string]*MempoolEntry)
+	for conflictID, entry := range conflicts {
+		replaced[conflictID] = entry
+		for _, descendant := range mp.collect(conflictID, func(e *MempoolEntry) map[string]bool { return e.children }) {
+			replaced[hex.EncodeToString(descendant.Tx.ID)] = descendant
+		}
+	}
+
+	pending := make(map[string]*Transaction)
+	parents := make(map[string]bool)
+	for _, vin := range tx.VIn {
+		parentID := hex.EncodeToString(vin.TxID)
+		if _, ok := replaced[parentID]; ok {
+			return fmt.Errorf("%w: %s spends %s which it replaces", ErrReplacementRejected, id, parentID)
+		}
+
+		if parent, ok := mp.txs[parentID]; ok {
+			pending[parentID] = parent.Tx
+			parents[parentID] = true
+		}
+	}
+
+	fee, err := mp.bc.checkTransaction(tx, pending)
+	if err != nil {
+		return err
+	}
+
+	if err := mp.bc.checkSequenceLocks(tx, pending, height, mp.bc.blockTimestamp(height)); err != nil {
+		return err
+	}
+
+	size := len(tx.Serialize())
+	if rate := feeRate(fee, size); rate < mp.policy.MinRelayFeeRate {
+		return fmt.Errorf("%w: %s pays %.3f per byte, min %.3f", ErrFeeTooLow, id, rate, mp.policy.MinRelayFeeRate)
+	}
+
+	if len(replaced) > 0 {
+		if err := checkReplacement(id, fee, size, conflicts, replaced, &mp.policy); err != nil {
+			return err
+		}
+
+		for replacedID := range replaced {
+			mp.remove(replacedID)
+		}
+		log.Printf("transaction %s replaced %d mempool transactions\n", id, len(replaced))
+	}
+
+	mp.txs[id] = &MempoolEntry{
+		Tx:       tx,
+		Fee:      fee,
+		Size:     size,
+		Added:    time.Now(),
+		parents:  parents,
+		children: make(map[string]bool),
+	}
+
+	for parentID := range parents {
+		mp.txs[parentID].children[id] = true
+	}
+
+	for _, vin := range tx.VIn {
+		mp.spent[outpointKey(vin.TxID, vin.VOut)] = id
+	}
+
+	return nil
+}
+
+// Policy returns the relay and mining policy of the mempool
+func (mp *Mempool) Policy() Policy {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.policy
+}
+
+// SetPolicy sets the relay and mining policy of the mempool, transactions
+// already in the mempool are kept
+func (mp *Mempool) SetPolicy(policy Policy) error {
+	if err := policy.validate(mp.bc.Params()); err != nil {
+		return err
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.policy = policy
+	return nil
+}
+
+// Has returns whether the transaction is in the mempool
+func (mp *Mempool) Has(txID []byte) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	_, ok := mp.txs[hex.EncodeToString(txID)]
+	return ok
+}
+
+// Get returns a transaction in the mempool
+func (mp *Mempool) Get(txID []byte) (*Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	entry, ok := mp.txs[hex.EncodeToString(txID)]
+	if !ok {
+		return nil, false
+	}
+
+	return entry.Tx, true
+}
+
+// Entry returns the entry of a transaction in the mempool
+func (mp *Mempool) Entry(txID []byte) (*MempoolEntry, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	entry, ok := mp.txs[hex.EncodeToString(txID)]
+	return entry, ok
+}
+
+// Len returns the number of transactions in the mempool
+func (mp *Mempool) Len() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.txs)
+}
+
+// Entries returns the mempool entries
+func (mp *Mempool) Entries() []*MempoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	entries := make([]*MempoolEntry, 0, len(mp.txs))
+	for _, entry := range mp.txs {
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// Ancestors returns the in-mempool transactions a transaction depends on
+func (mp *Mempool) Ancestors(txID []byte) []*MempoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.collect(hex.EncodeToString(txID), func(e *MempoolEntry) map[string]bool { return e.parents })
+}
+
+// Descendants returns the in-mempool transactions depending on a transaction
+func (mp *Mempool) Descendants(txID []byte) []*MempoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.collect(hex.EncodeToString(txID), func(e *MempoolEntry) map[string]bool { return e.children })
+}
+
+// collect walks the links returned by next from a transaction and returns
+// the entries reached, excluding the transaction itself
+func (mp *Mempool) collect(id string, next func(*MempoolEntry) map[string]bool) []*MempoolEntry {
+	var entries []*MempoolEntry
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+
+	for len(queue) > 0 {
+		entry, ok := mp.txs[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+
+		for linked := range next(entry) {
+			if !seen[linked] {
+				seen[linked] = true
+				queue = append(queue, linked)
+				entries = append(entries, mp.txs[linked])
+			}
+		}
+	}
+
+	return entries
+}
+
+// Remove removes transactions from the mempool, their descendants stay and
+// are expected to be mined or removed as well
+func (mp *Mempool) Remove(txIDs ...[]byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, txID := range txIDs {
+		mp.remove(hex.EncodeToString(txID))
+	}
+}
+
+// RemoveWithDescendants removes a transaction and all transactions depending on it
+func (mp *Mempool) RemoveWithDescendants(txID []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.removeWithDescendants(hex.EncodeToString(txID))
+}
+
+// removeWithDescendants removes a transaction and all transactions depending on it
+func (mp *Mempool) removeWithDescendants(id string) {
+	for _, entry := range mp.collect(id, func(e *MempoolEntry) map[string]bool { return e.children }) {
+		mp.remove(hex.EncodeToString(entry.Tx.ID))
+	}
+	mp.remove(id)
+}
+
+// remove removes a transaction and unlinks it from its parents and children
+func (mp *Mempool) remove(id string) {
+	entry, ok := mp.txs[id]
+	if !ok {
+		return
+	}
+
+	for parentID := range entry.parents {
+		if parent, ok := mp.txs[parentID]; ok {
+			delete(parent.children, id)
+		}
+	}
+
+	for childID := range entry.children {
+		if child, ok := mp.txs[childID]; ok {
+			delete(child.parents, id)
+		}
+	}
+
+	for _, vin := range entry.Tx.VIn {
+		delete(mp.spent, outpointKey(vin.TxID, vin.VOut))
+	}
+
+	delete(mp.txs, id)
+}
+
+// RemoveBlock removes the transactions of a block from the mempool, and
+// the transactions conflicting with them together with their descendants
+func (mp *Mempool) RemoveBlock(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		id := hex.EncodeToString(tx.ID)
+		mp.remove(id)
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.VIn {
+			if spender, ok := mp.spent[outpointKey(vin.TxID, vin.VOut)]; ok && spender != id {
+				mp.removeWithDescendants(spender)
+			}
+		}
+	}
+}
+
+// SelectByFeeRate returns the transactions with the highest fee rate whose
+// total size doesn't exceed maxSize. Transactions are scored together with
+// their unselected ancestors, so a high fee child pulls in its low fee
+// parents (child-pays-for-parent). Parents precede their children.
+func (mp *Mempool) SelectByFeeRate(maxSize int) []*MempoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	// the ancestors are collected once, the packages of the descendants of
+	// selected transactions shrink and are queued again with their new rate
+	ancestors := make(map[string][]*MempoolEntry, len(mp.txs))
+	descendants := make(map[string][]string, len(mp.txs))
+	packages := make(map[string]txPackage, len(mp.txs))
+	queue := make(packageQueue, 0, len(mp.txs))
+
+	for id, entry := range mp.txs {
+		pkg := txPackage{id: id, fee: entry.Fee, size: entry.Size}
+		for _, ancestor := range mp.collect(id, func(e *MempoolEntry) map[string]bool { return e.parents }) {
+			ancestorID := hex.EncodeToString(ancestor.Tx.ID)
+			ancestors[id] = append(ancestors[id], ancestor)
+			descendants[ancestorID] = append(descendants[ancestorID], id)
+			pkg.fee += ancestor.Fee
+			pkg.size += ancestor.Size
+		}
+
+		packages[id] = pkg
+		queue = append(queue, pkg)
+	}
+	heap.Init(&queue)
+
+	var selected []*MempoolEntry
+	inBlock := make(map[string]bool)
+	excluded := make(map[string]bool)
+	size := 0
+
+	for queue.Len() > 0 {
+		pkg := heap.Pop(&queue).(txPackage)
+		if inBlock[pkg.id] || excluded[pkg.id] || pkg != packages[pkg.id] {
+			continue
+		}
+
+		if size+pkg.size > maxSize {
+			excluded[pkg.id] = true
+			continue
+		}
+
+		entries := make([]*MempoolEntry, 0, len(ancestors[pkg.id])+1)
+		for _, ancestor := range ancestors[pkg.id] {
+			if !inBlock[hex.EncodeToString(ancestor.Tx.ID)] {
+				entries = append(entries, ancestor)
+			}
+		}
+
+		// a transaction has more ancestors than each of its ancestors
+		sort.SliceStable(entries, func(i, j int) bool {
+			return len(ancestors[hex.EncodeToString(entries[i].Tx.ID)]) < len(ancestors[hex.EncodeToString(entries[j].Tx.ID)])
+		})
+		entries = append(entries, mp.txs[pkg.id])
+
+		for _, entry := range entries {
+			inBlock[hex.EncodeToString(entry.Tx.ID)] = true
+		}
+
+		for _, entry := range entries {
+			selected = append(selected, entry)
+
+			for _, id := range descendants[hex.EncodeToString(entry.Tx.ID)] {
+				if inBlock[id] {
+					continue
+				}
+
+				descendant := packages[id]
+				descendant.fee -= entry.Fee
+				descendant.size -= entry.Size
+				packages[id] = descendant
+				heap.Push(&queue, descendant)
+			}
+		}
+		size += pkg.size
+	}
+
+	return selected
+}
+
+// txPackage is a transaction with its unselected ancestors
+type txPackage struct {
+	id        string
+	fee, size int
+}
+
+// packageQueue is a heap of packages, the highest fee rate first
+type packageQueue []txPackage
+
+// Len returns the number of queued packages
+func (q packageQueue) Len() int { return len(q) }
+
+// Less orders packages by fee rate, then by id to select deterministically
+func (q packageQueue) Less(i, j int) bool {
+	if ri, rj := feeRate(q[i].fee, q[i].size), feeRate(q[j].fee, q[j].size); ri != rj {
+		return ri > rj
+	}
+
+	return q[i].id < q[j].id
+}
+
+// Swap swaps two packages
+func (q packageQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+// Push queues a package
+func (q *packageQueue) Push(x interface{}) { *q = append(*q, x.(txPackage)) }
+
+// Pop removes the last package
+func (q *packageQueue) Pop() interface{} {
+	old := *q
+	pkg := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return pkg
+}
@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// testEntry describes a mempool transaction of newTestMempool
+type testEntry struct {
+	name      string
+	fee, size int
+	parents   []string
+}
+
+// newTestMempool returns a mempool of the described transactions linked to
+// their parents, without validating them
+func newTestMempool(entries []testEntry) *Mempool {
+	mp := &Mempool{txs: make(map[string]*MempoolEntry), spent: make(map[string]string)}
+	for _, e := range entries {
+		parents := make(map[string]bool)
+		for _, parent := range e.parents {
+			parentID := hex.EncodeToString([]byte(parent))
+			parents[parentID] = true
+			mp.txs[parentID].children[hex.EncodeToString([]byte(e.name))] = true
+		}
+
+		mp.txs[hex.EncodeToString([]byte(e.name))] = &MempoolEntry{
+			Tx:       &Transaction{ID: []byte(e.name)},
+			Fee:      e.fee,
+			Size:     e.size,
+			parents:  parents,
+			children: make(map[string]bool),
+		}
+	}
+
+	return mp
+}
+
+func TestSelectByFeeRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		maxSize int
+		want    []string
+	}{
+		{
+			"highest fee rate first",
+			[]testEntry{{"a", 100, 100, nil}, {"b", 300, 100, nil}, {"c", 400, 200, nil}},
+			1000,
+			[]string{"b", "c", "a"},
+		},
+		{
+			"child lifts its parent",
+			[]testEntry{{"parent", 100, 100, nil}, {"other", 200, 100, nil}, {"child", 1000, 100, []string{"parent"}}},
+			1000,
+			[]string{"parent", "child", "other"},
+		},
+		{
+			"package rate counts the parent fee",
+			[]testEntry{{"parent", 200, 100, nil}, {"other", 250, 100, nil}, {"child", 400, 100, []string{"parent"}}},
+			1000,
+			[]string{"parent", "child", "other"},
+		},
+		{
+			"low fee child doesn't lift its parent",
+			[]testEntry{{"parent", 100, 100, nil}, {"other", 200, 100, nil}, {"child", 200, 100, []string{"parent"}}},
+			1000,
+			[]string{"other", "parent", "child"},
+		},
+		{
+			"low fee child doesn't lower its parent",
+			[]testEntry{{"parent", 500, 100, nil}, {"other", 200, 100, nil}, {"child", 0, 100, []string{"parent"}}},
+			1000,
+			[]string{"parent", "other", "child"},
+		},
+		{
+			"grandchild lifts its ancestors",
+			[]testEntry{
+				{"grandparent", 0, 100, nil},
+				{"parent", 0, 100, []string{"grandparent"}},
+				{"other", 200, 100, nil},
+				{"child", 1200, 100, []string{"parent"}},
+			},
+			1000,
+			[]string{"grandparent", "parent", "child", "other"},
+		},
+		{
+			"parents selected once",
+			[]testEntry{
+				{"parent", 0, 100, nil},
+				{"child1", 900, 100, []string{"parent"}},
+				{"child2", 300, 100, []string{"parent"}},
+				{"other", 400, 100, nil},
+			},
+			1000,
+			[]string{"parent", "child1", "other", "child2"},
+		},
+		{
+			"package too big for the block",
+			[]testEntry{{"parent", 100, 100, nil}, {"child", 1000, 100, []string{"parent"}}, {"other", 150, 100, nil}},
+			150,
+			[]string{"other"},
+		},
+		{
+			"parent fits without its child",
+			[]testEntry{{"parent", 100, 100, nil}, {"child", 1000, 100, []string{"parent"}}},
+			150,
+			[]string{"parent"},
+		},
+		{
+			"smaller transaction fills the space left",
+			[]testEntry{{"a", 500, 100, nil}, {"b", 400, 200, nil}, {"c", 100, 50, nil}},
+			200,
+			[]string{"a", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var selected []string
+			for _, entry := range newTestMempool(test.entries).SelectByFeeRate(test.maxSize) {
+				selected = append(selected, string(entry.Tx.ID))
+			}
+
+			if !reflect.DeepEqual(selected, test.want) {
+				t.Fatalf("selected %v, want %v", selected, test.want)
+			}
+		})
+	}
+}
+
+func TestMinerPullsInLowFeeParent(t *testing.T) {
+	bc, genesis := newTestBlockchain(t)
+	params := bc.Params()
+	mempool := NewMempool(bc)
+	payee, to := NewWalletWithParams(params), string(NewWalletWithParams(params).GetAddress())
+
+	block := bc.MineBlock([]*Transaction{bc.NewCoinbaseTX(string(genesis.GetAddress()))})
+	coinbases := []*Transaction{genesisOf(t, bc).Transactions[0], block.Transactions[0]}
+
+	// spend builds a transaction paying 1 to to from an output and the rest
+	// less the fee to the payee
+	spend := func(prevTX *Transaction, fee int, signer *Wallet) *Transaction {
+		tx, err := NewTxBuilder(params, bc.GetBestHeight()+1).
+			AddInputFrom(prevTX, 0).
+			AddOutput(prevTX.VOut[0].Value-1-fee, string(payee.GetAddress())).
+			AddOutput(1, to).
+			SetFee(fee).
+			Sign(KeystoreSigner(signer)).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	parent := spend(coinbases[0], 1, genesis)
+	other := spend(coinbases[1], 3, genesis)
+	child := spend(parent, 6, payee)
+
+	if ancestors := mempool.Ancestors(child.ID); len(ancestors) != 1 || !bytes.Equal(ancestors[0].Tx.ID, parent.ID) {
+		t.Fatalf("child has %d ancestors, want its parent", len(ancestors))
+	}
+
+	// the block has room for the parent and its child but not the other
+	// transaction, which pays a higher rate than the parent alone
+	miner := NewMiner(bc, mempool, to, nil)
+	size := len(bc.NewCoinbaseTX(to).Serialize()) + len(parent.Serialize()) + len(child.Serialize())
+	policy := mempool.Policy()
+	policy.MaxBlockSize = size + len(other.Serialize())/2
+	if err := mempool.SetPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := miner.MineBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) != 3 || !bytes.Equal(block.Transactions[1].ID, parent.ID) || !bytes.Equal(block.Transactions[2].ID, child.ID) {
+		t.Fatalf("mined %d transactions, want the coinbase, the parent and the child", len(block.Transactions))
+	}
+	if reward := block.Transactions[0].OutputValue(); reward != params.BlockSubsidy(block.Height)+7 {
+		t.Errorf("coinbase pays %d, want the subsidy and 7 fees", reward)
+	}
+	if !mempool.Has(other.ID) || mempool.Len() != 1 {
+		t.Errorf("mempool has %d transactions, want the other one", mempool.Len())
+	}
+}
@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"sort"
+)
+
+const (
+	// CommandMempool mempool, answered with inventories of the mempool transactions
+	CommandMempool = "mempool"
+
+	// maxInvPerMessage is the largest number of items of an inventory
+	maxInvPerMessage = 50000
+)
+
+type mempoolData struct {
+	AddrFrom string
+}
+
+// requestMempool asks a peer for the transactions of its mempool
+func (s *Server) requestMempool(p *peerConn) {
+	p.send(CommandMempool, mempoolData{AddrFrom: s.address})
+}
+
+// handleMempool handles CommandMempool request by sending the IDs of the
+// mempool transactions paying at least the fee filter of the peer and
+// matching its bloom filter as inventories of at most maxInvPerMessage items. The oldest come first so
+// parents are requested before their children.
+func (s *Server) handleMempool(p *peerConn, request []byte) {
+	var payload mempoolData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	filter := s.manager.feeFilter(p)
+	var entries []*MempoolEntry
+	for _, entry := range s.mempool.Entries() {
+		if entry.FeeRate() >= filter && s.manager.matchesFilter(p, entry.Tx) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Added.Before(entries[j].Added)
+	})
+
+	for len(entries) > 0 {
+		n := len(entries)
+		if n > maxInvPerMessage {
+			n = maxInvPerMessage
+		}
+
+		items := make([][]byte, n)
+		for i, entry := range entries[:n] {
+			items[i] = entry.Tx.ID
+		}
+		entries = entries[n:]
+
+		p.send(CommandInv, invData{AddrFrom: s.address, Type: CommandGetDataTypeTx, Items: items})
+	}
+}
@@ -1,6 +1,9 @@
 package blockchain
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+)
 
 // MerkleTree represent a Merkle tree
 type MerkleTree struct {
@@ -18,22 +21,23 @@ type MerkleNode struct {
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []*MerkleNode
 
-	l := len(data)
-
-	if l%2 != 0 {
-		data = append(data, data[l-1])
-		l++
-	}
-
 	for _, datum := range data {
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, node)
 	}
 
-	for i := 0; i < l/2; i++ {
+	if len(nodes) == 0 {
+		return &MerkleTree{nil}
+	}
+
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var newLevel []*MerkleNode
 
-		for j := 0; i < len(nodes); j += 2 {
+		for j := 0; j < len(nodes); j += 2 {
 			node := NewMerkleNode(nodes[j], nodes[j+1], nil)
 			newLevel = append(newLevel, node)
 		}
@@ -41,10 +45,6 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = newLevel
 	}
 
-	if len(nodes) == 0 {
-		return &MerkleTree{nil}
-	}
-
 	return &MerkleTree{nodes[0]}
 }
 
@@ -58,9 +58,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	} else if left == nil || right == nil {
 		panic("NewMerkleNode left or right is nil")
 	} else {
-		prevHash := append(left.Data, right.Data...)
-		hash := sha256.Sum256(prevHash)
-		mNode.Data = hash[:]
+		mNode.Data = hashMerklePair(left.Data, right.Data)
 	}
 
 	mNode.Left = left
@@ -68,3 +66,65 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 
 	return mNode
 }
+
+// MerkleProof proves a datum is a leaf of a Merkle tree
+type MerkleProof struct {
+	// Index is the position of the leaf
+	Index int
+
+	// Siblings are the hashes of the sibling nodes from the leaf to the root
+	Siblings [][]byte
+}
+
+// NewMerkleProof builds the proof of the leaf at index of the Merkle tree
+// created from data
+func NewMerkleProof(data [][]byte, index int) MerkleProof {
+	proof := MerkleProof{Index: index}
+
+	var level [][]byte
+	for _, datum := range data {
+		level = append(level, NewMerkleNode(nil, nil, datum).Data)
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		proof.Siblings = append(proof.Siblings, level[index^1])
+
+		var next [][]byte
+		for j := 0; j < len(level); j += 2 {
+			next = append(next, hashMerklePair(level[j], level[j+1]))
+		}
+
+		level = next
+		index /= 2
+	}
+
+	return proof
+}
+
+// Verify checks the proof connects datum to the Merkle root
+func (p MerkleProof) Verify(root, datum []byte) bool {
+	hash := NewMerkleNode(nil, nil, datum).Data
+	index := p.Index
+
+	for _, sibling := range p.Siblings {
+		if index%2 == 0 {
+			hash = hashMerklePair(hash, sibling)
+		} else {
+			hash = hashMerklePair(sibling, hash)
+		}
+
+		index /= 2
+	}
+
+	return bytes.Equal(hash, root)
+}
+
+// hashMerklePair hashes two child nodes into their parent node
+func hashMerklePair(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+	return hash[:]
+}
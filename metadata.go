@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+const (
+	// metadataBucket is the bucket name of the chain metadata
+	metadataBucket = "metadata"
+
+	// metadataCreatedAtKey is the key of the chain creation time
+	metadataCreatedAtKey = "createdAt"
+
+	// metadataParamsHashKey is the key of the chain parameters hash
+	metadataParamsHashKey = "paramsHash"
+
+	// metadataCodecKey is the key of the codec used to store blocks
+	metadataCodecKey = "codec"
+
+	// metadataSchemaVersionKey is the key of the database schema version
+	metadataSchemaVersionKey = "schemaVersion"
+
+	// codec is the codec used to store blocks and outputs
+	codec = "gob"
+
+	// schemaVersion is the current database schema version
+	schemaVersion = 3
+)
+
+var (
+	// ErrChainParamsMismatch is returned when the database was created with other chain parameters
+	ErrChainParamsMismatch = errors.New("chain params mismatch")
+
+	// ErrCodecMismatch is returned when the database was created with another codec
+	ErrCodecMismatch = errors.New("codec mismatch")
+
+	// ErrSchemaVersionMismatch is returned when the database has an unsupported schema version
+	ErrSchemaVersionMismatch = errors.New("schema version mismatch")
+
+	// ErrMissingMetadata is returned when the database was created before the
+	// chain metadata existed, it has to be migrated with MigrateChainMetadata
+	// or the chain rebuilt
+	ErrMissingMetadata = errors.New("chain metadata missing, migrate or reindex the database")
+)
+
+// ChainMetadata describes how a chain database was created
+type ChainMetadata struct {
+	CreatedAt     time.Time
+	ParamsHash    []byte
+	Codec         string
+	SchemaVersion int
+}
+
+// newChainMetadata creates the metadata of a new chain
+func newChainMetadata(params *ChainParams) ChainMetadata {
+	return ChainMetadata{
+		CreatedAt:     time.Now(),
+		ParamsHash:    params.Hash(),
+		Codec:         codec,
+		SchemaVersion: schemaVersion,
+	}
+}
+
+// putChainMetadata writes the metadata into the metadata bucket
+func putChainMetadata(tx *bolt.Tx, meta ChainMetadata) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+	if err != nil {
+		return err
+	}
+
+	pairs := map[string][]byte{
+		metadataCreatedAtKey:     IntToHex(meta.CreatedAt.Unix()),
+		metadataParamsHashKey:    meta.ParamsHash,
+		metadataCodecKey:         []byte(meta.Codec),
+		metadataSchemaVersionKey: IntToHex(int64(meta.SchemaVersion)),
+	}
+
+	for k, v := range pairs {
+		if err = b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getChainMetadata reads the metadata from the metadata bucket, it returns
+// false if the database has no metadata.
+func getChainMetadata(tx *bolt.Tx) (ChainMetadata, bool) {
+	var meta ChainMetadata
+
+	b := tx.Bucket([]byte(metadataBucket))
+	if b == nil {
+		return meta, false
+	}
+
+	meta.CreatedAt = time.Unix(HexToInt(b.Get([]byte(metadataCreatedAtKey))), 0)
+	meta.ParamsHash = append([]byte{}, b.Get([]byte(metadataParamsHashKey))...)
+	meta.Codec = string(b.Get([]byte(metadataCodecKey)))
+	meta.SchemaVersion = int(HexToInt(b.Get([]byte(metadataSchemaVersionKey))))
+
+	return meta, true
+}
+
+// validate checks the metadata matches the chain parameters and the
+// codec and schema of this package
+func (m ChainMetadata) validate(params *ChainParams) error {
+	if m.SchemaVersion != schemaVersion {
+		return fmt.Errorf("%w: database has version %d, expected %d",
+			ErrSchemaVersionMismatch, m.SchemaVersion, schemaVersion)
+	}
+
+	if m.Codec != codec {
+		return fmt.Errorf("%w: database uses %q, expected %q", ErrCodecMismatch, m.Codec, codec)
+	}
+
+	if !bytes.Equal(m.ParamsHash, params.Hash()) {
+		return fmt.Errorf("%w: database was not created with %q params", ErrChainParamsMismatch, params.Name)
+	}
+
+	return nil
+}
+
+// loadChainMetadata validates the metadata of an opened database. Databases
+// created before the metadata bucket existed aren't opened, the params they
+// were created with are unknown.
+func loadChainMetadata(tx *bolt.Tx, params *ChainParams) (ChainMetadata, error) {
+	meta, ok := getChainMetadata(tx)
+	if !ok {
+		return meta, fmt.Errorf("%w: database of %q params has no metadata bucket", ErrMissingMetadata, params.Name)
+	}
+
+	return meta, meta.validate(params)
+}
+
+// MigrateChainMetadata writes the metadata of the options' params into a
+// database created before the chain metadata existed. The operator vouches
+// the chain was created with these params, they can't be checked.
+func MigrateChainMetadata(nodeID string, opts ...Option) error {
+	o := newOptions(opts)
+	dbFileName := getDBFile(nodeID)
+	if !dbExists(dbFileName) {
+		return fmt.Errorf("no blockchain found in %s", dbFileName)
+	}
+
+	db := openDB(dbFileName, o.storage)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, ok := getChainMetadata(tx); ok {
+			return nil
+		}
+
+		return putChainMetadata(tx, newChainMetadata(o.params))
+	}); err != nil {
+		return err
+	}
+
+	return migrateParamsHash(db, o.params)
+}
+
+// migrateParamsHash replaces a params hash stored before ChainParams.Hash
+// tagged the parameters with the current hash, when it is the legacy hash
+// of the params. Other params sharing the legacy hash can't be told apart.
+func migrateParamsHash(db *bolt.DB, params *ChainParams) error {
+	var legacy bool
+	if err := db.View(func(tx *bolt.Tx) error {
+		meta, ok := getChainMetadata(tx)
+		legacy = ok && bytes.Equal(meta.ParamsHash, params.legacyHash())
+		return nil
+	}); err != nil || !legacy {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(metadataBucket)).Put([]byte(metadataParamsHashKey), params.Hash())
+	})
+}
@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestLegacyDatabaseNeedsMetadataMigration(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	params := bc.Params()
+	if err := bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(metadataBucket))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	load := func() error {
+		db := openDB(getDBFile("test"), bc.opts.storage)
+		defer db.Close()
+
+		return db.View(func(tx *bolt.Tx) error {
+			_, err := loadChainMetadata(tx, params)
+			return err
+		})
+	}
+
+	if err := load(); !errors.Is(err, ErrMissingMetadata) {
+		t.Fatalf("error %v, want %v", err, ErrMissingMetadata)
+	}
+
+	if err := MigrateChainMetadata("test", WithChainParams(params)); err != nil {
+		t.Fatal(err)
+	}
+	if err := load(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"log"
+	"sync"
+)
+
+// Miner assembles blocks from the mempool and mines them
+type Miner struct {
+	mu        sync.Mutex
+	bc        *Blockchain
+	mempool   *Mempool
+	address   string
+	broadcast func(*Block)
+}
+
+// NewMiner creates a Miner paying the rewards to address, broadcast is
+// called with every mined block and may be nil
+func NewMiner(bc *Blockchain, mempool *Mempool, address string, broadcast func(*Block)) *Miner {
+	return &Miner{bc: bc, mempool: mempool, address: address, broadcast: broadcast}
+}
+
+// SelectTransactions returns the mempool transactions with the highest
+// ancestor package fee rate fitting into a block next to the coinbase
+func (m *Miner) SelectTransactions() []*Transaction {
+	coinbase := m.bc.NewCoinbaseTX(m.address)
+	policy := m.mempool.Policy()
+	maxSize := policy.blockSize(m.bc.opts.params) - len(coinbase.Serialize())
+
+	var transactions []*Transaction
+	for _, entry := range m.mempool.SelectByFeeRate(maxSize) {
+		transactions = append(transactions, entry.Tx)
+	}
+
+	return transactions
+}
+
+// MineBlock mines a block with the selected mempool transactions and a
+// coinbase awarding the subsidy and their fees, then broadcasts it. The
+// selected transactions stay in the mempool when mining fails.
+func (m *Miner) MineBlock() (*Block, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var transactions []*Transaction
+	pending := make(map[string]*Transaction)
+	invalid := make(map[string]bool)
+
+	for _, tx := range m.SelectTransactions() {
+		if spendsInvalid(tx, invalid) {
+			invalid[hex.EncodeToString(tx.ID)] = true
+			continue
+		}
+
+		if _, err := m.bc.checkTransaction(tx, pending); err != nil {
+			log.Printf("transaction %x is invalid, removed from mempool: %s\n", tx.ID, err)
+			invalid[hex.EncodeToString(tx.ID)] = true
+			m.mempool.RemoveWithDescendants(tx.ID)
+			continue
+		}
+
+		transactions = append(transactions, tx)
+		pending[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	coinbase := m.bc.NewCoinbaseTX(m.address)
+	block, err := m.bc.TryMineBlock(append([]*Transaction{coinbase}, transactions...))
+	if err != nil {
+		return nil, err
+	}
+	m.mempool.RemoveBlock(block)
+
+	log.Printf("Mined block %x with %d transactions\n", block.Hash, len(block.Transactions))
+
+	if m.broadcast != nil {
+		m.broadcast(block)
+	}
+
+	return block, nil
+}
+
+// spendsInvalid returns whether a transaction spends an invalid transaction
+func spendsInvalid(tx *Transaction, invalid map[string]bool) bool {
+	for _, vin := range tx.VIn {
+		if invalid[hex.EncodeToString(vin.TxID)] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,32 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTryMineBlockReturnsErrors(t *testing.T) {
+	bc, genesis := newTestBlockchain(t)
+	to := string(genesis.GetAddress())
+
+	unknown := &Transaction{
+		VIn:  []TXInput{{TxID: bytes.Repeat([]byte{1}, 32), Sequence: SequenceFinal}},
+		VOut: []TXOutput{*NewTXOutput(1, to)},
+	}
+	unknown.ID = unknown.Hash()
+
+	if _, err := bc.TryMineBlock([]*Transaction{bc.NewCoinbaseTX(to), unknown}); !errors.Is(err, ErrInvalidTransaction) {
+		t.Fatalf("error %v, want %v", err, ErrInvalidTransaction)
+	}
+	if height := bc.GetBestHeight(); height != 0 {
+		t.Fatalf("height %d, want 0", height)
+	}
+
+	if _, err := bc.TryMineBlock([]*Transaction{bc.NewCoinbaseTX(to)}); err != nil {
+		t.Fatal(err)
+	}
+	if height := bc.GetBestHeight(); height != 1 {
+		t.Fatalf("height %d, want 1", height)
+	}
+}
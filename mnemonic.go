@@ -0,0 +1,149 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"math/big"
+	"strings"
+)
+
+const (
+	// mnemonicSaltPrefix prefixes the passphrase in the seed derivation salt
+	mnemonicSaltPrefix = "mnemonic"
+
+	// mnemonicSeedRounds is the number of PBKDF2 rounds deriving the seed
+	mnemonicSeedRounds = 2048
+
+	// mnemonicSeedLen is the length of the seed of a mnemonic
+	mnemonicSeedLen = 64
+
+	// mnemonicWordBits is the number of bits of entropy and checksum of a word
+	mnemonicWordBits = 11
+)
+
+// ErrInvalidMnemonic is returned for mnemonics with an unknown word, a wrong
+// number of words or a bad checksum
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// mnemonicWords are the words of the word list, mnemonicWordIndex their indices
+var (
+	mnemonicWords     = strings.Fields(mnemonicWordList)
+	mnemonicWordIndex = func() map[string]int {
+		index := make(map[string]int, len(mnemonicWords))
+		for i, word := range mnemonicWords {
+			index[word] = i
+		}
+
+		return index
+	}()
+)
+
+// GenerateMnemonic returns a mnemonic of 12, 15, 18, 21 or 24 words of random entropy
+func GenerateMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", fmt.Errorf("%w: %d words, needs 12, 15, 18, 21 or 24", ErrInvalidMnemonic, words)
+	}
+
+	entropy := make([]byte, words/3*4)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+
+	return NewMnemonic(entropy)
+}
+
+// NewMnemonic encodes 16 to 32 bytes of entropy, a multiple of 4, with
+// its checksum as words
+func NewMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", fmt.Errorf("%w: %d bytes of entropy", ErrInvalidMnemonic, len(entropy))
+	}
+
+	checksumBits := uint(len(entropy) / 4)
+	sum := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	data.Or(data, big.NewInt(int64(sum[0]>>(8-checksumBits))))
+
+	count := (len(entropy)*8 + int(checksumBits)) / mnemonicWordBits
+	words := make([]string, count)
+	mask := big.NewInt(1<<mnemonicWordBits - 1)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = mnemonicWords[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, mnemonicWordBits)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes the entropy of a mnemonic and checks its checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := mnemonicWordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+
+		data.Lsh(data, mnemonicWordBits)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) / 3)
+	check := new(big.Int).And(data, big.NewInt(1<<checksumBits-1)).Int64()
+	data.Rsh(data, checksumBits)
+
+	entropy := make([]byte, len(words)/3*4)
+	data.FillBytes(entropy)
+
+	sum := sha256.Sum256(entropy)
+	if int64(sum[0]>>(8-checksumBits)) != check {
+		return nil, fmt.Errorf("%w: bad checksum", ErrInvalidMnemonic)
+	}
+
+	return entropy, nil
+}
+
+// ValidateMnemonic returns whether a mnemonic has known words and a valid checksum
+func ValidateMnemonic(mnemonic string) bool {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// MnemonicSeed derives the 64 byte HD wallet seed of a valid mnemonic and
+// an optional passphrase. Only ASCII passphrases are supported as others
+// would need Unicode normalization to match other wallets.
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(passphrase); i++ {
+		if passphrase[i] >= 0x80 {
+			return nil, errors.New("mnemonic passphrase must be ASCII")
+		}
+	}
+
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte(mnemonicSaltPrefix+passphrase), mnemonicSeedRounds, mnemonicSeedLen, sha512.New), nil
+}
+
+// NewHDWalletFromMnemonic creates an HD wallet of the default account from a mnemonic
+func NewHDWalletFromMnemonic(mnemonic, passphrase string) (*HDWallet, error) {
+	seed, err := MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHDWallet(seed)
+}
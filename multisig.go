@@ -0,0 +1,213 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidMultiSig is returned for invalid multisig parameters
+var ErrInvalidMultiSig = errors.New("invalid multisig")
+
+// MultiSigScript returns the script locking an output to required
+// signatures of the public keys, signatures must be in the order of the keys
+func MultiSigScript(required int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxMultiSigKeys {
+		return nil, fmt.Errorf("%w: %d public keys", ErrInvalidMultiSig, len(pubKeys))
+	}
+
+	if required < 1 || required > len(pubKeys) {
+		return nil, fmt.Errorf("%w: %d of %d signatures", ErrInvalidMultiSig, required, len(pubKeys))
+	}
+
+	builder := NewScriptBuilder().AddInt(int64(required))
+	for _, pubKey := range pubKeys {
+		builder.AddData(pubKey)
+	}
+
+	return builder.AddInt(int64(len(pubKeys))).AddOp(OpCheckMultiSig).Script(), nil
+}
+
+// ExtractMultiSig returns the required signatures and the public keys of a
+// multisig script
+func ExtractMultiSig(script []byte) (int, [][]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].opcode != OpCheckMultiSig {
+		return 0, nil, false
+	}
+
+	required, ok := smallInt(ops[0])
+	keyCount, ok2 := smallInt(ops[len(ops)-2])
+	if !ok || !ok2 || keyCount != len(ops)-3 || required < 1 || required > keyCount {
+		return 0, nil, false
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if op.opcode == Op0 || op.opcode > OpPushData2 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+
+	return required, pubKeys, true
+}
+
+// smallInt returns the number pushed by an operation of a multisig script
+func smallInt(op scriptOp) (int, bool) {
+	if op.opcode >= Op1 && op.opcode <= Op16 {
+		return int(op.opcode-Op1) + 1, true
+	}
+
+	if op.opcode > Op0 && op.opcode <= OpPushData2 {
+		n, err := decodeScriptNum(op.data, maxScriptNumLen)
+		return int(n), err == nil
+	}
+
+	return 0, false
+}
+
+// NewMultiSigTXOutput creates an output locked to required signatures of the public keys
+func NewMultiSigTXOutput(value, required int, pubKeys [][]byte) (*TXOutput, error) {
+	script, err := MultiSigScript(required, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TXOutput{Value: value, ScriptPubKey: script}, nil
+}
+
+// signMultiSig adds the signatures of the keys of the signer to the
+// unlocking script of a multisig input, signatures already collected from
+// the other parties are kept in the order of their keys. The script is
+// appended to the unlocking script when it is the redeem script of a pay to
+// script hash output.
+func (tx *Transaction) signMultiSig(index int, script []byte, signer Signer, isRedeemScript bool, hashType SigHashType) error {
+	required, pubKeys, ok := ExtractMultiSig(script)
+	if !ok {
+		return nil
+	}
+
+	// the signer holds keys of the script, so they are on the curve it is encoded for
+	sigs := tx.multiSigSignatures(index, script, pubKeys, publicKeyCurve(pubKeys[0]))
+	signed := false
+	for i, pubKey := range pubKeys {
+		address := keyAddress(pubKey)
+		held, err := signerKey(signer, address)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(held, pubKey) {
+			continue
+		}
+
+		if sigs[i], err = tx.signInput(signer, address, pubKey, index, script, hashType); err != nil {
+			return err
+		}
+		signed = true
+	}
+
+	if !signed {
+		return nil
+	}
+
+	builder := NewScriptBuilder().AddOp(Op0)
+	count := 0
+	for i := range pubKeys {
+		if sig, ok := sigs[i]; ok && count < required {
+			builder.AddData(sig)
+			count++
+		}
+	}
+
+	if isRedeemScript {
+		builder.AddData(script)
+	}
+
+	tx.VIn[index].ScriptSig = builder.Script()
+
+	return nil
+}
+
+// multiSigSignatures returns the valid signatures in the unlocking script
+// of a multisig input by the index of their public key on curve
+func (tx *Transaction) multiSigSignatures(index int, script []byte, pubKeys [][]byte, curve KeyCurve) map[int][]byte {
+	sigs := make(map[int][]byte)
+	checker := &txSigChecker{tx: tx, index: index, curve: curve}
+
+	ops, err := parseScript(tx.VIn[index].ScriptSig)
+	if err != nil || len(ops) == 0 || ops[0].opcode != Op0 {
+		return sigs
+	}
+
+	for _, op := range ops[1:] {
+		for i, pubKey := range pubKeys {
+			if _, ok := sigs[i]; !ok && checker.checkSig(op.data, pubKey, script) {
+				sigs[i] = op.data
+				break
+			}
+		}
+	}
+
+	return sigs
+}
+
+// MultiSigSignatures returns the number of signatures an input has with
+// keys on the curve of the chain and the number it requires, for inputs
+// spending multisig outputs
+func (tx *Transaction) MultiSigSignatures(index int, prevTXs map[string]Transaction, curve KeyCurve) (int, int, bool) {
+	if index < 0 || index >= len(tx.VIn) || tx.validatePrevTXs(prevTXs) != nil {
+		return 0, 0, false
+	}
+
+	script := prevOutput(tx.VIn[index], prevTXs).ScriptPubKey
+	if ExtractScriptHash(script) != nil {
+		var err error
+		if script, err = redeemScript(tx.VIn[index].ScriptSig); err != nil {
+			return 0, 0, false
+		}
+	}
+
+	required, pubKeys, ok := ExtractMultiSig(script)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return len(tx.multiSigSignatures(index, script, pubKeys, curve)), required, true
+}
+
+// NewMultiSigSpend creates an unsigned transaction paying amount to the
+// address from outputs locked with a multisig script, the change goes back
+// to the script. Each party then signs it with Blockchain.SignTransaction.
+func NewMultiSigSpend(script []byte, to string, amount, fee int, utxoSet *UTXOSet) (*Transaction, error) {
+	if amount <= 0 || fee < 0 {
+		return nil, errors.New("invalid amount or fee")
+	}
+
+	acc, validOutputs := utxoSet.FindSpendableScriptOutputs(script, amount+fee)
+	if acc < amount+fee {
+		return nil, fmt.Errorf("not enough funds: %d, need %d", acc, amount+fee)
+	}
+
+	bc := utxoSet.Blockchain
+	builder := NewTxBuilder(bc.Params(), bc.GetBestHeight()+1)
+
+	for txID, outs := range validOutputs {
+		txIDDecode, err := hex.DecodeString(txID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, out := range outs {
+			builder.AddInput(txIDDecode, out)
+		}
+	}
+
+	builder.AddOutput(amount, to)
+	if change := acc - amount - fee; change > 0 {
+		builder.AddScriptOutput(change, script)
+	}
+
+	return builder.Build()
+}
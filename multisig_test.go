@@ -0,0 +1,197 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMultiSigScript(t *testing.T) {
+	keys := func(n int) [][]byte {
+		pubKeys := make([][]byte, n)
+		for i := range pubKeys {
+			pubKeys[i] = bytes.Repeat([]byte{byte(i + 1)}, 33)
+		}
+		return pubKeys
+	}
+
+	tests := []struct {
+		name     string
+		required int
+		pubKeys  [][]byte
+		valid    bool
+	}{
+		{"1 of 1", 1, keys(1), true},
+		{"2 of 3", 2, keys(3), true},
+		{"20 of 20", 20, keys(maxMultiSigKeys), true},
+		{"no key", 1, nil, false},
+		{"no signature required", 0, keys(2), false},
+		{"more signatures than keys", 3, keys(2), false},
+		{"too many keys", 1, keys(maxMultiSigKeys + 1), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := MultiSigScript(test.required, test.pubKeys)
+			if !test.valid {
+				if !errors.Is(err, ErrInvalidMultiSig) {
+					t.Fatalf("error %v, want %v", err, ErrInvalidMultiSig)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			required, pubKeys, ok := ExtractMultiSig(script)
+			if !ok || required != test.required || len(pubKeys) != len(test.pubKeys) {
+				t.Fatalf("extracted %d of %d keys %v, want %d of %d", required, len(pubKeys), ok, test.required, len(test.pubKeys))
+			}
+			for i := range pubKeys {
+				if !bytes.Equal(pubKeys[i], test.pubKeys[i]) {
+					t.Errorf("key %d is %x, want %x", i, pubKeys[i], test.pubKeys[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractMultiSigRejectsOtherScripts(t *testing.T) {
+	key := bytes.Repeat([]byte{2}, 33)
+
+	tests := []struct {
+		name   string
+		script []byte
+	}{
+		{"pay to public key hash", PayToPubKeyHashScript(HashPubKey(key))},
+		{"key count mismatch", NewScriptBuilder().AddInt(1).AddData(key).AddInt(2).AddOp(OpCheckMultiSig).Script()},
+		{"more signatures than keys", NewScriptBuilder().AddInt(2).AddData(key).AddInt(1).AddOp(OpCheckMultiSig).Script()},
+		{"empty key", NewScriptBuilder().AddInt(1).AddData(nil).AddInt(1).AddOp(OpCheckMultiSig).Script()},
+		{"verify variant", NewScriptBuilder().AddInt(1).AddData(key).AddInt(1).AddOp(OpCheckMultiSigVerify).Script()},
+		{"truncated", []byte{Op1, 0x21, 0x02}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if required, pubKeys, ok := ExtractMultiSig(test.script); ok {
+				t.Fatalf("extracted %d of %d keys", required, len(pubKeys))
+			}
+		})
+	}
+}
+
+func TestVerifyMultiSigScript(t *testing.T) {
+	pubKeys := [][]byte{bytes.Repeat([]byte{2}, 33), bytes.Repeat([]byte{3}, 33), bytes.Repeat([]byte{4}, 33)}
+	sigs := [][]byte{testSig(1), testSig(2), testSig(3)}
+	checker := &stubChecker{keys: map[string][]byte{}}
+	for i, sig := range sigs {
+		checker.keys[string(sig)] = pubKeys[i]
+	}
+
+	script, err := MultiSigScript(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := append(script[:len(script)-1:len(script)-1], OpCheckMultiSigVerify, Op1)
+
+	unlocking := func(dummy []byte, sigs ...[]byte) []byte {
+		builder := NewScriptBuilder().AddData(dummy)
+		for _, sig := range sigs {
+			builder.AddData(sig)
+		}
+		return builder.Script()
+	}
+
+	tests := []struct {
+		name      string
+		scriptSig []byte
+		script    []byte
+		valid     bool
+	}{
+		{"first and second keys", unlocking(nil, sigs[0], sigs[1]), script, true},
+		{"first and third keys", unlocking(nil, sigs[0], sigs[2]), script, true},
+		{"second and third keys", unlocking(nil, sigs[1], sigs[2]), script, true},
+		{"OP_CHECKMULTISIGVERIFY", unlocking(nil, sigs[0], sigs[2]), verify, true},
+		{"signatures out of key order", unlocking(nil, sigs[1], sigs[0]), script, false},
+		{"same signature twice", unlocking(nil, sigs[0], sigs[0]), script, false},
+		{"one signature", unlocking(nil, sigs[0]), script, false},
+		{"unknown signature", unlocking(nil, sigs[0], testSig(4)), script, false},
+		{"badly encoded signature", unlocking(nil, sigs[0], []byte{0x30, 0x00, byte(SigHashAll)}), script, false},
+		{"non-empty dummy", unlocking([]byte{1}, sigs[0], sigs[1]), script, false},
+		{"no dummy", NewScriptBuilder().AddData(sigs[0]).AddData(sigs[1]).Script(), script, false},
+		{"OP_CHECKMULTISIGVERIFY of one signature", unlocking(nil, sigs[0], testSig(4)), verify, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyScript(test.scriptSig, test.script, checker)
+			if test.valid && err != nil {
+				t.Fatalf("script failed: %v", err)
+			}
+			if !test.valid && !errors.Is(err, ErrScriptFailed) {
+				t.Fatalf("error %v, want %v", err, ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestMultiSigSpendNeedsRequiredSignatures(t *testing.T) {
+	bc, genesis := newTestBlockchain(t)
+	params := bc.Params()
+	parties := []*Wallet{NewWalletWithParams(params), NewWalletWithParams(params), NewWalletWithParams(params)}
+	outsider := NewWalletWithParams(params)
+
+	var pubKeys [][]byte
+	for _, party := range parties {
+		pubKeys = append(pubKeys, party.PublicKey)
+	}
+	script, err := MultiSigScript(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	funding, err := NewTxBuilder(params, bc.GetBestHeight()+1).
+		AddCandidates(&UTXOSet{bc}, HashPubKey(genesis.PublicKey)).
+		AddScriptOutput(6, script).
+		SetFee(1).
+		SetChangeAddress(string(genesis.GetAddress())).
+		Sign(KeystoreSigner(genesis)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.MineBlock([]*Transaction{bc.NewCoinbaseTX(string(outsider.GetAddress())), funding})
+
+	spend, err := NewMultiSigSpend(script, string(outsider.GetAddress()), 4, 0, &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		signer *Wallet
+		have   int
+	}{
+		{outsider, 0},
+		{parties[2], 1},
+		{parties[2], 1},
+		{parties[0], 2},
+	}
+
+	for _, step := range steps {
+		if err := bc.SignTransaction(spend, KeystoreSigner(step.signer)); err != nil {
+			t.Fatal(err)
+		}
+
+		prevTXs, err := bc.findPrevTransactions(spend, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, required, ok := spend.MultiSigSignatures(0, prevTXs, params.KeyCurve)
+		if !ok || have != step.have || required != 2 {
+			t.Fatalf("%d of %d signatures %v, want %d of 2", have, required, ok, step.have)
+		}
+		if verified := bc.VerifyTransaction(spend); verified != (step.have == 2) {
+			t.Fatalf("verified %v with %d signatures", verified, have)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+const (
+	// noiseProtocolName names the handshake pattern and the primitives of
+	// the Noise transport
+	noiseProtocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+
+	// noisePrologue binds the handshakes to the protocol of the chain
+	noisePrologue = "blockchain/noise/v1"
+
+	// noiseMaxMessage is the largest Noise message
+	noiseMaxMessage = 65535
+
+	// noiseMaxPlaintext is the largest plaintext of a transport message
+	noiseMaxPlaintext = noiseMaxMessage - chacha20poly1305.Overhead
+
+	// noiseStaticKeyPrefix prefixes the static key signed by the node identity
+	noiseStaticKeyPrefix = "noise-static-key:"
+
+	// identityCoordinateSize is the size of a coordinate of a P-256 identity key
+	identityCoordinateSize = 32
+)
+
+// ErrNoiseHandshake is returned when a peer fails the Noise handshake
+var ErrNoiseHandshake = errors.New("noise handshake failed")
+
+// noiseKeyPair is a Curve25519 key pair
+type noiseKeyPair struct {
+	private []byte
+	public  []byte
+}
+
+// newNoiseKeyPair generates a Curve25519 key pair
+func newNoiseKeyPair() noiseKeyPair {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		log.Panic(err)
+	}
+
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return noiseKeyPair{private: private, public: public}
+}
+
+// noiseKey is the static key of a node for the Noise handshakes and the
+// payload binding it to the node identity
+type noiseKey struct {
+	static  noiseKeyPair
+	payload []byte
+}
+
+// newNoiseKey generates the static key of a node, signed by its identity.
+// The payload is the public key of the identity and the signature as var
+// bytes. The key is sent with fixed width coordinates, a shorter X would
+// make the halves of the raw X||Y key ambiguous.
+func newNoiseKey(identity *NodeIdentity) (*noiseKey, error) {
+	static := newNoiseKeyPair()
+
+	signature, err := identity.Sign(append([]byte(noiseStaticKeyPrefix), static.public...))
+	if err != nil {
+		return nil, err
+	}
+
+	public := make([]byte, 2*identityCoordinateSize)
+	identity.PrivateKey.X.FillBytes(public[:identityCoordinateSize])
+	identity.PrivateKey.Y.FillBytes(public[identityCoordinateSize:])
+
+	var payload bytes.Buffer
+	writeVarBytes(&payload, public)
+	writeVarBytes(&payload, signature)
+
+	return &noiseKey{static: static, payload: payload.Bytes()}, nil
+}
+
+// verifyNoisePayload checks the payload of a peer signs its static key and
+// returns the public key of its identity
+func verifyNoisePayload(payload, static []byte) ([]byte, error) {
+	r := bytes.NewReader(payload)
+
+	identity, err := readVarBytes(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoiseHandshake, err)
+	}
+
+	signature, err := readVarBytes(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoiseHandshake, err)
+	}
+
+	if !VerifyNodeSignature(identity, append([]byte(noiseStaticKeyPrefix), static...), signature) {
+		return nil, fmt.Errorf("%w: static key isn't signed by the identity", ErrNoiseHandshake)
+	}
+
+	return identity, nil
+}
+
+// noiseCipher encrypts the messages of one direction, it passes them
+// through until it is keyed
+type noiseCipher struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+// newNoiseCipher creates a cipher keyed with a 32 byte key
+func newNoiseCipher(key []byte) *noiseCipher {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return &noiseCipher{aead: aead}
+}
+
+// nextNonce returns the nonce of the next message, 4 zero bytes followed
+// by the little-endian counter
+func (c *noiseCipher) nextNonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], c.nonce)
+	c.nonce++
+
+	return nonce
+}
+
+// encrypt encrypts a message with the associated data
+func (c *noiseCipher) encrypt(ad, plaintext []byte) []byte {
+	if c == nil {
+		return plaintext
+	}
+
+	return c.aead.Seal(nil, c.nextNonce(), plaintext, ad)
+}
+
+// decrypt decrypts and authenticates a message with the associated data
+func (c *noiseCipher) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if c == nil {
+		return ciphertext, nil
+	}
+
+	return c.aead.Open(nil, c.nextNonce(), ciphertext, ad)
+}
+
+// noiseHandshakeState is the symmetric state of a handshake
+type noiseHandshakeState struct {
+	ck     []byte
+	h      []byte
+	cipher *noiseCipher
+}
+
+// newNoiseHandshakeState initializes the state with the protocol name and the prologue
+func newNoiseHandshakeState() *noiseHandshakeState {
+	h := make([]byte, sha256.Size)
+	copy(h, noiseProtocolName)
+
+	st := &noiseHandshakeState{ck: append([]byte{}, h...), h: h}
+	st.mixHash([]byte(noisePrologue))
+
+	return st
+}
+
+// mixHash hashes data into the handshake hash
+func (st *noiseHandshakeState) mixHash(data []byte) {
+	hash := sha256.New()
+	hash.Write(st.h)
+	hash.Write(data)
+	st.h = hash.Sum(nil)
+}
+
+// mixKey derives a new chaining key and cipher key from a DH output
+func (st *noiseHandshakeState) mixKey(ikm []byte) {
+	var key []byte
+	st.ck, key = noiseHKDF(st.ck, ikm)
+	st.cipher = newNoiseCipher(key)
+}
+
+// mixDH mixes the DH of a private and a public key into the keys
+func (st *noiseHandshakeState) mixDH(private, public []byte) error {
+	shared, err := curve25519.X25519(private, public)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNoiseHandshake, err)
+	}
+
+	st.mixKey(shared)
+	return nil
+}
+
+// encryptAndHash encrypts a handshake field and hashes the ciphertext
+func (st *noiseHandshakeState) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := st.cipher.encrypt(st.h, plaintext)
+	st.mixHash(ciphertext)
+
+	return ciphertext
+}
+
+// decryptAndHash decrypts a handshake field and hashes the ciphertext
+func (st *noiseHandshakeState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := st.cipher.decrypt(st.h, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoiseHandshake, err)
+	}
+
+	st.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the ciphers of the initiator and the responder messages
+func (st *noiseHandshakeState) split() (*noiseCipher, *noiseCipher) {
+	k1, k2 := noiseHKDF(st.ck, nil)
+
+	return newNoiseCipher(k1), newNoiseCipher(k2)
+}
+
+// noiseHKDF derives two keys from a chaining key and input key material
+func noiseHKDF(ck, ikm []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, prk)
+	mac.Write([]byte{1})
+	out1 := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(out1)
+	mac.Write([]byte{2})
+
+	return out1, mac.Sum(nil)
+}
+
+// readNoiseFrame reads a Noise message prefixed with its 2 byte length
+func readNoiseFrame(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// writeNoiseFrame writes a Noise message prefixed with its 2 byte length
+func writeNoiseFrame(w io.Writer, frame []byte) error {
+	buf := make([]byte, 2, 2+len(frame))
+	binary.BigEndian.PutUint16(buf, uint16(len(frame)))
+
+	_, err := w.Write(append(buf, frame...))
+	return err
+}
+
+// noiseConn is a connection encrypted by the Noise XX handshake, the
+// static keys of both sides are signed by their node identities. The
+// handshake runs on the first Read or Write.
+type noiseConn struct {
+	net.Conn
+	key       *noiseKey
+	initiator bool
+
+	handshakeMu   sync.Mutex
+	handshakeDone bool
+	handshakeErr  error
+
+	// remoteIdentity is the identity public key of the peer, set by the handshake
+	remoteIdentity []byte
+
+	readMu sync.Mutex
+	recv   *noiseCipher
+	buf    []byte
+
+	writeMu sync.Mutex
+	send    *noiseCipher
+}
+
+// newNoiseConn wraps a connection, the initiator is the dialing side
+func newNoiseConn(conn net.Conn, key *noiseKey, initiator bool) *noiseConn {
+	return &noiseConn{Conn: conn, key: key, initiator: initiator}
+}
+
+// Handshake runs the handshake unless it already ran and returns its error
+func (c *noiseConn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	if !c.handshakeDone {
+		c.handshakeDone = true
+		if c.initiator {
+			c.handshakeErr = c.initiatorHandshake()
+		} else {
+			c.handshakeErr = c.responderHandshake()
+		}
+	}
+
+	return c.handshakeErr
+}
+
+// initiatorHandshake runs the handshake of the dialing side:
+// -> e; <- e, ee, s, es; -> s, se
+func (c *noiseConn) initiatorHandshake() error {
+	st := newNoiseHandshakeState()
+	e := newNoiseKeyPair()
+
+	st.mixHash(e.public)
+	if err := writeNoiseFrame(c.Conn, append(e.public, st.encryptAndHash(nil)...)); err != nil {
+		return err
+	}
+
+	message, err := readNoiseFrame(c.Conn)
+	if err != nil {
+		return err
+	}
+	if len(message) < curve25519.PointSize+curve25519.PointSize+chacha20poly1305.Overhead {
+		return fmt.Errorf("%w: short message", ErrNoiseHandshake)
+	}
+
+	re := message[:curve25519.PointSize]
+	st.mixHash(re)
+	if err = st.mixDH(e.private, re); err != nil {
+		return err
+	}
+
+	rs, err := st.decryptAndHash(message[curve25519.PointSize : 2*curve25519.PointSize+chacha20poly1305.Overhead])
+	if err != nil {
+		return err
+	}
+	if err = st.mixDH(e.private, rs); err != nil {
+		return err
+	}
+
+	payload, err := st.decryptAndHash(message[2*curve25519.PointSize+chacha20poly1305.Overhead:])
+	if err != nil {
+		return err
+	}
+	if c.remoteIdentity, err = verifyNoisePayload(payload, rs); err != nil {
+		return err
+	}
+
+	s := st.encryptAndHash(c.key.static.public)
+	if err = st.mixDH(c.key.static.private, re); err != nil {
+		return err
+	}
+	if err = writeNoiseFrame(c.Conn, append(s, st.encryptAndHash(c.key.payload)...)); err != nil {
+		return err
+	}
+
+	c.send, c.recv = st.split()
+	return nil
+}
+
+// responderHandshake runs the handshake of the accepting side
+func (c *noiseConn) responderHandshake() error {
+	st := newNoiseHandshakeState()
+
+	message, err := readNoiseFrame(c.Conn)
+	if err != nil {
+		return err
+	}
+	if len(message) < curve25519.PointSize {
+		return fmt.Errorf("%w: short message", ErrNoiseHandshake)
+	}
+
+	re := message[:curve25519.PointSize]
+	st.mixHash(re)
+	if _, err = st.decryptAndHash(message[curve25519.PointSize:]); err != nil {
+		return err
+	}
+
+	e := newNoiseKeyPair()
+	st.mixHash(e.public)
+	if err = st.mixDH(e.private, re); err != nil {
+		return err
+	}
+
+	s := st.encryptAndHash(c.key.static.public)
+	if err = st.mixDH(c.key.static.private, re); err != nil {
+		return err
+	}
+
+	reply := append(append(e.public, s...), st.encryptAndHash(c.key.payload)...)
+	if err = writeNoiseFrame(c.Conn, reply); err != nil {
+		return err
+	}
+
+	message, err = readNoiseFrame(c.Conn)
+	if err != nil {
+		return err
+	}
+	if len(message) < curve25519.PointSize+chacha20poly1305.Overhead {
+		return fmt.Errorf("%w: short message", ErrNoiseHandshake)
+	}
+
+	rs, err := st.decryptAndHash(message[:curve25519.PointSize+chacha20poly1305.Overhead])
+	if err != nil {
+		return err
+	}
+	if err = st.mixDH(e.private, rs); err != nil {
+		return err
+	}
+
+	payload, err := st.decryptAndHash(message[curve25519.PointSize+chacha20poly1305.Overhead:])
+	if err != nil {
+		return err
+	}
+	if c.remoteIdentity, err = verifyNoisePayload(payload, rs); err != nil {
+		return err
+	}
+
+	c.recv, c.send = st.split()
+	return nil
+}
+
+// Read reads decrypted data, after the handshake
+func (c *noiseConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.buf) == 0 {
+		frame, err := readNoiseFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+
+		if c.buf, err = c.recv.decrypt(nil, frame); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+// Write encrypts data in messages of at most noiseMaxPlaintext bytes,
+// after the handshake
+func (c *noiseConn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > noiseMaxPlaintext {
+			chunk = chunk[:noiseMaxPlaintext]
+		}
+
+		if err := writeNoiseFrame(c.Conn, c.send.encrypt(nil, chunk)); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// RemoteIdentity returns the identity public key of the peer, nil before
+// the handshake
+func (c *noiseConn) RemoteIdentity() []byte {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	return c.remoteIdentity
+}
+
+// noiseListener accepts the connections of a listener as Noise responders
+type noiseListener struct {
+	net.Listener
+	key *noiseKey
+}
+
+// Accept returns the next connection wrapped in the Noise transport
+func (l noiseListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return newNoiseConn(conn, l.key, false), nil
+}
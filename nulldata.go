@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxDataCarrierSize is the maximum data carried by a data output relayed by this node
+const MaxDataCarrierSize = 80
+
+// ErrNonStandardTx is returned for a valid transaction the node doesn't relay
+var ErrNonStandardTx = errors.New("transaction is not standard")
+
+// NullDataScript returns the script of a provably unspendable output
+// carrying data, e.g. a document hash anchored on the chain
+func NullDataScript(data []byte) ([]byte, error) {
+	if len(data) > maxScriptElementSize {
+		return nil, fmt.Errorf("data of %d bytes is larger than %d", len(data), maxScriptElementSize)
+	}
+
+	return NewScriptBuilder().AddOp(OpReturn).AddData(data).Script(), nil
+}
+
+// ExtractNullData returns the data carried by a data output script
+func ExtractNullData(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 2 || ops[0].opcode != OpReturn || !ops[1].isPush() {
+		return nil, false
+	}
+
+	return ops[1].data, true
+}
+
+// IsUnspendable returns whether the output can never be spent, such outputs
+// are not added to the UTXO set
+func (out *TXOutput) IsUnspendable() bool {
+	return len(out.ScriptPubKey) > 0 && out.ScriptPubKey[0] == OpReturn
+}
+
+// checkDataOutputs checks the relay policy of data outputs, a transaction
+// has at most one carrying at most MaxDataCarrierSize bytes
+func checkDataOutputs(tx *Transaction) error {
+	dataOutputs := 0
+
+	for i, out := range tx.VOut {
+		if !out.IsUnspendable() {
+			continue
+		}
+
+		data, ok := ExtractNullData(out.ScriptPubKey)
+		if !ok {
+			return fmt.Errorf("%w: %x output %d is not a data output", ErrNonStandardTx, tx.ID, i)
+		}
+
+		if len(data) > MaxDataCarrierSize {
+			return fmt.Errorf("%w: %x output %d carries %d bytes, max %d", ErrNonStandardTx, tx.ID, i, len(data), MaxDataCarrierSize)
+		}
+
+		if dataOutputs++; dataOutputs > 1 {
+			return fmt.Errorf("%w: %x has more than one data output", ErrNonStandardTx, tx.ID)
+		}
+	}
+
+	return nil
+}
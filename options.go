@@ -0,0 +1,75 @@
+package blockchain
+
+// options are the options of a Blockchain
+type options struct {
+	params          *ChainParams
+	utxoCommitments bool
+	storage         StorageOptions
+
+	slowBlockThresholds SlowBlockThresholds
+
+	deterministic *DeterministicMining
+
+	archival bool
+}
+
+// Option configures a Blockchain
+type Option func(*options)
+
+// WithChainParams sets the chain parameters, MainNetParams is used by default
+func WithChainParams(params *ChainParams) Option {
+	return func(o *options) {
+		o.params = params
+	}
+}
+
+// WithUTXOCommitments makes mined blocks commit to the UTXO set they are built on
+func WithUTXOCommitments() Option {
+	return func(o *options) {
+		o.utxoCommitments = true
+	}
+}
+
+// WithStorageOptions sets the bolt tuning, DefaultStorageOptions is used by default
+func WithStorageOptions(storage StorageOptions) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// WithSlowBlockThresholds sets the thresholds above which validated blocks are logged
+func WithSlowBlockThresholds(thresholds SlowBlockThresholds) Option {
+	return func(o *options) {
+		o.slowBlockThresholds = thresholds
+	}
+}
+
+// WithDeterministicMining makes the coinbase data, timestamps and
+// transaction order of mined blocks deterministic, for reproducible tests
+func WithDeterministicMining(d DeterministicMining) Option {
+	return func(o *options) {
+		o.deterministic = &d
+	}
+}
+
+// WithArchival keeps an index of the balance of every address after each
+// block, so historical balances are served by GetBalanceAt
+func WithArchival() Option {
+	return func(o *options) {
+		o.archival = true
+	}
+}
+
+// newOptions applies opts on the default options
+func newOptions(opts []Option) *options {
+	o := &options{
+		params:              &MainNetParams,
+		storage:             DefaultStorageOptions,
+		slowBlockThresholds: DefaultSlowBlockThresholds,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
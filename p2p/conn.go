@@ -0,0 +1,150 @@
+package p2p
+
+import (
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Addr is the address of a stream which isn't an IP address, e.g. one
+// relayed by a circuit relay
+type Addr struct {
+	Multiaddr ma.Multiaddr
+	Peer      peer.ID
+}
+
+// Network returns the network of the transport
+func (a Addr) Network() string {
+	return Network
+}
+
+// String returns the multiaddr with the peer id
+func (a Addr) String() string {
+	return a.Multiaddr.String() + "/p2p/" + a.Peer.String()
+}
+
+// streamConn is a libp2p stream as a net.Conn, its addresses are TCP or
+// UDP addresses when the connection is direct so the allowlist and the
+// self connection detection of the server apply
+type streamConn struct {
+	network.Stream
+	once sync.Once
+	err  error
+}
+
+// newStreamConn wraps a stream
+func newStreamConn(s network.Stream) *streamConn {
+	return &streamConn{Stream: s}
+}
+
+// Close closes the stream once, the later calls return net.ErrClosed
+func (c *streamConn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		err = c.Stream.Close()
+	})
+
+	return err
+}
+
+// LocalAddr returns the local address of the connection of the stream
+func (c *streamConn) LocalAddr() net.Addr {
+	return netAddr(c.Conn().LocalMultiaddr(), c.Conn().LocalPeer())
+}
+
+// RemoteAddr returns the remote address of the connection of the stream
+func (c *streamConn) RemoteAddr() net.Addr {
+	return netAddr(c.Conn().RemoteMultiaddr(), c.Conn().RemotePeer())
+}
+
+// netAddr converts a multiaddr to a net.Addr, an Addr when it isn't an IP
+// address
+func netAddr(addr ma.Multiaddr, id peer.ID) net.Addr {
+	if a, err := manet.ToNetAddr(addr); err == nil {
+		return a
+	}
+
+	return Addr{Multiaddr: addr, Peer: id}
+}
+
+// listener accepts the inbound streams the transport passes to it
+type listener struct {
+	transport *Transport
+	addrs     []ma.Multiaddr
+	conns     chan net.Conn
+	done      chan struct{}
+	once      sync.Once
+}
+
+// newListener creates a listener of a transport
+func newListener(t *Transport) *listener {
+	return &listener{transport: t, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Accept waits for an inbound stream
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// deliver passes an inbound stream to Accept, false when the listener is
+// closed
+func (l *listener) deliver(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// accepts returns whether the listener is bound to a local address, an
+// address bound to every interface accepts every IP of its port
+func (l *listener) accepts(local ma.Multiaddr) bool {
+	for _, addr := range l.addrs {
+		if addr.Equal(local) {
+			return true
+		}
+
+		ip, rest := ma.SplitFirst(addr)
+		if ip == nil || !manet.IsIPUnspecified(ma.Multiaddr{*ip}) {
+			continue
+		}
+
+		if _, localRest := ma.SplitFirst(local); localRest.Equal(rest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close stops listening, the transport stops accepting streams on the
+// addresses of the listener
+func (l *listener) Close() error {
+	err := net.ErrClosed
+	l.once.Do(func() {
+		close(l.done)
+		l.transport.closeListener(l)
+		err = nil
+	})
+
+	return err
+}
+
+// Addr returns the first address of the listener
+func (l *listener) Addr() net.Addr {
+	if len(l.addrs) == 0 {
+		return Addr{Multiaddr: ma.Multiaddr{}, Peer: l.transport.host.ID()}
+	}
+
+	return Addr{Multiaddr: l.addrs[0], Peer: l.transport.host.ID()}
+}
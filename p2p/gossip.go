@@ -0,0 +1,79 @@
+package p2p
+
+import (
+	"context"
+	"net"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// joinInvTopic starts gossipsub and joins the topic of the inventories
+func (t *Transport) joinInvTopic(name string) error {
+	if name == "" {
+		name = DefaultInvTopic
+	}
+
+	ps, err := pubsub.NewGossipSub(context.Background(), t.host)
+	if err != nil {
+		return err
+	}
+
+	topic, err := ps.Join(name)
+	if err != nil {
+		return err
+	}
+
+	t.pubsub, t.topic = ps, topic
+	return nil
+}
+
+// PublishInv publishes an inventory message to the gossipsub topic
+func (t *Transport) PublishInv(inv []byte) error {
+	return t.topic.Publish(context.Background(), inv)
+}
+
+// SubscribeInv calls handle with the inventory messages other nodes publish
+// to the gossipsub topic until done is closed, from matches the streams of
+// the node which published each
+func (t *Transport) SubscribeInv(done <-chan struct{}, handle func(inv []byte, from func(net.Conn) bool)) error {
+	sub, err := t.topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	defer sub.Cancel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if msg.ReceivedFrom == t.host.ID() {
+			continue
+		}
+
+		handle(msg.Data, fromPeer(msg.GetFrom()))
+	}
+}
+
+// fromPeer returns whether a connection is a stream of a peer
+func fromPeer(id peer.ID) func(net.Conn) bool {
+	return func(conn net.Conn) bool {
+		s, ok := conn.(*streamConn)
+		return ok && s.Conn().RemotePeer() == id
+	}
+}
@@ -0,0 +1,362 @@
+// Package p2p is a libp2p transport of blockchain servers, for nodes
+// deployed across NATs. Nodes are named by their peer ids and reached at
+// multiaddrs, the peer connections are libp2p streams secured by the
+// libp2p handshakes, nodes behind a NAT map their ports and reach each
+// other through circuit relays and hole punching, and the inventories of
+// new blocks are relayed over gossipsub.
+//
+// A Transport is selected with blockchain.ServerConfig.Transport, the
+// listeners of the server are multiaddrs of the "libp2p" network:
+//
+//	tr, err := p2p.New(p2p.Config{Identity: identity})
+//	...
+//	s := blockchain.NewServer(blockchain.ServerConfig{
+//		Transport: tr,
+//		Listeners: []blockchain.ListenerConfig{{Network: p2p.Network, Address: "/ip4/0.0.0.0/tcp/4001"}},
+//		Seeds:     []string{"/ip4/192.0.2.7/tcp/4001/p2p/12D3KooW..."},
+//	})
+package p2p
+
+import (
+	"blockchain"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// Network is the network of the listeners of the transport, their
+	// addresses are multiaddrs, e.g. "/ip4/0.0.0.0/tcp/4001"
+	Network = "libp2p"
+
+	// ProtocolID is the libp2p protocol of the peer streams
+	ProtocolID = protocol.ID("/blockchain/1.0.0")
+
+	// DefaultInvTopic is the gossipsub topic of the inventories
+	DefaultInvTopic = "/blockchain/inv/1.0.0"
+)
+
+var (
+	// ErrNoPeerID is returned when dialing a multiaddr without a /p2p/ peer id
+	ErrNoPeerID = errors.New("address has no peer id")
+
+	// ErrTransportClosed is returned when the transport is used after Close
+	ErrTransportClosed = errors.New("transport closed")
+)
+
+// Config configures a Transport
+type Config struct {
+	// Identity is the key of the node, its peer id is derived from it. A
+	// new identity when nil, the peer id then changes at every start.
+	Identity *blockchain.NodeIdentity
+
+	// Relays are the multiaddrs of circuit relays with their peer ids. A
+	// node which isn't reachable reserves a slot on them, other nodes dial
+	// it through a relay and the connection is upgraded to a direct one by
+	// hole punching.
+	Relays []string
+
+	// RelayService makes the node a circuit relay of the others, it should
+	// be publicly reachable
+	RelayService bool
+
+	// InvTopic is the gossipsub topic of the inventories, DefaultInvTopic
+	// when empty. Nodes of different networks should use different topics.
+	InvTopic string
+}
+
+// Transport is a blockchain.Transport over libp2p. It also names the nodes
+// by multiaddrs, see blockchain.Addresser, and relays inventories over
+// gossipsub, see blockchain.InvRelay.
+type Transport struct {
+	host   host.Host
+	pubsub *pubsub.PubSub
+	topic  *pubsub.Topic
+
+	mu        sync.Mutex
+	listeners []*listener
+	closed    bool
+}
+
+// New starts the libp2p host of a transport, it doesn't listen until the
+// server opens its listeners
+func New(cfg Config) (*Transport, error) {
+	identity := cfg.Identity
+	if identity == nil {
+		identity = blockchain.NewNodeIdentity()
+	}
+
+	key, _, err := crypto.ECDSAKeyPairFromKey(&identity.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []libp2p.Option{
+		libp2p.Identity(key),
+		libp2p.NoListenAddrs,
+		libp2p.NATPortMap(),
+		libp2p.EnableNATService(),
+		libp2p.EnableHolePunching(),
+	}
+
+	if len(cfg.Relays) > 0 {
+		relays := make([]peer.AddrInfo, 0, len(cfg.Relays))
+		for _, addr := range cfg.Relays {
+			info, err := addrInfo(addr)
+			if err != nil {
+				return nil, fmt.Errorf("relay %s: %w", addr, err)
+			}
+			relays = append(relays, *info)
+		}
+		options = append(options, libp2p.EnableAutoRelayWithStaticRelays(relays))
+	}
+
+	if cfg.RelayService {
+		options = append(options, libp2p.EnableRelayService())
+	}
+
+	h, err := libp2p.New(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transport{host: h}
+	if err := t.joinInvTopic(cfg.InvTopic); err != nil {
+		_ = h.Close()
+		return nil, err
+	}
+	h.SetStreamHandler(ProtocolID, t.handleStream)
+
+	return t, nil
+}
+
+// ID returns the peer id of the node
+func (t *Transport) ID() peer.ID {
+	return t.host.ID()
+}
+
+// Addrs returns the multiaddrs the node is reachable at, with its peer id
+func (t *Transport) Addrs() []string {
+	var addrs []string
+	for _, addr := range t.host.Addrs() {
+		addrs = append(addrs, t.withID(addr.String()))
+	}
+
+	return addrs
+}
+
+// Listen opens a listener per config, each listens on the multiaddr of its
+// config. None is left open on error.
+func (t *Transport) Listen(configs []blockchain.ListenerConfig) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	for _, config := range configs {
+		ln, err := t.listen(config)
+		if err != nil {
+			for _, open := range listeners {
+				_ = open.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", config, err)
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
+
+// listen binds the multiaddr of a config, the addresses the host listens
+// on afterwards but not before are those of the listener
+func (t *Transport) listen(config blockchain.ListenerConfig) (*listener, error) {
+	if config.Network != Network {
+		return nil, fmt.Errorf("unsupported listener network %q", config.Network)
+	}
+
+	addr, err := ma.NewMultiaddr(config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, ErrTransportClosed
+	}
+
+	before := make(map[string]bool)
+	for _, bound := range t.host.Network().ListenAddresses() {
+		before[string(bound.Bytes())] = true
+	}
+
+	if err := t.host.Network().Listen(addr); err != nil {
+		return nil, err
+	}
+
+	ln := newListener(t)
+	for _, bound := range t.host.Network().ListenAddresses() {
+		if !before[string(bound.Bytes())] {
+			ln.addrs = append(ln.addrs, bound)
+		}
+	}
+	t.listeners = append(t.listeners, ln)
+
+	return ln, nil
+}
+
+// closeListener stops listening on the addresses of a listener
+func (t *Transport) closeListener(ln *listener) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, open := range t.listeners {
+		if open == ln {
+			t.listeners = append(t.listeners[:i], t.listeners[i+1:]...)
+			break
+		}
+	}
+
+	if closer, ok := t.host.Network().(interface{ ListenClose(...ma.Multiaddr) }); ok {
+		closer.ListenClose(ln.addrs...)
+	}
+}
+
+// handleStream passes an inbound stream to the listener bound to its local
+// address, or to the first listener for relayed streams and addresses no
+// listener is bound to. Streams are reset when no listener is open.
+func (t *Transport) handleStream(s network.Stream) {
+	t.mu.Lock()
+	var target *listener
+	for _, ln := range t.listeners {
+		if ln.accepts(s.Conn().LocalMultiaddr()) {
+			target = ln
+			break
+		}
+	}
+	if target == nil && len(t.listeners) > 0 {
+		target = t.listeners[0]
+	}
+	t.mu.Unlock()
+
+	if target == nil || !target.deliver(newStreamConn(s)) {
+		_ = s.Reset()
+	}
+}
+
+// Dial connects to a node at a multiaddr ending with its peer id, e.g.
+// "/ip4/192.0.2.7/tcp/4001/p2p/12D3KooW...", and opens a stream to it
+func (t *Transport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	info, err := addrInfo(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := t.host.Connect(ctx, *info); err != nil {
+		return nil, err
+	}
+
+	s, err := t.host.NewStream(ctx, info.ID, ProtocolID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStreamConn(s), nil
+}
+
+// AdvertisedAddress returns the multiaddr of a listener with the peer id
+// of the node
+func (t *Transport) AdvertisedAddress(listen string) string {
+	return t.withID(listen)
+}
+
+// CheckAddress checks other nodes can dial an advertised multiaddr, it
+// must name the node by its peer id and can't be bound to every interface
+// or a random port
+func (t *Transport) CheckAddress(addr string) error {
+	info, err := addrInfo(addr)
+	if err != nil {
+		return fmt.Errorf("%w: %s", blockchain.ErrNoExternalAddress, err)
+	}
+
+	if info.ID != t.host.ID() {
+		return fmt.Errorf("%w: %s names peer %s, not the node %s", blockchain.ErrNoExternalAddress, addr, info.ID, t.host.ID())
+	}
+
+	for _, a := range info.Addrs {
+		for _, c := range a {
+			switch c.Code() {
+			case ma.P_IP4, ma.P_IP6:
+				if !net.IP(c.RawValue()).IsUnspecified() {
+					continue
+				}
+			case ma.P_TCP, ma.P_UDP:
+				if c.Value() != "0" {
+					continue
+				}
+			default:
+				continue
+			}
+
+			return fmt.Errorf("%w: %s can't be dialed, set ServerConfig.ExternalAddress", blockchain.ErrNoExternalAddress, addr)
+		}
+	}
+
+	return nil
+}
+
+// Close stops the libp2p host, the servers of the transport should be
+// stopped first
+func (t *Transport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	if err := t.topic.Close(); err != nil && !errors.Is(err, pubsub.ErrTopicClosed) {
+		return err
+	}
+
+	return t.host.Close()
+}
+
+// withID appends the peer id of the node to a multiaddr without one
+func (t *Transport) withID(addr string) string {
+	if strings.Contains(addr, "/p2p/") {
+		return addr
+	}
+
+	return addr + "/p2p/" + t.host.ID().String()
+}
+
+// addrInfo parses a multiaddr ending with a peer id
+func addrInfo(addr string) (*peer.AddrInfo, error) {
+	a, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := a.ValueForProtocol(ma.P_P2P); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoPeerID, addr)
+	}
+
+	return peer.AddrInfoFromP2pAddr(a)
+}
@@ -0,0 +1,191 @@
+package p2p
+
+import (
+	"blockchain"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// testParams are the chain parameters of the test nodes, easy to mine
+var testParams = func() blockchain.ChainParams {
+	params := blockchain.MainNetParams
+	params.TargetBits = 8
+	return params
+}()
+
+// newTestChains creates a chain and copies it for every other node id, so
+// the nodes share a genesis block
+func newTestChains(t *testing.T, nodeIDs ...string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	wallet := blockchain.NewWalletWithParams(&testParams)
+	blockchain.CreateBlockchain(string(wallet.GetAddress()), nodeIDs[0], blockchain.WithChainParams(&testParams)).Close()
+
+	data, err := os.ReadFile(fmt.Sprintf("blockchain_%s.db", nodeIDs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeID := range nodeIDs[1:] {
+		if err := os.WriteFile(fmt.Sprintf("blockchain_%s.db", nodeID), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// freeAddress returns a multiaddr of a free local TCP port
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", ln.Addr().(*net.TCPAddr).Port)
+}
+
+// startTestNode starts the server of a node over a libp2p transport, on a
+// free local port unless it only connects to its seeds
+func startTestNode(t *testing.T, cfg blockchain.ServerConfig) (*blockchain.Server, *Transport) {
+	t.Helper()
+
+	tr, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+
+	cfg.Blockchain = blockchain.NewBlockchain(cfg.NodeID, blockchain.WithChainParams(&testParams))
+	cfg.Transport = tr
+	if !cfg.ConnectOnly {
+		cfg.Listeners = []blockchain.ListenerConfig{{Network: Network, Address: freeAddress(t)}}
+	}
+	if cfg.Seeds == nil {
+		cfg.Seeds = []string{}
+	}
+
+	s := blockchain.NewServer(cfg)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+
+	return s, tr
+}
+
+// waitFor polls a condition for up to ten seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// handshaken returns whether a server has a peer past the handshake
+func handshaken(s *blockchain.Server) bool {
+	for _, p := range s.Peers() {
+		if p.Version > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestServersRelayBlocksOverGossipsub(t *testing.T) {
+	newTestChains(t, "a", "b", "c")
+
+	ahead := blockchain.NewBlockchain("a", blockchain.WithChainParams(&testParams))
+	to := blockchain.NewWalletWithParams(&testParams).GetAddress()
+	ahead.MineBlock([]*blockchain.Transaction{ahead.NewCoinbaseTX(string(to))})
+	ahead.Close()
+
+	b, bTransport := startTestNode(t, blockchain.ServerConfig{NodeID: "b"})
+	c, cTransport := startTestNode(t, blockchain.ServerConfig{NodeID: "c", Seeds: []string{b.Address()}})
+	waitFor(t, "c to connect to b", func() bool { return handshaken(c) && handshaken(b) })
+	waitFor(t, "b and c to join the inventory topic", func() bool {
+		return len(bTransport.topic.ListPeers()) > 0 && len(cTransport.topic.ListPeers()) > 0
+	})
+	// the messages published before the first heartbeat of gossipsub
+	// aren't forwarded
+	time.Sleep(2 * pubsub.GossipSubHeartbeatInitialDelay)
+
+	// a is ahead of b and advertises no address, b syncs from a at the
+	// handshake and relays the block to c over gossipsub
+	startTestNode(t, blockchain.ServerConfig{NodeID: "a", Seeds: []string{b.Address()}, ConnectOnly: true})
+
+	waitFor(t, "b to sync from a", func() bool { return b.Blockchain().GetBestHeight() == 1 })
+	waitFor(t, "c to get the block relayed by b", func() bool { return c.Blockchain().GetBestHeight() == 1 })
+}
+
+func TestCheckAddress(t *testing.T) {
+	tr, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	other, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	tests := []struct {
+		name  string
+		addr  string
+		valid bool
+	}{
+		{"peer id of the node", "/ip4/192.0.2.7/tcp/4001/p2p/" + tr.ID().String(), true},
+		{"no peer id", "/ip4/192.0.2.7/tcp/4001", false},
+		{"peer id of another node", "/ip4/192.0.2.7/tcp/4001/p2p/" + other.ID().String(), false},
+		{"every interface", "/ip4/0.0.0.0/tcp/4001/p2p/" + tr.ID().String(), false},
+		{"random port", "/ip4/192.0.2.7/tcp/0/p2p/" + tr.ID().String(), false},
+		{"host:port", "192.0.2.7:4001", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := tr.CheckAddress(test.addr)
+			if test.valid && err != nil {
+				t.Fatalf("address rejected: %v", err)
+			}
+			if !test.valid && !errors.Is(err, blockchain.ErrNoExternalAddress) {
+				t.Fatalf("error %v, want %v", err, blockchain.ErrNoExternalAddress)
+			}
+		})
+	}
+}
+
+func TestDialRequiresPeerID(t *testing.T) {
+	tr, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	if _, err := tr.Dial("/ip4/127.0.0.1/tcp/4001", time.Second); !errors.Is(err, ErrNoPeerID) {
+		t.Fatalf("error %v, want %v", err, ErrNoPeerID)
+	}
+}
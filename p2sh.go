@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// PayToScriptHashScript returns the script locking an output to the hash
+// of a redeem script, the redeem script is revealed by the spending input
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OpHash160).AddData(scriptHash).AddOp(OpEqual).Script()
+}
+
+// ExtractScriptHash returns the script hash of a pay to script hash script,
+// nil for other scripts
+func ExtractScriptHash(script []byte) []byte {
+	if len(script) == 23 && script[0] == OpHash160 && script[1] == 20 && script[22] == OpEqual {
+		return script[2:22]
+	}
+
+	return nil
+}
+
+// ScriptHashAddress returns the address paying to a redeem script
+func ScriptHashAddress(redeemScript []byte) []byte {
+	return encodeAddress(scriptHashVersion, HashPubKey(redeemScript))
+}
+
+// redeemScript returns the redeem script revealed by the unlocking script
+// of an input, it is the last element pushed
+func redeemScript(scriptSig []byte) ([]byte, error) {
+	ops, err := parseScript(scriptSig)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: no redeem script", ErrScriptFailed)
+	}
+
+	return ops[len(ops)-1].data, nil
+}
+
+// SignRedeemScript signs the inputs of a Transaction spending pay to script
+// hash outputs of the redeem script with the keys of the signer, multisig
+// redeem scripts collect the signatures of each party signing in turn
+func (tx *Transaction) SignRedeemScript(signer Signer, prevTXs map[string]Transaction, redeemScript []byte) error {
+	if tx.IsCoinbase() {
+		return nil
+	} else if err := tx.validatePrevTXs(prevTXs); err != nil {
+		return err
+	}
+
+	scriptHash := HashPubKey(redeemScript)
+	for inID, vin := range tx.VIn {
+		if !bytes.Equal(ExtractScriptHash(prevOutput(vin, prevTXs).ScriptPubKey), scriptHash) {
+			continue
+		}
+
+		pubKeyHash := ExtractPubKeyHash(redeemScript)
+		if pubKeyHash == nil {
+			if err := tx.signMultiSig(inID, redeemScript, signer, true, SigHashAll); err != nil {
+				return err
+			}
+			continue
+		}
+
+		address := string(encodeAddress(version, pubKeyHash))
+		pubKey, err := signerKey(signer, address)
+		if err != nil {
+			return err
+		}
+		if pubKey == nil {
+			continue
+		}
+
+		signature, err := tx.signInput(signer, address, pubKey, inID, redeemScript, SigHashAll)
+		if err != nil {
+			return err
+		}
+		tx.VIn[inID].ScriptSig = NewScriptBuilder().
+			AddData(signature).
+			AddData(pubKey).
+			AddData(redeemScript).
+			Script()
+	}
+
+	return nil
+}
+
+// SignRedeemScript signs inputs of a Transaction spending pay to script hash outputs of the redeem script
+func (bc *Blockchain) SignRedeemScript(tx *Transaction, signer Signer, redeemScript []byte) error {
+	prevTXs, err := bc.findPrevTransactions(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.SignRedeemScript(signer, prevTXs, redeemScript)
+}
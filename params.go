@@ -0,0 +1,238 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+)
+
+// defaultMaxBlockSize is the maximum block size of MainNetParams
+const defaultMaxBlockSize = 1000000
+
+// paramsHashVersion is the version of the encoding hashed by
+// ChainParams.Hash, the first byte hashed
+const paramsHashVersion = 1
+
+// paramTag identifies a parameter in the encoding hashed by ChainParams.Hash
+type paramTag byte
+
+const (
+	paramName paramTag = iota + 1
+	paramGenesisCoinbaseData
+	paramSubsidy
+	paramSubsidyHalvingInterval
+	paramTargetBits
+	paramAddressVersion
+	paramScriptHashAddressVersion
+	paramKeyCurve
+	paramMaxBlockSize
+	paramActivation
+)
+
+// Feature is a consensus feature activated at a height
+type Feature int
+
+const (
+	// FeatureCanonicalTx enables transactions in the canonical format
+	FeatureCanonicalTx Feature = iota + 1
+
+	// FeatureAssets enables asset-carrying transactions
+	FeatureAssets
+)
+
+// ChainParams defines the consensus parameters of a chain
+type ChainParams struct {
+	// Name is the name of the chain
+	Name string
+
+	// GenesisCoinbaseData is the coinbase data of the genesis block
+	GenesisCoinbaseData string
+
+	// Subsidy is the reward for mining a block
+	Subsidy int
+
+	// SubsidyHalvingInterval is the number of blocks after which the subsidy
+	// is halved, the subsidy never halves when it is zero
+	SubsidyHalvingInterval int
+
+	// TargetBits is the proof of work difficulty
+	TargetBits int
+
+	// AddressVersion is the version byte of addresses
+	AddressVersion byte
+
+	// ScriptHashAddressVersion is the version byte of script hash addresses
+	ScriptHashAddressVersion byte
+
+	// Bech32HRP is the human-readable prefix of bech32 addresses,
+	// DefaultBech32HRP when empty. Addresses aren't consensus, it isn't in Hash.
+	Bech32HRP string
+
+	// KeyCurve is the curve of the keys of new wallets
+	KeyCurve KeyCurve
+
+	// MaxBlockSize is the maximum serialized size of a block's transactions
+	MaxBlockSize int
+
+	// Activations are the heights features activate at, features missing
+	// are never active
+	Activations map[Feature]int
+
+	// NetworkMagic starts the wire messages of the nodes of the chain, so
+	// nodes of other chains are disconnected. DefaultNetworkMagic when
+	// zero. It isn't in Hash, the chain doesn't depend on it.
+	NetworkMagic uint32
+}
+
+// MainNetParams are the default chain parameters
+var MainNetParams = ChainParams{
+	Name:                     "mainnet",
+	GenesisCoinbaseData:      genesisCoinbaseData,
+	Subsidy:                  subsidy,
+	TargetBits:               targetBits,
+	AddressVersion:           version,
+	ScriptHashAddressVersion: scriptHashVersion,
+	Bech32HRP:                DefaultBech32HRP,
+	MaxBlockSize:             defaultMaxBlockSize,
+	NetworkMagic:             DefaultNetworkMagic,
+}
+
+// Hash returns a hash of the consensus parameters, it is stored in the chain
+// metadata to detect a database created with different parameters. Each
+// parameter is hashed with its tag and length after the paramsHashVersion
+// byte, so distinct parameters can't hash alike.
+func (p *ChainParams) Hash() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(paramsHashVersion)
+
+	writeParam := func(tag paramTag, value []byte) {
+		buf.WriteByte(byte(tag))
+		writeVarInt(&buf, uint64(len(value)))
+		buf.Write(value)
+	}
+
+	writeParam(paramName, []byte(p.Name))
+	writeParam(paramGenesisCoinbaseData, []byte(p.GenesisCoinbaseData))
+	writeParam(paramSubsidy, IntToHex(int64(p.Subsidy)))
+	writeParam(paramSubsidyHalvingInterval, IntToHex(int64(p.SubsidyHalvingInterval)))
+	writeParam(paramTargetBits, IntToHex(int64(p.TargetBits)))
+	writeParam(paramAddressVersion, []byte{p.AddressVersion})
+	writeParam(paramScriptHashAddressVersion, []byte{p.ScriptHashAddressVersion})
+	writeParam(paramKeyCurve, IntToHex(int64(p.KeyCurve)))
+	writeParam(paramMaxBlockSize, IntToHex(int64(p.MaxBlockSize)))
+
+	for _, feature := range p.sortedFeatures() {
+		writeParam(paramActivation, append(IntToHex(int64(feature)), IntToHex(int64(p.Activations[feature]))...))
+	}
+
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// legacyHash returns the hash of the parameters stored by databases created
+// before Hash tagged the parameters, the parameters are concatenated and
+// those added later only away from their defaults
+func (p *ChainParams) legacyHash() []byte {
+	data := bytes.Join(
+		[][]byte{
+			[]byte(p.Name),
+			[]byte(p.GenesisCoinbaseData),
+			IntToHex(int64(p.Subsidy)),
+			IntToHex(int64(p.TargetBits)),
+			{p.AddressVersion},
+		},
+		[]byte{},
+	)
+
+	for _, feature := range p.sortedFeatures() {
+		data = append(data, IntToHex(int64(feature))...)
+		data = append(data, IntToHex(int64(p.Activations[feature]))...)
+	}
+
+	if p.SubsidyHalvingInterval != 0 {
+		data = append(data, IntToHex(int64(p.SubsidyHalvingInterval))...)
+	}
+
+	if p.KeyCurve != CurveP256 {
+		data = append(data, IntToHex(int64(p.KeyCurve))...)
+	}
+
+	if p.MaxBlockSize != defaultMaxBlockSize {
+		data = append(data, IntToHex(int64(p.MaxBlockSize))...)
+	}
+
+	if p.ScriptHashAddressVersion != scriptHashVersion {
+		data = append(data, p.ScriptHashAddressVersion)
+	}
+
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// sortedFeatures returns the features with an activation height in order
+func (p *ChainParams) sortedFeatures() []Feature {
+	features := make([]Feature, 0, len(p.Activations))
+	for feature := range p.Activations {
+		features = append(features, feature)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i] < features[j] })
+
+	return features
+}
+
+// BlockSubsidy returns the subsidy of the block at height
+func (p *ChainParams) BlockSubsidy(height int) int {
+	if p.SubsidyHalvingInterval <= 0 {
+		return p.Subsidy
+	}
+
+	halvings := height / p.SubsidyHalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.Subsidy >> uint(halvings)
+}
+
+// ProjectSupply returns the total subsidy issued by the blocks from the
+// genesis up to and including height
+func (p *ChainParams) ProjectSupply(height int) int {
+	if height < 0 {
+		return 0
+	}
+
+	if p.SubsidyHalvingInterval <= 0 {
+		return (height + 1) * p.Subsidy
+	}
+
+	supply := 0
+	for start := 0; start <= height; start += p.SubsidyHalvingInterval {
+		subsidy := p.BlockSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+
+		end := start + p.SubsidyHalvingInterval - 1
+		if end > height {
+			end = height
+		}
+		supply += (end - start + 1) * subsidy
+	}
+
+	return supply
+}
+
+// networkMagic returns the magic of the wire messages of the chain
+func (p *ChainParams) networkMagic() uint32 {
+	if p.NetworkMagic == 0 {
+		return DefaultNetworkMagic
+	}
+
+	return p.NetworkMagic
+}
+
+// IsActive returns whether a feature is active in the block at height
+func (p *ChainParams) IsActive(feature Feature, height int) bool {
+	activation, ok := p.Activations[feature]
+	return ok && height >= activation
+}
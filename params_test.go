@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestChainParamsHashGolden(t *testing.T) {
+	p := ChainParams{
+		Name:                     "t",
+		GenesisCoinbaseData:      "g",
+		Subsidy:                  10,
+		TargetBits:               8,
+		ScriptHashAddressVersion: 5,
+		KeyCurve:                 CurveSecp256k1,
+		MaxBlockSize:             1000,
+		Activations:              map[Feature]int{FeatureAssets: 9, FeatureCanonicalTx: 2},
+	}
+
+	const preimage = "01" +
+		"010174" + "020167" +
+		"0308000000000000000a" + "04080000000000000000" + "05080000000000000008" +
+		"060100" + "070105" +
+		"08080000000000000001" + "090800000000000003e8" +
+		"0a1000000000000000010000000000000002" + "0a1000000000000000020000000000000009"
+
+	data, err := hex.DecodeString(preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(data); !bytes.Equal(p.Hash(), want[:]) {
+		t.Errorf("hash %x, want %x", p.Hash(), want)
+	}
+}
+
+func TestChainParamsHashSeparatesFields(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b func(*ChainParams)
+	}{
+		{
+			"halving interval and block size",
+			func(p *ChainParams) { p.SubsidyHalvingInterval, p.MaxBlockSize = 500000, defaultMaxBlockSize },
+			func(p *ChainParams) { p.SubsidyHalvingInterval, p.MaxBlockSize = 0, 500000 },
+		},
+		{
+			"name and genesis coinbase data",
+			func(p *ChainParams) { p.Name, p.GenesisCoinbaseData = "main", "net" },
+			func(p *ChainParams) { p.Name, p.GenesisCoinbaseData = "mainn", "et" },
+		},
+		{
+			"default and explicit block size",
+			func(p *ChainParams) { p.MaxBlockSize = defaultMaxBlockSize },
+			func(p *ChainParams) { p.MaxBlockSize = defaultMaxBlockSize + 1 },
+		},
+		{
+			"activation heights",
+			func(p *ChainParams) { p.Activations = map[Feature]int{FeatureCanonicalTx: 1} },
+			func(p *ChainParams) { p.Activations = map[Feature]int{FeatureAssets: 1} },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := MainNetParams, MainNetParams
+			test.a(&a)
+			test.b(&b)
+
+			if bytes.Equal(a.Hash(), b.Hash()) {
+				t.Errorf("params %+v and %+v hash alike", a, b)
+			}
+		})
+	}
+}
+
+func TestNewBlockchainMigratesLegacyParamsHash(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	params := bc.Params()
+	if err := bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(metadataBucket)).Put([]byte(metadataParamsHashKey), params.legacyHash())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	bc = NewBlockchain("test", WithChainParams(params))
+	defer bc.Close()
+
+	if got := bc.Metadata().ParamsHash; !bytes.Equal(got, params.Hash()) {
+		t.Errorf("params hash %x, want %x", got, params.Hash())
+	}
+}
@@ -0,0 +1,170 @@
+package blockchain
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// peerQueueSize is the number of messages waiting to be written to a
+	// peer, a peer too slow to read them is disconnected
+	peerQueueSize = 100
+
+	// peerWriteTimeout is how long writing a message to a peer may take
+	peerWriteTimeout = 30 * time.Second
+
+	// peerDialTimeout is how long connecting to a peer may take
+	peerDialTimeout = 10 * time.Second
+)
+
+// peerConn is a persistent connection to a peer, the messages are read and
+// written as frames by its own goroutines
+type peerConn struct {
+	// addr is the address the peer listens on, empty for an inbound connection
+	addr string
+	conn net.Conn
+
+	// magic is the network magic of the frames of the chain of the node
+	magic uint32
+
+	// version and verack are received during the handshake, they are only
+	// used by the goroutine reading the messages
+	version *versionData
+	verack  bool
+
+	// limiter is the rate limit of the messages of the peer, nil for a
+	// peer exempt from rate limits. It is only used by the goroutine
+	// reading the messages.
+	limiter *peerLimiter
+
+	out       chan []byte
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// newPeerConn wraps a connection to a peer exchanging the frames of a
+// network magic
+func newPeerConn(conn net.Conn, addr string, magic uint32) *peerConn {
+	return &peerConn{addr: addr, conn: conn, magic: magic, out: make(chan []byte, peerQueueSize), quit: make(chan struct{})}
+}
+
+// send queues a command and its payload for the peer
+func (p *peerConn) send(command string, data interface{}) {
+	p.queue(EncodeMessage(command, data))
+}
+
+// queue queues a message for the peer, the peer is disconnected when its
+// queue is full
+func (p *peerConn) queue(message []byte) {
+	select {
+	case p.out <- message:
+	case <-p.quit:
+	default:
+		log.Printf("%s is too slow, disconnecting\n", p)
+		p.close()
+	}
+}
+
+// writeLoop writes the queued messages until the connection is closed
+func (p *peerConn) writeLoop() {
+	for {
+		select {
+		case message := <-p.out:
+			if err := p.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout)); err != nil {
+				log.Println(err)
+			}
+
+			if err := WriteNetworkMessage(p.conn, p.magic, message); err != nil {
+				log.Printf("writing to %s failed: %s\n", p, err)
+				p.close()
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// readLoop reads the messages of the peer and handles them in order until
+// the connection fails or is closed. A message above maxPayload of its
+// command closes the connection. Once handshaken a peer silent for
+// peerReadTimeout is disconnected, before the read deadline of the
+// handshake applies.
+func (p *peerConn) readLoop(handle func(p *peerConn, message []byte), maxPayload func(command string) uint32) {
+	defer p.close()
+
+	for {
+		if p.handshaken() {
+			if err := p.conn.SetReadDeadline(time.Now().Add(peerReadTimeout)); err != nil {
+				log.Println(err)
+			}
+		}
+
+		message, err := readMessage(p.conn, p.magic, maxPayload)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("reading from %s failed: %s\n", p, err)
+			}
+			return
+		}
+
+		if !p.handle(handle, message) {
+			return
+		}
+	}
+}
+
+// handle handles a message, a handler panicking on a message of the peer
+// disconnects the peer instead of stopping the node
+func (p *peerConn) handle(handle func(p *peerConn, message []byte), message []byte) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("handling %s from %s panicked: %v\n%s", bytesToCommand(message[:commandLength]), p, r, debug.Stack())
+			ok = false
+		}
+	}()
+
+	handle(p, message)
+	return true
+}
+
+// handshaken returns whether the peer sent its version and acknowledged
+// the version of the node
+func (p *peerConn) handshaken() bool {
+	return p.version != nil && p.verack
+}
+
+// close closes the connection, stopping both loops
+func (p *peerConn) close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		if err := p.conn.Close(); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// closed returns whether the connection was closed
+func (p *peerConn) closed() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// String returns the address of the peer, the remote address of the
+// connection when it isn't known yet
+func (p *peerConn) String() string {
+	if p.addr != "" {
+		return p.addr
+	}
+
+	return p.conn.RemoteAddr().String()
+}
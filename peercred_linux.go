@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package blockchain
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPeerCredentials allows Unix socket peers running as the same user or root
+func checkPeerCredentials(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("%T is not a unix connection", conn)
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+
+	if credErr != nil {
+		return credErr
+	}
+
+	if uid := int(cred.Uid); uid != os.Getuid() && uid != 0 {
+		return fmt.Errorf("peer uid %d is not allowed", uid)
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package blockchain
+
+import "net"
+
+// checkPeerCredentials relies on the socket file permissions where peer
+// credentials are not available, any local process of a user allowed by the
+// socket mode can connect
+func checkPeerCredentials(net.Conn) error {
+	return nil
+}
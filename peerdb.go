@@ -0,0 +1,163 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/boltdb/bolt"
+	"log"
+	"time"
+)
+
+// peersBucket is the bucket name of the known nodes by address
+const peersBucket = "peers"
+
+// KnownAddress is a known node with its connection stats, the peer
+// database of a node holds them so it reconnects to the network after a
+// restart without the seeds
+type KnownAddress struct {
+	NetAddress
+
+	// LastAttempt and LastSuccess are when the node was last dialed and
+	// when a handshake with it last completed
+	LastAttempt time.Time
+	LastSuccess time.Time
+
+	// Successes counts the completed handshakes, Failures the dial failures
+	// since the last one
+	Successes int
+	Failures  int
+
+	// NextAttempt is when the node may be dialed again after it failed, the
+	// backoff of the dial policy
+	NextAttempt time.Time
+
+	// Identity is the identity public key the node authenticated with in
+	// its first Noise handshake, later connections must present the same
+	Identity []byte
+}
+
+// better returns whether a known node is a better dial candidate than
+// another one, fewer failures first, then the most recent success
+func (a *KnownAddress) better(b *KnownAddress) bool {
+	if a.Failures != b.Failures {
+		return a.Failures < b.Failures
+	}
+
+	return a.LastSuccess.After(b.LastSuccess)
+}
+
+// loadPeers returns the known nodes of the peer database
+func (bc *Blockchain) loadPeers() ([]KnownAddress, error) {
+	var nodes []KnownAddress
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(peersBucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var node KnownAddress
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&node); err != nil {
+				return err
+			}
+
+			nodes = append(nodes, node)
+			return nil
+		})
+	})
+
+	return nodes, err
+}
+
+// savePeers replaces the known nodes of the peer database
+func (bc *Blockchain) savePeers(nodes []KnownAddress) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(peersBucket)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		b, err := tx.CreateBucket([]byte(peersBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, node := range nodes {
+			if err = b.Put([]byte(node.Addr), gobEncode(node)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// savePeers writes the known nodes to the peer database
+func (s *Server) savePeers() {
+	if err := s.bc.savePeers(s.KnownAddresses()); err != nil {
+		log.Printf("saving the peer database failed: %s\n", err)
+	}
+}
+
+// markAttempt records that a known node is being dialed
+func (s *Server) markAttempt(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := knownNodeIndex(s.knownNodes, addr); i >= 0 {
+		s.knownNodes[i].LastAttempt = time.Now()
+	}
+}
+
+// markSuccess records a completed handshake with a known node
+func (s *Server) markSuccess(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := knownNodeIndex(s.knownNodes, addr); i >= 0 {
+		node := &s.knownNodes[i]
+		node.LastSuccess = time.Now()
+		node.Successes++
+		node.Failures = 0
+		node.NextAttempt = time.Time{}
+	}
+}
+
+// pinIdentity records the identity a known node authenticated with and
+// returns whether it matches the one it authenticated with before
+func (s *Server) pinIdentity(addr string, identity []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := knownNodeIndex(s.knownNodes, addr)
+	if i < 0 {
+		return true
+	}
+
+	node := &s.knownNodes[i]
+	if node.Identity == nil {
+		node.Identity = identity
+		return true
+	}
+
+	return bytes.Equal(node.Identity, identity)
+}
+
+// markFailure records a failure to dial a known node, it isn't dialed
+// again before the backoff of the dial policy. It returns whether the node
+// failed DialPolicy.MaxFailures times in a row.
+func (s *Server) markFailure(addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := knownNodeIndex(s.knownNodes, addr)
+	if i < 0 {
+		return false
+	}
+
+	node := &s.knownNodes[i]
+	node.Failures++
+	node.NextAttempt = time.Now().Add(s.cfg.DialPolicy.backoff(node.Failures))
+
+	return node.Failures >= s.cfg.DialPolicy.MaxFailures
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// PeerEventType is the type of a PeerEvent
+type PeerEventType int
+
+const (
+	// PeerConnected is sent when a node becomes a known peer
+	PeerConnected PeerEventType = iota + 1
+
+	// PeerDisconnected is sent when a peer is removed from the known nodes
+	PeerDisconnected
+
+	// PeerBanned is sent when a peer is banned for misbehaving
+	PeerBanned
+)
+
+// defaultBanDuration is how long a misbehaving peer is banned
+const defaultBanDuration = 24 * time.Hour
+
+// String returns the name of the event type
+func (t PeerEventType) String() string {
+	switch t {
+	case PeerConnected:
+		return "connected"
+	case PeerDisconnected:
+		return "disconnected"
+	case PeerBanned:
+		return "banned"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerEvent notifies a change of the peers of the node
+type PeerEvent struct {
+	Type   PeerEventType
+	Node   string
+	Peer   string
+	Reason string
+	Time   time.Time
+}
+
+// PeerEventHook receives the peer events, e.g. to export the network
+// topology to a monitoring system. Hooks are called in order from the
+// goroutine handling the peer and must not block.
+type PeerEventHook interface {
+	HandlePeerEvent(event PeerEvent)
+}
+
+// PeerEventHookFunc adapts a function to a PeerEventHook
+type PeerEventHookFunc func(event PeerEvent)
+
+// HandlePeerEvent calls f
+func (f PeerEventHookFunc) HandlePeerEvent(event PeerEvent) {
+	f(event)
+}
+
+// PeerInfo describes a connected peer
+type PeerInfo struct {
+	Addr        string
+	Inbound     bool
+	ConnectedAt time.Time
+
+	// Score is the misbehaviour score of the peer, it is banned at 100
+	Score int
+
+	// Version, Services and UserAgent are those the peer sent in its
+	// version, zero until the handshake
+	Version   int
+	Services  ServiceFlag
+	UserAgent string
+
+	// Latency is the round trip of the last ping the peer answered, zero
+	// until it answers one
+	Latency time.Duration
+
+	// Identity is the identity public key the peer authenticated with over
+	// Noise, nil on other transports
+	Identity []byte
+
+	// FeeFilter is the minimum fee rate of the transactions relayed to the peer
+	FeeFilter float64
+}
+
+// peerRegistry tracks which peers of a node are banned, and sends their
+// events to the hooks
+type peerRegistry struct {
+	node   string
+	mu     sync.Mutex
+	hooks  []PeerEventHook
+	banned map[string]time.Time
+}
+
+// newPeerRegistry creates the peer registry of the node of an address
+func newPeerRegistry(node string) *peerRegistry {
+	return &peerRegistry{node: node, banned: make(map[string]time.Time)}
+}
+
+// RegisterPeerEventHook adds a hook receiving the peer events
+func (s *Server) RegisterPeerEventHook(hook PeerEventHook) {
+	s.peers.mu.Lock()
+	defer s.peers.mu.Unlock()
+
+	s.peers.hooks = append(s.peers.hooks, hook)
+}
+
+// emit sends an event to the hooks
+func (r *peerRegistry) emit(eventType PeerEventType, peer, reason string) {
+	event := PeerEvent{Type: eventType, Node: r.node, Peer: peer, Reason: reason, Time: time.Now()}
+
+	r.mu.Lock()
+	hooks := append([]PeerEventHook{}, r.hooks...)
+	r.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook.HandlePeerEvent(event)
+	}
+}
+
+// isBanned returns whether a peer is banned
+func (r *peerRegistry) isBanned(peer string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	until, ok := r.banned[peer]
+	if ok && time.Now().After(until) {
+		delete(r.banned, peer)
+		return false
+	}
+
+	return ok
+}
+
+// BanPeer disconnects a peer, removes it from the known nodes and ignores
+// it for the duration
+func (s *Server) BanPeer(peer, reason string, duration time.Duration) {
+	s.peers.mu.Lock()
+	s.peers.banned[peer] = time.Now().Add(duration)
+	s.peers.mu.Unlock()
+
+	s.removeFromKnownNodes(peer)
+	s.manager.disconnect(peer)
+	s.peers.emit(PeerBanned, peer, reason)
+}
+
+// Peers returns the connected peers of the node, the live network
+// topology seen from this node, see PeerManager.Peers
+func (s *Server) Peers() []PeerInfo {
+	return s.manager.Peers()
+}
+
+// isMisbehaviour returns whether a rejected block proves its sender misbehaves
+func isMisbehaviour(err error) bool {
+	return errors.Is(err, ErrInvalidBlock) || errors.Is(err, ErrInvalidProofOfWork) || errors.Is(err, ErrInvalidTransaction)
+}
@@ -0,0 +1,479 @@
+package blockchain
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxInbound is the default number of inbound peers
+	DefaultMaxInbound = 117
+
+	// DefaultMaxOutbound is the default number of outbound peers
+	DefaultMaxOutbound = 8
+
+	// banThreshold is the misbehaviour score a peer is banned at
+	banThreshold = 100
+
+	// unknownCommandScore is the misbehaviour score of an unknown command
+	unknownCommandScore = 10
+
+	// malformedMessageScore is the misbehaviour score of a message whose
+	// payload doesn't decode
+	malformedMessageScore = 20
+
+	// peerDialInterval is how often the node dials known nodes when it has
+	// fewer outbound peers than its target
+	peerDialInterval = 30 * time.Second
+)
+
+// peerState is what the peer manager knows of a connected peer
+type peerState struct {
+	// addr is the address the peer listens on, empty for an inbound peer
+	// until its version tells it
+	addr        string
+	inbound     bool
+	connectedAt time.Time
+	score       int
+	handshaken  bool
+
+	// version, services, userAgent, bestHeight and nonce are those of the
+	// version of the peer
+	version    int
+	services   ServiceFlag
+	userAgent  string
+	bestHeight int
+	nonce      uint64
+
+	// pingNonce and pingSent are those of the ping the peer didn't answer
+	// yet, latency is the round trip of the last answered one
+	pingNonce uint64
+	pingSent  time.Time
+	latency   time.Duration
+
+	// identity is the identity public key of a peer authenticated by Noise
+	identity []byte
+
+	// feeFilter is the minimum fee rate of the transactions relayed to the peer
+	feeFilter float64
+
+	// sendHeaders is set when the peer wants new blocks announced with
+	// their headers
+	sendHeaders bool
+
+	// filter is the bloom filter of the transactions relayed to the peer,
+	// nil relays every transaction
+	filter *BloomFilter
+}
+
+// PeerManager tracks the connected peers of a node and enforces the limits
+// of inbound and outbound peers
+type PeerManager struct {
+	maxInbound  int
+	maxOutbound int
+
+	mu    sync.Mutex
+	peers map[*peerConn]*peerState
+}
+
+// newPeerManager creates a peer manager with limits of inbound and outbound peers
+func newPeerManager(maxInbound, maxOutbound int) *PeerManager {
+	return &PeerManager{maxInbound: maxInbound, maxOutbound: maxOutbound, peers: make(map[*peerConn]*peerState)}
+}
+
+// addInbound adds an inbound peer. When the node has its maximum of
+// inbound peers the worst-behaving one is evicted for it, it is refused
+// when none misbehaved.
+func (m *PeerManager) addInbound(p *peerConn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.count(true) >= m.maxInbound {
+		worst := m.worstInbound()
+		if worst == nil {
+			return false
+		}
+
+		delete(m.peers, worst)
+		worst.close()
+	}
+
+	m.peers[p] = &peerState{addr: p.addr, inbound: true, connectedAt: time.Now()}
+	return true
+}
+
+// addOutbound adds an outbound peer, it is refused when the node has its
+// maximum of outbound peers
+func (m *PeerManager) addOutbound(p *peerConn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.count(false) >= m.maxOutbound {
+		return false
+	}
+
+	m.peers[p] = &peerState{addr: p.addr, connectedAt: time.Now()}
+	return true
+}
+
+// worstInbound returns the inbound peer with the highest misbehaviour
+// score, the newest first on ties, nil when no inbound peer misbehaved.
+// m.mu must be held.
+func (m *PeerManager) worstInbound() *peerConn {
+	var worst *peerConn
+	for p, state := range m.peers {
+		if !state.inbound || state.score == 0 {
+			continue
+		}
+
+		if worst == nil || state.score > m.peers[worst].score ||
+			state.score == m.peers[worst].score && state.connectedAt.After(m.peers[worst].connectedAt) {
+			worst = p
+		}
+	}
+
+	return worst
+}
+
+// count returns the number of inbound or outbound peers, m.mu must be held
+func (m *PeerManager) count(inbound bool) int {
+	n := 0
+	for _, state := range m.peers {
+		if state.inbound == inbound {
+			n++
+		}
+	}
+
+	return n
+}
+
+// remove forgets a disconnected peer
+func (m *PeerManager) remove(p *peerConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.peers, p)
+}
+
+// setVersion records the version of a peer and the address an inbound
+// peer listens on. It returns another open connection whose version had
+// the same nonce, to the same node, nil without one.
+func (m *PeerManager) setVersion(p *peerConn, v versionData) *peerConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.peers[p]
+	if !ok {
+		return nil
+	}
+
+	if state.addr == "" {
+		state.addr = v.AddrFrom
+	}
+	state.version, state.services, state.userAgent, state.bestHeight = v.Version, v.Services, v.UserAgent, v.BestHeight
+	state.nonce = v.Nonce
+
+	for other, otherState := range m.peers {
+		if other != p && otherState.version != 0 && otherState.nonce == v.Nonce && !other.closed() {
+			return other
+		}
+	}
+
+	return nil
+}
+
+// setIdentity records the identity a peer authenticated with
+func (m *PeerManager) setIdentity(p *peerConn, identity []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.peers[p]; ok {
+		state.identity = identity
+	}
+}
+
+// setFeeFilter records the minimum fee rate of the transactions relayed to a peer
+func (m *PeerManager) setFeeFilter(p *peerConn, rate float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.peers[p]; ok {
+		state.feeFilter = rate
+	}
+}
+
+// feeFilter returns the minimum fee rate of the transactions relayed to a peer
+func (m *PeerManager) feeFilter(p *peerConn) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.peers[p]; ok {
+		return state.feeFilter
+	}
+
+	return 0
+}
+
+// setSendHeaders records that a peer wants new blocks announced with their headers
+func (m *PeerManager) setSendHeaders(p *peerConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.peers[p]; ok {
+		state.sendHeaders = true
+	}
+}
+
+// sendsHeaders returns whether new blocks are announced to a peer with their headers
+func (m *PeerManager) sendsHeaders(p *peerConn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.peers[p]
+	return ok && state.sendHeaders
+}
+
+// setFilter loads the bloom filter of a peer, nil clears it
+func (m *PeerManager) setFilter(p *peerConn, filter *BloomFilter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.peers[p]; ok {
+		state.filter = filter
+	}
+}
+
+// addToFilter adds an item to the bloom filter of a peer, false when it
+// has none
+func (m *PeerManager) addToFilter(p *peerConn, data []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.peers[p]
+	if !ok || state.filter == nil {
+		return false
+	}
+
+	state.filter.Add(data)
+	return true
+}
+
+// matchesFilter returns whether a transaction matches the bloom filter of
+// a peer, true when it has none
+func (m *PeerManager) matchesFilter(p *peerConn, tx *Transaction) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.peers[p]
+	return !ok || state.filter == nil || state.filter.MatchTransaction(tx)
+}
+
+// startPing records a ping sent to a peer and returns whether it may be
+// sent, a peer is pinged again only after answering
+func (m *PeerManager) startPing(p *peerConn, nonce uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.peers[p]
+	if !ok || !state.pingSent.IsZero() {
+		return false
+	}
+
+	state.pingNonce, state.pingSent = nonce, time.Now()
+	return true
+}
+
+// finishPing records the pong of a peer and returns the round trip of its
+// ping, false when no ping of the nonce is waiting
+func (m *PeerManager) finishPing(p *peerConn, nonce uint64) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.peers[p]
+	if !ok || state.pingSent.IsZero() || state.pingNonce != nonce {
+		return 0, false
+	}
+
+	state.latency = time.Since(state.pingSent)
+	state.pingNonce, state.pingSent = 0, time.Time{}
+	return state.latency, true
+}
+
+// unansweredPings returns the peers which didn't answer a ping for longer
+// than timeout
+func (m *PeerManager) unansweredPings(timeout time.Duration) []*peerConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conns []*peerConn
+	for p, state := range m.peers {
+		if !state.pingSent.IsZero() && time.Since(state.pingSent) > timeout {
+			conns = append(conns, p)
+		}
+	}
+
+	return conns
+}
+
+// syncPeer returns the handshaken peer to download blocks from among those
+// with a chain longer than height, the lowest latency first and peers not
+// measured yet last, nil when no peer has a longer chain
+func (m *PeerManager) syncPeer(height int) *peerConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var best *peerConn
+	for p, state := range m.peers {
+		if !state.handshaken || state.bestHeight <= height {
+			continue
+		}
+
+		if best == nil || fasterThan(state, m.peers[best]) {
+			best = p
+		}
+	}
+
+	return best
+}
+
+// fasterThan returns whether a peer has a lower latency than another one,
+// a peer whose latency isn't measured yet is the slowest
+func fasterThan(a, b *peerState) bool {
+	if a.latency == 0 || b.latency == 0 {
+		return b.latency == 0 && a.latency != 0
+	}
+
+	return a.latency < b.latency
+}
+
+// lookup returns the peer listening on an address
+func (m *PeerManager) lookup(addr string) *peerConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for p, state := range m.peers {
+		if state.addr == addr {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// connected returns the connected peers
+func (m *PeerManager) connected() []*peerConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conns := make([]*peerConn, 0, len(m.peers))
+	for p := range m.peers {
+		conns = append(conns, p)
+	}
+
+	return conns
+}
+
+// setHandshaken records that a peer completed the handshake
+func (m *PeerManager) setHandshaken(p *peerConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.peers[p]; ok {
+		state.handshaken = true
+	}
+}
+
+// ready returns the connected peers which completed the handshake, those
+// blocks, transactions and addresses are relayed to
+func (m *PeerManager) ready() []*peerConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conns []*peerConn
+	for p, state := range m.peers {
+		if state.handshaken {
+			conns = append(conns, p)
+		}
+	}
+
+	return conns
+}
+
+// disconnect closes the connections to the peer listening on an address
+func (m *PeerManager) disconnect(addr string) {
+	for _, p := range m.connected() {
+		m.mu.Lock()
+		state, ok := m.peers[p]
+		match := ok && state.addr == addr
+		m.mu.Unlock()
+
+		if match {
+			p.close()
+		}
+	}
+}
+
+// misbehaving adds to the misbehaviour score of a peer and returns the
+// new score and the address the peer listens on
+func (m *PeerManager) misbehaving(p *peerConn, score int) (int, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.peers[p]
+	if !ok {
+		return 0, ""
+	}
+
+	state.score += score
+	return state.score, state.addr
+}
+
+// Inbound returns the number of inbound peers
+func (m *PeerManager) Inbound() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.count(true)
+}
+
+// Outbound returns the number of outbound peers
+func (m *PeerManager) Outbound() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.count(false)
+}
+
+// Peers returns the connected peers, the oldest first
+func (m *PeerManager) Peers() []PeerInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	infos := make([]PeerInfo, 0, len(m.peers))
+	for p, state := range m.peers {
+		addr := state.addr
+		if addr == "" {
+			addr = p.String()
+		}
+
+		infos = append(infos, PeerInfo{
+			Addr:        addr,
+			Inbound:     state.inbound,
+			ConnectedAt: state.connectedAt,
+			Score:       state.score,
+			Version:     state.version,
+			Services:    state.services,
+			UserAgent:   state.userAgent,
+			Latency:     state.latency,
+			Identity:    state.identity,
+			FeeFilter:   state.feeFilter,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+
+	return infos
+}
@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// NewPeerTLSConfig returns the TLS configuration of a node presenting the
+// certificate of certFile and keyFile. The certificates of the peers are
+// verified against the PEM bundle of caFile, the system roots when it is
+// empty. With mutual the inbound peers must present a certificate too,
+// e.g. for consortium deployments where only the member nodes may connect.
+func NewPeerTLSConfig(certFile, keyFile, caFile string, mutual bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		config.RootCAs, config.ClientCAs = pool, pool
+	}
+
+	if mutual {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// DialNode connects to a node, over TLS when config is set. The TLS
+// handshake is included in the timeout.
+func DialNode(addr string, timeout time.Duration, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		return net.DialTimeout(protocol, addr, timeout)
+	}
+
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, protocol, addr, config)
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// CommandPing ping, answered with CommandPong of the same nonce
+	CommandPing = "ping"
+
+	// CommandPong pong
+	CommandPong = "pong"
+
+	// pingInterval is how often the peers are pinged
+	pingInterval = 2 * time.Minute
+
+	// pingTimeout is how long a peer may take to answer a ping before it is
+	// disconnected
+	pingTimeout = time.Minute
+)
+
+type pingData struct {
+	Nonce uint64
+}
+
+type pongData struct {
+	Nonce uint64
+}
+
+// pingPeers disconnects the peers which didn't answer their ping within
+// pingTimeout and pings the others every pingInterval, until done is closed
+func (s *Server) pingPeers(done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			for _, p := range s.manager.unansweredPings(pingTimeout) {
+				log.Printf("Disconnecting %s, it didn't answer a ping within %s\n", p, pingTimeout)
+				p.close()
+			}
+
+			for _, p := range s.manager.ready() {
+				s.ping(p)
+			}
+		}
+	}
+}
+
+// ping sends a ping of a new nonce to a peer, unless it didn't answer the
+// previous one yet
+func (s *Server) ping(p *peerConn) {
+	nonce := newNonce()
+	if s.manager.startPing(p, nonce) {
+		p.send(CommandPing, pingData{Nonce: nonce})
+	}
+}
+
+// handlePing handles CommandPing request by sending the nonce back
+func (s *Server) handlePing(p *peerConn, request []byte) {
+	var payload pingData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	p.send(CommandPong, pongData{Nonce: payload.Nonce})
+}
+
+// handlePong handles CommandPong request, the round trip of the ping of
+// the nonce becomes the latency of the peer. Pongs of other nonces are
+// ignored.
+func (s *Server) handlePong(p *peerConn, request []byte) {
+	var payload pongData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	if latency, ok := s.manager.finishPing(p, payload.Nonce); ok {
+		log.Printf("Latency of %s is %s\n", p, latency)
+	}
+}
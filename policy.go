@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxReplacedTxs is the maximum number of mempool transactions a replacement may evict
+const maxReplacedTxs = 100
+
+var (
+	// ErrFeeTooLow is returned for a transaction paying less than the minimum relay fee rate
+	ErrFeeTooLow = errors.New("fee is below the minimum relay fee")
+
+	// ErrReplacementRejected is returned for a transaction which can't replace the mempool transactions it conflicts with
+	ErrReplacementRejected = errors.New("replacement rejected")
+
+	// ErrInvalidPolicy is returned when setting an inconsistent policy
+	ErrInvalidPolicy = errors.New("invalid policy")
+)
+
+// Policy is what a node relays and mines beyond the consensus rules, each
+// node chooses its own
+type Policy struct {
+	// MinRelayFeeRate is the minimum fee per byte of relayed transactions
+	MinRelayFeeRate float64
+
+	// DustThreshold is the minimum value of a relayed spendable output
+	DustThreshold int
+
+	// MaxStandardTxSize is the maximum serialized size of a relayed transaction, zero for no limit
+	MaxStandardTxSize int
+
+	// MaxStandardScriptSigSize is the maximum unlocking script size of a relayed input, zero for no limit
+	MaxStandardScriptSigSize int
+
+	// MaxBlockSize is the maximum size of the transactions of mined blocks,
+	// the consensus maximum when zero
+	MaxBlockSize int
+
+	// ReplaceByFee lets a transaction paying more fees replace the mempool
+	// transactions spending the same outputs
+	ReplaceByFee bool
+}
+
+// DefaultPolicy is the policy of a new Mempool
+var DefaultPolicy = Policy{
+	DustThreshold:            1,
+	MaxStandardTxSize:        100000,
+	MaxStandardScriptSigSize: 1650,
+}
+
+// validate checks the policy is consistent with the chain parameters
+func (p *Policy) validate(params *ChainParams) error {
+	if p.MinRelayFeeRate < 0 || p.DustThreshold < 0 || p.MaxStandardTxSize < 0 || p.MaxStandardScriptSigSize < 0 || p.MaxBlockSize < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidPolicy)
+	}
+
+	if p.MaxBlockSize > params.MaxBlockSize {
+		return fmt.Errorf("%w: block size %d above the consensus maximum %d", ErrInvalidPolicy, p.MaxBlockSize, params.MaxBlockSize)
+	}
+
+	return nil
+}
+
+// blockSize returns the maximum size of the transactions of mined blocks
+func (p *Policy) blockSize(params *ChainParams) int {
+	if p.MaxBlockSize == 0 {
+		return params.MaxBlockSize
+	}
+
+	return p.MaxBlockSize
+}
+
+// checkReplacement checks a transaction pays for replacing the mempool
+// transactions it conflicts with and their descendants: its fee rate beats
+// each conflicting transaction and its fee covers the replaced fees plus
+// its own relay at the minimum fee rate
+func checkReplacement(id string, fee, size int, conflicts map[string]*MempoolEntry, replaced map[string]*MempoolEntry, policy *Policy) error {
+	if len(replaced) > maxReplacedTxs {
+		return fmt.Errorf("%w: %s would evict %d transactions, max %d", ErrReplacementRejected, id, len(replaced), maxReplacedTxs)
+	}
+
+	rate := feeRate(fee, size)
+	for conflictID, entry := range conflicts {
+		if rate <= entry.FeeRate() {
+			return fmt.Errorf("%w: %s fee rate %.3f doesn't beat %.3f of %s", ErrReplacementRejected, id, rate, entry.FeeRate(), conflictID)
+		}
+	}
+
+	replacedFees := 0
+	for _, entry := range replaced {
+		replacedFees += entry.Fee
+	}
+
+	if minFee := float64(replacedFees) + policy.MinRelayFeeRate*float64(size); fee <= replacedFees || float64(fee) < minFee {
+		return fmt.Errorf("%w: %s pays %d, replaced transactions pay %d", ErrReplacementRejected, id, fee, replacedFees)
+	}
+
+	return nil
+}
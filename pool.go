@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrShareAboveTarget is returned when a share doesn't meet the share difficulty
+var ErrShareAboveTarget = errors.New("share is above the share target")
+
+// Payout is an amount paid to a pool participant
+type Payout struct {
+	Address string
+	Value   int
+}
+
+// CheckShare validates a share, a block template mined by a pool participant
+// at a share difficulty lower than the network's. isBlock reports whether the
+// share also meets the network difficulty and can be submitted as a block.
+func CheckShare(block *Block, networkBits, shareBits int) (isBlock bool, err error) {
+	pow := NewProofOfWorkWithBits(block, networkBits)
+
+	if !pow.ValidateAt(shareBits) {
+		return false, fmt.Errorf("%w: share %x at difficulty %d", ErrShareAboveTarget, block.Hash, shareBits)
+	}
+
+	return pow.Validate(), nil
+}
+
+// SplitReward splits a reward proportionally to the shares found by each
+// address, the remainder of the division goes to the addresses with the
+// most shares
+func SplitReward(reward int, shares map[string]int) []Payout {
+	total := 0
+	addresses := make([]string, 0, len(shares))
+	for address, n := range shares {
+		if n > 0 {
+			total += n
+			addresses = append(addresses, address)
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		if shares[addresses[i]] != shares[addresses[j]] {
+			return shares[addresses[i]] > shares[addresses[j]]
+		}
+
+		return addresses[i] < addresses[j]
+	})
+
+	payouts := make([]Payout, len(addresses))
+	paid := 0
+	for i, address := range addresses {
+		payouts[i] = Payout{Address: address, Value: reward * shares[address] / total}
+		paid += payouts[i].Value
+	}
+
+	for i := 0; paid < reward; i = (i + 1) % len(payouts) {
+		payouts[i].Value++
+		paid++
+	}
+
+	var nonEmpty []Payout
+	for _, payout := range payouts {
+		if payout.Value > 0 {
+			nonEmpty = append(nonEmpty, payout)
+		}
+	}
+
+	return nonEmpty
+}
+
+// NewPayoutCoinbaseTX creates a coinbase transaction paying the participants
+// of a pool directly, its outputs must not exceed subsidy plus fees
+func NewPayoutCoinbaseTX(payouts []Payout, data string) (*Transaction, error) {
+	if len(payouts) == 0 {
+		return nil, errors.New("coinbase needs at least one payout")
+	}
+
+	for _, payout := range payouts {
+		if payout.Value <= 0 {
+			return nil, fmt.Errorf("invalid payout of %d to %s", payout.Value, payout.Address)
+		}
+
+		if _, _, err := decodeAddress(payout.Address); err != nil {
+			return nil, fmt.Errorf("invalid payout of %d: %w", payout.Value, err)
+		}
+	}
+
+	tx := newCoinbaseTX(payouts[0].Address, data, payouts[0].Value)
+	for _, payout := range payouts[1:] {
+		tx.VOut = append(tx.VOut, *NewTXOutput(payout.Value, payout.Address))
+	}
+	tx.ID = tx.Hash()
+
+	return tx, nil
+}
@@ -0,0 +1,562 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPortMappingLifetime is the lifetime of the port mappings, they
+	// are renewed at half of it
+	DefaultPortMappingLifetime = time.Hour
+
+	// DefaultPortMappingTimeout is how long DiscoverPortMapper waits for
+	// each protocol
+	DefaultPortMappingTimeout = 3 * time.Second
+
+	// portMappingDescription names the mappings in the router
+	portMappingDescription = "blockchain node"
+
+	// natPMPPort is the port of the NAT-PMP gateways
+	natPMPPort = 5351
+
+	// natPMPInitialWait is the first retransmission delay of NAT-PMP
+	// requests, doubled on every retry
+	natPMPInitialWait = 250 * time.Millisecond
+
+	// NAT-PMP opcodes
+	natPMPOpExternalAddress = 0
+	natPMPOpMapTCP          = 2
+
+	// ssdpAddress is the multicast address of the UPnP discovery
+	ssdpAddress = "239.255.255.250:1900"
+
+	// upnpMaxResponse is the largest UPnP description or SOAP response read
+	upnpMaxResponse = 1 << 20
+)
+
+var (
+	// ErrNoGateway is returned when the default gateway isn't known
+	ErrNoGateway = errors.New("no default gateway")
+
+	// ErrNoPortMapper is returned when no router answers NAT-PMP or UPnP
+	ErrNoPortMapper = errors.New("no port mapping router")
+
+	// ErrPortMapping is returned when a router refuses a port mapping request
+	ErrPortMapping = errors.New("port mapping failed")
+)
+
+// upnpServiceTypes are the UPnP services mapping ports, in order of
+// preference
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// PortMapper maps TCP ports of a router to the node, so a node behind a
+// NAT accepts inbound peers
+type PortMapper interface {
+	// ExternalIP returns the public address of the router
+	ExternalIP() (net.IP, error)
+
+	// MapPort maps a port of the router, externalPort if it is free, to
+	// the internal port of the node for lifetime and returns the port
+	// mapped
+	MapPort(internalPort, externalPort int, lifetime time.Duration) (int, error)
+
+	// UnmapPort deletes a mapping
+	UnmapPort(internalPort, externalPort int) error
+}
+
+// DiscoverPortMapper returns the router of the node, asked with NAT-PMP
+// first and UPnP then. It takes up to timeout for each.
+func DiscoverPortMapper(timeout time.Duration) (PortMapper, error) {
+	if gateway, err := defaultGateway(); err == nil {
+		pmp := NewNATPMPMapper(gateway, timeout)
+		if _, err = pmp.ExternalIP(); err == nil {
+			return pmp, nil
+		}
+	}
+
+	return discoverUPnP(timeout)
+}
+
+// NATPMPMapper maps ports with the NAT-PMP protocol of RFC 6886
+type NATPMPMapper struct {
+	gateway net.IP
+	timeout time.Duration
+}
+
+// NewNATPMPMapper creates the mapper of a gateway, requests are retried
+// until timeout
+func NewNATPMPMapper(gateway net.IP, timeout time.Duration) *NATPMPMapper {
+	return &NATPMPMapper{gateway: gateway, timeout: timeout}
+}
+
+// ExternalIP returns the public address of the gateway
+func (m *NATPMPMapper) ExternalIP() (net.IP, error) {
+	response, err := m.request([]byte{0, natPMPOpExternalAddress}, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.IPv4(response[8], response[9], response[10], response[11]), nil
+}
+
+// MapPort maps a TCP port of the gateway to the internal port
+func (m *NATPMPMapper) MapPort(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	request := make([]byte, 12)
+	request[1] = natPMPOpMapTCP
+	binary.BigEndian.PutUint16(request[4:], uint16(internalPort))
+	binary.BigEndian.PutUint16(request[6:], uint16(externalPort))
+	binary.BigEndian.PutUint32(request[8:], uint32(lifetime/time.Second))
+
+	response, err := m.request(request, 16)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(binary.BigEndian.Uint16(response[10:])), nil
+}
+
+// UnmapPort deletes the mapping of the internal port, a request of zero
+// lifetime
+func (m *NATPMPMapper) UnmapPort(internalPort, _ int) error {
+	_, err := m.MapPort(internalPort, 0, 0)
+	return err
+}
+
+// request sends a request to the gateway and returns its response of at
+// least size bytes, it is resent with doubling delays until the timeout
+func (m *NATPMPMapper) request(request []byte, size int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: m.gateway, Port: natPMPPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(m.timeout)
+	response := make([]byte, 16)
+	for wait := natPMPInitialWait; ; wait *= 2 {
+		if _, err = conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		next := time.Now().Add(wait)
+		if next.After(deadline) {
+			next = deadline
+		}
+		if err = conn.SetReadDeadline(next); err != nil {
+			return nil, err
+		}
+
+		n, err := conn.Read(response)
+		var netErr net.Error
+		switch {
+		case err == nil && n >= size && response[0] == 0 && response[1] == request[1]|0x80:
+			if code := binary.BigEndian.Uint16(response[2:]); code != 0 {
+				return nil, fmt.Errorf("%w: NAT-PMP result code %d from %s", ErrPortMapping, code, m.gateway)
+			}
+			return response[:n], nil
+		case err != nil && !(errors.As(err, &netErr) && netErr.Timeout()):
+			return nil, err
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: no NAT-PMP answer from %s", ErrNoPortMapper, m.gateway)
+		}
+	}
+}
+
+// upnpMapper maps ports with the WAN connection service of a UPnP internet
+// gateway device
+type upnpMapper struct {
+	client      *http.Client
+	controlURL  string
+	serviceType string
+
+	// internalIP is the address of the node on the network of the gateway
+	internalIP string
+}
+
+// upnpDevice is a device of a UPnP description, with its embedded devices
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// service returns the service of a type of the device or its embedded
+// devices
+func (d upnpDevice) service(serviceType string) (upnpService, bool) {
+	for _, service := range d.Services {
+		if service.ServiceType == serviceType {
+			return service, true
+		}
+	}
+
+	for _, device := range d.Devices {
+		if service, ok := device.service(serviceType); ok {
+			return service, true
+		}
+	}
+
+	return upnpService{}, false
+}
+
+// discoverUPnP searches the gateway devices with SSDP and returns the
+// mapper of the first one with a WAN connection service
+func discoverUPnP(timeout time.Duration) (*upnpMapper, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ssdp, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	search := "M-SEARCH * HTTP/1.1\r\nHOST: " + ssdpAddress + "\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n"
+	if _, err = conn.WriteTo([]byte(search), ssdp); err != nil {
+		return nil, err
+	}
+
+	if err = conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("%w: no UPnP gateway answered", ErrNoPortMapper)
+		} else if err != nil {
+			return nil, err
+		}
+
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := response.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+
+		mapper, err := newUPnPMapper(location, timeout)
+		if err != nil {
+			log.Printf("UPnP gateway %s skipped: %s\n", location, err)
+			continue
+		}
+
+		return mapper, nil
+	}
+}
+
+// newUPnPMapper creates the mapper of the gateway described at location
+func newUPnPMapper(location string, timeout time.Duration) (*upnpMapper, error) {
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("description request failed with %s", response.Status)
+	}
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err = xml.NewDecoder(io.LimitReader(response.Body, upnpMaxResponse)).Decode(&root); err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, serviceType := range upnpServiceTypes {
+		service, ok := root.Device.service(serviceType)
+		if !ok {
+			continue
+		}
+
+		control, err := base.Parse(service.ControlURL)
+		if err != nil {
+			return nil, err
+		}
+
+		internalIP, err := localAddressTo(control.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		return &upnpMapper{client: client, controlURL: control.String(), serviceType: serviceType, internalIP: internalIP}, nil
+	}
+
+	return nil, fmt.Errorf("%w: no WAN connection service", ErrNoPortMapper)
+}
+
+// localAddressTo returns the local address routing to a host:port, no
+// packet is sent
+func localAddressTo(hostport string) (string, error) {
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(hostport, "80")
+	}
+
+	conn, err := net.Dial("udp4", hostport)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// ExternalIP returns the public address of the gateway
+func (m *upnpMapper) ExternalIP() (net.IP, error) {
+	response, err := m.call("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(soapValue(response, "NewExternalIPAddress"))
+	if ip == nil {
+		return nil, fmt.Errorf("%w: invalid external address from UPnP gateway", ErrPortMapping)
+	}
+
+	return ip, nil
+}
+
+// MapPort maps a TCP port of the gateway to the internal port, the
+// external port is the internal one when zero
+func (m *upnpMapper) MapPort(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	if externalPort == 0 {
+		externalPort = internalPort
+	}
+
+	_, err := m.call("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", "TCP"},
+		{"NewInternalPort", strconv.Itoa(internalPort)},
+		{"NewInternalClient", m.internalIP},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", portMappingDescription},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return externalPort, nil
+}
+
+// UnmapPort deletes the mapping of the external port
+func (m *upnpMapper) UnmapPort(_, externalPort int) error {
+	_, err := m.call("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", "TCP"},
+	})
+
+	return err
+}
+
+// call invokes a SOAP action of the service with its ordered arguments and
+// returns the response body
+func (m *upnpMapper) call(action string, args [][2]string) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, m.serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		if err := xml.EscapeText(&body, []byte(arg[1])); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	request, err := http.NewRequest(http.MethodPost, m.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, m.serviceType, action))
+
+	response, err := m.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(response.Body, upnpMaxResponse))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: UPnP %s answered %s: %s", ErrPortMapping, action, response.Status, soapValue(raw, "errorDescription"))
+	}
+
+	return raw, nil
+}
+
+// soapValue returns the text of the first element of a name in a SOAP
+// response
+func soapValue(raw []byte, name string) string {
+	decoder := xml.NewDecoder(bytes.NewReader(raw))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == name {
+			var value string
+			if err = decoder.DecodeElement(&value, &start); err != nil {
+				return ""
+			}
+
+			return strings.TrimSpace(value)
+		}
+	}
+}
+
+// portMapping is a port of the router mapped to the advertised listener
+type portMapping struct {
+	mapper   PortMapper
+	internal int
+	external int
+}
+
+// mapPort maps a port of the router to the advertised listener, the
+// external address of the router becomes the advertised address unless
+// ServerConfig.ExternalAddress is set. A failure is logged, the node then
+// relies on its outbound peers.
+func (s *Server) mapPort(lns []net.Listener) {
+	internal := listenerPort(s.cfg.Listeners, lns)
+	if internal == 0 {
+		log.Println("Port mapping skipped, the node has no TCP listener")
+		return
+	}
+
+	mapper := s.cfg.PortMapper
+	if mapper == nil {
+		var err error
+		if mapper, err = DiscoverPortMapper(DefaultPortMappingTimeout); err != nil {
+			log.Printf("Port mapping failed: %s\n", err)
+			return
+		}
+	}
+
+	ip, err := mapper.ExternalIP()
+	if err != nil {
+		log.Printf("Port mapping failed: %s\n", err)
+		return
+	}
+
+	external, err := mapper.MapPort(internal, internal, DefaultPortMappingLifetime)
+	if err != nil {
+		log.Printf("Port mapping failed: %s\n", err)
+		return
+	}
+
+	s.portMapping = &portMapping{mapper: mapper, internal: internal, external: external}
+	log.Printf("Mapped port %d of the router %s to %d\n", external, ip, internal)
+
+	if s.advertiseMapping {
+		s.address = net.JoinHostPort(ip.String(), strconv.Itoa(external))
+	}
+}
+
+// listenerPort returns the port of the first TCP listener, 0 without one
+func listenerPort(configs []ListenerConfig, lns []net.Listener) int {
+	for i, config := range configs {
+		if config.Network == "unix" || config.Network == "ws" || i >= len(lns) {
+			continue
+		}
+
+		if addr, ok := lns[i].Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+
+		_, port, err := net.SplitHostPort(config.Address)
+		if err != nil {
+			return 0
+		}
+		p, _ := strconv.Atoi(port)
+		return p
+	}
+
+	return 0
+}
+
+// renewPortMapping renews the port mapping at half of its lifetime until
+// done is closed
+func (s *Server) renewPortMapping(done <-chan struct{}) {
+	ticker := time.NewTicker(DefaultPortMappingLifetime / 2)
+	defer ticker.Stop()
+
+	m := s.portMapping
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			external, err := m.mapper.MapPort(m.internal, m.external, DefaultPortMappingLifetime)
+			if err != nil {
+				log.Printf("Renewing the port mapping failed: %s\n", err)
+				continue
+			}
+
+			if external != m.external {
+				log.Printf("The router moved the mapping of port %d from %d to %d\n", m.internal, m.external, external)
+				m.external = external
+			}
+		}
+	}
+}
+
+// unmapPort deletes the port mapping, once the loops are stopped
+func (s *Server) unmapPort(m *portMapping) {
+	if m == nil {
+		return
+	}
+
+	if err := m.mapper.UnmapPort(m.internal, m.external); err != nil {
+		log.Printf("Deleting the port mapping failed: %s\n", err)
+	}
+}
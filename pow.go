@@ -19,23 +19,46 @@ const targetBits = 16
 type ProofOfWork struct {
 	block  *Block
 	target *big.Int
+	bits   int
+
+	// txHash caches the Merkle root of the block's transactions
+	txHash []byte
 }
 
 // NewProofOfWork builds and returns a ProofOfWork
 func NewProofOfWork(b *Block) *ProofOfWork {
+	return NewProofOfWorkWithBits(b, targetBits)
+}
+
+// NewProofOfWorkWithBits builds and returns a ProofOfWork at a difficulty
+func NewProofOfWorkWithBits(b *Block, bits int) *ProofOfWork {
+	return &ProofOfWork{block: b, target: bitsToTarget(bits), bits: bits}
+}
+
+// bitsToTarget returns the target a hash must be below for a difficulty
+func bitsToTarget(bits int) *big.Int {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target.Lsh(target, uint(256-bits))
 
-	return &ProofOfWork{block: b, target: target}
+	return target
 }
 
+// prepareData returns the header preimage: the previous block hash, the
+// merkle root and the UTXO commitment followed by the height, the
+// timestamp, the bits and the nonce as big endian int64s
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	if pow.txHash == nil {
+		pow.txHash = pow.block.HashTransactions()
+	}
+
 	data := bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
+			pow.txHash,
+			pow.block.UTXOCommitment,
+			IntToHex(int64(pow.block.Height)),
 			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
+			IntToHex(int64(pow.bits)),
 			IntToHex(int64(nonce)),
 		},
 		[]byte{},
@@ -74,11 +97,22 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 
 // Validate validates block's proof of work
 func (pow *ProofOfWork) Validate() bool {
+	return pow.ValidateAt(pow.bits)
+}
+
+// ValidateAt validates block's proof of work against the target of another
+// difficulty, e.g. a lower share difficulty of a mining pool
+func (pow *ProofOfWork) ValidateAt(bits int) bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	hash := pow.Hash(pow.block.Nonce)
+	hashInt.SetBytes(hash)
+
+	return hashInt.Cmp(bitsToTarget(bits)) == -1 && bytes.Equal(hash, pow.block.Hash)
+}
 
-	return hashInt.Cmp(pow.target) == -1
+// Hash returns the block hash for a nonce
+func (pow *ProofOfWork) Hash(nonce int) []byte {
+	hash := sha256.Sum256(pow.prepareData(nonce))
+	return hash[:]
 }
@@ -0,0 +1,378 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var (
+	// ErrPartialTxMismatch is returned when combining partially signed transactions of different transactions
+	ErrPartialTxMismatch = errors.New("partially signed transactions differ")
+
+	// ErrIncompleteTx is returned when finalizing a transaction missing signatures
+	ErrIncompleteTx = errors.New("transaction is not fully signed")
+)
+
+// PartialInput is what the signers of an input need and what they collected
+type PartialInput struct {
+	// PrevOut is the output spent by the input
+	PrevOut TXOutput
+
+	// RedeemScript is the redeem script of a pay to script hash output
+	RedeemScript []byte
+
+	// Signatures are the signatures followed by their hash type, by hex
+	// encoded public key
+	Signatures map[string][]byte
+
+	// HashType is the hash type of the signatures, SigHashAll when zero
+	HashType SigHashType
+}
+
+// PartiallySignedTx is an unsigned transaction carried between machines to
+// collect the signatures of its inputs, e.g. from an offline signer or the
+// parties of a multisig. Each party signs its copy, the copies are combined
+// and the transaction is finalized once every input has its signatures.
+type PartiallySignedTx struct {
+	Tx     *Transaction
+	Inputs []PartialInput
+
+	// Curve is the curve of the keys of the chain, signatures of keys of
+	// the other curve don't count
+	Curve KeyCurve
+}
+
+// NewPartiallySignedTx creates a PartiallySignedTx of a transaction spending
+// outputs of the previous transactions on a chain with keys on curve, its
+// unlocking scripts are dropped
+func NewPartiallySignedTx(tx *Transaction, prevTXs map[string]Transaction, curve KeyCurve) (*PartiallySignedTx, error) {
+	if tx.IsCoinbase() {
+		return nil, errors.New("coinbase transactions aren't signed")
+	}
+
+	unsigned := tx.TrimmedCopy()
+	unsigned.ID = unsigned.Hash()
+
+	p := &PartiallySignedTx{Tx: &unsigned, Inputs: make([]PartialInput, len(tx.VIn)), Curve: curve}
+	for i, vin := range tx.VIn {
+		prevTX, ok := prevTXs[hex.EncodeToString(vin.TxID)]
+		if !ok || vin.VOut < 0 || vin.VOut >= len(prevTX.VOut) {
+			return nil, fmt.Errorf("input %d spends unknown output %x:%d", i, vin.TxID, vin.VOut)
+		}
+
+		p.Inputs[i] = PartialInput{PrevOut: prevTX.VOut[vin.VOut], Signatures: make(map[string][]byte)}
+	}
+
+	return p, nil
+}
+
+// Serialize encodes the partially signed transaction
+func (p *PartiallySignedTx) Serialize() []byte {
+	var buff bytes.Buffer
+
+	if err := gob.NewEncoder(&buff).Encode(p); err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializePartiallySignedTx decodes a partially signed transaction
+func DeserializePartiallySignedTx(data []byte) (*PartiallySignedTx, error) {
+	var p PartiallySignedTx
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return nil, err
+	}
+
+	if p.Tx == nil || len(p.Inputs) != len(p.Tx.VIn) {
+		return nil, errors.New("partially signed transaction doesn't describe each input")
+	}
+
+	for i := range p.Inputs {
+		if p.Inputs[i].Signatures == nil {
+			p.Inputs[i].Signatures = make(map[string][]byte)
+		}
+	}
+
+	return &p, nil
+}
+
+// EncodeHex encodes the partially signed transaction as hex, to be copied
+// to and from an offline machine
+func (p *PartiallySignedTx) EncodeHex() string {
+	return hex.EncodeToString(p.Serialize())
+}
+
+// DecodePartiallySignedTxHex decodes a partially signed transaction encoded by EncodeHex
+func DecodePartiallySignedTxHex(s string) (*PartiallySignedTx, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+
+	return DeserializePartiallySignedTx(data)
+}
+
+// PrevOutputs returns the outputs spent by each input
+func (p *PartiallySignedTx) PrevOutputs() []TXOutput {
+	prevOuts := make([]TXOutput, len(p.Inputs))
+	for i, in := range p.Inputs {
+		prevOuts[i] = in.PrevOut
+	}
+
+	return prevOuts
+}
+
+// Fee returns the difference between the values of the spent outputs and the outputs
+func (p *PartiallySignedTx) Fee() int {
+	fee := 0
+	for _, in := range p.Inputs {
+		fee += in.PrevOut.Value
+	}
+
+	return fee - p.Tx.OutputValue()
+}
+
+// AddRedeemScript sets the redeem script of the inputs spending pay to
+// script hash outputs of it and returns how many there are
+func (p *PartiallySignedTx) AddRedeemScript(redeemScript []byte) int {
+	scriptHash := HashPubKey(redeemScript)
+
+	added := 0
+	for i := range p.Inputs {
+		if bytes.Equal(ExtractScriptHash(p.Inputs[i].PrevOut.ScriptPubKey), scriptHash) {
+			p.Inputs[i].RedeemScript = append([]byte{}, redeemScript...)
+			added++
+		}
+	}
+
+	return added
+}
+
+// SetHashType sets the hash type the signatures of an input commit to
+func (p *PartiallySignedTx) SetHashType(index int, hashType SigHashType) error {
+	if index < 0 || index >= len(p.Inputs) {
+		return fmt.Errorf("no input %d", index)
+	}
+
+	if err := p.Tx.checkHashType(index, hashType); err != nil {
+		return err
+	}
+
+	p.Inputs[index].HashType = hashType
+	return nil
+}
+
+// script returns the script the signatures of an input commit to, the
+// redeem script of pay to script hash outputs
+func (in *PartialInput) script() []byte {
+	if ExtractScriptHash(in.PrevOut.ScriptPubKey) != nil {
+		return in.RedeemScript
+	}
+
+	return in.PrevOut.ScriptPubKey
+}
+
+// hashType returns the hash type of the signatures of the input
+func (in *PartialInput) hashType() SigHashType {
+	if in.HashType == 0 {
+		return SigHashAll
+	}
+
+	return in.HashType
+}
+
+// canSign returns whether a public key signs for an input
+func (in *PartialInput) canSign(pubKey []byte) bool {
+	script := in.script()
+	if pubKeyHash := ExtractPubKeyHash(script); pubKeyHash != nil {
+		return bytes.Equal(pubKeyHash, HashPubKey(pubKey))
+	}
+
+	if _, pubKeys, ok := ExtractMultiSig(script); ok {
+		for _, key := range pubKeys {
+			if bytes.Equal(key, pubKey) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Sign adds the signatures of the keys of the signer to the inputs it can
+// sign and returns how many it signed. Pay to script hash inputs are signed
+// once their redeem script is added. The signer trusts the spent outputs of
+// the inputs to be those of the transaction.
+func (p *PartiallySignedTx) Sign(signer Signer) (int, error) {
+	signed := 0
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		script := in.script()
+
+		addresses := []string{}
+		if pubKeyHash := ExtractPubKeyHash(script); pubKeyHash != nil {
+			addresses = append(addresses, string(encodeAddress(version, pubKeyHash)))
+		} else if _, pubKeys, ok := ExtractMultiSig(script); ok {
+			for _, pubKey := range pubKeys {
+				addresses = append(addresses, keyAddress(pubKey))
+			}
+		}
+
+		for _, address := range addresses {
+			pubKey, err := signerKey(signer, address)
+			if err != nil {
+				return signed, err
+			}
+			if pubKey == nil || !in.canSign(pubKey) {
+				continue
+			}
+
+			sig, err := p.Tx.signInput(signer, address, pubKey, i, script, in.hashType())
+			if err != nil {
+				return signed, err
+			}
+			in.Signatures[hex.EncodeToString(pubKey)] = sig
+			signed++
+		}
+	}
+
+	return signed, nil
+}
+
+// SignKeystore signs the inputs with the keys of the keystore and returns
+// how many signatures were added, the keys of multisig inputs are looked up
+// by the hash of their public key
+func (p *PartiallySignedTx) SignKeystore(keystore Keystore) int {
+	signed, err := p.Sign(KeystoreSigner(keystore))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return signed
+}
+
+// Combine adds the redeem scripts and signatures collected by the other
+// copies of the transaction
+func (p *PartiallySignedTx) Combine(others ...*PartiallySignedTx) error {
+	for _, other := range others {
+		if !bytes.Equal(other.Tx.Hash(), p.Tx.Hash()) || len(other.Inputs) != len(p.Inputs) || other.Curve != p.Curve {
+			return fmt.Errorf("%w: %x and %x", ErrPartialTxMismatch, p.Tx.ID, other.Tx.ID)
+		}
+
+		for i := range p.Inputs {
+			in, otherIn := &p.Inputs[i], other.Inputs[i]
+			if !bytes.Equal(in.PrevOut.ScriptPubKey, otherIn.PrevOut.ScriptPubKey) || in.PrevOut.Value != otherIn.PrevOut.Value {
+				return fmt.Errorf("%w: input %d spends different outputs", ErrPartialTxMismatch, i)
+			}
+
+			if in.RedeemScript == nil {
+				in.RedeemScript = otherIn.RedeemScript
+			}
+
+			if in.HashType == 0 {
+				in.HashType = otherIn.HashType
+			}
+
+			for pubKey, sig := range otherIn.Signatures {
+				if _, ok := in.Signatures[pubKey]; !ok {
+					in.Signatures[pubKey] = sig
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// scriptSig returns the unlocking script of an input from its signatures,
+// or false when signatures are missing
+func (p *PartiallySignedTx) scriptSig(index int) ([]byte, bool) {
+	in := &p.Inputs[index]
+	script := in.script()
+	if script == nil {
+		return nil, false
+	}
+
+	checker := &txSigChecker{tx: p.Tx, index: index, curve: p.Curve}
+	builder := NewScriptBuilder()
+
+	if pubKeyHash := ExtractPubKeyHash(script); pubKeyHash != nil {
+		found := false
+		for id, sig := range in.Signatures {
+			pubKey, err := hex.DecodeString(id)
+			if err == nil && bytes.Equal(HashPubKey(pubKey), pubKeyHash) && checker.checkSig(sig, pubKey, script) {
+				builder.AddData(sig).AddData(pubKey)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, false
+		}
+	} else if required, pubKeys, ok := ExtractMultiSig(script); ok {
+		builder.AddOp(Op0)
+
+		count := 0
+		for _, pubKey := range pubKeys {
+			sig, ok := in.Signatures[hex.EncodeToString(pubKey)]
+			if ok && count < required && checker.checkSig(sig, pubKey, script) {
+				builder.AddData(sig)
+				count++
+			}
+		}
+
+		if count < required {
+			return nil, false
+		}
+	} else {
+		return nil, false
+	}
+
+	if ExtractScriptHash(in.PrevOut.ScriptPubKey) != nil {
+		builder.AddData(script)
+	}
+
+	return builder.Script(), true
+}
+
+// IsComplete returns whether every input has the signatures it needs
+func (p *PartiallySignedTx) IsComplete() bool {
+	for i := range p.Inputs {
+		if _, ok := p.scriptSig(i); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Finalize returns the signed transaction, the unlocking scripts are built
+// from the collected signatures and verified against the spent outputs
+func (p *PartiallySignedTx) Finalize() (*Transaction, error) {
+	tx := p.Tx.TrimmedCopy()
+
+	for i := range p.Inputs {
+		scriptSig, ok := p.scriptSig(i)
+		if !ok {
+			return nil, fmt.Errorf("%w: input %d is missing signatures", ErrIncompleteTx, i)
+		}
+
+		tx.VIn[i].ScriptSig = scriptSig
+	}
+
+	tx.ID = tx.Hash()
+	for i, vin := range tx.VIn {
+		if err := VerifyScript(vin.ScriptSig, p.Inputs[i].PrevOut.ScriptPubKey, &txSigChecker{tx: &tx, index: i, curve: p.Curve}); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+
+	return &tx, nil
+}
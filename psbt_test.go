@@ -0,0 +1,299 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// psbtParties are the keys of a test partially signed transaction, a owns
+// its pay to public key hash input and the three share its multisig inputs
+type psbtParties struct {
+	a, b, c *Wallet
+	redeem  []byte
+}
+
+// newTestPSBT returns a partially signed transaction spending a pay to
+// public key hash output, a bare 2 of 3 multisig output and a pay to
+// script hash 2 of 3 multisig output, with a fee of 3
+func newTestPSBT(t *testing.T) (*PartiallySignedTx, psbtParties) {
+	t.Helper()
+
+	parties := psbtParties{a: NewWallet(), b: NewWallet(), c: NewWallet()}
+	redeem, err := MultiSigScript(2, [][]byte{parties.a.PublicKey, parties.b.PublicKey, parties.c.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parties.redeem = redeem
+
+	prevTX := &Transaction{
+		VIn: []TXInput{{TxID: bytes.Repeat([]byte{1}, 32), Sequence: SequenceFinal}},
+		VOut: []TXOutput{
+			*NewTXOutput(5, string(parties.a.GetAddress())),
+			{Value: 6, ScriptPubKey: redeem},
+			{Value: 7, ScriptPubKey: PayToScriptHashScript(HashPubKey(redeem))},
+		},
+	}
+	prevTX.ID = prevTX.Hash()
+
+	tx := &Transaction{VOut: []TXOutput{*NewTXOutput(15, string(NewWallet().GetAddress()))}}
+	for i := range prevTX.VOut {
+		tx.VIn = append(tx.VIn, TXInput{TxID: prevTX.ID, VOut: i, Sequence: SequenceFinal})
+	}
+	tx.ID = tx.Hash()
+
+	p, err := NewPartiallySignedTx(tx, map[string]Transaction{hex.EncodeToString(prevTX.ID): *prevTX}, CurveP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, parties
+}
+
+// copyPSBT returns a copy of a partially signed transaction through its hex encoding
+func copyPSBT(t *testing.T, p *PartiallySignedTx) *PartiallySignedTx {
+	t.Helper()
+
+	c, err := DecodePartiallySignedTxHex(p.EncodeHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestPartiallySignedTx(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error
+		complete bool
+		err      error
+	}{
+		{
+			"every party signs one copy",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				p.AddRedeemScript(parties.redeem)
+				p.SignKeystore(parties.a)
+				p.SignKeystore(parties.c)
+				return nil
+			},
+			true, nil,
+		},
+		{
+			"each party signs its copy",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				p.AddRedeemScript(parties.redeem)
+				b, c := copyPSBT(t, p), copyPSBT(t, p)
+				p.SignKeystore(parties.a)
+				b.SignKeystore(parties.b)
+				c.SignKeystore(parties.c)
+				return p.Combine(b, c)
+			},
+			true, nil,
+		},
+		{
+			"redeem script combined from another copy",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				withRedeem := copyPSBT(t, p)
+				withRedeem.AddRedeemScript(parties.redeem)
+				if err := p.Combine(withRedeem); err != nil {
+					return err
+				}
+				p.SignKeystore(parties.a)
+				p.SignKeystore(parties.b)
+				return nil
+			},
+			true, nil,
+		},
+		{
+			"redeem script added after signing",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				p.SignKeystore(parties.a)
+				p.SignKeystore(parties.b)
+				p.AddRedeemScript(parties.redeem)
+				p.SignKeystore(parties.a)
+				p.SignKeystore(parties.b)
+				return nil
+			},
+			true, nil,
+		},
+		{
+			"multisig signature missing",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				p.AddRedeemScript(parties.redeem)
+				p.SignKeystore(parties.a)
+				return nil
+			},
+			false, nil,
+		},
+		{
+			"redeem script missing",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				p.SignKeystore(parties.a)
+				p.SignKeystore(parties.b)
+				return nil
+			},
+			false, nil,
+		},
+		{
+			"outsider signs",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				p.AddRedeemScript(parties.redeem)
+				if n := p.SignKeystore(NewWallet()); n != 0 {
+					t.Errorf("outsider added %d signatures", n)
+				}
+				p.SignKeystore(parties.b)
+				return nil
+			},
+			false, nil,
+		},
+		{
+			"forged signature",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				p.AddRedeemScript(parties.redeem)
+				p.SignKeystore(parties.b)
+				p.SignKeystore(parties.c)
+				p.Inputs[0].Signatures[hex.EncodeToString(parties.a.PublicKey)] = testSig(1)
+				return nil
+			},
+			false, nil,
+		},
+		{
+			"signature of another transaction",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				other := copyPSBT(t, p)
+				other.Tx.VOut[0].Value--
+				other.SignKeystore(parties.a)
+				p.AddRedeemScript(parties.redeem)
+				p.SignKeystore(parties.b)
+				p.SignKeystore(parties.c)
+				for key, sig := range other.Inputs[0].Signatures {
+					p.Inputs[0].Signatures[key] = sig
+				}
+				return nil
+			},
+			false, nil,
+		},
+		{
+			"combined with another transaction",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				other := copyPSBT(t, p)
+				other.Tx.VOut[0].Value--
+				return p.Combine(other)
+			},
+			false, ErrPartialTxMismatch,
+		},
+		{
+			"combined with another spent output",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				other := copyPSBT(t, p)
+				other.Inputs[1].PrevOut.Value++
+				return p.Combine(other)
+			},
+			false, ErrPartialTxMismatch,
+		},
+		{
+			"combined with a copy for another curve",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				other := copyPSBT(t, p)
+				other.Curve = CurveSecp256k1
+				return p.Combine(other)
+			},
+			false, ErrPartialTxMismatch,
+		},
+		{
+			"undefined hash type",
+			func(t *testing.T, p *PartiallySignedTx, parties psbtParties) error {
+				return p.SetHashType(0, 0x04)
+			},
+			false, ErrInvalidSigHashType,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, parties := newTestPSBT(t)
+			if fee := p.Fee(); fee != 3 {
+				t.Fatalf("fee %d, want 3", fee)
+			}
+
+			err := test.run(t, p, parties)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if complete := p.IsComplete(); complete != test.complete {
+				t.Fatalf("complete %v, want %v", complete, test.complete)
+			}
+
+			tx, err := copyPSBT(t, p).Finalize()
+			if !test.complete {
+				if !errors.Is(err, ErrIncompleteTx) {
+					t.Fatalf("error %v, want %v", err, ErrIncompleteTx)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, vin := range tx.VIn {
+				if err := VerifyScript(vin.ScriptSig, p.Inputs[i].PrevOut.ScriptPubKey, &txSigChecker{tx: tx, index: i, curve: CurveP256}); err != nil {
+					t.Errorf("input %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSetHashTypeOfPartiallySignedTx(t *testing.T) {
+	p, parties := newTestPSBT(t)
+	p.AddRedeemScript(parties.redeem)
+
+	if err := p.SetHashType(len(p.Inputs), SigHashAll); err == nil {
+		t.Error("hash type set on a missing input")
+	}
+	if err := p.SetHashType(1, SigHashSingle); !errors.Is(err, ErrInvalidSigHashType) {
+		t.Errorf("SINGLE without a matching output: error %v, want %v", err, ErrInvalidSigHashType)
+	}
+	if err := p.SetHashType(0, SigHashSingle|SigHashAnyOneCanPay); err != nil {
+		t.Fatal(err)
+	}
+
+	p.SignKeystore(parties.a)
+	p.SignKeystore(parties.b)
+	tx, err := p.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := parseScript(tx.VIn[0].ScriptSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig := ops[0].data; SigHashType(sig[len(sig)-1]) != SigHashSingle|SigHashAnyOneCanPay {
+		t.Errorf("signature hash type %s, want %s", SigHashType(sig[len(sig)-1]), SigHashSingle|SigHashAnyOneCanPay)
+	}
+}
+
+func TestDecodePartiallySignedTxRejectsGarbage(t *testing.T) {
+	p, _ := newTestPSBT(t)
+	p.Inputs = p.Inputs[:1]
+
+	for name, encoded := range map[string]string{
+		"not hex":           "zz",
+		"not a transaction": "00ff",
+		"missing inputs":    p.EncodeHex(),
+	} {
+		if _, err := DecodePartiallySignedTxHex(encoded); err == nil {
+			t.Errorf("%s decoded", name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// rateLimitScore is the misbehaviour score of a message over the rate
+	// limit of the peer, the message is dropped
+	rateLimitScore = 10
+
+	// peerReadTimeout is how long a handshaken peer may stay silent, and
+	// the longest a message may take to read. Pings keep healthy peers
+	// from reaching it.
+	peerReadTimeout = pingInterval + 3*pingTimeout
+
+	// smallMessagePayload is the largest payload of the commands carrying
+	// a handful of fields
+	smallMessagePayload = 4 << 10
+
+	// addrMessagePayload is the largest payload of CommandAddr, enough for
+	// maxAddrPerMessage records
+	addrMessagePayload = maxAddrPerMessage * 256
+
+	// blockMessageOverhead is the room of a block message above the
+	// maximum size of the transactions, for the header and the encoding
+	blockMessageOverhead = 64 << 10
+)
+
+// PeerRateLimit limits the messages each peer may send, as token buckets
+// refilled every second
+type PeerRateLimit struct {
+	// Messages and Bytes are the messages and payload bytes per second
+	Messages float64
+	Bytes    float64
+
+	// MessageBurst and ByteBurst are the most a peer may send at once,
+	// ByteBurst must hold the largest message
+	MessageBurst float64
+	ByteBurst    float64
+}
+
+// DefaultPeerRateLimit is the rate limit of the peers of servers configured
+// without one
+var DefaultPeerRateLimit = PeerRateLimit{
+	Messages:     100,
+	Bytes:        2 << 20,
+	MessageBurst: 1000,
+	ByteBurst:    2 * MaxMessagePayload,
+}
+
+// tokenBucket holds up to burst tokens refilled at rate per second
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take takes n tokens and returns true, false when the bucket has fewer
+func (b *tokenBucket) take(n float64) bool {
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens < n {
+		return false
+	}
+
+	b.tokens -= n
+	return true
+}
+
+// peerLimiter is the rate limit of a peer, it is only used by the
+// goroutine reading its messages
+type peerLimiter struct {
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+// newPeerLimiter creates the limiter of a peer
+func newPeerLimiter(limit PeerRateLimit) *peerLimiter {
+	return &peerLimiter{
+		messages: newTokenBucket(limit.Messages, limit.MessageBurst),
+		bytes:    newTokenBucket(limit.Bytes, limit.ByteBurst),
+	}
+}
+
+// allow returns whether the peer may send a message of size bytes
+func (l *peerLimiter) allow(size int) bool {
+	return l.messages.take(1) && l.bytes.take(float64(size))
+}
+
+// maxPayload returns the largest payload the peers may send with a command
+func (s *Server) maxPayload(command string) uint32 {
+	switch command {
+	case CommandVersion, CommandVerack, CommandPing, CommandPong, CommandGetAddr,
+		CommandGetBlocks, CommandGetData, CommandNotFound, CommandReject, CommandMempool,
+		CommandFeeFilter, CommandSendHeaders, CommandGetHeaders, CommandFilterAdd, CommandFilterClear,
+		CommandGetCFilters, CommandGetCFHeaders:
+		return smallMessagePayload
+	case CommandFilterLoad:
+		return filterLoadMessagePayload
+	case CommandAddr:
+		return addrMessagePayload
+	case CommandTx, CommandBlock:
+		return uint32(s.bc.Params().MaxBlockSize) + blockMessageOverhead
+	default:
+		return MaxMessagePayload
+	}
+}
@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// RawInput is the JSON-able view of a transaction input
+type RawInput struct {
+	TxID      string
+	VOut      int
+	ScriptSig string
+	Asm       string `json:",omitempty"`
+	Sequence  uint32
+}
+
+// RawOutput is the JSON-able view of a transaction output
+type RawOutput struct {
+	Value        int
+	ScriptPubKey string
+	Asm          string
+	Type         ScriptType
+	Address      string `json:",omitempty"`
+}
+
+// RawTransaction is the JSON-able view of a raw transaction, scripts are
+// hex encoded and disassembled. It can be edited and turned back into a
+// transaction with Transaction.
+type RawTransaction struct {
+	ID       string
+	WTxID    string
+	Version  int
+	LockTime uint32
+	Size     int
+	Coinbase bool
+	Inputs   []RawInput
+	Outputs  []RawOutput
+}
+
+// EncodeRawTransaction returns the hex canonical encoding of a transaction
+func EncodeRawTransaction(tx *Transaction) string {
+	return hex.EncodeToString(tx.SerializeCanonical())
+}
+
+// DecodeRawTransaction decodes a hex transaction into its view, the
+// canonical encoding and the legacy gob encoding are accepted
+func DecodeRawTransaction(rawTx string) (*RawTransaction, error) {
+	tx, err := parseRawTransaction(rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRawTransaction(tx), nil
+}
+
+// parseRawTransaction decodes a hex transaction of the canonical encoding
+// or the legacy gob encoding
+func parseRawTransaction(rawTx string) (*Transaction, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(rawTx))
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := DeserializeCanonical(data)
+	if err == nil {
+		return tx, nil
+	}
+
+	if legacy, legacyErr := TryDeserializeTransaction(data); legacyErr == nil {
+		return legacy, nil
+	}
+
+	return nil, err
+}
+
+// NewRawTransaction returns the view of a transaction
+func NewRawTransaction(tx *Transaction) *RawTransaction {
+	r := &RawTransaction{
+		ID:       hex.EncodeToString(tx.ID),
+		WTxID:    hex.EncodeToString(tx.WitnessHash()),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Size:     len(tx.SerializeCanonical()),
+		Coinbase: tx.IsCoinbase(),
+	}
+
+	for _, vin := range tx.VIn {
+		in := RawInput{
+			TxID:      hex.EncodeToString(vin.TxID),
+			VOut:      vin.VOut,
+			ScriptSig: hex.EncodeToString(vin.ScriptSig),
+			Sequence:  vin.Sequence,
+		}
+
+		if !r.Coinbase {
+			in.Asm = DisasmScript(vin.ScriptSig)
+		}
+
+		r.Inputs = append(r.Inputs, in)
+	}
+
+	for _, out := range tx.VOut {
+		r.Outputs = append(r.Outputs, RawOutput{
+			Value:        out.Value,
+			ScriptPubKey: hex.EncodeToString(out.ScriptPubKey),
+			Asm:          DisasmScript(out.ScriptPubKey),
+			Type:         ClassifyOutput(out),
+			Address:      OutputAddress(out),
+		})
+	}
+
+	return r
+}
+
+// Transaction returns the transaction of the view from its hex fields, the
+// id is computed and the disassembly, type and address are ignored
+func (r *RawTransaction) Transaction() (*Transaction, error) {
+	tx := &Transaction{Version: r.Version, LockTime: r.LockTime}
+
+	for i, in := range r.Inputs {
+		txID, err := hex.DecodeString(in.TxID)
+		if err != nil {
+			return nil, fmt.Errorf("input %d txid: %w", i, err)
+		}
+
+		scriptSig, err := hex.DecodeString(in.ScriptSig)
+		if err != nil {
+			return nil, fmt.Errorf("input %d scriptSig: %w", i, err)
+		}
+
+		tx.VIn = append(tx.VIn, TXInput{TxID: txID, VOut: in.VOut, ScriptSig: scriptSig, Sequence: in.Sequence})
+	}
+
+	for i, out := range r.Outputs {
+		script, err := hex.DecodeString(out.ScriptPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("output %d scriptPubKey: %w", i, err)
+		}
+
+		tx.VOut = append(tx.VOut, TXOutput{Value: out.Value, ScriptPubKey: script})
+	}
+
+	tx.ID = tx.Hash()
+	return tx, nil
+}
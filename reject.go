@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// CommandReject reject, the answer to a block or transaction the node refused
+const CommandReject = "reject"
+
+// RejectCode is the reason code of a reject message
+type RejectCode uint8
+
+const (
+	// RejectMalformed is a block or transaction which can't be decoded
+	RejectMalformed RejectCode = 0x01
+
+	// RejectInvalid is a block or transaction breaking the consensus rules
+	RejectInvalid RejectCode = 0x10
+
+	// RejectDuplicate is a transaction already in the mempool or spending
+	// outputs a mempool transaction spends
+	RejectDuplicate RejectCode = 0x12
+
+	// RejectNonstandard is a transaction the relay policy refuses
+	RejectNonstandard RejectCode = 0x40
+
+	// RejectInsufficientFee is a transaction paying less than the minimum
+	// relay fee rate
+	RejectInsufficientFee RejectCode = 0x42
+)
+
+// String returns the name of the code
+func (c RejectCode) String() string {
+	switch c {
+	case RejectMalformed:
+		return "malformed"
+	case RejectInvalid:
+		return "invalid"
+	case RejectDuplicate:
+		return "duplicate"
+	case RejectNonstandard:
+		return "nonstandard"
+	case RejectInsufficientFee:
+		return "insufficient fee"
+	default:
+		return fmt.Sprintf("code %#x", uint8(c))
+	}
+}
+
+type rejectData struct {
+	AddrFrom string
+
+	// Command and ID are the command and the hash of the refused item, ID
+	// is empty when it couldn't be decoded
+	Command string
+	ID      []byte
+
+	Code   RejectCode
+	Reason string
+}
+
+// rejectCode returns the reason code of the error refusing a block or a
+// transaction
+func rejectCode(err error) RejectCode {
+	switch {
+	case errors.Is(err, ErrTxInMempool), errors.Is(err, ErrDoubleSpend), errors.Is(err, ErrReplacementRejected):
+		return RejectDuplicate
+	case errors.Is(err, ErrFeeTooLow):
+		return RejectInsufficientFee
+	case errors.Is(err, ErrNonStandardTx), errors.Is(err, ErrNonFinalTx), errors.Is(err, ErrSequenceLocked):
+		return RejectNonstandard
+	default:
+		return RejectInvalid
+	}
+}
+
+// sendReject tells a peer why an item of a command was refused
+func (s *Server) sendReject(p *peerConn, command string, id []byte, code RejectCode, err error) {
+	p.send(CommandReject, rejectData{AddrFrom: s.address, Command: command, ID: id, Code: code, Reason: err.Error()})
+}
+
+// handleReject handles CommandReject request
+func (s *Server) handleReject(p *peerConn, request []byte) {
+	var payload rejectData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	log.Printf("%s rejected %s %x, %s: %s\n", p, payload.Command, payload.ID, payload.Code, payload.Reason)
+}
@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// reorganize makes newBlock, whose branch forks from the main chain below
+// oldTip, the tip of the UTXO set: the main chain blocks above the fork point
+// are disconnected and the branch blocks connected. Branch blocks weren't
+// checked against the UTXO set when received, their scripts and fees are
+// checked as they are connected.
+func (bc *Blockchain) reorganize(tx *bolt.Tx, oldTip, newBlock *Block) ([]ChainEvent, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+	parent := func(block *Block) (*Block, error) {
+		data := b.Get(block.PrevBlockHash)
+		if data == nil {
+			return nil, fmt.Errorf("%w: previous block %x of %x is unknown", ErrInvalidBlock, block.PrevBlockHash, block.Hash)
+		}
+
+		return TryDeserializeBlock(data)
+	}
+
+	var disconnect, branch []*Block
+	old, fork := oldTip, newBlock
+
+	for fork.Height > old.Height {
+		branch = append([]*Block{fork}, branch...)
+
+		var err error
+		if fork, err = parent(fork); err != nil {
+			return nil, err
+		}
+	}
+
+	for !bytes.Equal(old.Hash, fork.Hash) {
+		disconnect = append(disconnect, old)
+		branch = append([]*Block{fork}, branch...)
+
+		var err error
+		if old, err = parent(old); err != nil {
+			return nil, err
+		}
+		if fork, err = parent(fork); err != nil {
+			return nil, err
+		}
+	}
+
+	var events []ChainEvent
+	for _, block := range disconnect {
+		if err := disconnectUTXOSet(tx, block); err != nil {
+			return nil, err
+		}
+
+		events = append(events, ChainEvent{Type: BlockDisconnected, Block: block})
+	}
+
+	for _, block := range branch {
+		if len(block.UTXOCommitment) != 0 && !bytes.Equal(utxoCommitment(tx), block.UTXOCommitment) {
+			return nil, fmt.Errorf("%w: block %x", ErrUTXOCommitmentMismatch, block.Hash)
+		}
+
+		if err := bc.checkConnectBlock(tx, block); err != nil {
+			return nil, err
+		}
+
+		if err := updateUTXOSet(tx, block); err != nil {
+			return nil, err
+		}
+
+		events = append(events, ChainEvent{Type: BlockConnected, Block: block})
+	}
+
+	return events, nil
+}
+
+// checkConnectBlock checks the output values, scripts, fees and locks of a
+// block against the UTXO set within a bolt transaction, the UTXO set must
+// be at its previous block
+func (bc *Blockchain) checkConnectBlock(tx *bolt.Tx, block *Block) error {
+	utxos := tx.Bucket([]byte(utxoBucket))
+	if err := transactionLocks(utxos, block.Transactions, block.Height, block.Timestamp); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidBlock, err)
+	}
+
+	pending := make(map[string]*Transaction, len(block.Transactions))
+	spent := make(map[string]bool)
+	fees := 0
+
+	for _, transaction := range block.Transactions {
+		if err := checkOutputValues(transaction); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidBlock, err)
+		}
+
+		if transaction.IsCoinbase() {
+			pending[hex.EncodeToString(transaction.ID)] = transaction
+			continue
+		}
+
+		if err := checkNoDuplicateInputs(transaction, spent); err != nil {
+			return err
+		}
+
+		inputValue := 0
+		for inID, vin := range transaction.VIn {
+			prevOut, ok := findOutput(utxos, pending, vin)
+			if !ok {
+				return fmt.Errorf("%w: %x spends %x:%d", ErrDoubleSpend, transaction.ID, vin.TxID, vin.VOut)
+			}
+
+			checker := &txSigChecker{tx: transaction, index: inID, curve: bc.opts.params.KeyCurve}
+			if err := VerifyScript(vin.ScriptSig, prevOut.ScriptPubKey, checker); err != nil {
+				return fmt.Errorf("%w: %x input %d: %s", ErrInvalidTransaction, transaction.ID, inID, err)
+			}
+
+			if inputValue, ok = addValue(inputValue, prevOut.Value); !ok {
+				return fmt.Errorf("%w: %x input values overflow", ErrInvalidBlock, transaction.ID)
+			}
+		}
+
+		fee := inputValue - transaction.OutputValue()
+		if fee < 0 {
+			return fmt.Errorf("%w: %x outputs exceed inputs by %d", ErrInvalidTransaction, transaction.ID, -fee)
+		}
+
+		var ok bool
+		if fees, ok = addValue(fees, fee); !ok {
+			return fmt.Errorf("%w: fees overflow", ErrInvalidBlock)
+		}
+		pending[hex.EncodeToString(transaction.ID)] = transaction
+	}
+
+	allowed, ok := addValue(bc.opts.params.BlockSubsidy(block.Height), fees)
+	if claimed := block.Transactions[0].OutputValue(); !ok || claimed > allowed {
+		return fmt.Errorf("%w: coinbase claims %d, allowed %d", ErrInvalidBlock, claimed, allowed)
+	}
+
+	return nil
+}
+
+// findOutput returns the output spent by an input from the transactions of
+// the same block or the UTXO set
+func findOutput(utxos *bolt.Bucket, pending map[string]*Transaction, vin TXInput) (TXOutput, bool) {
+	if prevTX, ok := pending[hex.EncodeToString(vin.TxID)]; ok {
+		if vin.VOut < 0 || vin.VOut >= len(prevTX.VOut) || prevTX.VOut[vin.VOut].IsUnspendable() {
+			return TXOutput{}, false
+		}
+
+		return prevTX.VOut[vin.VOut], true
+	}
+
+	data := utxos.Get(vin.TxID)
+	if data == nil {
+		return TXOutput{}, false
+	}
+
+	outs := DeserializeOutputs(data)
+	for i, out := range outs.Outputs {
+		if outs.Index(i) == vin.VOut {
+			return out, true
+		}
+	}
+
+	return TXOutput{}, false
+}
@@ -0,0 +1,319 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// newTestBlockchain creates a blockchain in a temporary directory, mining
+// at a low difficulty, and a wallet owning the genesis output
+func newTestBlockchain(t *testing.T) (*Blockchain, *Wallet) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	params := MainNetParams
+	params.TargetBits = 8
+	wallet := NewWalletWithParams(&params)
+
+	bc := CreateBlockchain(string(wallet.GetAddress()), "test", WithChainParams(&params))
+	t.Cleanup(func() { bc.Close() })
+
+	return bc, wallet
+}
+
+// mineOn mines a block with a coinbase paying the subsidy to an address and
+// the transactions on top of prev, without connecting it
+func mineOn(bc *Blockchain, prev *Block, to string, txs ...*Transaction) *Block {
+	coinbase := newCoinbaseTX(to, "", bc.opts.params.BlockSubsidy(prev.Height+1))
+	block := &Block{
+		Timestamp:     prev.Timestamp + 1,
+		Transactions:  append([]*Transaction{coinbase}, txs...),
+		PrevBlockHash: prev.Hash,
+		Height:        prev.Height + 1,
+	}
+	block.mine(bc.opts.params.TargetBits)
+
+	return block
+}
+
+// genesisOf returns the genesis block of a blockchain
+func genesisOf(t *testing.T, bc *Blockchain) *Block {
+	t.Helper()
+
+	hashes := bc.GetBlockHashes()
+	genesis, err := bc.GetBlock(hashes[len(hashes)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &genesis
+}
+
+// isUnspent returns whether the UTXO set holds outputs of a transaction
+func isUnspent(t *testing.T, bc *Blockchain, txID []byte) bool {
+	t.Helper()
+
+	var unspent bool
+	if err := bc.db.View(func(tx *bolt.Tx) error {
+		unspent = tx.Bucket([]byte(utxoBucket)).Get(txID) != nil
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return unspent
+}
+
+func TestReorganizeToLongerBranch(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	genesis := genesisOf(t, bc)
+	other := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	tip := bc.MineBlock([]*Transaction{bc.NewCoinbaseTX(other)})
+
+	spend := NewUTXOTransaction(wallet, other, 10, 0, &UTXOSet{bc})
+	fork := mineOn(bc, genesis, other, spend)
+	if err := bc.AddBlock(fork); err != nil {
+		t.Fatal(err)
+	}
+	if !isUnspent(t, bc, tip.Transactions[0].ID) {
+		t.Fatal("a block of the same height reorganized the chain")
+	}
+
+	var events []ChainEvent
+	bc.Subscribe(func(e ChainEvent) { events = append(events, e) })
+
+	longer := mineOn(bc, fork, other)
+	if err := bc.AddBlock(longer); err != nil {
+		t.Fatal(err)
+	}
+
+	if height := bc.GetBestHeight(); height != 2 {
+		t.Fatalf("best height %d, want 2", height)
+	}
+	if isUnspent(t, bc, tip.Transactions[0].ID) {
+		t.Error("the disconnected coinbase is still unspent")
+	}
+	if isUnspent(t, bc, genesis.Transactions[0].ID) {
+		t.Error("the output spent on the branch is still unspent")
+	}
+	for _, txID := range [][]byte{spend.ID, fork.Transactions[0].ID, longer.Transactions[0].ID} {
+		if !isUnspent(t, bc, txID) {
+			t.Errorf("branch transaction %x isn't in the UTXO set", txID)
+		}
+	}
+
+	if len(events) != 3 || events[0].Type != BlockDisconnected || events[1].Type != BlockConnected || events[2].Type != BlockConnected {
+		t.Errorf("events %v, want one disconnected and two connected blocks", events)
+	}
+}
+
+func TestReorganizeRejectsInvalidBranch(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	genesis := genesisOf(t, bc)
+	to := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	locked := &Transaction{
+		VIn:  []TXInput{{TxID: genesis.Transactions[0].ID, VOut: 0, Sequence: RelativeLockBlocks(5)}},
+		VOut: []TXOutput{*NewTXOutput(10, to)},
+	}
+	locked.ID = locked.Hash()
+
+	unsigned := &Transaction{
+		VIn:  []TXInput{{TxID: genesis.Transactions[0].ID, VOut: 0, Sequence: SequenceFinal}},
+		VOut: []TXOutput{*NewTXOutput(10, to)},
+	}
+	unsigned.ID = unsigned.Hash()
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  error
+	}{
+		{"sequence locked", locked, ErrInvalidBlock},
+		{"unsigned input", unsigned, ErrInvalidTransaction},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tip := bc.MineBlock([]*Transaction{bc.NewCoinbaseTX(to)})
+
+			fork := mineOn(bc, genesisOf(t, bc), to, test.tx)
+			fork.Timestamp = tip.Timestamp
+			fork.mine(bc.opts.params.TargetBits)
+			if err := bc.AddBlock(fork); err != nil {
+				t.Fatal(err)
+			}
+
+			branch := fork
+			for branch.Height < tip.Height {
+				branch = mineOn(bc, branch, to)
+				if err := bc.AddBlock(branch); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := bc.AddBlock(mineOn(bc, branch, to))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if got := bc.currentTip(); string(got) != string(tip.Hash) {
+				t.Errorf("tip %x, want %x", got, tip.Hash)
+			}
+		})
+	}
+}
+
+func TestProcessBlockChecksScriptsOnTip(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	to := string(NewWalletWithParams(bc.Params()).GetAddress())
+	tip := bc.MineBlock([]*Transaction{bc.NewCoinbaseTX(to)})
+
+	missing := &Transaction{
+		VIn:  []TXInput{{TxID: []byte("missing"), VOut: 0, Sequence: SequenceFinal}},
+		VOut: []TXOutput{*NewTXOutput(10, to)},
+	}
+	missing.ID = missing.Hash()
+
+	if _, err := bc.ProcessBlock(mineOn(bc, tip, to, missing).Serialize()); !errors.Is(err, ErrDoubleSpend) {
+		t.Fatalf("error %v, want %v", err, ErrDoubleSpend)
+	}
+	if got := bc.currentTip(); string(got) != string(tip.Hash) {
+		t.Errorf("tip %x, want %x", got, tip.Hash)
+	}
+	if _, ok := bc.ValidationMetrics().Stages()[StageScripts]; !ok {
+		t.Error("the scripts stage wasn't timed")
+	}
+}
+
+func TestAddBlockRejectsBlockOnStaleUTXOSet(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	genesis := genesisOf(t, bc)
+	to := string(NewWalletWithParams(bc.Params()).GetAddress())
+	tip := bc.MineBlock([]*Transaction{bc.NewCoinbaseTX(to)})
+
+	if err := bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(blocksBucket)).Put([]byte(utxoTipDbKey), genesis.Hash)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bc.AddBlock(mineOn(bc, tip, to)); !errors.Is(err, ErrUTXOSetStale) {
+		t.Fatalf("error %v, want %v", err, ErrUTXOSetStale)
+	}
+	if height := bc.GetBestHeight(); height != 1 {
+		t.Errorf("best height %d, want 1", height)
+	}
+}
+
+func TestBlockRejectsInvalidOutputValues(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	genesis := genesisOf(t, bc)
+	coinbase := genesis.Transactions[0]
+	to := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	spend := func(values ...int) *Transaction {
+		tx := &Transaction{VIn: []TXInput{{TxID: coinbase.ID, VOut: 0, Sequence: SequenceFinal}}}
+		for _, value := range values {
+			tx.VOut = append(tx.VOut, *NewTXOutput(value, to))
+		}
+		tx.ID = tx.Hash()
+
+		prevTXs := map[string]Transaction{hex.EncodeToString(coinbase.ID): *coinbase}
+		if err := tx.Sign(KeystoreSigner(wallet), prevTXs); err != nil {
+			t.Fatal(err)
+		}
+
+		return tx
+	}
+
+	value := coinbase.VOut[0].Value
+	tests := []struct {
+		name string
+		tx   *Transaction
+	}{
+		{"negative output", spend(-1000, value+1000)},
+		{"overflowing outputs", spend(maxValue, maxValue, 2)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := mineOn(bc, genesis, to, test.tx)
+
+			if err := bc.AddBlock(block); !errors.Is(err, ErrInvalidBlock) {
+				t.Fatalf("AddBlock error %v, want %v", err, ErrInvalidBlock)
+			}
+			if _, err := bc.ProcessBlock(block.Serialize()); !errors.Is(err, ErrInvalidBlock) {
+				t.Fatalf("ProcessBlock error %v, want %v", err, ErrInvalidBlock)
+			}
+			if height := bc.GetBestHeight(); height != 0 {
+				t.Errorf("best height %d, want 0", height)
+			}
+			if !isUnspent(t, bc, coinbase.ID) {
+				t.Error("the spent output left the UTXO set")
+			}
+		})
+	}
+}
+
+func TestAddBlockChecksHeight(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	genesis := genesisOf(t, bc)
+	to := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	orphan := mineOn(bc, genesis, to)
+	orphan.PrevBlockHash = bytes.Repeat([]byte{0x55}, 32)
+	orphan.mine(bc.opts.params.TargetBits)
+
+	tests := []struct {
+		name   string
+		height int
+		block  *Block
+		err    error
+	}{
+		{"skipped heights", 500, nil, ErrInvalidBlock},
+		{"same height as parent", 0, nil, ErrInvalidBlock},
+		{"unknown parent", 1, orphan, ErrOrphanBlock},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := test.block
+			if block == nil {
+				block = mineOn(bc, genesis, to)
+				block.Height = test.height
+				block.mine(bc.opts.params.TargetBits)
+			}
+
+			if _, err := bc.ProcessBlock(block.Serialize()); !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if height := bc.GetBestHeight(); height != 0 {
+				t.Errorf("best height %d, want 0", height)
+			}
+		})
+	}
+}
+
+func TestBlockHashCommitsHeight(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	block := mineOn(bc, genesisOf(t, bc), string(NewWalletWithParams(bc.Params()).GetAddress()))
+
+	block.Height = 500
+	if err := checkProofOfWork(block, bc.opts.params.TargetBits); !errors.Is(err, ErrInvalidProofOfWork) {
+		t.Fatalf("error %v, want %v", err, ErrInvalidProofOfWork)
+	}
+}
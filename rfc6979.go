@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+)
+
+// nonceGenerator generates the deterministic ECDSA nonces of RFC 6979
+// section 3.2 with HMAC-SHA256 for a private key and a message hash
+type nonceGenerator struct {
+	q    *big.Int
+	rlen int
+	k    []byte
+	v    []byte
+}
+
+// newNonceGenerator creates the nonceGenerator of a private key and a hash
+func newNonceGenerator(privateKey *ecdsa.PrivateKey, hash []byte) *nonceGenerator {
+	q := privateKey.Curve.Params().N
+	g := &nonceGenerator{
+		q:    q,
+		rlen: (q.BitLen() + 7) / 8,
+		k:    make([]byte, sha256.Size),
+		v:    make([]byte, sha256.Size),
+	}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+
+	seed := append(g.int2octets(privateKey.D), g.bits2octets(hash)...)
+	g.k = g.mac(g.v, []byte{0x00}, seed)
+	g.v = g.mac(g.v)
+	g.k = g.mac(g.v, []byte{0x01}, seed)
+	g.v = g.mac(g.v)
+
+	return g
+}
+
+// mac returns the HMAC of the data under the current key
+func (g *nonceGenerator) mac(data ...[]byte) []byte {
+	h := hmac.New(sha256.New, g.k)
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+// bits2int converts a bit string to an integer of at most the bit length of q
+func (g *nonceGenerator) bits2int(b []byte) *big.Int {
+	x := new(big.Int).SetBytes(b)
+	if excess := len(b)*8 - g.q.BitLen(); excess > 0 {
+		x.Rsh(x, uint(excess))
+	}
+
+	return x
+}
+
+// int2octets converts an integer to rlen bytes
+func (g *nonceGenerator) int2octets(x *big.Int) []byte {
+	return x.FillBytes(make([]byte, g.rlen))
+}
+
+// bits2octets converts a hash to rlen bytes reduced modulo q
+func (g *nonceGenerator) bits2octets(b []byte) []byte {
+	z := g.bits2int(b)
+	if z.Cmp(g.q) >= 0 {
+		z.Sub(z, g.q)
+	}
+
+	return g.int2octets(z)
+}
+
+// next returns the next nonce in [1, q-1], the state is advanced so a
+// rejected nonce is followed by a new one
+func (g *nonceGenerator) next() *big.Int {
+	for {
+		var t []byte
+		for len(t) < g.rlen {
+			g.v = g.mac(g.v)
+			t = append(t, g.v...)
+		}
+
+		k := g.bits2int(t[:g.rlen])
+		g.k = g.mac(g.v, []byte{0x00})
+		g.v = g.mac(g.v)
+
+		if k.Sign() > 0 && k.Cmp(g.q) < 0 {
+			return k
+		}
+	}
+}
+
+// signDeterministic signs a hash with a nonce derived from the private key
+// and the hash as in RFC 6979, s is normalized to the lower half of the
+// curve order so the signature can't be malleated
+func signDeterministic(privateKey *ecdsa.PrivateKey, hash []byte) (r, s *big.Int) {
+	curve := privateKey.Curve
+	n := curve.Params().N
+	gen := newNonceGenerator(privateKey, hash)
+	e := gen.bits2int(hash)
+
+	for {
+		k := gen.next()
+
+		x, _ := curve.ScalarBaseMult(k.Bytes())
+		r = new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		s = new(big.Int).Mul(r, privateKey.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		if isHighS(n, s) {
+			s.Sub(n, s)
+		}
+
+		return r, s
+	}
+}
+
+// isHighS returns whether s is in the upper half of the curve order n
+func isHighS(n, s *big.Int) bool {
+	return s.Cmp(new(big.Int).Rsh(n, 1)) > 0
+}
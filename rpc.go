@@ -0,0 +1,648 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// rpcSocketMode is the file mode of the control socket, only its owner may connect
+	rpcSocketMode = 0600
+
+	// rpcChainService is the name of the read-only chain service
+	rpcChainService = "Chain"
+
+	// rpcAdminService is the name of the admin and wallet service
+	rpcAdminService = "Admin"
+)
+
+var (
+	// ErrInsecureSocketDir is returned when the control socket directory is writable by others
+	ErrInsecureSocketDir = errors.New("control socket directory is writable by other users")
+
+	// ErrNoWallets is returned by the wallet commands of a node without wallets
+	ErrNoWallets = errors.New("node has no wallets")
+)
+
+// ChainService is the read-only RPC service, served on TCP and on the control socket
+type ChainService struct {
+	bc *Blockchain
+}
+
+// NoArgs are the arguments of RPC calls without arguments
+type NoArgs struct{}
+
+// GetBestHeight returns the height of the tip
+func (s *ChainService) GetBestHeight(_ NoArgs, reply *int) error {
+	*reply = s.bc.GetBestHeight()
+	return nil
+}
+
+// GetBlockHashes returns the hex hashes of all blocks from the tip
+func (s *ChainService) GetBlockHashes(_ NoArgs, reply *[]string) error {
+	for _, hash := range s.bc.GetBlockHashes() {
+		*reply = append(*reply, hex.EncodeToString(hash))
+	}
+
+	return nil
+}
+
+// GetBalance returns the confirmed balance of an address
+func (s *ChainService) GetBalance(address string, reply *int) error {
+	if _, _, err := decodeAddress(address); err != nil {
+		return err
+	}
+
+	for _, out := range NewUTXOSet(s.bc).FindUTXO(pubKeyHashFromAddress(address)) {
+		*reply += out.Value
+	}
+
+	return nil
+}
+
+// BalanceAtArgs are the arguments of ChainService.GetBalanceAt
+type BalanceAtArgs struct {
+	Address string
+	Height  int
+}
+
+// GetBalanceAt returns the balance of an address at a height of an archival node
+func (s *ChainService) GetBalanceAt(args BalanceAtArgs, reply *int) error {
+	balance, err := s.bc.GetBalanceAt(args.Address, args.Height)
+	if err != nil {
+		return err
+	}
+
+	*reply = balance
+	return nil
+}
+
+// InputInfo describes a transaction input
+type InputInfo struct {
+	TxID     string
+	VOut     int
+	Sequence uint32
+}
+
+// OutputInfo describes a transaction output
+type OutputInfo struct {
+	Value   int
+	Type    ScriptType
+	Script  string
+	Address string `json:",omitempty"`
+}
+
+// TransactionInfo describes a transaction
+type TransactionInfo struct {
+	ID       string
+	WTxID    string
+	Version  int
+	LockTime uint32
+	Coinbase bool
+	Inputs   []InputInfo
+	Outputs  []OutputInfo
+}
+
+// newTransactionInfo describes a transaction
+func newTransactionInfo(tx *Transaction) TransactionInfo {
+	info := TransactionInfo{
+		ID:       hex.EncodeToString(tx.ID),
+		WTxID:    hex.EncodeToString(tx.WitnessHash()),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Coinbase: tx.IsCoinbase(),
+	}
+
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.VIn {
+			info.Inputs = append(info.Inputs, InputInfo{TxID: hex.EncodeToString(vin.TxID), VOut: vin.VOut, Sequence: vin.Sequence})
+		}
+	}
+
+	for _, out := range tx.VOut {
+		info.Outputs = append(info.Outputs, OutputInfo{
+			Value:   out.Value,
+			Type:    ClassifyOutput(out),
+			Script:  DisasmScript(out.ScriptPubKey),
+			Address: OutputAddress(out),
+		})
+	}
+
+	return info
+}
+
+// GetTransaction returns a transaction of the chain by its hex id
+func (s *ChainService) GetTransaction(txID string, reply *TransactionInfo) error {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.bc.FindTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	*reply = newTransactionInfo(&tx)
+	return nil
+}
+
+// TxConfirmations describes the confirmations of a main chain transaction
+type TxConfirmations struct {
+	Confirmations int
+	BlockHash     string
+	Height        int
+}
+
+// GetTxConfirmations returns the confirmations of a main chain transaction by its hex id
+func (s *ChainService) GetTxConfirmations(txID string, reply *TxConfirmations) error {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return err
+	}
+
+	confirmations, block, err := s.bc.GetTxConfirmations(id)
+	if err != nil {
+		return err
+	}
+
+	*reply = TxConfirmations{Confirmations: confirmations, BlockHash: hex.EncodeToString(block.Hash), Height: block.Height}
+	return nil
+}
+
+// GetRawTransaction returns the hex canonical encoding of a transaction of the chain by its hex id
+func (s *ChainService) GetRawTransaction(txID string, reply *string) error {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.bc.FindTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	*reply = EncodeRawTransaction(&tx)
+	return nil
+}
+
+// DecodeRawTransaction returns the view of a hex transaction
+func (s *ChainService) DecodeRawTransaction(rawTx string, reply *RawTransaction) error {
+	r, err := DecodeRawTransaction(rawTx)
+	if err != nil {
+		return err
+	}
+
+	*reply = *r
+	return nil
+}
+
+// GetChainStats returns the statistics of the chain
+func (s *ChainService) GetChainStats(_ NoArgs, reply *ChainStats) error {
+	stats, err := s.bc.ChainStats()
+	if err != nil {
+		return err
+	}
+
+	*reply = *stats
+	return nil
+}
+
+// ProjectSupply returns the total subsidy issued up to and including a height
+func (s *ChainService) ProjectSupply(height int, reply *int) error {
+	*reply = s.bc.Params().ProjectSupply(height)
+	return nil
+}
+
+// AdminService is the RPC service of admin and wallet commands, only served
+// on the control socket
+type AdminService struct {
+	bc      *Blockchain
+	mempool *Mempool
+	wallets *Wallets
+}
+
+// MempoolInfo describes the mempool
+type MempoolInfo struct {
+	Size  int
+	Bytes int
+	Fees  int
+}
+
+// GetMempoolInfo returns the size of the mempool
+func (s *AdminService) GetMempoolInfo(_ NoArgs, reply *MempoolInfo) error {
+	for _, entry := range s.mempool.Entries() {
+		reply.Size++
+		reply.Bytes += entry.Size
+		reply.Fees += entry.Fee
+	}
+
+	return nil
+}
+
+// GetFeeHistogram returns the mempool fee histogram over the fee rate band
+// bounds, the default bands are used without bounds
+func (s *AdminService) GetFeeHistogram(bounds []float64, reply *[]FeeBand) error {
+	*reply = s.mempool.FeeHistogram(bounds)
+	return nil
+}
+
+// GetPolicy returns the relay and mining policy of the node
+func (s *AdminService) GetPolicy(_ NoArgs, reply *Policy) error {
+	*reply = s.mempool.Policy()
+	return nil
+}
+
+// SetPolicy sets the relay and mining policy of the node and returns it
+func (s *AdminService) SetPolicy(policy Policy, reply *Policy) error {
+	if err := s.mempool.SetPolicy(policy); err != nil {
+		return err
+	}
+
+	*reply = s.mempool.Policy()
+	return nil
+}
+
+// SendRawTransaction adds a hex transaction, canonical or legacy encoded, to
+// the mempool and returns its id
+func (s *AdminService) SendRawTransaction(rawTx string, reply *string) error {
+	tx, err := parseRawTransaction(rawTx)
+	if err != nil {
+		return err
+	}
+
+	if err = s.mempool.Add(tx); err != nil {
+		return err
+	}
+
+	*reply = hex.EncodeToString(tx.ID)
+	return nil
+}
+
+// SendPartiallySignedTransaction finalizes a hex encoded partially signed
+// transaction, adds it to the mempool and returns its id
+func (s *AdminService) SendPartiallySignedTransaction(partialTx string, reply *string) error {
+	p, err := DecodePartiallySignedTxHex(partialTx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.Finalize()
+	if err != nil {
+		return err
+	}
+
+	if err = s.mempool.Add(tx); err != nil {
+		return err
+	}
+
+	*reply = hex.EncodeToString(tx.ID)
+	return nil
+}
+
+// ReindexUTXO rebuilds the UTXO set
+func (s *AdminService) ReindexUTXO(_ NoArgs, reply *int) error {
+	NewUTXOSet(s.bc).Reindex()
+	*reply = s.bc.GetBestHeight()
+	return nil
+}
+
+// wallet returns the wallets of the node, ErrNoWallets without
+func (s *AdminService) wallet() (*Wallets, error) {
+	if s.wallets == nil {
+		return nil, ErrNoWallets
+	}
+
+	return s.wallets, nil
+}
+
+// ListAddresses returns the addresses of the wallets
+func (s *AdminService) ListAddresses(_ NoArgs, reply *[]string) error {
+	ws, err := s.wallet()
+	if err != nil {
+		return err
+	}
+
+	*reply = ws.GetAddresses()
+	return nil
+}
+
+// NewAddress returns a new receiving address of the wallets
+func (s *AdminService) NewAddress(_ NoArgs, reply *string) error {
+	ws, err := s.wallet()
+	if err != nil {
+		return err
+	}
+
+	address, err := ws.CreateWallet()
+	if err != nil {
+		return err
+	}
+
+	*reply = address
+	return nil
+}
+
+// GetWalletBalance returns the confirmed balance of an address of the wallets
+func (s *AdminService) GetWalletBalance(address string, reply *int) error {
+	ws, err := s.wallet()
+	if err != nil {
+		return err
+	}
+
+	balance, err := ws.GetBalance(address)
+	if err != nil {
+		return err
+	}
+
+	*reply = balance
+	return nil
+}
+
+// ListTransactionsArgs are the arguments of ListWalletTransactions
+type ListTransactionsArgs struct {
+	Address string
+	Limit   int
+	Offset  int
+}
+
+// ListWalletTransactions returns the history of an address of the wallets,
+// newest first
+func (s *AdminService) ListWalletTransactions(args ListTransactionsArgs, reply *[]WalletTx) error {
+	ws, err := s.wallet()
+	if err != nil {
+		return err
+	}
+
+	history, err := ws.ListTransactions(args.Address, args.Limit, args.Offset)
+	if err != nil {
+		return err
+	}
+
+	*reply = history
+	return nil
+}
+
+// SendArgs are the arguments of Send
+type SendArgs struct {
+	Payments []Payment
+	Fee      int
+}
+
+// Send pays from the wallets, submits the transaction to the mempool,
+// broadcasts it and returns its id
+func (s *AdminService) Send(args SendArgs, reply *string) error {
+	ws, err := s.wallet()
+	if err != nil {
+		return err
+	}
+
+	tx, err := ws.Send(args.Payments, args.Fee)
+	if err != nil {
+		return err
+	}
+
+	*reply = hex.EncodeToString(tx.ID)
+	return nil
+}
+
+// UnlockArgs are the arguments of UnlockWallet
+type UnlockArgs struct {
+	Passphrase string
+
+	// Seconds the wallets stay unlocked for, until LockWallet when zero
+	Seconds int
+}
+
+// UnlockWallet unlocks encrypted wallets for a while
+func (s *AdminService) UnlockWallet(args UnlockArgs, reply *bool) error {
+	ws, err := s.wallet()
+	if err != nil {
+		return err
+	}
+
+	if err = ws.Unlock(args.Passphrase, time.Duration(args.Seconds)*time.Second); err != nil {
+		return err
+	}
+
+	*reply = true
+	return nil
+}
+
+// LockWallet locks encrypted wallets
+func (s *AdminService) LockWallet(_ NoArgs, reply *bool) error {
+	ws, err := s.wallet()
+	if err != nil {
+		return err
+	}
+
+	ws.Lock()
+	*reply = ws.IsLocked()
+	return nil
+}
+
+// RPCServer serves JSON-RPC on TCP for read-only commands and on a Unix
+// control socket for all commands, the socket is protected by file
+// permissions and peer credentials
+type RPCServer struct {
+	public  *rpc.Server
+	private *rpc.Server
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewRPCServer creates an RPCServer, the wallet commands answer
+// ErrNoWallets when wallets is nil
+func NewRPCServer(bc *Blockchain, mempool *Mempool, wallets *Wallets) *RPCServer {
+	chain := &ChainService{bc: bc}
+	admin := &AdminService{bc: bc, mempool: mempool, wallets: wallets}
+
+	public := rpc.NewServer()
+	private := rpc.NewServer()
+
+	for _, err := range []error{
+		public.RegisterName(rpcChainService, chain),
+		private.RegisterName(rpcChainService, chain),
+		private.RegisterName(rpcAdminService, admin),
+	} {
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	return &RPCServer{public: public, private: private, conns: make(map[net.Conn]bool)}
+}
+
+// ListenTCP serves the read-only commands on a TCP address
+func (s *RPCServer) ListenTCP(addr string) error {
+	ln, err := net.Listen(protocol, addr)
+	if err != nil {
+		return err
+	}
+
+	s.serve(ln, s.public, nil)
+	return nil
+}
+
+// ListenUnix serves all commands on a Unix socket only the current user can
+// connect to. The socket is bound in a private directory and moved to path
+// once its mode is set, so no other user can connect in between. Peer
+// credentials are checked on Linux only, elsewhere the socket mode is the
+// only protection.
+func (s *RPCServer) ListenUnix(path string) error {
+	dir := filepath.Dir(path)
+	if err := checkSocketDir(dir); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	private, err := os.MkdirTemp(dir, ".rpc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(private)
+
+	bound := filepath.Join(private, "rpc.sock")
+	ln, err := net.Listen("unix", bound)
+	if err != nil {
+		return err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err = os.Chmod(bound, rpcSocketMode); err == nil {
+		err = os.Rename(bound, path)
+	}
+	if err != nil {
+		_ = ln.Close()
+		return err
+	}
+
+	s.serve(&unixListener{Listener: ln, path: path}, s.private, checkPeerCredentials)
+	return nil
+}
+
+// unixListener removes its socket file, bound under another name, when it
+// is closed
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+// Close closes the listener and removes the socket file
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	if rmErr := os.Remove(l.path); err == nil && rmErr != nil && !os.IsNotExist(rmErr) {
+		err = rmErr
+	}
+
+	return err
+}
+
+// serve accepts connections until the listener is closed, authorize may
+// reject a connection
+func (s *RPCServer) serve(ln net.Listener, server *rpc.Server, authorize func(net.Conn) error) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			if authorize != nil {
+				if err = authorize(conn); err != nil {
+					log.Printf("rpc connection is rejected: %s\n", err)
+					_ = conn.Close()
+					continue
+				}
+			}
+
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				_ = conn.Close()
+				continue
+			}
+			s.conns[conn] = true
+			s.wg.Add(1)
+			s.mu.Unlock()
+
+			go func() {
+				defer s.wg.Done()
+
+				server.ServeCodec(jsonrpc.NewServerCodec(conn))
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+		}
+	}()
+}
+
+// Close stops accepting RPC connections, closes the open ones and waits
+// for the calls being served
+func (s *RPCServer) Close() error {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.listeners, s.closed = nil, true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, ln := range listeners {
+		if err := ln.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	s.wg.Wait()
+	return firstErr
+}
+
+// DialUnixRPC connects a local client to the control socket
+func DialUnixRPC(path string) (*rpc.Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonrpc.NewClient(conn), nil
+}
+
+// checkSocketDir checks other users can't replace the socket in dir
+func checkSocketDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Perm()&0022 != 0 && info.Mode()&os.ModeSticky == 0 {
+		return fmt.Errorf("%w: %s", ErrInsecureSocketDir, dir)
+	}
+
+	return nil
+}
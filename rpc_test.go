@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/rpc/jsonrpc"
+	"path/filepath"
+	"testing"
+)
+
+// freeTCPAddress returns a free local TCP address
+func freeTCPAddress(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+// startRPCTestServer starts a server of a test blockchain serving JSON-RPC
+// on a TCP address and a control socket, with the wallets holding the
+// genesis wallet unless withWallets is false
+func startRPCTestServer(t *testing.T, withWallets bool) (s *Server, rpcAddr, socket string, genesis *Wallet) {
+	t.Helper()
+
+	bc, genesis := newTestBlockchain(t)
+
+	var ws *Wallets
+	if withWallets {
+		var err error
+		if ws, err = NewWallets("test"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ws.AddWallet(genesis); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rpcAddr, socket = freeTCPAddress(t), filepath.Join(t.TempDir(), "rpc.sock")
+	s = NewServer(ServerConfig{
+		NodeID:     "test",
+		Blockchain: bc,
+		Listeners:  []ListenerConfig{{Network: "tcp", Address: freeTCPAddress(t)}},
+		Seeds:      []string{},
+		RPCAddress: rpcAddr,
+		RPCSocket:  socket,
+		Wallets:    ws,
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+
+	return s, rpcAddr, socket, genesis
+}
+
+func TestServerServesWalletCommands(t *testing.T) {
+	s, _, socket, genesis := startRPCTestServer(t, true)
+	from := string(genesis.GetAddress())
+
+	client, err := DialUnixRPC(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var addresses []string
+	if err := client.Call("Admin.ListAddresses", NoArgs{}, &addresses); err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 1 || addresses[0] != from {
+		t.Fatalf("addresses %v, want [%s]", addresses, from)
+	}
+
+	var to string
+	if err := client.Call("Admin.NewAddress", NoArgs{}, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	var balance int
+	if err := client.Call("Admin.GetWalletBalance", from, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if subsidy := s.Blockchain().Params().Subsidy; balance != subsidy {
+		t.Fatalf("balance %d, want %d", balance, subsidy)
+	}
+
+	var txID string
+	args := SendArgs{Payments: []Payment{{Address: to, Amount: 3}}, Fee: 1}
+	if err := client.Call("Admin.Send", args, &txID); err != nil {
+		t.Fatal(err)
+	}
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.mempool.Has(id) {
+		t.Fatalf("sent transaction %s isn't in the mempool", txID)
+	}
+
+	var history []WalletTx
+	if err := client.Call("Admin.ListWalletTransactions", ListTransactionsArgs{Address: to}, &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || hex.EncodeToString(history[0].Tx.ID) != txID {
+		t.Fatalf("history of %s is %+v, want the sent transaction", to, history)
+	}
+}
+
+func TestServerServesOnlyChainCommandsOnTCP(t *testing.T) {
+	_, rpcAddr, _, _ := startRPCTestServer(t, true)
+
+	client, err := jsonrpc.Dial("tcp", rpcAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var height int
+	if err := client.Call("Chain.GetBestHeight", NoArgs{}, &height); err != nil {
+		t.Fatal(err)
+	}
+
+	var addresses []string
+	if err := client.Call("Admin.ListAddresses", NoArgs{}, &addresses); err == nil {
+		t.Fatalf("wallet addresses %v served on TCP", addresses)
+	}
+}
+
+func TestWalletCommandsWithoutWallets(t *testing.T) {
+	_, _, socket, _ := startRPCTestServer(t, false)
+
+	client, err := DialUnixRPC(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var addresses []string
+	if err := client.Call("Admin.ListAddresses", NoArgs{}, &addresses); err == nil || err.Error() != ErrNoWallets.Error() {
+		t.Fatalf("error %v, want %v", err, ErrNoWallets)
+	}
+}
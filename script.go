@@ -0,0 +1,651 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Script opcodes, values 0x01-0x4b push the next that many bytes
+const (
+	Op0                   = 0x00
+	OpPushData1           = 0x4c
+	OpPushData2           = 0x4d
+	Op1                   = 0x51
+	Op16                  = 0x60
+	OpVerify              = 0x69
+	OpReturn              = 0x6a
+	OpDrop                = 0x75
+	OpDup                 = 0x76
+	OpEqual               = 0x87
+	OpEqualVerify         = 0x88
+	OpSHA256              = 0xa8
+	OpHash160             = 0xa9
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultiSig       = 0xae
+	OpCheckMultiSigVerify = 0xaf
+	OpCheckLockTimeVerify = 0xb1
+	OpCheckSequenceVerify = 0xb2
+)
+
+const (
+	// maxScriptSize is the maximum size of a script
+	maxScriptSize = 10000
+
+	// maxScriptElementSize is the maximum size of a pushed element
+	maxScriptElementSize = 520
+
+	// maxScriptStackSize is the maximum number of elements on the stack
+	maxScriptStackSize = 1000
+
+	// maxScriptNumLen is the maximum size of a number operand
+	maxScriptNumLen = 5
+
+	// maxMultiSigKeys is the maximum number of public keys of OpCheckMultiSig
+	maxMultiSigKeys = 20
+)
+
+var (
+	// ErrScriptFailed is returned when a script doesn't validate
+	ErrScriptFailed = errors.New("script failed")
+
+	// ErrMalformedScript is returned for a script that can't be parsed
+	ErrMalformedScript = errors.New("malformed script")
+)
+
+// opcodeNames are the names of the opcodes in disassembled scripts
+var opcodeNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSHA256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+// scriptOp is a parsed script operation, data is set for pushes
+type scriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+// isPush returns whether the operation pushes data or a small number
+func (op scriptOp) isPush() bool {
+	return op.opcode <= OpPushData2 || (op.opcode >= Op1 && op.opcode <= Op16)
+}
+
+// parseScript splits a script into its operations
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: size %d", ErrMalformedScript, len(script))
+	}
+
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		size := 0
+		switch {
+		case opcode > Op0 && opcode < OpPushData1:
+			size = int(opcode)
+		case opcode == OpPushData1:
+			if i+1 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push length", ErrMalformedScript)
+			}
+			size = int(script[i])
+			i++
+		case opcode == OpPushData2:
+			if i+2 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push length", ErrMalformedScript)
+			}
+			size = int(script[i]) | int(script[i+1])<<8
+			i += 2
+		}
+
+		if i+size > len(script) {
+			return nil, fmt.Errorf("%w: push of %d bytes past the end", ErrMalformedScript, size)
+		}
+
+		op := scriptOp{opcode: opcode}
+		if opcode <= OpPushData2 {
+			op.data = script[i : i+size]
+		}
+		ops = append(ops, op)
+		i += size
+	}
+
+	return ops, nil
+}
+
+// ScriptBuilder builds scripts
+type ScriptBuilder struct {
+	script []byte
+}
+
+// NewScriptBuilder creates an empty ScriptBuilder
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+// AddOp appends an opcode
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// AddData appends a push of data with the smallest push operation
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch size := len(data); {
+	case size == 0:
+		b.script = append(b.script, Op0)
+	case size < OpPushData1:
+		b.script = append(b.script, byte(size))
+	case size <= 0xff:
+		b.script = append(b.script, OpPushData1, byte(size))
+	default:
+		b.script = append(b.script, OpPushData2, byte(size), byte(size>>8))
+	}
+
+	b.script = append(b.script, data...)
+	return b
+}
+
+// AddInt appends a push of a number, 1 to 16 use the small number opcodes
+func (b *ScriptBuilder) AddInt(n int64) *ScriptBuilder {
+	if n >= 1 && n <= 16 {
+		return b.AddOp(byte(Op1 - 1 + n))
+	}
+
+	return b.AddData(encodeScriptNum(n))
+}
+
+// Script returns the built script
+func (b *ScriptBuilder) Script() []byte {
+	return append([]byte{}, b.script...)
+}
+
+// PayToPubKeyHashScript returns the script locking an output to a public key hash
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OpDup).
+		AddOp(OpHash160).
+		AddData(pubKeyHash).
+		AddOp(OpEqualVerify).
+		AddOp(OpCheckSig).
+		Script()
+}
+
+// ExtractPubKeyHash returns the public key hash of a pay to public key hash
+// script, nil for other scripts
+func ExtractPubKeyHash(script []byte) []byte {
+	if len(script) == 25 && script[0] == OpDup && script[1] == OpHash160 && script[2] == 20 &&
+		script[23] == OpEqualVerify && script[24] == OpCheckSig {
+		return script[3:23]
+	}
+
+	return nil
+}
+
+// DisasmScript returns a human-readable representation of a script
+func DisasmScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[error: %s]", err)
+	}
+
+	var words []string
+	for _, op := range ops {
+		switch {
+		case op.opcode >= Op1 && op.opcode <= Op16:
+			words = append(words, fmt.Sprintf("OP_%d", op.opcode-Op1+1))
+		case op.opcode != Op0 && op.opcode <= OpPushData2:
+			words = append(words, hex.EncodeToString(op.data))
+		case opcodeNames[op.opcode] != "":
+			words = append(words, opcodeNames[op.opcode])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN%d", op.opcode))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// encodeScriptNum encodes a number as a minimal little endian sign and magnitude
+func encodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// decodeScriptNum decodes a number encoded by encodeScriptNum
+func decodeScriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, fmt.Errorf("%w: number of %d bytes", ErrScriptFailed, len(data))
+	}
+
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	if data[len(data)-1]&0x7f == 0 && (len(data) == 1 || data[len(data)-2]&0x80 == 0) {
+		return 0, fmt.Errorf("%w: number %x is not minimally encoded", ErrScriptFailed, data)
+	}
+
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << (8 * uint(i))
+	}
+
+	if data[len(data)-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * uint(len(data)-1))
+		return -n, nil
+	}
+
+	return n, nil
+}
+
+// castToBool returns the boolean value of a stack element
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+// scriptChecker checks the conditions of a script referring to the
+// transaction spending the output
+type scriptChecker interface {
+	// checkSig verifies a signature of the input with the script being executed
+	checkSig(sig, pubKey, script []byte) bool
+
+	// checkLockTime checks the transaction locktime is at least lockTime
+	checkLockTime(lockTime int64) bool
+
+	// checkSequence checks the input relative locktime is at least sequence
+	checkSequence(sequence int64) bool
+}
+
+// scriptStack is the stack of the script engine
+type scriptStack [][]byte
+
+// push pushes an element
+func (s *scriptStack) push(data []byte) error {
+	if len(*s) >= maxScriptStackSize {
+		return fmt.Errorf("%w: stack overflow", ErrScriptFailed)
+	}
+
+	*s = append(*s, data)
+	return nil
+}
+
+// pop pops the top element
+func (s *scriptStack) pop() ([]byte, error) {
+	if len(*s) == 0 {
+		return nil, fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+	}
+
+	top := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return top, nil
+}
+
+// peek returns the top element without popping it
+func (s *scriptStack) peek() ([]byte, error) {
+	if len(*s) == 0 {
+		return nil, fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+	}
+
+	return (*s)[len(*s)-1], nil
+}
+
+// popInt pops a number
+func (s *scriptStack) popInt() (int64, error) {
+	data, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeScriptNum(data, maxScriptNumLen)
+}
+
+// popBool pops a boolean
+func (s *scriptStack) popBool() (bool, error) {
+	data, err := s.pop()
+	if err != nil {
+		return false, err
+	}
+
+	return castToBool(data), nil
+}
+
+// scriptTrue is the element pushed for true
+var scriptTrue = []byte{1}
+
+// boolElement returns the element pushed for a boolean
+func boolElement(v bool) []byte {
+	if v {
+		return scriptTrue
+	}
+
+	return nil
+}
+
+// executeScript runs a script on the stack
+func executeScript(script []byte, stack *scriptStack, checker scriptChecker) error {
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if len(op.data) > maxScriptElementSize {
+			return fmt.Errorf("%w: push of %d bytes", ErrScriptFailed, len(op.data))
+		}
+
+		if err := executeOp(op, script, stack, checker); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// executeOp runs a single operation of script
+func executeOp(op scriptOp, script []byte, stack *scriptStack, checker scriptChecker) error {
+	switch {
+	case op.opcode <= OpPushData2:
+		return stack.push(op.data)
+	case op.opcode >= Op1 && op.opcode <= Op16:
+		return stack.push(encodeScriptNum(int64(op.opcode - Op1 + 1)))
+	}
+
+	switch op.opcode {
+	case OpVerify:
+		ok, err := stack.popBool()
+		if err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w: OP_VERIFY", ErrScriptFailed)
+		}
+
+	case OpReturn:
+		return fmt.Errorf("%w: OP_RETURN", ErrScriptFailed)
+
+	case OpDrop:
+		_, err := stack.pop()
+		return err
+
+	case OpDup:
+		top, err := stack.peek()
+		if err != nil {
+			return err
+		}
+		return stack.push(top)
+
+	case OpEqual, OpEqualVerify:
+		a, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		b, err := stack.pop()
+		if err != nil {
+			return err
+		}
+
+		if op.opcode == OpEqualVerify {
+			if !bytes.Equal(a, b) {
+				return fmt.Errorf("%w: OP_EQUALVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return stack.push(boolElement(bytes.Equal(a, b)))
+
+	case OpSHA256:
+		data, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		return stack.push(hash[:])
+
+	case OpHash160:
+		data, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		return stack.push(HashPubKey(data))
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		if err = checkSignatureEncoding(sig); err != nil {
+			return fmt.Errorf("%w: %s", ErrScriptFailed, err)
+		}
+
+		ok := checker.checkSig(sig, pubKey, script)
+		if op.opcode == OpCheckSigVerify {
+			if !ok {
+				return fmt.Errorf("%w: OP_CHECKSIGVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return stack.push(boolElement(ok))
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		ok, err := checkMultiSig(stack, script, checker)
+		if err != nil {
+			return err
+		}
+
+		if op.opcode == OpCheckMultiSigVerify {
+			if !ok {
+				return fmt.Errorf("%w: OP_CHECKMULTISIGVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return stack.push(boolElement(ok))
+
+	case OpCheckLockTimeVerify:
+		top, err := stack.peek()
+		if err != nil {
+			return err
+		}
+		lockTime, err := decodeScriptNum(top, maxScriptNumLen)
+		if err != nil {
+			return err
+		}
+
+		if lockTime < 0 || !checker.checkLockTime(lockTime) {
+			return fmt.Errorf("%w: OP_CHECKLOCKTIMEVERIFY %d", ErrScriptFailed, lockTime)
+		}
+
+	case OpCheckSequenceVerify:
+		top, err := stack.peek()
+		if err != nil {
+			return err
+		}
+		sequence, err := decodeScriptNum(top, maxScriptNumLen)
+		if err != nil {
+			return err
+		}
+
+		if sequence < 0 || (sequence&SequenceLockTimeDisabled == 0 && !checker.checkSequence(sequence)) {
+			return fmt.Errorf("%w: OP_CHECKSEQUENCEVERIFY %d", ErrScriptFailed, sequence)
+		}
+
+	default:
+		return fmt.Errorf("%w: unknown opcode %#x", ErrScriptFailed, op.opcode)
+	}
+
+	return nil
+}
+
+// checkMultiSig pops the operands of OpCheckMultiSig and returns whether
+// the signatures match public keys in order
+func checkMultiSig(stack *scriptStack, script []byte, checker scriptChecker) (bool, error) {
+	keyCount, err := stack.popInt()
+	if err != nil {
+		return false, err
+	} else if keyCount < 0 || keyCount > maxMultiSigKeys {
+		return false, fmt.Errorf("%w: %d public keys", ErrScriptFailed, keyCount)
+	}
+
+	pubKeys := make([][]byte, keyCount)
+	for i := range pubKeys {
+		if pubKeys[i], err = stack.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	sigCount, err := stack.popInt()
+	if err != nil {
+		return false, err
+	} else if sigCount < 0 || sigCount > keyCount {
+		return false, fmt.Errorf("%w: %d signatures for %d public keys", ErrScriptFailed, sigCount, keyCount)
+	}
+
+	sigs := make([][]byte, sigCount)
+	for i := range sigs {
+		if sigs[i], err = stack.pop(); err != nil {
+			return false, err
+		}
+		if err = checkSignatureEncoding(sigs[i]); err != nil {
+			return false, fmt.Errorf("%w: %s", ErrScriptFailed, err)
+		}
+	}
+
+	// the dummy element consumed by the original implementation must be empty
+	dummy, err := stack.pop()
+	if err != nil {
+		return false, err
+	} else if len(dummy) != 0 {
+		return false, fmt.Errorf("%w: OP_CHECKMULTISIG dummy is not empty", ErrScriptFailed)
+	}
+
+	// keys and signatures were popped in reverse, match them from the last one
+	key := 0
+	for _, sig := range sigs {
+		for key < len(pubKeys) && !checker.checkSig(sig, pubKeys[key], script) {
+			key++
+		}
+
+		if key == len(pubKeys) {
+			return false, nil
+		}
+		key++
+	}
+
+	return true, nil
+}
+
+// isPushOnly returns whether a script only pushes data
+func isPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+
+	for _, op := range ops {
+		if !op.isPush() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VerifyScript runs the unlocking script of an input then the locking
+// script of the output it spends, it succeeds when the stack ends with true.
+// For pay to script hash outputs the redeem script, the last element pushed
+// by the unlocking script, then runs on the other elements.
+func VerifyScript(scriptSig, scriptPubKey []byte, checker scriptChecker) error {
+	if !isPushOnly(scriptSig) {
+		return fmt.Errorf("%w: unlocking script is not push only", ErrScriptFailed)
+	}
+
+	var stack scriptStack
+	if err := executeScript(scriptSig, &stack, checker); err != nil {
+		return err
+	}
+	redeemStack := append(scriptStack{}, stack...)
+
+	if err := executeScript(scriptPubKey, &stack, checker); err != nil {
+		return err
+	}
+
+	if err := checkStackTrue(&stack); err != nil {
+		return err
+	}
+
+	if ExtractScriptHash(scriptPubKey) == nil {
+		return nil
+	}
+
+	redeem, err := redeemStack.pop()
+	if err != nil {
+		return err
+	}
+
+	if err := executeScript(redeem, &redeemStack, checker); err != nil {
+		return err
+	}
+
+	return checkStackTrue(&redeemStack)
+}
+
+// checkStackTrue checks the script ended with true on the stack
+func checkStackTrue(stack *scriptStack) error {
+	if ok, err := stack.popBool(); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%w: stack ends with false", ErrScriptFailed)
+	}
+
+	return nil
+}
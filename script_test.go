@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// stubChecker is a scriptChecker accepting the signatures of its keys, and
+// the locktimes and sequences up to its own
+type stubChecker struct {
+	// keys maps the signatures to the public key each is valid for
+	keys     map[string][]byte
+	lockTime int64
+	sequence int64
+}
+
+// checkSig returns whether the signature is valid for the public key
+func (c *stubChecker) checkSig(sig, pubKey, _ []byte) bool {
+	key, ok := c.keys[string(sig)]
+	return ok && bytes.Equal(key, pubKey)
+}
+
+// checkLockTime returns whether the locktime is reached
+func (c *stubChecker) checkLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+// checkSequence returns whether the relative locktime is reached
+func (c *stubChecker) checkSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+// testSig returns a strictly DER encoded signature followed by SigHashAll,
+// distinct for each n
+func testSig(n int64) []byte {
+	return append(encodeDERSignature(big.NewInt(n), big.NewInt(1)), byte(SigHashAll))
+}
+
+func TestVerifyScript(t *testing.T) {
+	pubKey, otherKey := bytes.Repeat([]byte{2}, 33), bytes.Repeat([]byte{3}, 33)
+	sig := testSig(1)
+	checker := &stubChecker{keys: map[string][]byte{string(sig): pubKey}, lockTime: 100, sequence: 10}
+
+	secret := []byte("secret")
+	secretHash := sha256.Sum256(secret)
+	hashLock := NewScriptBuilder().AddOp(OpSHA256).AddData(secretHash[:]).AddOp(OpEqual).Script()
+	p2pkh := PayToPubKeyHashScript(HashPubKey(pubKey))
+
+	lockTimeScript := func(n int64) []byte {
+		return NewScriptBuilder().AddInt(n).AddOp(OpCheckLockTimeVerify).AddOp(OpDrop).AddOp(Op1).Script()
+	}
+	sequenceScript := func(n int64) []byte {
+		return NewScriptBuilder().AddInt(n).AddOp(OpCheckSequenceVerify).AddOp(OpDrop).AddOp(Op1).Script()
+	}
+
+	redeem := NewScriptBuilder().AddData(pubKey).AddOp(OpCheckSig).Script()
+	p2sh := PayToScriptHashScript(HashPubKey(redeem))
+
+	tests := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+		err          error
+	}{
+		{"true", nil, []byte{Op1}, nil},
+		{"equal numbers", NewScriptBuilder().AddInt(7).Script(), NewScriptBuilder().AddInt(7).AddOp(OpEqual).Script(), nil},
+		{"hash preimage", NewScriptBuilder().AddData(secret).Script(), hashLock, nil},
+		{"wrong hash preimage", NewScriptBuilder().AddData([]byte("guess")).Script(), hashLock, ErrScriptFailed},
+		{"pay to public key hash", NewScriptBuilder().AddData(sig).AddData(pubKey).Script(), p2pkh, nil},
+		{"other public key", NewScriptBuilder().AddData(sig).AddData(otherKey).Script(), p2pkh, ErrScriptFailed},
+		{"invalid signature", NewScriptBuilder().AddData(testSig(2)).AddData(pubKey).Script(), p2pkh, ErrScriptFailed},
+		{"empty signature", NewScriptBuilder().AddData(nil).AddData(pubKey).Script(), p2pkh, ErrScriptFailed},
+		{"signature not DER", NewScriptBuilder().AddData([]byte{0x30, 0x01, 0x02, byte(SigHashAll)}).AddData(pubKey).Script(), p2pkh, ErrScriptFailed},
+		{"unlocking script not push only", []byte{Op1, OpDup}, []byte{OpEqual}, ErrScriptFailed},
+		{"OP_RETURN", nil, []byte{Op1, OpReturn}, ErrScriptFailed},
+		{"OP_VERIFY of false", nil, []byte{Op0, OpVerify, Op1}, ErrScriptFailed},
+		{"ends with false", nil, []byte{Op0}, ErrScriptFailed},
+		{"ends with negative zero", NewScriptBuilder().AddData([]byte{0x80}).Script(), nil, ErrScriptFailed},
+		{"empty stack", nil, nil, ErrScriptFailed},
+		{"stack underflow", nil, []byte{OpDup}, ErrScriptFailed},
+		{"unknown opcode", nil, []byte{Op1, 0xff}, ErrScriptFailed},
+		{"truncated push", nil, []byte{0x05, 0x01, 0x02}, ErrMalformedScript},
+		{"truncated push length", nil, []byte{OpPushData2, 0x01}, ErrMalformedScript},
+		{"oversized element", nil, NewScriptBuilder().AddData(make([]byte, maxScriptElementSize+1)).AddOp(OpDrop).AddOp(Op1).Script(), ErrScriptFailed},
+		{"oversized script", nil, make([]byte, maxScriptSize+1), ErrMalformedScript},
+		{"locktime reached", nil, lockTimeScript(100), nil},
+		{"locktime not reached", nil, lockTimeScript(101), ErrScriptFailed},
+		{"negative locktime", nil, lockTimeScript(-1), ErrScriptFailed},
+		{"sequence reached", nil, sequenceScript(10), nil},
+		{"sequence not reached", nil, sequenceScript(11), ErrScriptFailed},
+		{"sequence lock disabled", nil, sequenceScript(SequenceLockTimeDisabled | 11), nil},
+		{"pay to script hash", NewScriptBuilder().AddData(sig).AddData(redeem).Script(), p2sh, nil},
+		{"script hash of another redeem script", NewScriptBuilder().AddData(sig).AddData([]byte{Op1}).Script(), p2sh, ErrScriptFailed},
+		{"redeem script fails", NewScriptBuilder().AddData(testSig(2)).AddData(redeem).Script(), p2sh, ErrScriptFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyScript(test.scriptSig, test.scriptPubKey, checker)
+			if test.err == nil && err != nil {
+				t.Fatalf("script failed: %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestScriptNum(t *testing.T) {
+	tests := []struct {
+		n       int64
+		encoded []byte
+	}{
+		{0, nil},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{1 << 31, []byte{0x00, 0x00, 0x00, 0x80, 0x00}},
+	}
+
+	for _, test := range tests {
+		if encoded := encodeScriptNum(test.n); !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("encoding of %d is %x, want %x", test.n, encoded, test.encoded)
+		}
+
+		if n, err := decodeScriptNum(test.encoded, maxScriptNumLen); err != nil || n != test.n {
+			t.Errorf("decoding of %x is %d %v, want %d", test.encoded, n, err, test.n)
+		}
+	}
+
+	for _, data := range [][]byte{{0x00}, {0x01, 0x00}, {0x01, 0x80}, {0x01, 0x02, 0x03, 0x04, 0x05, 0x06}} {
+		if n, err := decodeScriptNum(data, maxScriptNumLen); err == nil {
+			t.Errorf("%x decoded to %d, want an error", data, n)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package blockchain
+
+import "fmt"
+
+// ScriptType is the standard type of a locking script
+type ScriptType int
+
+const (
+	// ScriptTypeNonStandard is a script of no standard type
+	ScriptTypeNonStandard ScriptType = iota
+
+	// ScriptTypePubKeyHash pays to a public key hash
+	ScriptTypePubKeyHash
+
+	// ScriptTypeScriptHash pays to a redeem script hash
+	ScriptTypeScriptHash
+
+	// ScriptTypeMultiSig pays to M of N public keys
+	ScriptTypeMultiSig
+
+	// ScriptTypeNullData is an unspendable data output
+	ScriptTypeNullData
+)
+
+// scriptTypeNames are the names of the script types
+var scriptTypeNames = map[ScriptType]string{
+	ScriptTypeNonStandard: "nonstandard",
+	ScriptTypePubKeyHash:  "pubkeyhash",
+	ScriptTypeScriptHash:  "scripthash",
+	ScriptTypeMultiSig:    "multisig",
+	ScriptTypeNullData:    "nulldata",
+}
+
+// String returns the name of the script type
+func (t ScriptType) String() string {
+	if name, ok := scriptTypeNames[t]; ok {
+		return name
+	}
+
+	return scriptTypeNames[ScriptTypeNonStandard]
+}
+
+// MarshalText encodes the script type as its name, e.g. in JSON
+func (t ScriptType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a script type from its name
+func (t *ScriptType) UnmarshalText(text []byte) error {
+	for scriptType, name := range scriptTypeNames {
+		if name == string(text) {
+			*t = scriptType
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown script type %q", text)
+}
+
+// ClassifyOutput returns the script type of an output
+func ClassifyOutput(out TXOutput) ScriptType {
+	script := out.ScriptPubKey
+
+	switch {
+	case ExtractPubKeyHash(script) != nil:
+		return ScriptTypePubKeyHash
+	case ExtractScriptHash(script) != nil:
+		return ScriptTypeScriptHash
+	case isMultiSig(script):
+		return ScriptTypeMultiSig
+	case isNullData(script):
+		return ScriptTypeNullData
+	default:
+		return ScriptTypeNonStandard
+	}
+}
+
+// isMultiSig returns whether the script is a multisig script
+func isMultiSig(script []byte) bool {
+	_, _, ok := ExtractMultiSig(script)
+	return ok
+}
+
+// isNullData returns whether the script is a data output script
+func isNullData(script []byte) bool {
+	_, ok := ExtractNullData(script)
+	return ok
+}
+
+// OutputAddress returns the address an output pays to, empty for outputs
+// not paying to a public key hash or a script hash
+func OutputAddress(out TXOutput) string {
+	if hash := ExtractPubKeyHash(out.ScriptPubKey); hash != nil {
+		return string(encodeAddress(version, hash))
+	}
+
+	if hash := ExtractScriptHash(out.ScriptPubKey); hash != nil {
+		return string(encodeAddress(scriptHashVersion, hash))
+	}
+
+	return ""
+}
@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"net"
+)
+
+// CommandSendHeaders send headers, new blocks are announced to the sender
+// with CommandHeaders instead of an inventory
+const CommandSendHeaders = "sendheaders"
+
+type sendHeadersData struct {
+	AddrFrom string
+}
+
+// handleSendHeaders handles CommandSendHeaders request
+func (s *Server) handleSendHeaders(p *peerConn, request []byte) {
+	var payload sendHeadersData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	s.manager.setSendHeaders(p)
+}
+
+// announceBlock announces a new block to the peers but the one it came
+// from, with its header to the peers in sendheaders mode and with an
+// inventory to the others. The inventory is published once when the
+// transport relays inventories, see relayInventories.
+func (s *Server) announceBlock(block *Block, from *peerConn) {
+	headers := HeadersMessage{AddrFrom: s.address, Headers: []BlockHeader{block.Header()}}
+	inv := invData{AddrFrom: s.address, Type: CommandGetDataTypeBlock, Items: [][]byte{block.Hash}}
+
+	if s.relay != nil {
+		if err := s.relay.PublishInv(EncodeMessage(CommandInv, inv)); err != nil {
+			log.Printf("publishing the inventory of block %x failed: %s\n", block.Hash, err)
+		}
+		return
+	}
+
+	for _, p := range s.manager.ready() {
+		switch {
+		case p == from:
+		case s.manager.sendsHeaders(p):
+			p.send(CommandHeaders, headers)
+		default:
+			p.send(CommandInv, inv)
+		}
+	}
+}
+
+// relayInventories handles the inventories other nodes publish to the
+// relay of the transport until done is closed. The blocks are requested
+// from the node which published them, inventories of nodes which aren't
+// peers are skipped as the peers publish theirs once they have the blocks.
+func (s *Server) relayInventories(done <-chan struct{}) {
+	err := s.relay.SubscribeInv(done, func(inv []byte, from func(net.Conn) bool) {
+		for _, p := range s.manager.ready() {
+			if !from(p.conn) {
+				continue
+			}
+
+			if command, err := MessageCommand(inv); err != nil || command != CommandInv {
+				s.malformed(p, inv, fmt.Errorf("%w: relayed %q isn't an inventory", ErrMalformedMessage, command))
+				return
+			}
+
+			s.handleMessage(p, inv)
+			return
+		}
+	})
+	if err != nil {
+		log.Printf("relaying inventories failed: %s\n", err)
+	}
+}
+
+// handleHeaders handles CommandHeaders request, the headers pushed by a
+// peer in sendheaders mode. The blocks of the headers which aren't in the
+// chain yet are requested once their proof of work is checked, the chain
+// is synced with the peer when they don't connect to a known block.
+func (s *Server) handleHeaders(p *peerConn, request []byte) {
+	var payload HeadersMessage
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	var missing [][]byte
+	for _, header := range payload.Headers {
+		if _, err := s.bc.GetBlock(header.Hash); err == nil {
+			continue
+		}
+
+		if err := header.Validate(s.bc.Params().TargetBits); err != nil {
+			s.misbehaving(p, banThreshold, err.Error())
+			return
+		}
+
+		if len(missing) == 0 {
+			if _, err := s.bc.GetBlock(header.PrevBlockHash); err != nil {
+				log.Printf("Headers from %s don't connect, syncing the chain\n", p)
+				p.send(CommandGetBlocks, getBlocksData{AddrFrom: s.address})
+				return
+			}
+		}
+
+		missing = append(missing, header.Hash)
+	}
+
+	s.requestBlocks(p, missing)
+}
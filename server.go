@@ -2,24 +2,36 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const (
-	// CommandVersion version
+	// CommandVersion version, the first message of the handshake
 	CommandVersion = "version"
 
+	// CommandVerack version acknowledgement, completing the handshake
+	CommandVerack = "verack"
+
 	// CommandBlock block
 	CommandBlock = "block"
 
-	// CommandAddr address
+	// CommandAddr address records of known nodes
 	CommandAddr = "addr"
 
+	// CommandGetAddr get address records, answered with CommandAddr
+	CommandGetAddr = "getaddr"
+
 	// CommandInv invite
 	CommandInv = "inv"
 
@@ -31,40 +43,417 @@ const (
 
 	// CommandTx transaction
 	CommandTx = "tx"
+
+	// CommandGetUTXOProof get utxo proofs
+	CommandGetUTXOProof = "getutxoproof"
+
+	// CommandUTXOProof utxo proofs
+	CommandUTXOProof = "utxoproof"
+
+	// CommandNotFound not found, the answer to getdata for unknown items
+	CommandNotFound = "notfound"
 )
 
 const (
+	// CommandGetDataTypeBlock is the inventory type of blocks
 	CommandGetDataTypeBlock = "block"
 
 	CommandGetDataTypeData = "data"
+
+	// CommandGetDataTypeTx is the inventory type of transactions
+	CommandGetDataTypeTx = "tx"
 )
 
 const (
 	// protocol is server protocol
 	protocol = "tcp"
 
-	nodeVersion = 1
+	// nodeVersion is the protocol version of the node
+	nodeVersion = 2
 
 	// commandLength is the length for command
 	commandLength = 12
+
+	// minMiningTxs is the number of mempool transactions a mining node waits for
+	minMiningTxs = 2
+
+	// DefaultSeedNode is the seed of nodes configured without seeds
+	DefaultSeedNode = "localhost:3000"
 )
 
 var (
-	// nodeAddress is the address of node
-	nodeAddress string
-
-	// miningAddress is the address for mining
-	miningAddress string
+	// ErrServerStarted is returned when a started server is started again
+	ErrServerStarted = errors.New("server already started")
 
-	// knownNodes is a list of known nodes
-	knownNodes = []string{"localhost:3000"}
+	// ErrServerStopped is returned when a stopped server is started
+	ErrServerStopped = errors.New("server stopped")
 
-	// blocksInTransit stores block data in transit
-	blocksInTransit = [][]byte{}
+	// ErrTransportConflict is returned when a server is configured with
+	// more than one of TLS, Noise and a Transport, or with port mapping and
+	// a Transport not naming nodes by host:port
+	ErrTransportConflict = errors.New("TLS, Noise and Transport are exclusive")
 )
 
+// ServerConfig configures a Server
+type ServerConfig struct {
+	// NodeID names the chain database and the default listener
+	NodeID string
+
+	// MinerAddress receives the rewards of the blocks the node mines, the
+	// node doesn't mine when it is empty
+	MinerAddress string
+
+	// Listeners are the endpoints the node serves peers on, e.g. to bind
+	// 0.0.0.0:3000 to accept nodes of other machines. DefaultListeners(NodeID)
+	// when empty.
+	Listeners []ListenerConfig
+
+	// ExternalAddress is the host:port advertised to other nodes in the
+	// version and addr messages, e.g. the public address behind a NAT. The address of
+	// the first TCP listener when empty, with the peer id of the node for
+	// a Transport naming nodes by multiaddrs, see Addresser.
+	ExternalAddress string
+
+	// Seeds are the nodes known at start, outbound peers are dialed from
+	// them. []string{DefaultSeedNode} when nil.
+	Seeds []string
+
+	// DNSSeeds are host:port names resolved at start, every address of a
+	// name becomes a known node with the port
+	DNSSeeds []string
+
+	// Blockchain is the chain of the node, NewBlockchain(NodeID) when nil
+	Blockchain *Blockchain
+
+	// MaxInbound and MaxOutbound limit the peers connecting to the node and
+	// those it connects to, DefaultMaxInbound and DefaultMaxOutbound when zero
+	MaxInbound  int
+	MaxOutbound int
+
+	// TargetOutbound is the number of outbound peers the node dials known
+	// nodes to keep, MaxOutbound when zero
+	TargetOutbound int
+
+	// UserAgent identifies the software of the node in its version,
+	// DefaultUserAgent when empty
+	UserAgent string
+
+	// TLS encrypts the peer connections when set, the TCP listeners only
+	// accept TLS and the peers are dialed with it. Unix socket listeners
+	// stay cleartext as their peers are local. See NewPeerTLSConfig.
+	TLS *tls.Config
+
+	// Noise encrypts the peer connections with the Noise XX handshake and
+	// authenticates the peers by their node identities, without a CA. Like
+	// TLS it applies to the TCP listeners and the dialed peers, the two are
+	// exclusive.
+	Noise bool
+
+	// Identity is the identity of the node, LoadNodeIdentity(NodeID) when
+	// nil and Noise is set
+	Identity *NodeIdentity
+
+	// DialPolicy configures the dial timeout, the retries and the backoff
+	// of the outbound peers, DefaultDialPolicy when nil
+	DialPolicy *DialPolicy
+
+	// RateLimit limits the messages of each peer, DefaultPeerRateLimit
+	// when nil. Peers of listeners with ListenerPolicy.RateLimitExempt
+	// aren't limited.
+	RateLimit *PeerRateLimit
+
+	// Transport carries the peer connections instead of the built in
+	// transport when set, it opens the Listeners and dials the peers, e.g.
+	// the libp2p transport of package p2p
+	Transport Transport
+
+	// PortMapping maps the port of the first TCP listener on the router
+	// with NAT-PMP or UPnP at start, so the node accepts inbound peers
+	// behind a NAT. The mapping is renewed until Stop deletes it, and the
+	// external address of the router is advertised unless ExternalAddress
+	// is set.
+	PortMapping bool
+
+	// PortMapper is the router of PortMapping, DiscoverPortMapper when nil
+	PortMapper PortMapper
+
+	// InboundAllowlist restricts the inbound peers of the TCP and WebSocket
+	// listeners to the IP addresses and CIDR ranges listed, e.g.
+	// "10.1.0.0/16" or "192.0.2.7". Every peer is accepted when empty, the
+	// peers of Unix sockets are local and always accepted.
+	InboundAllowlist []string
+
+	// ConnectOnly makes a private node, e.g. of a consortium deployment. It
+	// opens no listener, advertises no address and only dials the Seeds,
+	// which stay known after failed dials.
+	ConnectOnly bool
+
+	// RPCAddress is the TCP address the read-only JSON-RPC commands are
+	// served on, e.g. "127.0.0.1:8332", none when empty
+	RPCAddress string
+
+	// RPCSocket is the path of the Unix control socket serving all the
+	// JSON-RPC commands, see RPCServer.ListenUnix, none when empty
+	RPCSocket string
+
+	// Wallets are served by the wallet commands of the control socket, they
+	// are connected to the chain and the mempool of the node and broadcast
+	// to its peers unless they have a broadcast of their own
+	Wallets *Wallets
+}
+
+// Server is a node of the network, it relays blocks and transactions with
+// its peers and mines when it has a miner address. Several servers
+// can run in one process.
+type Server struct {
+	cfg     ServerConfig
+	address string
+	bc      *Blockchain
+	mempool *Mempool
+	miner   *Miner
+	peers   *peerRegistry
+	manager *PeerManager
+
+	// nonce is sent in the versions of the node to detect connections to itself
+	nonce uint64
+
+	// noise is the static key of the Noise handshakes, nil without Noise
+	noise *noiseKey
+
+	// transport opens the listeners and dials the peers, relay is the
+	// transport when it relays inventories
+	transport Transport
+	relay     InvRelay
+
+	// rpc serves the JSON-RPC commands, nil without RPCAddress nor RPCSocket
+	rpc *RPCServer
+
+	// advertiseMapping is whether the address of the port mapping is
+	// advertised, portMapping is the mapping made by Start
+	advertiseMapping bool
+	portMapping      *portMapping
+
+	// allowlist are the networks of the inbound peers, set by Start from
+	// ServerConfig.InboundAllowlist
+	allowlist []*net.IPNet
+
+	// dialRequests wakes the dialing of outbound peers up
+	dialRequests chan struct{}
+
+	// miningRequests wakes the miner loop up when transactions were added
+	miningRequests chan struct{}
+
+	// mu guards knownNodes, selfAddrs, blocksInTransit and feeFilter
+	mu sync.Mutex
+
+	// knownNodes are the known nodes and their connection stats, outbound
+	// peers are dialed from them
+	knownNodes []KnownAddress
+
+	// selfAddrs are the addresses the node connected to itself at
+	selfAddrs map[string]bool
+
+	// blocksInTransit stores the hashes of the blocks requested one at a time
+	blocksInTransit [][]byte
+
+	// feeFilter is the minimum relay fee rate last announced to the peers
+	feeFilter float64
+
+	// runMu guards started, stopping, listeners, rpc, portMapping and the
+	// address set by Start
+	runMu     sync.Mutex
+	started   bool
+	stopping  bool
+	listeners []net.Listener
+
+	// wg counts the goroutines of the loops and the peer connections, Stop
+	// waits for them after closing done
+	wg   sync.WaitGroup
+	done chan struct{}
+
+	// errs receives the errors of failing listeners, stopped is closed when
+	// Stop completes
+	errs     chan error
+	stopped  chan struct{}
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// NewServer creates a server, its chain is opened and its mempool created
+// but it doesn't serve peers until Run
+func NewServer(cfg ServerConfig) *Server {
+	if len(cfg.Listeners) == 0 && !cfg.ConnectOnly {
+		cfg.Listeners = DefaultListeners(cfg.NodeID)
+	}
+
+	if cfg.Blockchain == nil {
+		cfg.Blockchain = NewBlockchain(cfg.NodeID)
+	}
+
+	if cfg.ConnectOnly {
+		cfg.Listeners, cfg.ExternalAddress, cfg.PortMapping = nil, "", false
+	}
+
+	advertiseMapping := cfg.PortMapping && cfg.ExternalAddress == ""
+	if cfg.ExternalAddress == "" {
+		cfg.ExternalAddress = advertisedAddress(cfg.Listeners)
+		if addresser, ok := cfg.Transport.(Addresser); ok && cfg.ExternalAddress != "" {
+			cfg.ExternalAddress = addresser.AdvertisedAddress(cfg.ExternalAddress)
+		}
+	}
+
+	if cfg.Seeds == nil {
+		cfg.Seeds = []string{DefaultSeedNode}
+	}
+
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent
+	}
+
+	if cfg.MaxInbound == 0 {
+		cfg.MaxInbound = DefaultMaxInbound
+	}
+
+	if cfg.MaxOutbound == 0 {
+		cfg.MaxOutbound = DefaultMaxOutbound
+	}
+
+	if cfg.TargetOutbound == 0 || cfg.TargetOutbound > cfg.MaxOutbound {
+		cfg.TargetOutbound = cfg.MaxOutbound
+	}
+
+	if cfg.RateLimit == nil {
+		limit := DefaultPeerRateLimit
+		cfg.RateLimit = &limit
+	}
+
+	if cfg.DialPolicy == nil {
+		policy := DefaultDialPolicy
+		cfg.DialPolicy = &policy
+	}
+
+	if cfg.Noise && cfg.Identity == nil {
+		identity, err := LoadNodeIdentity(cfg.NodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		cfg.Identity = identity
+	}
+
+	s := &Server{
+		cfg:            cfg,
+		address:        cfg.ExternalAddress,
+		bc:             cfg.Blockchain,
+		mempool:        NewMempool(cfg.Blockchain),
+		manager:        newPeerManager(cfg.MaxInbound, cfg.MaxOutbound),
+		nonce:          newNonce(),
+		selfAddrs:      make(map[string]bool),
+		dialRequests:   make(chan struct{}, 1),
+		miningRequests: make(chan struct{}, 1),
+		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	s.feeFilter = s.mempool.Policy().MinRelayFeeRate
+	s.advertiseMapping = advertiseMapping
+	if cfg.Noise {
+		key, err := newNoiseKey(cfg.Identity)
+		if err != nil {
+			log.Panic(err)
+		}
+		s.noise = key
+	}
+
+	s.transport = cfg.Transport
+	if s.transport == nil {
+		s.transport = streamTransport{tls: cfg.TLS, noise: s.noise}
+	}
+	s.relay, _ = s.transport.(InvRelay)
+
+	for _, seed := range cfg.Seeds {
+		if seed != s.address {
+			s.knownNodes = append(s.knownNodes, KnownAddress{NetAddress: NetAddress{Addr: seed}})
+		}
+	}
+
+	saved, err := s.bc.loadPeers()
+	if err != nil {
+		log.Printf("loading the peer database failed: %s\n", err)
+	}
+	for _, node := range saved {
+		if node.Addr != s.address && knownNodeIndex(s.knownNodes, node.Addr) < 0 {
+			s.knownNodes = append(s.knownNodes, node)
+		}
+	}
+	s.peers = newPeerRegistry(s.address)
+
+	if cfg.MinerAddress != "" {
+		s.miner = NewMiner(s.bc, s.mempool, cfg.MinerAddress, s.broadcastBlock)
+	}
+
+	if cfg.Wallets != nil {
+		cfg.Wallets.Connect(s.bc, s.mempool)
+		cfg.Wallets.mu.Lock()
+		if cfg.Wallets.broadcast == nil {
+			cfg.Wallets.broadcast = s.BroadcastTransaction
+		}
+		cfg.Wallets.mu.Unlock()
+	}
+
+	return s
+}
+
+// Address returns the address advertised to other nodes, the external
+// address of the port mapping once started with PortMapping
+func (s *Server) Address() string {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	return s.address
+}
+
+// Blockchain returns the chain of the node
+func (s *Server) Blockchain() *Blockchain {
+	return s.bc
+}
+
+// Mempool returns the transactions waiting to be mined
+func (s *Server) Mempool() *Mempool {
+	return s.mempool
+}
+
+// PeerManager returns the manager of the connected peers
+func (s *Server) PeerManager() *PeerManager {
+	return s.manager
+}
+
+// KnownNodes returns the nodes outbound peers are dialed from
+func (s *Server) KnownNodes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := make([]string, 0, len(s.knownNodes))
+	for _, node := range s.knownNodes {
+		nodes = append(nodes, node.Addr)
+	}
+
+	return nodes
+}
+
+// KnownAddresses returns the known nodes and their connection stats
+func (s *Server) KnownAddresses() []KnownAddress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]KnownAddress{}, s.knownNodes...)
+}
+
 type addrData struct {
-	AddrList []string
+	AddrFrom string
+	AddrList []NetAddress
+}
+
+type getAddrData struct {
+	AddrFrom string
 }
 
 type blockData struct {
@@ -74,20 +463,57 @@ type blockData struct {
 
 type versionData struct {
 	Version    int
+	Services   ServiceFlag
+	UserAgent  string
 	BestHeight int
+	Nonce      uint64
 	AddrFrom   string
 }
 
+type verackData struct {
+	AddrFrom string
+}
+
 type getBlocksData struct {
 	AddrFrom string
 }
 
+type invData struct {
+	AddrFrom string
+	Type     string
+	Items    [][]byte
+}
+
+type txData struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+type getUTXOProofData struct {
+	AddrFrom string
+	TxIDs    [][]byte
+}
+
+type utxoProofData struct {
+	AddrFrom   string
+	Tip        []byte
+	Commitment []byte
+	Proofs     []UTXOProof
+	Error      string
+}
+
 type getDataData struct {
 	AddrFrom string
 	Type     string
 	ID       []byte
 }
 
+type notFoundData struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
 // commandToBytes converts command string to bytes
 func commandToBytes(command string) []byte {
 	var byteArr [commandLength]byte
@@ -112,214 +538,872 @@ func bytesToCommand(bytes []byte) string {
 	return string(command)
 }
 
-func sendCommandAndPayload(addr, command string, data interface{}) {
-	payload := gobEncode(data)
-	request := append(commandToBytes(command), payload...)
+// maintainPeers resolves the DNS seeds, then dials known nodes whenever
+// the node has fewer outbound peers than its target. Every peerDialInterval
+// it saves the peer database and announces the minimum relay fee rate when
+// the mempool policy changed it, until done is closed.
+func (s *Server) maintainPeers(done <-chan struct{}) {
+	s.resolveDNSSeeds()
 
-	sendData(addr, request)
+	ticker := time.NewTicker(peerDialInterval)
+	defer ticker.Stop()
+
+	for {
+		s.dialPeers()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.savePeers()
+			s.announceFeeFilter()
+		case <-s.dialRequests:
+		}
+	}
 }
 
-func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
-	if err != nil {
-		log.Printf("%s is not avaliable\n", addr)
+// requestDial wakes maintainPeers up
+func (s *Server) requestDial() {
+	select {
+	case s.dialRequests <- struct{}{}:
+	default:
+	}
+}
 
-		var newKnownNodes []string
-		for _, node := range knownNodes {
-			if node != addr {
-				newKnownNodes = append(newKnownNodes, node)
+// mineBlocks mines blocks while the mempool has minMiningTxs transactions,
+// it waits for requestMining between them until done is closed. A block
+// failing to be mined is logged and retried on the next request.
+func (s *Server) mineBlocks(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.miningRequests:
+		}
+
+		for s.mempool.Len() >= minMiningTxs {
+			select {
+			case <-done:
+				return
+			default:
 			}
+
+			block, err := s.miner.MineBlock()
+			if err != nil {
+				log.Printf("mining failed: %s\n", err)
+				break
+			}
+
+			// only the coinbase, the mempool transactions don't fit a block
+			if len(block.Transactions) == 1 {
+				break
+			}
+		}
+	}
+}
+
+// requestMining wakes mineBlocks up
+func (s *Server) requestMining() {
+	select {
+	case s.miningRequests <- struct{}{}:
+	default:
+	}
+}
+
+// dialPeers dials known nodes which aren't peers until the node has its
+// target of outbound peers, the best candidates first, each is tried once.
+// Nodes backing off after failed dials are skipped, as are the nodes other
+// than the seeds of a ConnectOnly node.
+func (s *Server) dialPeers() {
+	tried := make(map[string]bool)
+
+	for s.manager.Outbound() < s.cfg.TargetOutbound {
+		var candidate *KnownAddress
+		now := time.Now()
+		for _, node := range s.KnownAddresses() {
+			node := node
+			if s.isSelf(node.Addr) || tried[node.Addr] || now.Before(node.NextAttempt) || s.peers.isBanned(node.Addr) || s.manager.lookup(node.Addr) != nil {
+				continue
+			}
+
+			if s.cfg.ConnectOnly && !s.isConfiguredPeer(node.Addr) {
+				continue
+			}
+
+			if candidate == nil || node.better(candidate) {
+				candidate = &node
+			}
+		}
+
+		if candidate == nil {
+			return
 		}
 
-		knownNodes = newKnownNodes
+		tried[candidate.Addr] = true
+		s.dial(candidate.Addr)
+	}
+}
+
+// dial connects to a node as an outbound peer and sends it the version of
+// the node, failed dials are retried as the dial policy says. A node
+// failing DialPolicy.MaxFailures times in a row is removed from the known
+// nodes, unless it is a seed of a ConnectOnly node.
+func (s *Server) dial(addr string) {
+	s.markAttempt(addr)
+
+	conn, err := s.dialWithRetry(addr)
+	if err != nil {
+		log.Printf("%s is not avaliable: %s\n", addr, err)
+
+		if s.markFailure(addr) && !s.cfg.ConnectOnly && s.removeFromKnownNodes(addr) {
+			s.peers.emit(PeerDisconnected, addr, err.Error())
+		}
+		return
+	}
+
+	p := newPeerConn(conn, addr, s.bc.Params().networkMagic())
+	p.limiter = newPeerLimiter(*s.cfg.RateLimit)
+	if !s.manager.addOutbound(p) {
+		p.close()
 		return
 	}
 
-	defer func() {
-		if err = conn.Close(); err != nil {
-			log.Println(err)
+	if !s.track() {
+		s.manager.remove(p)
+		p.close()
+		return
+	}
+
+	if err = conn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		log.Println(err)
+	}
+	go p.writeLoop()
+	go func() {
+		defer s.wg.Done()
+		s.serveConn(p)
+	}()
+
+	s.sendVersion(p)
+}
+
+// serveConn handles the messages of a peer until its connection or the
+// server is closed, a lost outbound peer is replaced at the next
+// peerDialInterval
+func (s *Server) serveConn(p *peerConn) {
+	go func() {
+		select {
+		case <-s.done:
+			p.close()
+		case <-p.quit:
 		}
 	}()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
+	p.readLoop(s.handleMessage, s.maxPayload)
+
+	s.manager.remove(p)
+}
+
+// malformed disconnects a peer which sent a request that doesn't decode
+// and adds to its misbehaviour score
+func (s *Server) malformed(p *peerConn, request []byte, err error) {
+	log.Printf("Disconnecting %s: %s\n", p, err)
+	s.misbehaving(p, malformedMessageScore, err.Error())
+	p.close()
+}
+
+// misbehaving adds to the misbehaviour score of a peer, a peer reaching
+// banThreshold is banned, or disconnected when its address isn't known
+func (s *Server) misbehaving(p *peerConn, score int, reason string) {
+	total, addr := s.manager.misbehaving(p, score)
+	if total < banThreshold {
+		return
 	}
+
+	if addr == "" {
+		p.close()
+		return
+	}
+
+	s.BanPeer(addr, reason, defaultBanDuration)
 }
 
-// sendVersion sends the current height of blockchain to other node
-func sendVersion(addr string, bc *Blockchain) {
-	bestHeight := bc.GetBestHeight()
+// StartServer runs a node listening on the localhost port of its id
+func StartServer(nodeID, minerAddress string) {
+	StartServerWithListeners(nodeID, minerAddress, DefaultListeners(nodeID))
+}
 
-	v := versionData{
-		Version:    nodeVersion,
-		BestHeight: bestHeight,
-		AddrFrom:   nodeAddress,
+// StartServerWithListeners runs a node serving peers on every listener,
+// the first TCP listener is the address advertised to other nodes. The
+// node is stopped on SIGINT or SIGTERM.
+func StartServerWithListeners(nodeID, minerAddress string, listeners []ListenerConfig) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	s := NewServer(ServerConfig{NodeID: nodeID, MinerAddress: minerAddress, Listeners: listeners})
+	if err := s.Start(ctx); err != nil {
+		log.Panic(err)
 	}
 
-	sendCommandAndPayload(addr, CommandVersion, v)
+	if err := s.Wait(); err != nil {
+		log.Panic(err)
+	}
 }
 
-func requestBlocks() {
-	for _, node := range knownNodes {
-		sendCommandAndPayload(node, CommandGetBlocks, getBlocksData{AddrFrom: nodeAddress})
+// Run serves peers until one of the listeners fails or Stop is called, see
+// Start and Wait
+func (s *Server) Run() error {
+	if err := s.Start(context.Background()); err != nil {
+		return err
 	}
+
+	return s.Wait()
 }
 
-func StartServer(nodeID, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	miningAddress = minerAddress
-	ln, err := net.Listen(protocol, nodeAddress)
+// Start serves peers on the listeners, meanwhile outbound peers are dialed
+// from the known nodes, the seeds first, and the JSON-RPC commands are
+// served on the RPC address and socket. The server is stopped when ctx is
+// done. It fails with ErrNoExternalAddress when other nodes couldn't dial
+// the address advertised to them.
+func (s *Server) Start(ctx context.Context) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	switch {
+	case s.stopping:
+		return ErrServerStopped
+	case s.started:
+		return ErrServerStarted
+	case s.cfg.TLS != nil && s.noise != nil, s.cfg.Transport != nil && (s.cfg.TLS != nil || s.noise != nil):
+		return ErrTransportConflict
+	}
+	if _, ok := s.transport.(Addresser); ok && s.cfg.PortMapping {
+		return ErrTransportConflict
+	}
+
+	if s.address != "" && !s.advertiseMapping {
+		if err := s.checkAddress(s.address); err != nil {
+			return err
+		}
+	}
+
+	allowlist, err := parseAllowlist(s.cfg.InboundAllowlist)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	s.allowlist = allowlist
+
+	var lns []net.Listener
+	if len(s.cfg.Listeners) > 0 {
+		if lns, err = s.transport.Listen(s.cfg.Listeners); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.PortMapping {
+		s.mapPort(lns)
+	}
+
+	if s.address != "" && s.advertiseMapping {
+		if err := s.checkAddress(s.address); err != nil {
+			s.unmapPort(s.portMapping)
+			s.portMapping = nil
+			for _, ln := range lns {
+				_ = ln.Close()
+			}
+			return err
+		}
 	}
-	defer func() {
-		if closeErr := ln.Close(); closeErr != nil {
-			log.Panic(closeErr)
+
+	rpcServer, err := s.startRPC()
+	if err != nil {
+		s.unmapPort(s.portMapping)
+		s.portMapping = nil
+		for _, ln := range lns {
+			_ = ln.Close()
+		}
+		return err
+	}
+	s.started, s.listeners, s.rpc = true, lns, rpcServer
+	s.errs = make(chan error, len(lns))
+
+	loops := []func(done <-chan struct{}){s.maintainPeers, s.gossipAddresses, s.pingPeers}
+	if s.portMapping != nil {
+		loops = append(loops, s.renewPortMapping)
+	}
+	if s.relay != nil {
+		loops = append(loops, s.relayInventories)
+	}
+	if s.miner != nil {
+		loops = append(loops, s.mineBlocks)
+	}
+	s.wg.Add(len(loops) + len(lns))
+	for _, loop := range loops {
+		go func(loop func(done <-chan struct{})) {
+			defer s.wg.Done()
+			loop(s.done)
+		}(loop)
+	}
+
+	for i, ln := range lns {
+		go func(ln net.Listener, policy ListenerPolicy) {
+			defer s.wg.Done()
+
+			handle := func(conn net.Conn) {
+				s.handleConnection(conn, policy)
+			}
+			if err := acceptConnections(ln, policy, handle); !errors.Is(err, net.ErrClosed) {
+				s.errs <- err
+			}
+		}(ln, s.cfg.Listeners[i].Policy)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := s.Stop(); err != nil {
+				log.Println(err)
+			}
+		case <-s.done:
 		}
 	}()
 
-	bc := NewBlockchain(nodeID)
+	return nil
+}
 
-	if nodeAddress != knownNodes[0] {
-		sendVersion(knownNodes[0], bc)
+// startRPC serves the JSON-RPC commands on the RPC address and socket of
+// the config, nil when it has neither
+func (s *Server) startRPC() (*RPCServer, error) {
+	if s.cfg.RPCAddress == "" && s.cfg.RPCSocket == "" {
+		return nil, nil
 	}
 
-	for {
-		conn, cErr := ln.Accept()
-		if cErr != nil {
-			log.Panic(cErr)
+	rpcServer := NewRPCServer(s.bc, s.mempool, s.cfg.Wallets)
+	if s.cfg.RPCAddress != "" {
+		if err := rpcServer.ListenTCP(s.cfg.RPCAddress); err != nil {
+			return nil, err
 		}
-		go func() {
-			defer func() {
-				if closeErr := conn.Close(); closeErr != nil {
-					log.Panic(err)
-				}
-			}()
-			handleConnection(conn, bc)
-		}()
 	}
+	if s.cfg.RPCSocket != "" {
+		if err := rpcServer.ListenUnix(s.cfg.RPCSocket); err != nil {
+			_ = rpcServer.Close()
+			return nil, err
+		}
+	}
+
+	return rpcServer, nil
 }
 
-// TODO: impl
-func handleConnection(conn net.Conn, bc *Blockchain) {
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
+// Wait blocks until the server is stopped. A failing listener stops the
+// server and its error is returned.
+func (s *Server) Wait() error {
+	s.runMu.Lock()
+	errs := s.errs
+	s.runMu.Unlock()
+
+	select {
+	case err := <-errs:
+		if stopErr := s.Stop(); stopErr != nil {
+			log.Println(stopErr)
+		}
+		return err
+	case <-s.stopped:
+		return nil
+	}
+}
+
+// Stop stops accepting connections, waits for the messages being handled,
+// closes the peer connections, saves the peer database and closes the
+// chain. Only the first call stops the server, the others wait for it and
+// return the same error.
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		s.runMu.Lock()
+		s.stopping = true
+		lns, rpcServer, mapping := s.listeners, s.rpc, s.portMapping
+		s.runMu.Unlock()
+
+		close(s.done)
+		for _, ln := range lns {
+			if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Println(err)
+			}
+		}
+		if rpcServer != nil {
+			if err := rpcServer.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Println(err)
+			}
+		}
+
+		s.wg.Wait()
+		s.unmapPort(mapping)
+		s.savePeers()
+		s.stopErr = s.bc.Close()
+		close(s.stopped)
+	})
+
+	return s.stopErr
+}
+
+// track counts a peer connection Stop waits for and returns true, false
+// when the server is stopping
+func (s *Server) track() bool {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if s.stopping {
+		return false
+	}
+
+	s.wg.Add(1)
+	return true
+}
+
+// handleConnection serves an inbound connection of a listener with a
+// policy, see serveConn. It is refused when the inbound allowlist doesn't
+// list it, the node has its maximum of inbound peers or is stopping.
+func (s *Server) handleConnection(conn net.Conn, policy ListenerPolicy) {
+	if !s.allowsInbound(conn) {
+		log.Printf("Refusing %s, it isn't in the inbound allowlist\n", conn.RemoteAddr())
+		return
+	}
+
+	if !s.track() {
+		return
+	}
+	defer s.wg.Done()
+
+	p := newPeerConn(conn, "", s.bc.Params().networkMagic())
+	if !policy.RateLimitExempt {
+		p.limiter = newPeerLimiter(*s.cfg.RateLimit)
+	}
+	if !s.manager.addInbound(p) {
+		log.Printf("Refusing %s, the node has %d inbound peers\n", p, s.cfg.MaxInbound)
+		return
 	}
 
+	if err := conn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		log.Println(err)
+	}
+	go p.writeLoop()
+
+	s.serveConn(p)
+}
+
+// handleMessage handles a message of a peer, the responses are sent back
+// on its connection. A peer sending other commands before completing the
+// handshake is disconnected, a message over the rate limit of the peer is
+// dropped.
+func (s *Server) handleMessage(p *peerConn, request []byte) {
 	command := bytesToCommand(request[:commandLength])
 
-	log.Printf("Receiver %s command\n", command)
+	log.Printf("Receiver %s command from %s\n", command, p)
+
+	if p.limiter != nil && !p.limiter.allow(len(request)) {
+		s.misbehaving(p, rateLimitScore, "rate limit exceeded by "+command)
+		return
+	}
+
+	if !p.handshaken() && command != CommandVersion && command != CommandVerack {
+		log.Printf("Disconnecting %s, it sent %s before the handshake\n", p, command)
+		p.close()
+		return
+	}
 
 	switch command {
 	case CommandVersion:
-		handleVersion(request, bc)
+		s.handleVersion(p, request)
+	case CommandVerack:
+		s.handleVerack(p, request)
 	case CommandAddr:
-		handleAddr(request)
+		s.handleAddr(p, request)
+	case CommandGetAddr:
+		s.handleGetAddr(p, request)
+	case CommandPing:
+		s.handlePing(p, request)
+	case CommandPong:
+		s.handlePong(p, request)
 	case CommandBlock:
-		handleBlock(request, bc)
+		s.handleBlock(p, request)
 	case CommandInv:
-		handleInv(request, bc)
+		s.handleInv(p, request)
 	case CommandGetBlocks:
-		handleGetBlocks(request, bc)
+		s.handleGetBlocks(p, request)
 	case CommandGetData:
-		handleGetData(request, bc)
+		s.handleGetData(p, request)
 	case CommandTx:
-		handleTx(request, bc)
+		s.handleTx(p, request)
+	case CommandGetUTXOProof:
+		s.handleGetUTXOProof(p, request)
+	case CommandUTXOProof:
+		s.handleUTXOProof(p, request)
+	case CommandNotFound:
+		s.handleNotFound(p, request)
+	case CommandReject:
+		s.handleReject(p, request)
+	case CommandMempool:
+		s.handleMempool(p, request)
+	case CommandFeeFilter:
+		s.handleFeeFilter(p, request)
+	case CommandSendHeaders:
+		s.handleSendHeaders(p, request)
+	case CommandHeaders:
+		s.handleHeaders(p, request)
+	case CommandFilterLoad:
+		s.handleFilterLoad(p, request)
+	case CommandFilterAdd:
+		s.handleFilterAdd(p, request)
+	case CommandFilterClear:
+		s.handleFilterClear(p, request)
+	case CommandGetCFilters:
+		s.handleGetCFilters(p, request)
+	case CommandGetCFHeaders:
+		s.handleGetCFHeaders(p, request)
+	case CommandGetHeaders:
+		s.handleGetHeaders(p, request)
+	case CommandGetTxProofs:
+		s.handleGetTxProofs(p, request)
 	default:
 		log.Println("Unknown command")
+		s.misbehaving(p, unknownCommandScore, "unknown command "+command)
 	}
 }
 
-// TODO: impl
-func handleAddr(request []byte) {
-	var payload addrData
+// handleBlock handles CommandBlock request, a refused block is answered
+// with CommandReject
+func (s *Server) handleBlock(p *peerConn, request []byte) {
+	var payload blockData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	height := s.bc.GetBestHeight()
+	block, err := s.bc.ProcessBlock(payload.Block)
+	if err != nil {
+		log.Printf("block is rejected: %s\n", err)
+		if block == nil {
+			s.sendReject(p, CommandBlock, nil, RejectMalformed, err)
+		} else {
+			s.sendReject(p, CommandBlock, block.Hash, rejectCode(err), err)
+		}
 
-	decodeRequestData(&payload, request)
-	for _, addr := range payload.AddrList {
-		addToKnownNodes(addr)
+		if isMisbehaviour(err) {
+			s.misbehaving(p, banThreshold, err.Error())
+		}
+		return
 	}
 
-	requestBlocks()
+	log.Printf("Added block %x\n", block.Hash)
+	s.mempool.RemoveBlock(block)
+
+	if next, ok := s.nextBlockInTransit(); ok {
+		p.send(CommandGetData, getDataData{AddrFrom: s.address, Type: CommandGetDataTypeBlock, ID: next})
+	} else {
+		if p.addr != "" {
+			s.requestMempool(p)
+		}
+		if s.bc.GetBestHeight() > height {
+			s.announceBlock(block, p)
+		}
+	}
 }
 
-// TODO: impl
-func handleBlock(request []byte, bc *Blockchain) {
-	var payload blockData
-	decodeRequestData(&payload, request)
+// nextBlockInTransit takes the hash of the next block to request
+func (s *Server) nextBlockInTransit() ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.blocksInTransit) == 0 {
+		return nil, false
+	}
 
-	block := DeserializeBlock(payload.Block)
+	next := s.blocksInTransit[0]
+	s.blocksInTransit = s.blocksInTransit[1:]
 
-	bc.AddBlock(block)
+	return next, true
+}
+
+// handleInv handles CommandInv request. The blocks of the inventory which
+// aren't in the chain yet are requested, see requestBlocks. Transactions
+// which aren't in the mempool are requested.
+func (s *Server) handleInv(p *peerConn, request []byte) {
+	var payload invData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
 
-	if hasBlockInTransit() {
-		sendCommandAndPayload(payload.AddrFrom, CommandGetData,
-			getDataData{AddrFrom: nodeAddress, Type: CommandGetDataTypeBlock, ID: blocksInTransit[0]})
+	log.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		NewUTXOSet(bc).Reindex()
+	switch payload.Type {
+	case CommandGetDataTypeBlock:
+		var missing [][]byte
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if _, err := s.bc.GetBlock(payload.Items[i]); err != nil {
+				missing = append(missing, payload.Items[i])
+			}
+		}
+
+		s.requestBlocks(p, missing)
+	case CommandGetDataTypeTx:
+		for _, txID := range payload.Items {
+			if !s.mempool.Has(txID) {
+				p.send(CommandGetData, getDataData{AddrFrom: s.address, Type: CommandGetDataTypeTx, ID: txID})
+			}
+		}
+	default:
+		log.Printf("Unknown inventory type %q\n", payload.Type)
 	}
 }
 
-// hasBlockInTransit returns if having blocks in transit
-func hasBlockInTransit() bool {
-	return len(blocksInTransit) != 0
+// requestBlocks requests blocks from a peer one at a time, oldest first,
+// the rest wait in blocksInTransit until the previous one arrives
+func (s *Server) requestBlocks(p *peerConn, hashes [][]byte) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	s.blocksInTransit = hashes[1:]
+	s.mu.Unlock()
+
+	p.send(CommandGetData, getDataData{AddrFrom: s.address, Type: CommandGetDataTypeBlock, ID: hashes[0]})
 }
 
-// TODO: impl
-func handleInv(request []byte, bc *Blockchain) {
+// handleGetBlocks handles CommandGetBlocks request by sending the
+// inventory of the hashes of the main chain blocks, tip first
+func (s *Server) handleGetBlocks(p *peerConn, request []byte) {
+	var payload getBlocksData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
 
+	p.send(CommandInv, invData{AddrFrom: s.address, Type: CommandGetDataTypeBlock, Items: s.bc.GetBlockHashes()})
 }
 
-// TODO: impl
-func handleGetBlocks(request []byte, bc *Blockchain) {
+// handleGetData handles CommandGetData request by sending the block, its
+// CommandMerkleBlock or the mempool transaction of the ID, or
+// CommandNotFound when it is unknown
+func (s *Server) handleGetData(p *peerConn, request []byte) {
+	var payload getDataData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
 
+	switch payload.Type {
+	case CommandGetDataTypeBlock:
+		if block, err := s.bc.GetBlock(payload.ID); err == nil {
+			s.sendBlock(p, &block)
+			return
+		}
+	case CommandGetDataTypeFilteredBlock:
+		if block, err := s.bc.GetBlock(payload.ID); err == nil {
+			s.sendMerkleBlock(p, &block)
+			return
+		}
+	case CommandGetDataTypeTx:
+		if tx, ok := s.mempool.Get(payload.ID); ok {
+			s.sendTx(p, tx)
+			return
+		}
+	}
+
+	p.send(CommandNotFound, notFoundData{AddrFrom: s.address, Type: payload.Type, ID: payload.ID})
 }
 
-// TODO: impl
-func handleGetData(request []byte, bc *Blockchain) {
+// handleNotFound handles CommandNotFound request. A block the peer doesn't
+// have anymore drops the blocks in transit after it, which can't connect
+// without it, they are requested again with the next inventory.
+func (s *Server) handleNotFound(p *peerConn, request []byte) {
+	var payload notFoundData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	log.Printf("%s doesn't have %s %x\n", payload.AddrFrom, payload.Type, payload.ID)
+
+	if payload.Type == CommandGetDataTypeBlock {
+		s.mu.Lock()
+		s.blocksInTransit = [][]byte{}
+		s.mu.Unlock()
+	}
+}
+
+// handleTx handles CommandTx request, a refused transaction is answered
+// with CommandReject. A mining node wakes its miner loop up, see mineBlocks.
+func (s *Server) handleTx(p *peerConn, request []byte) {
+	var payload txData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	tx, err := TryDeserializeTransaction(payload.Transaction)
+	if err != nil {
+		s.sendReject(p, CommandTx, nil, RejectMalformed, err)
+		return
+	}
+
+	if err = s.mempool.Add(tx); err != nil {
+		log.Printf("transaction %x is rejected: %s\n", tx.ID, err)
+		s.sendReject(p, CommandTx, tx.ID, rejectCode(err), err)
+		return
+	}
+
+	if s.miner != nil {
+		s.requestMining()
+	}
+}
 
+// requestUTXOProofs asks a peer for proofs of the unspent outputs of transactions
+func (s *Server) requestUTXOProofs(p *peerConn, txIDs [][]byte) {
+	p.send(CommandGetUTXOProof, getUTXOProofData{AddrFrom: s.address, TxIDs: txIDs})
 }
 
-// TODO: impl
-func handleTx(request []byte, bc *Blockchain) {
+// handleGetUTXOProof handles CommandGetUTXOProof request by serving proofs
+// against the current UTXO commitment
+func (s *Server) handleGetUTXOProof(p *peerConn, request []byte) {
+	var payload getUTXOProofData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
+
+	response := utxoProofData{AddrFrom: s.address}
+	proofs, commitment, tip, err := NewUTXOSet(s.bc).Proofs(payload.TxIDs)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Tip, response.Commitment, response.Proofs = tip, commitment, proofs
+	}
 
+	p.send(CommandUTXOProof, response)
 }
 
-// handleVersion handles CommandVersion request
-func handleVersion(request []byte, bc *Blockchain) {
-	var payload versionData
-	decodeRequestData(payload, request)
+// handleUTXOProof handles CommandUTXOProof request by verifying the proofs
+func (s *Server) handleUTXOProof(p *peerConn, request []byte) {
+	var payload utxoProofData
+	if err := decodeRequestData(&payload, request); err != nil {
+		s.malformed(p, request, err)
+		return
+	}
 
-	myBestHeight := bc.GetBestHeight()
-	foreignerBestHeight := payload.BestHeight
+	if payload.Error != "" {
+		log.Printf("%s can't prove utxos: %s\n", payload.AddrFrom, payload.Error)
+		return
+	}
 
-	if myBestHeight < foreignerBestHeight {
-		sendCommandAndPayload(payload.AddrFrom, CommandGetBlocks, getBlocksData{AddrFrom: nodeAddress})
-	} else if myBestHeight > foreignerBestHeight {
-		sendVersion(payload.AddrFrom, bc)
+	for i := range payload.Proofs {
+		if err := payload.Proofs[i].Verify(payload.Commitment); err != nil {
+			log.Printf("utxo proofs from %s are invalid: %s\n", payload.AddrFrom, err)
+			return
+		}
 	}
 
-	addToKnownNodes(payload.AddrFrom)
+	log.Printf("Received %d valid utxo proofs from %s at tip %x\n", len(payload.Proofs), payload.AddrFrom, payload.Tip)
 }
 
-// addToKnownNodes checks whether address is in the known nodes list and adds to list if not.
-func addToKnownNodes(addr string) {
-	if !nodeIsKnow(addr) {
-		knownNodes = append(knownNodes, addr)
+// sendBlock sends a block to a peer
+func (s *Server) sendBlock(p *peerConn, block *Block) {
+	p.send(CommandBlock, blockData{AddrFrom: s.address, Block: block.Serialize()})
+}
+
+// broadcastBlock announces a block mined by the node to all peers
+func (s *Server) broadcastBlock(block *Block) {
+	s.announceBlock(block, nil)
+}
+
+// sendTx sends a transaction to a peer
+func (s *Server) sendTx(p *peerConn, tx *Transaction) {
+	p.send(CommandTx, txData{AddrFrom: s.address, Transaction: tx.Serialize()})
+}
+
+// BroadcastTransaction sends a transaction to all peers, the
+// broadcast of the wallets of the node, see WithBroadcast. Peers whose fee
+// filter is above the fee rate of the transaction or whose bloom filter
+// doesn't match it are skipped.
+func (s *Server) BroadcastTransaction(tx *Transaction) {
+	rate, known := s.txFeeRate(tx)
+
+	for _, p := range s.manager.ready() {
+		if (known && rate < s.manager.feeFilter(p)) || !s.manager.matchesFilter(p, tx) {
+			continue
+		}
+
+		s.sendTx(p, tx)
 	}
 }
 
-func decodeRequestData(data interface{}, request []byte) {
-	var buff bytes.Buffer
-	buff.Write(request[commandLength:])
+// addToKnownNodes adds an address record to the known nodes, or updates the
+// record of a known node when it is newer. Banned addresses and the
+// addresses of the node are ignored. When maxKnownNodes are known the oldest record is
+// replaced. The reason is sent to the peer event hooks.
+func (s *Server) addToKnownNodes(record NetAddress, reason string) {
+	if record.Addr == "" || s.isSelf(record.Addr) || s.peers.isBanned(record.Addr) {
+		return
+	}
 
-	dec := gob.NewDecoder(&buff)
-	if err := dec.Decode(data); err != nil {
-		log.Panic(err)
+	s.mu.Lock()
+	i := knownNodeIndex(s.knownNodes, record.Addr)
+	switch {
+	case i >= 0:
+		if record.Timestamp.After(s.knownNodes[i].Timestamp) {
+			s.knownNodes[i].NetAddress = record
+		}
+	case len(s.knownNodes) < maxKnownNodes:
+		s.knownNodes = append(s.knownNodes, KnownAddress{NetAddress: record})
+	default:
+		oldest := 0
+		for j, known := range s.knownNodes {
+			if known.Timestamp.Before(s.knownNodes[oldest].Timestamp) {
+				oldest = j
+			}
+		}
+
+		if !record.Timestamp.After(s.knownNodes[oldest].Timestamp) {
+			s.mu.Unlock()
+			return
+		}
+		s.knownNodes[oldest] = KnownAddress{NetAddress: record}
 	}
+	s.mu.Unlock()
+
+	if i < 0 {
+		s.peers.emit(PeerConnected, record.Addr, reason)
+		s.requestDial()
+	}
+}
+
+// removeFromKnownNodes removes an address from the known nodes list and
+// returns whether it was known
+func (s *Server) removeFromKnownNodes(addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var newKnownNodes []KnownAddress
+	for _, node := range s.knownNodes {
+		if node.Addr != addr {
+			newKnownNodes = append(newKnownNodes, node)
+		}
+	}
+
+	removed := len(newKnownNodes) != len(s.knownNodes)
+	s.knownNodes = newKnownNodes
+
+	return removed
+}
+
+// decodeRequestData decodes the payload of a request, a payload which
+// doesn't decode is a malformed message
+func decodeRequestData(data interface{}, request []byte) error {
+	if err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(data); err != nil {
+		return fmt.Errorf("%w: %s payload: %s", ErrMalformedMessage, bytesToCommand(request[:commandLength]), err)
+	}
+
+	return nil
 }
 
 func gobEncode(data interface{}) []byte {
@@ -334,12 +1418,14 @@ func gobEncode(data interface{}) []byte {
 	return buff.Bytes()
 }
 
-func nodeIsKnow(addr string) bool {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
+// knownNodeIndex returns the index of the record of an address, -1 when
+// it isn't known
+func knownNodeIndex(nodes []KnownAddress, addr string) int {
+	for i, node := range nodes {
+		if node.Addr == addr {
+			return i
 		}
 	}
 
-	return false
+	return -1
 }
@@ -0,0 +1,190 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestServer starts a server of a test blockchain listening on a free
+// local port, without seeds, and returns it with its address
+func startTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	bc, _ := newTestBlockchain(t)
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(ServerConfig{
+		NodeID:     "test",
+		Blockchain: bc,
+		Listeners:  []ListenerConfig{{Network: "tcp", Address: addr}},
+		Seeds:      []string{},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+
+	return s, addr
+}
+
+// waitClosed waits until the node closes a connection
+func waitClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("connection wasn't closed: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func TestMalformedMessageDisconnectsPeer(t *testing.T) {
+	_, addr := startTestServer(t)
+	garbage := []byte{0xff, 0x00, 0x13, 0x37, 0xde, 0xad}
+
+	tests := []struct {
+		name      string
+		handshake bool
+		command   string
+	}{
+		{"version before the handshake", false, CommandVersion},
+		{"block after the handshake", true, CommandBlock},
+		{"getdata after the handshake", true, CommandGetData},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := DialNode(addr, time.Second, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if test.handshake {
+				if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+					t.Fatal(err)
+				}
+				if err := clientHandshake(conn, DefaultNetworkMagic); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := WriteMessage(conn, append(commandToBytes(test.command), garbage...)); err != nil {
+				t.Fatal(err)
+			}
+			waitClosed(t, conn)
+
+			var headers HeadersMessage
+			response, err := Request(addr, EncodeMessage(CommandGetHeaders, GetHeadersMessage{}), CommandHeaders, 5*time.Second)
+			if err != nil {
+				t.Fatalf("node stopped serving after a malformed %s: %v", test.command, err)
+			}
+			if err := DecodeMessagePayload(response, &headers); err != nil || len(headers.Headers) != 1 {
+				t.Fatalf("headers %v, %v", headers.Headers, err)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestDataReturnsMalformed(t *testing.T) {
+	var payload blockData
+	request := append(commandToBytes(CommandBlock), 0xff, 0x01)
+
+	if err := decodeRequestData(&payload, request); !errors.Is(err, ErrMalformedMessage) {
+		t.Fatalf("error %v, want %v", err, ErrMalformedMessage)
+	}
+}
+
+func TestMiningNodeMinesReceivedTransactions(t *testing.T) {
+	bc, genesis := newTestBlockchain(t)
+	params := bc.Params()
+	miner, payee := NewWalletWithParams(params), NewWalletWithParams(params)
+
+	addr := freeTCPAddress(t)
+	s := NewServer(ServerConfig{
+		NodeID:       "test",
+		Blockchain:   bc,
+		Listeners:    []ListenerConfig{{Network: "tcp", Address: addr}},
+		Seeds:        []string{},
+		MinerAddress: string(miner.GetAddress()),
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+
+	// the second transaction spends the change of the first in the mempool
+	var txs []*Transaction
+	prevTX := genesisOf(t, bc).Transactions[0]
+	for i := 0; i < minMiningTxs; i++ {
+		tx, err := NewTxBuilder(params, 1).
+			AddInputFrom(prevTX, len(prevTX.VOut)-1).
+			AddOutput(1, string(payee.GetAddress())).
+			SetFee(1).
+			SetChangeAddress(string(genesis.GetAddress())).
+			Sign(KeystoreSigner(genesis)).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs, prevTX = append(txs, tx), tx
+	}
+
+	conn, err := DialNode(addr, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientHandshake(conn, DefaultNetworkMagic); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tx := range txs {
+		request := append(commandToBytes(CommandTx), gobEncode(txData{Transaction: tx.Serialize()})...)
+		if err := WriteMessage(conn, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for bc.GetBestHeight() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no block mined, %d mempool transactions", s.mempool.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	block, err := bc.GetBlock(bc.GetBlockHashes()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) != 1+len(txs) {
+		t.Fatalf("mined %d transactions, want the coinbase and %d", len(block.Transactions), len(txs))
+	}
+	if s.mempool.Len() != 0 {
+		t.Errorf("%d transactions left in the mempool", s.mempool.Len())
+	}
+}
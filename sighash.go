@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// SigHashType selects the parts of a transaction a signature commits to,
+// it is appended to each signature
+type SigHashType byte
+
+const (
+	// SigHashAll commits to every input and output
+	SigHashAll SigHashType = 0x01
+
+	// SigHashNone commits to the inputs but no output, anyone may choose
+	// where the coins go
+	SigHashNone SigHashType = 0x02
+
+	// SigHashSingle commits to the inputs and the output at the index of
+	// the signed input
+	SigHashSingle SigHashType = 0x03
+
+	// SigHashAnyOneCanPay is combined with the other types to commit only
+	// to the signed input, other inputs may be added
+	SigHashAnyOneCanPay SigHashType = 0x80
+
+	// sigHashMask selects the base type without SigHashAnyOneCanPay
+	sigHashMask SigHashType = 0x1f
+)
+
+// ErrInvalidSigHashType is returned when a hash type can't sign an input
+var ErrInvalidSigHashType = errors.New("invalid signature hash type")
+
+// baseType returns the hash type without SigHashAnyOneCanPay
+func (t SigHashType) baseType() SigHashType {
+	return t & sigHashMask
+}
+
+// anyOneCanPay returns whether the hash type commits only to the signed input
+func (t SigHashType) anyOneCanPay() bool {
+	return t&SigHashAnyOneCanPay != 0
+}
+
+// String returns the name of the hash type
+func (t SigHashType) String() string {
+	var name string
+	switch t.baseType() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("0x%02x", byte(t))
+	}
+
+	if t.anyOneCanPay() {
+		name += "|ANYONECANPAY"
+	}
+
+	return name
+}
+
+// checkHashType checks the hash type is defined and can sign the input at
+// index of tx, SigHashSingle needs an output at the same index
+func (tx *Transaction) checkHashType(index int, hashType SigHashType) error {
+	if hashType&^(sigHashMask|SigHashAnyOneCanPay) != 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidSigHashType, hashType)
+	}
+
+	switch hashType.baseType() {
+	case SigHashAll, SigHashNone:
+		return nil
+	case SigHashSingle:
+		if index >= len(tx.VOut) {
+			return fmt.Errorf("%w: %s input %d has no matching output", ErrInvalidSigHashType, hashType, index)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidSigHashType, hashType)
+	}
+}
+
+// doubleSHA256 returns the SHA-256 of the SHA-256 of data
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
+
+// signatureHash returns the hash signed for the input at index, or nil when
+// the hash type can't sign it. The preimage is the canonical serialization
+// of the transaction where the input being signed has the script of the
+// output it spends and the other inputs have empty scripts, followed by the
+// input index and the hash type. The hash type trims the preimage:
+//   - SigHashNone drops the outputs and the sequences of the other inputs
+//   - SigHashSingle keeps the output at index, the outputs before it are
+//     blanked and the sequences of the other inputs dropped
+//   - SigHashAnyOneCanPay keeps only the signed input and drops the index
+//
+// The preimage of canonical transactions starts with DomainTxSigHash.
+func (tx *Transaction) signatureHash(index int, script []byte, hashType SigHashType) []byte {
+	if tx.checkHashType(index, hashType) != nil {
+		return nil
+	}
+
+	var preimage bytes.Buffer
+	baseType := hashType.baseType()
+
+	if tx.isCanonical() {
+		writeVarBytes(&preimage, []byte(DomainTxSigHash))
+	}
+	writeUint32(&preimage, uint32(tx.Version))
+
+	inputs := tx.VIn
+	if hashType.anyOneCanPay() {
+		inputs = tx.VIn[index : index+1]
+	}
+
+	writeVarInt(&preimage, uint64(len(inputs)))
+	for i, vin := range inputs {
+		signed := i == index || hashType.anyOneCanPay()
+		writeVarBytes(&preimage, vin.TxID)
+		writeUint32(&preimage, uint32(vin.VOut))
+		if signed {
+			writeVarBytes(&preimage, script)
+		} else {
+			writeVarBytes(&preimage, nil)
+		}
+
+		if signed || baseType == SigHashAll {
+			writeUint32(&preimage, vin.Sequence)
+		} else {
+			writeUint32(&preimage, 0)
+		}
+	}
+
+	outputs := tx.VOut
+	switch baseType {
+	case SigHashNone:
+		outputs = nil
+	case SigHashSingle:
+		outputs = tx.VOut[:index+1]
+	}
+
+	writeVarInt(&preimage, uint64(len(outputs)))
+	for i, out := range outputs {
+		if baseType == SigHashSingle && i != index {
+			writeInt64(&preimage, -1)
+			writeVarBytes(&preimage, nil)
+			continue
+		}
+
+		writeInt64(&preimage, int64(out.Value))
+		writeVarBytes(&preimage, out.ScriptPubKey)
+	}
+
+	writeUint32(&preimage, tx.LockTime)
+	if !hashType.anyOneCanPay() {
+		writeUint32(&preimage, uint32(index))
+	}
+	writeUint32(&preimage, uint32(hashType))
+
+	return doubleSHA256(preimage.Bytes())
+}
+
+// signInput returns the signature by the key of an address held by the
+// signer of the input at index with the script of the output it spends,
+// followed by the hash type
+func (tx *Transaction) signInput(signer Signer, address string, pubKey []byte, index int, script []byte, hashType SigHashType) ([]byte, error) {
+	hash := tx.signatureHash(index, script, hashType)
+	if hash == nil {
+		return nil, tx.checkHashType(index, hashType)
+	}
+
+	signature, err := signDigest(signer, address, pubKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signature, byte(hashType)), nil
+}
@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// newSigHashTestTX returns an unsigned transaction of two inputs and two
+// outputs
+func newSigHashTestTX() *Transaction {
+	to := string(NewWallet().GetAddress())
+	tx := &Transaction{
+		VIn: []TXInput{
+			{TxID: bytes.Repeat([]byte{1}, 32), VOut: 0, Sequence: SequenceFinal},
+			{TxID: bytes.Repeat([]byte{2}, 32), VOut: 1, Sequence: SequenceFinal},
+		},
+		VOut: []TXOutput{*NewTXOutput(5, to), *NewTXOutput(7, to)},
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestCheckHashType(t *testing.T) {
+	tx := newSigHashTestTX()
+
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		index    int
+		valid    bool
+	}{
+		{"ALL", SigHashAll, 0, true},
+		{"NONE", SigHashNone, 1, true},
+		{"SINGLE with its output", SigHashSingle, 1, true},
+		{"ALL|ANYONECANPAY", SigHashAll | SigHashAnyOneCanPay, 0, true},
+		{"SINGLE|ANYONECANPAY", SigHashSingle | SigHashAnyOneCanPay, 0, true},
+		{"SINGLE without its output", SigHashSingle, 2, false},
+		{"zero", 0x00, 0, false},
+		{"undefined base type", 0x04, 0, false},
+		{"ANYONECANPAY alone", SigHashAnyOneCanPay, 0, false},
+		{"undefined flag", SigHashAll | 0x40, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := tx.checkHashType(test.index, test.hashType)
+			if test.valid && err != nil {
+				t.Fatalf("hash type rejected: %v", err)
+			}
+			if !test.valid {
+				if !errors.Is(err, ErrInvalidSigHashType) {
+					t.Fatalf("error %v, want %v", err, ErrInvalidSigHashType)
+				}
+				if hash := tx.signatureHash(test.index, nil, test.hashType); hash != nil {
+					t.Fatalf("hash %x of an invalid hash type", hash)
+				}
+			}
+		})
+	}
+}
+
+func TestSignatureHashCommitments(t *testing.T) {
+	script := PayToPubKeyHashScript(bytes.Repeat([]byte{9}, 20))
+	all, none, single := SigHashAll, SigHashNone, SigHashSingle
+	acp := SigHashAnyOneCanPay
+
+	otherOutput := func(tx *Transaction) { tx.VOut[1].Value++ }
+	signedOutput := func(tx *Transaction) { tx.VOut[0].Value++ }
+	otherSequence := func(tx *Transaction) { tx.VIn[1].Sequence = 0 }
+	otherOutpoint := func(tx *Transaction) { tx.VIn[1].VOut = 2 }
+	addedInput := func(tx *Transaction) { tx.VIn = append(tx.VIn, TXInput{TxID: bytes.Repeat([]byte{3}, 32)}) }
+	addedOutput := func(tx *Transaction) { tx.VOut = append(tx.VOut, tx.VOut[0]) }
+	lockTime := func(tx *Transaction) { tx.LockTime = 1 }
+
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		mutate   func(*Transaction)
+		commits  bool
+	}{
+		{"ALL other output", all, otherOutput, true},
+		{"ALL other sequence", all, otherSequence, true},
+		{"ALL added input", all, addedInput, true},
+		{"ALL locktime", all, lockTime, true},
+		{"NONE other output", none, otherOutput, false},
+		{"NONE signed output", none, signedOutput, false},
+		{"NONE other sequence", none, otherSequence, false},
+		{"NONE other outpoint", none, otherOutpoint, true},
+		{"NONE locktime", none, lockTime, true},
+		{"SINGLE signed output", single, signedOutput, true},
+		{"SINGLE other output", single, otherOutput, false},
+		{"SINGLE added output", single, addedOutput, false},
+		{"SINGLE other sequence", single, otherSequence, false},
+		{"SINGLE other outpoint", single, otherOutpoint, true},
+		{"ALL|ANYONECANPAY other output", all | acp, otherOutput, true},
+		{"ALL|ANYONECANPAY added input", all | acp, addedInput, false},
+		{"ALL|ANYONECANPAY other outpoint", all | acp, otherOutpoint, false},
+		{"SINGLE|ANYONECANPAY added input and output", single | acp, func(tx *Transaction) { addedInput(tx); addedOutput(tx) }, false},
+		{"SINGLE|ANYONECANPAY signed output", single | acp, signedOutput, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := newSigHashTestTX()
+			before := tx.signatureHash(0, script, test.hashType)
+
+			test.mutate(tx)
+			after := tx.signatureHash(0, script, test.hashType)
+
+			if changed := !bytes.Equal(before, after); changed != test.commits {
+				t.Fatalf("hash changed %v, want %v", changed, test.commits)
+			}
+		})
+	}
+
+	tx := newSigHashTestTX()
+	if bytes.Equal(tx.signatureHash(0, script, all), tx.signatureHash(0, script, all|acp)) {
+		t.Error("the hash doesn't commit to the hash type")
+	}
+}
+
+func TestSignWithHashType(t *testing.T) {
+	wallet := NewWallet()
+	prevOut := *NewTXOutput(10, string(wallet.GetAddress()))
+
+	// verify runs the unlocking script of the first input
+	verify := func(tx *Transaction) error {
+		return VerifyScript(tx.VIn[0].ScriptSig, prevOut.ScriptPubKey, &txSigChecker{tx: tx, index: 0, curve: CurveP256})
+	}
+
+	// retype replaces the hash type following the signature of the first input
+	retype := func(tx *Transaction, hashType SigHashType) {
+		ops, err := parseScript(tx.VIn[0].ScriptSig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := append([]byte{}, ops[0].data...)
+		sig[len(sig)-1] = byte(hashType)
+		tx.VIn[0].ScriptSig = NewScriptBuilder().AddData(sig).AddData(ops[1].data).Script()
+	}
+
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		mutate   func(*Transaction)
+		valid    bool
+	}{
+		{"ALL", SigHashAll, nil, true},
+		{"ALL with another output", SigHashAll, func(tx *Transaction) { tx.VOut[1].Value++ }, false},
+		{"NONE with another output", SigHashNone, func(tx *Transaction) { tx.VOut[1].Value++ }, true},
+		{"SINGLE|ANYONECANPAY with an added input", SigHashSingle | SigHashAnyOneCanPay, func(tx *Transaction) {
+			tx.VIn = append(tx.VIn, TXInput{TxID: bytes.Repeat([]byte{3}, 32), Sequence: SequenceFinal})
+		}, true},
+		{"ALL claimed as NONE", SigHashAll, func(tx *Transaction) { retype(tx, SigHashNone) }, false},
+		{"undefined hash type", SigHashAll, func(tx *Transaction) { retype(tx, 0x04) }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := newSigHashTestTX()
+			if err := tx.SignPrevOutputs(KeystoreSigner(wallet), []TXOutput{prevOut, prevOut}, test.hashType); err != nil {
+				t.Fatal(err)
+			}
+			if test.mutate != nil {
+				test.mutate(tx)
+			}
+
+			err := verify(tx)
+			if test.valid && err != nil {
+				t.Fatalf("signature rejected: %v", err)
+			}
+			if !test.valid && !errors.Is(err, ErrScriptFailed) {
+				t.Fatalf("error %v, want %v", err, ErrScriptFailed)
+			}
+		})
+	}
+
+	tx := newSigHashTestTX()
+	tx.VOut = tx.VOut[:1]
+	if err := tx.SignPrevOutputs(KeystoreSigner(wallet), []TXOutput{prevOut, prevOut}, SigHashSingle); !errors.Is(err, ErrInvalidSigHashType) {
+		t.Errorf("signing SINGLE without a matching output: error %v, want %v", err, ErrInvalidSigHashType)
+	}
+	if err := newSigHashTestTX().SignPrevOutputs(KeystoreSigner(wallet), []TXOutput{prevOut, prevOut}, 0x04); !errors.Is(err, ErrInvalidSigHashType) {
+		t.Errorf("signing with an undefined hash type: error %v, want %v", err, ErrInvalidSigHashType)
+	}
+}
@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Signer signs digests with the keys of addresses without revealing them,
+// so the keys can live in an HSM, a cloud KMS or a hardware wallet
+type Signer interface {
+	// PublicKey returns the public key of an address as stored in scripts,
+	// the error wraps ErrMissingKey when the signer has no key for it
+	PublicKey(address string) ([]byte, error)
+
+	// Sign returns the DER encoded signature of a digest by the key of an address
+	Sign(address string, digest []byte) ([]byte, error)
+}
+
+// keystoreSigner signs with the private keys of a keystore
+type keystoreSigner struct {
+	keystore Keystore
+}
+
+// KeystoreSigner returns a Signer of the private keys of a keystore, e.g. a
+// Wallet or a ColdWallet
+func KeystoreSigner(keystore Keystore) Signer {
+	return keystoreSigner{keystore: keystore}
+}
+
+// key returns the private key of an address
+func (s keystoreSigner) key(address string) (*ecdsa.PrivateKey, error) {
+	if _, _, err := decodeAddress(address); err != nil {
+		return nil, err
+	}
+
+	key, ok := s.keystore.KeyFor(pubKeyHashFromAddress(address))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingKey, address)
+	}
+
+	return key, nil
+}
+
+// PublicKey returns the public key of the private key of an address
+func (s keystoreSigner) PublicKey(address string) ([]byte, error) {
+	key, err := s.key(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodePublicKey(&key.PublicKey), nil
+}
+
+// Sign signs a digest with the private key of an address and a deterministic nonce
+func (s keystoreSigner) Sign(address string, digest []byte) ([]byte, error) {
+	key, err := s.key(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return signHash(*key, digest), nil
+}
+
+// keyAddress returns the pay to public key hash address of a public key
+func keyAddress(pubKey []byte) string {
+	return string(encodeAddress(version, HashPubKey(pubKey)))
+}
+
+// signerKey returns the public key the signer has for an address, nil
+// when it has no key for it
+func signerKey(signer Signer, address string) ([]byte, error) {
+	pubKey, err := signer.PublicKey(address)
+	if errors.Is(err, ErrMissingKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(HashPubKey(pubKey), pubKeyHashFromAddress(address)) {
+		return nil, fmt.Errorf("%w: signer key isn't of %s", ErrInvalidPublicKey, address)
+	}
+
+	return pubKey, nil
+}
+
+// signDigest signs a digest with the key of an address held by the signer.
+// The signature is normalized to a low S and checked against the public
+// key, so a faulty signer can't make invalid or malleable transactions.
+func signDigest(signer Signer, address string, pubKey, digest []byte) ([]byte, error) {
+	sig, err := signer.Sign(address, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	r, s, err := parseDERSignature(sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signer of %s: %s", ErrInvalidSignature, address, err)
+	}
+
+	key, err := parsePublicKey(publicKeyCurve(pubKey), pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := key.Curve.Params().N; isHighS(n, s) {
+		s = new(big.Int).Sub(n, s)
+	}
+
+	if !ecdsa.Verify(key, digest, r, s) {
+		return nil, fmt.Errorf("%w: signer of %s", ErrInvalidSignature, address)
+	}
+
+	return encodeDERSignature(r, s), nil
+}
@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Signing domains are the tags prefixed to the data signed in each context,
+// so a signature made in one context never verifies in another. External
+// verifiers hash the domain as var bytes followed by the signed data.
+const (
+	// DomainTxSigHash tags the signature hashes of canonical transactions,
+	// legacy transactions keep their untagged signature hashes
+	DomainTxSigHash = "blockchain/tx-sighash/v1"
+
+	// DomainSignedMessage tags the messages signed by wallets
+	DomainSignedMessage = "blockchain/signed-message/v1"
+
+	// DomainNodeIdentity tags the data signed by node identities for peers
+	DomainNodeIdentity = "blockchain/node-identity/v1"
+
+	// DomainCheckpoint tags the checkpoint statements signed by node identities
+	DomainCheckpoint = "blockchain/checkpoint/v1"
+)
+
+// ErrInvalidSignature is returned when a signature doesn't verify
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// domainHash returns the SHA-256 of the domain as var bytes followed by data
+func domainHash(domain string, data []byte) []byte {
+	var buf bytes.Buffer
+	writeVarBytes(&buf, []byte(domain))
+	writeBytes(&buf, data)
+
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// messageHash returns the hash signed for a message
+func messageHash(message string) []byte {
+	var buf bytes.Buffer
+	writeVarBytes(&buf, []byte(message))
+
+	return domainHash(DomainSignedMessage, buf.Bytes())
+}
+
+// SignMessage signs a message with the wallet key, the signature is the
+// public key as var bytes followed by the DER encoded signature
+func (w *Wallet) SignMessage(message string) []byte {
+	var buf bytes.Buffer
+	writeVarBytes(&buf, w.PublicKey)
+	writeBytes(&buf, signHash(w.PrivateKey, messageHash(message)))
+
+	return buf.Bytes()
+}
+
+// VerifyMessage checks a message was signed by SignMessage with the key of
+// an address, the key must be on the curve of the chain
+func VerifyMessage(curve KeyCurve, address, message string, signature []byte) error {
+	if _, _, err := decodeAddress(address); err != nil {
+		return err
+	}
+
+	if len(signature) == 0 || int(signature[0]) >= 0xfd || len(signature) < 1+int(signature[0]) {
+		return fmt.Errorf("%w: malformed", ErrInvalidSignature)
+	}
+	pubKey, sig := signature[1:1+signature[0]], signature[1+signature[0]:]
+
+	if !bytes.Equal(HashPubKey(pubKey), pubKeyHashFromAddress(address)) {
+		return fmt.Errorf("%w: key isn't of %s", ErrInvalidSignature, address)
+	}
+
+	key, err := parsePublicKey(curve, pubKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	r, s, err := parseDERSignature(sig)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	if isHighS(key.Curve.Params().N, s) || !ecdsa.Verify(key, messageHash(message), r, s) {
+		return fmt.Errorf("%w: message of %s", ErrInvalidSignature, address)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// Query reads the chain within a snapshot, every read sees the same tip
+// and UTXO set
+type Query interface {
+	// Tip returns the hash of the last block
+	Tip() []byte
+
+	// BestHeight returns the height of the last block
+	BestHeight() int
+
+	// Block returns a block by its hash
+	Block(hash []byte) (*Block, error)
+
+	// BlockHashes returns the hashes of the blocks from the tip to the genesis block
+	BlockHashes() [][]byte
+
+	// Transaction returns a transaction of the chain by its id
+	Transaction(id []byte) (*Transaction, error)
+
+	// UnspentOutputs returns the unspent outputs of a transaction
+	UnspentOutputs(txID []byte) (TXOutputs, bool)
+
+	// FindUTXO returns the unspent outputs locked with the public key hash
+	FindUTXO(pubKeyHash []byte) []TXOutput
+
+	// ForEachUnspent calls fn with the unspent outputs of each transaction
+	ForEachUnspent(fn func(txID []byte, outs TXOutputs) error) error
+}
+
+// ViewSnapshot runs fn with a Query reading from a single read transaction,
+// responses assembled from several queries are consistent even if blocks
+// are added meanwhile. The Query must not be used after fn returns.
+func (bc *Blockchain) ViewSnapshot(fn func(q Query) error) error {
+	return bc.db.View(func(tx *bolt.Tx) error {
+		return fn(&snapshotQuery{tx: tx, blocks: tx.Bucket([]byte(blocksBucket))})
+	})
+}
+
+// snapshotQuery implements Query on a bolt read transaction
+type snapshotQuery struct {
+	tx     *bolt.Tx
+	blocks *bolt.Bucket
+}
+
+// Tip returns the hash of the last block
+func (q *snapshotQuery) Tip() []byte {
+	return append([]byte{}, q.blocks.Get([]byte(tipDbKey))...)
+}
+
+// BestHeight returns the height of the last block
+func (q *snapshotQuery) BestHeight() int {
+	return DeserializeBlock(q.blocks.Get(q.blocks.Get([]byte(tipDbKey)))).Height
+}
+
+// Block returns a block by its hash
+func (q *snapshotQuery) Block(hash []byte) (*Block, error) {
+	data := q.blocks.Get(hash)
+	if data == nil {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+
+	return TryDeserializeBlock(data)
+}
+
+// BlockHashes returns the hashes of the blocks from the tip to the genesis block
+func (q *snapshotQuery) BlockHashes() [][]byte {
+	var hashes [][]byte
+
+	for hash := q.Tip(); len(hash) > 0; {
+		block := DeserializeBlock(q.blocks.Get(hash))
+		hashes = append(hashes, block.Hash)
+		hash = block.PrevBlockHash
+	}
+
+	return hashes
+}
+
+// Transaction returns a transaction of the chain by its id
+func (q *snapshotQuery) Transaction(id []byte) (*Transaction, error) {
+	for hash := q.Tip(); len(hash) > 0; {
+		block := DeserializeBlock(q.blocks.Get(hash))
+
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				return tx, nil
+			}
+		}
+
+		hash = block.PrevBlockHash
+	}
+
+	return nil, fmt.Errorf("%w: %x", ErrTransactionNotFound, id)
+}
+
+// UnspentOutputs returns the unspent outputs of a transaction
+func (q *snapshotQuery) UnspentOutputs(txID []byte) (TXOutputs, bool) {
+	data := q.tx.Bucket([]byte(utxoBucket)).Get(txID)
+	if data == nil {
+		return TXOutputs{}, false
+	}
+
+	return DeserializeOutputs(data), true
+}
+
+// FindUTXO returns the unspent outputs locked with the public key hash
+func (q *snapshotQuery) FindUTXO(pubKeyHash []byte) []TXOutput {
+	return findUTXO(q.tx, pubKeyHash)
+}
+
+// ForEachUnspent calls fn with the unspent outputs of each transaction
+func (q *snapshotQuery) ForEachUnspent(fn func(txID []byte, outs TXOutputs) error) error {
+	return q.tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+		return fn(append([]byte{}, k...), DeserializeOutputs(v))
+	})
+}
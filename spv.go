@@ -0,0 +1,457 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLightClientTimeout is the wait for a node response of light
+	// clients configured without one
+	DefaultLightClientTimeout = 10 * time.Second
+
+	// DefaultFilterFalsePositiveRate is the false positive rate of the bloom
+	// filter of light clients configured without one
+	DefaultFilterFalsePositiveRate = 0.0001
+
+	// lightHeaderOverlap is the number of known headers requested again to
+	// detect reorgs
+	lightHeaderOverlap = 6
+
+	// filteredBlocksPerBatch is the number of filtered blocks requested
+	// before their responses are read
+	filteredBlocksPerBatch = 100
+)
+
+var (
+	// ErrInvalidHeader is returned when a node sends a header not connecting
+	// to the header chain of a light client
+	ErrInvalidHeader = errors.New("invalid header")
+
+	// ErrGenesisMismatch is returned when a node is on another chain than
+	// the pinned genesis
+	ErrGenesisMismatch = errors.New("genesis block mismatch")
+)
+
+// LightClientConfig configures a LightClient
+type LightClientConfig struct {
+	// Peer is the address of the full node serving the headers and the
+	// filtered blocks
+	Peer string
+
+	// Params are the chain parameters, MainNetParams when nil
+	Params *ChainParams
+
+	// GenesisHash pins the genesis block when set
+	GenesisHash []byte
+
+	// Timeout is the wait for a node response, DefaultLightClientTimeout
+	// when zero
+	Timeout time.Duration
+
+	// FalsePositiveRate is the false positive rate of the bloom filter,
+	// DefaultFilterFalsePositiveRate when zero. Higher rates hide the
+	// wallet addresses from the node better but cost bandwidth.
+	FalsePositiveRate float64
+
+	// TLS connects to the node over TLS when set
+	TLS *tls.Config
+}
+
+// LightTx is a wallet transaction proven to be in a block of the header chain
+type LightTx struct {
+	Tx        *Transaction
+	BlockHash []byte
+	Height    int
+}
+
+// lightOutput is an unspent output of the wallets
+type lightOutput struct {
+	outpoint Outpoint
+	value    int
+}
+
+// LightClient is an SPV client of a full node. It syncs the block headers
+// only and verifies the Merkle proofs of the transactions of the wallets,
+// watch-only addresses included, which the node sends for the bloom filter
+// of their addresses. Their balance and history are known without a
+// chainstate.
+type LightClient struct {
+	cfg     LightClientConfig
+	wallets *Wallets
+
+	mu      sync.Mutex
+	headers []BlockHeader
+	synced  int
+	txs     []LightTx
+	utxos   map[string]lightOutput
+}
+
+// NewLightClient creates a LightClient of the wallets
+func NewLightClient(wallets *Wallets, cfg LightClientConfig) *LightClient {
+	if cfg.Params == nil {
+		params := MainNetParams
+		cfg.Params = &params
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultLightClientTimeout
+	}
+
+	if cfg.FalsePositiveRate == 0 {
+		cfg.FalsePositiveRate = DefaultFilterFalsePositiveRate
+	}
+
+	return &LightClient{cfg: cfg, wallets: wallets, synced: -1, utxos: make(map[string]lightOutput)}
+}
+
+// lightSession is a connection of a light client to its node
+type lightSession struct {
+	conn    net.Conn
+	magic   uint32
+	timeout time.Duration
+}
+
+// send sends a message to the node, the node must answer within the timeout
+func (s *lightSession) send(command string, payload interface{}) error {
+	if err := s.conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	return WriteNetworkMessage(s.conn, s.magic, EncodeMessage(command, payload))
+}
+
+// receive reads the next message of the node of one of the commands,
+// pings are answered and other messages skipped
+func (s *lightSession) receive(commands ...string) ([]byte, string, error) {
+	for {
+		message, err := ReadNetworkMessage(s.conn, s.magic)
+		if err != nil {
+			return nil, "", err
+		}
+
+		command, err := MessageCommand(message)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if command == CommandPing {
+			var ping pingData
+			if err = DecodeMessagePayload(message, &ping); err != nil {
+				return nil, "", err
+			}
+			if err = s.send(CommandPong, pongData{Nonce: ping.Nonce}); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		for _, c := range commands {
+			if command == c {
+				return message, command, nil
+			}
+		}
+	}
+}
+
+// Sync syncs the headers with the node, then loads the bloom filter of the
+// wallets and fetches the filtered blocks not synced yet
+func (c *LightClient) Sync() error {
+	conn, err := DialNode(c.cfg.Peer, c.cfg.Timeout, c.cfg.TLS)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err = conn.SetDeadline(time.Now().Add(c.cfg.Timeout)); err != nil {
+		return err
+	}
+
+	if err = clientHandshake(conn, c.cfg.Params.networkMagic()); err != nil {
+		return err
+	}
+
+	session := &lightSession{conn: conn, magic: c.cfg.Params.networkMagic(), timeout: c.cfg.Timeout}
+	if err = c.syncHeaders(session); err != nil {
+		return err
+	}
+
+	if err = session.send(CommandFilterLoad, FilterLoadMessage{Filter: *c.filter()}); err != nil {
+		return err
+	}
+
+	return c.syncBlocks(session)
+}
+
+// filter returns the bloom filter of the public key hashes of the wallets
+// and of their unspent outputs
+func (c *LightClient) filter() *BloomFilter {
+	pubKeyHashes := c.wallets.PubKeyHashes()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filter := NewBloomFilter(len(pubKeyHashes)+len(c.utxos), c.cfg.FalsePositiveRate, uint32(newNonce()))
+	for _, pubKeyHash := range pubKeyHashes {
+		filter.Add(pubKeyHash)
+	}
+	for _, u := range c.utxos {
+		filter.Add(u.outpoint.Bytes())
+	}
+
+	return filter
+}
+
+// syncHeaders fetches the headers of the node until its tip
+func (c *LightClient) syncHeaders(session *lightSession) error {
+	for {
+		c.mu.Lock()
+		from := len(c.headers) - lightHeaderOverlap
+		c.mu.Unlock()
+		if from < 0 {
+			from = 0
+		}
+
+		if err := session.send(CommandGetHeaders, GetHeadersMessage{FromHeight: from}); err != nil {
+			return err
+		}
+
+		message, _, err := session.receive(CommandHeaders)
+		if err != nil {
+			return err
+		}
+
+		var response HeadersMessage
+		if err = DecodeMessagePayload(message, &response); err != nil {
+			return err
+		}
+
+		if response.Error != "" {
+			return errors.New(response.Error)
+		}
+
+		extended, err := c.connectHeaders(from, response.Headers)
+		if err != nil {
+			return err
+		}
+
+		if !extended || len(response.Headers) < MaxHeadersPerMessage {
+			return nil
+		}
+	}
+}
+
+// connectHeaders checks headers starting at a height and replaces the
+// chain from the first differing header when they make it longer, it
+// returns whether the chain changed
+func (c *LightClient) connectHeaders(from int, headers []BlockHeader) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fork := from
+	for _, header := range headers {
+		if fork >= len(c.headers) || !bytes.Equal(c.headers[fork].Hash, header.Hash) {
+			break
+		}
+		fork++
+	}
+
+	branch := headers[fork-from:]
+	if len(branch) == 0 || fork+len(branch) <= len(c.headers) {
+		return false, nil
+	}
+
+	for i := range branch {
+		header := &branch[i]
+		if header.Height != fork+i {
+			return false, fmt.Errorf("%w: %x has height %d, expected %d", ErrInvalidHeader, header.Hash, header.Height, fork+i)
+		}
+
+		if header.Height == 0 {
+			if len(c.cfg.GenesisHash) != 0 && !bytes.Equal(header.Hash, c.cfg.GenesisHash) {
+				return false, fmt.Errorf("%w: %x", ErrGenesisMismatch, header.Hash)
+			}
+		} else {
+			var prev BlockHeader
+			if i > 0 {
+				prev = branch[i-1]
+			} else {
+				prev = c.headers[fork-1]
+			}
+			if !bytes.Equal(header.PrevBlockHash, prev.Hash) {
+				return false, fmt.Errorf("%w: %x doesn't connect to %x", ErrInvalidHeader, header.Hash, prev.Hash)
+			}
+		}
+
+		if err := header.Validate(c.cfg.Params.TargetBits); err != nil {
+			return false, fmt.Errorf("%w: %s", ErrInvalidHeader, err)
+		}
+	}
+
+	if fork < len(c.headers) {
+		log.Printf("reorganizing the headers from height %d\n", fork)
+		c.synced = -1
+		c.txs = nil
+		c.utxos = make(map[string]lightOutput)
+	}
+
+	c.headers = append(c.headers[:fork], branch...)
+
+	return true, nil
+}
+
+// syncBlocks fetches the filtered blocks after the synced height in
+// batches and verifies the proofs of their transactions against the headers
+func (c *LightClient) syncBlocks(session *lightSession) error {
+	for {
+		c.mu.Lock()
+		batch := append([]BlockHeader{}, c.headers[c.synced+1:]...)
+		c.mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		if len(batch) > filteredBlocksPerBatch {
+			batch = batch[:filteredBlocksPerBatch]
+		}
+
+		for _, header := range batch {
+			if err := session.send(CommandGetData, getDataData{Type: CommandGetDataTypeFilteredBlock, ID: header.Hash}); err != nil {
+				return err
+			}
+		}
+
+		for _, header := range batch {
+			message, command, err := session.receive(CommandMerkleBlock, CommandNotFound)
+			if err != nil {
+				return err
+			}
+
+			if command == CommandNotFound {
+				return fmt.Errorf("%s doesn't have block %x", c.cfg.Peer, header.Hash)
+			}
+
+			var response MerkleBlockMessage
+			if err = DecodeMessagePayload(message, &response); err != nil {
+				return err
+			}
+
+			if err = c.connectBlock(header, &response); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// connectBlock verifies the proofs of a filtered block against its header
+// and applies the wallet transactions
+func (c *LightClient) connectBlock(header BlockHeader, block *MerkleBlockMessage) error {
+	if !bytes.Equal(block.Header.Hash, header.Hash) {
+		return fmt.Errorf("%w: filtered block %x instead of %x", ErrInvalidTxProof, block.Header.Hash, header.Hash)
+	}
+
+	var txs []*Transaction
+	for i := range block.Matches {
+		tx, err := block.Matches[i].Verify(header)
+		if err != nil {
+			return err
+		}
+		txs = append(txs, tx)
+	}
+
+	pubKeyHashes := make(map[string]bool)
+	for _, pubKeyHash := range c.wallets.PubKeyHashes() {
+		pubKeyHashes[string(pubKeyHash)] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.synced+1 != header.Height || !bytes.Equal(c.headers[header.Height].Hash, header.Hash) {
+		return fmt.Errorf("headers reorganized during the sync with %s", c.cfg.Peer)
+	}
+
+	for _, tx := range txs {
+		c.applyTransaction(LightTx{Tx: tx, BlockHash: header.Hash, Height: header.Height}, pubKeyHashes)
+	}
+	c.synced = header.Height
+
+	return nil
+}
+
+// applyTransaction records a transaction spending or paying the wallets,
+// false positives of the filter are dropped, c.mu must be held
+func (c *LightClient) applyTransaction(tx LightTx, pubKeyHashes map[string]bool) {
+	relevant := false
+
+	if !tx.Tx.IsCoinbase() {
+		for _, vin := range tx.Tx.VIn {
+			key := outpointKey(vin.TxID, vin.VOut)
+			if _, ok := c.utxos[key]; ok {
+				delete(c.utxos, key)
+				relevant = true
+			}
+		}
+	}
+
+	for i, out := range tx.Tx.VOut {
+		if pubKeyHashes[string(out.PubKeyHash())] {
+			c.utxos[outpointKey(tx.Tx.ID, i)] = lightOutput{outpoint: Outpoint{TxID: tx.Tx.ID, VOut: i}, value: out.Value}
+			relevant = true
+		}
+	}
+
+	if relevant {
+		c.txs = append(c.txs, tx)
+	}
+}
+
+// Height returns the height of the synced header chain, -1 before the first sync
+func (c *LightClient) Height() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.headers) - 1
+}
+
+// Header returns the synced header at a height
+func (c *LightClient) Header(height int) (BlockHeader, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if height < 0 || height >= len(c.headers) {
+		return BlockHeader{}, false
+	}
+
+	return c.headers[height], true
+}
+
+// Balance returns the value of the unspent outputs of the wallets in the
+// synced blocks
+func (c *LightClient) Balance() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	balance := 0
+	for _, u := range c.utxos {
+		balance += u.value
+	}
+
+	return balance
+}
+
+// History returns the proven wallet transactions in chain order
+func (c *LightClient) History() []LightTx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]LightTx{}, c.txs...)
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+import "fmt"
+
+// maxStandardMultiSigKeys is the maximum number of public keys of a relayed bare multisig output
+const maxStandardMultiSigKeys = 3
+
+// checkStandard checks the relay policy of a transaction: its size, the
+// size of its unlocking scripts, the types of its outputs and their value
+// against the dust threshold. Blocks may still include non-standard
+// transactions, the policy isn't consensus.
+func checkStandard(tx *Transaction, policy *Policy) error {
+	if size := len(tx.Serialize()); policy.MaxStandardTxSize > 0 && size > policy.MaxStandardTxSize {
+		return fmt.Errorf("%w: %x has %d bytes, max %d", ErrNonStandardTx, tx.ID, size, policy.MaxStandardTxSize)
+	}
+
+	for i, vin := range tx.VIn {
+		if policy.MaxStandardScriptSigSize > 0 && len(vin.ScriptSig) > policy.MaxStandardScriptSigSize {
+			return fmt.Errorf("%w: %x input %d unlocking script has %d bytes, max %d",
+				ErrNonStandardTx, tx.ID, i, len(vin.ScriptSig), policy.MaxStandardScriptSigSize)
+		}
+	}
+
+	for i, out := range tx.VOut {
+		switch ClassifyOutput(out) {
+		case ScriptTypeNonStandard:
+			return fmt.Errorf("%w: %x output %d has a non-standard script", ErrNonStandardTx, tx.ID, i)
+		case ScriptTypeMultiSig:
+			if _, pubKeys, _ := ExtractMultiSig(out.ScriptPubKey); len(pubKeys) > maxStandardMultiSigKeys {
+				return fmt.Errorf("%w: %x output %d is a multisig of %d keys, max %d",
+					ErrNonStandardTx, tx.ID, i, len(pubKeys), maxStandardMultiSigKeys)
+			}
+		}
+
+		if IsDust(out, policy) {
+			return fmt.Errorf("%w: %x output %d of %d is dust, min %d", ErrNonStandardTx, tx.ID, i, out.Value, policy.DustThreshold)
+		}
+	}
+
+	return checkDataOutputs(tx)
+}
+
+// IsDust returns whether a spendable output is worth less than the dust
+// threshold, data outputs are never dust
+func IsDust(out TXOutput, policy *Policy) bool {
+	return !out.IsUnspendable() && out.Value < policy.DustThreshold
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+// ChainStats are statistics of the chain up to the tip
+type ChainStats struct {
+	Height       int
+	Transactions int
+
+	// Outputs counts every output of the chain by script type
+	Outputs map[ScriptType]int
+
+	// Unspent counts the outputs of the UTXO set by script type
+	Unspent map[ScriptType]int
+}
+
+// ChainStats computes the statistics of the chain
+func (bc *Blockchain) ChainStats() (*ChainStats, error) {
+	stats := &ChainStats{Outputs: make(map[ScriptType]int), Unspent: make(map[ScriptType]int)}
+
+	err := bc.ViewSnapshot(func(q Query) error {
+		stats.Height = q.BestHeight()
+
+		for _, hash := range q.BlockHashes() {
+			block, err := q.Block(hash)
+			if err != nil {
+				return err
+			}
+
+			for _, tx := range block.Transactions {
+				stats.Transactions++
+
+				for _, out := range tx.VOut {
+					stats.Outputs[ClassifyOutput(out)]++
+				}
+			}
+		}
+
+		return q.ForEachUnspent(func(_ []byte, outs TXOutputs) error {
+			for _, out := range outs.Outputs {
+				stats.Unspent[ClassifyOutput(out)]++
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
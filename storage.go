@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"github.com/boltdb/bolt"
+	"log"
+	"time"
+)
+
+// StorageOptions tunes the bolt database of a Blockchain
+type StorageOptions struct {
+	// NoSync skips fsync after each commit, data written since the last
+	// checkpoint may be lost on crash
+	NoSync bool
+
+	// MmapSize is the initial mmap size of the database, a large enough
+	// value avoids remapping while the database grows
+	MmapSize int
+
+	// MaxBatchSize is the maximum number of blocks committed together by
+	// concurrent AddBlock calls, zero disables batching
+	MaxBatchSize int
+
+	// MaxBatchDelay is the maximum delay before a batch is committed
+	MaxBatchDelay time.Duration
+
+	// CheckpointInterval is the number of blocks after which the database
+	// is synced when NoSync is set, zero only syncs on explicit checkpoints
+	CheckpointInterval int
+}
+
+var (
+	// DefaultStorageOptions syncs every commit and is safe against crashes
+	DefaultStorageOptions = StorageOptions{}
+
+	// BulkImportStorageOptions favors import speed over durability
+	BulkImportStorageOptions = StorageOptions{
+		NoSync:             true,
+		MmapSize:           1 << 30,
+		MaxBatchSize:       bolt.DefaultMaxBatchSize,
+		MaxBatchDelay:      bolt.DefaultMaxBatchDelay,
+		CheckpointInterval: 1000,
+	}
+)
+
+// openDB opens the bolt database with the storage options
+func openDB(dbFileName string, storage StorageOptions) *bolt.DB {
+	db, err := bolt.Open(dbFileName, dbFileMode, &bolt.Options{InitialMmapSize: storage.MmapSize})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	db.NoSync = storage.NoSync
+	db.MaxBatchSize = storage.MaxBatchSize
+	db.MaxBatchDelay = storage.MaxBatchDelay
+
+	return db
+}
+
+// update executes fn in a read-write transaction, it is batched with
+// concurrent updates when batching is enabled
+func (bc *Blockchain) update(fn func(tx *bolt.Tx) error) error {
+	if bc.db.MaxBatchSize > 0 {
+		return bc.db.Batch(fn)
+	}
+
+	return bc.db.Update(fn)
+}
+
+// BeginBulkImport stops syncing the database after each commit, blocks are
+// only durable after a checkpoint or EndBulkImport
+func (bc *Blockchain) BeginBulkImport() {
+	bc.syncMu.Lock()
+	bc.bulkImport = true
+	bc.syncMu.Unlock()
+
+	bc.setNoSync(true)
+}
+
+// EndBulkImport restores the configured sync policy and syncs the database
+func (bc *Blockchain) EndBulkImport() error {
+	bc.syncMu.Lock()
+	bc.bulkImport = false
+	bc.syncMu.Unlock()
+
+	bc.setNoSync(bc.opts.storage.NoSync)
+	return bc.Checkpoint()
+}
+
+// setNoSync sets the sync policy of the database. Bolt reads it while
+// committing under its writer lock, so it is set in a write transaction.
+func (bc *Blockchain) setNoSync(noSync bool) {
+	if err := bc.db.Update(func(*bolt.Tx) error {
+		bc.db.NoSync = noSync
+		return nil
+	}); err != nil {
+		log.Panic(err)
+	}
+}
+
+// Checkpoint syncs the database to disk
+func (bc *Blockchain) Checkpoint() error {
+	bc.syncMu.Lock()
+	defer bc.syncMu.Unlock()
+
+	bc.unsynced = 0
+	return bc.db.Sync()
+}
+
+// Close syncs and closes the database, the chain can't be used afterwards
+func (bc *Blockchain) Close() error {
+	if err := bc.Checkpoint(); err != nil {
+		return err
+	}
+
+	return bc.db.Close()
+}
+
+// blockCommitted counts a committed block and makes a checkpoint when
+// enough blocks were committed without syncing
+func (bc *Blockchain) blockCommitted() {
+	bc.syncMu.Lock()
+	if !bc.bulkImport && !bc.opts.storage.NoSync {
+		bc.syncMu.Unlock()
+		return
+	}
+
+	bc.unsynced++
+	interval := bc.opts.storage.CheckpointInterval
+	needCheckpoint := interval > 0 && bc.unsynced >= interval
+	bc.syncMu.Unlock()
+
+	if needCheckpoint {
+		if err := bc.Checkpoint(); err != nil {
+			log.Panic(err)
+		}
+	}
+}
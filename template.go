@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// defaultBlockSpacing is the timestamp spacing of deterministic blocks
+const defaultBlockSpacing = 10 * time.Minute
+
+// DeterministicMining makes mined blocks reproducible, two nodes mining the
+// same transactions on the same chain produce identical blocks
+type DeterministicMining struct {
+	// Seed derives the coinbase data
+	Seed int64
+
+	// GenesisTime is the timestamp of the genesis block
+	GenesisTime time.Time
+
+	// BlockSpacing is the timestamp difference between consecutive blocks
+	BlockSpacing time.Duration
+}
+
+// coinbaseData returns the coinbase data of the block at height
+func (d *DeterministicMining) coinbaseData(height int) string {
+	hash := sha256.Sum256(append(IntToHex(d.Seed), IntToHex(int64(height))...))
+
+	return hex.EncodeToString(hash[:20])
+}
+
+// timestamp returns the timestamp of the block at height
+func (d *DeterministicMining) timestamp(height int) int64 {
+	spacing := d.BlockSpacing
+	if spacing == 0 {
+		spacing = defaultBlockSpacing
+	}
+
+	return d.GenesisTime.Add(time.Duration(height) * spacing).Unix()
+}
+
+// blockTimestamp returns the timestamp of a new block at height
+func (bc *Blockchain) blockTimestamp(height int) int64 {
+	return blockTimestamp(bc.opts, height)
+}
+
+// blockTimestamp returns the timestamp of a new block at height
+func blockTimestamp(o *options, height int) int64 {
+	if o.deterministic != nil {
+		return o.deterministic.timestamp(height)
+	}
+
+	return time.Now().Unix()
+}
+
+// NewCoinbaseTX creates the coinbase transaction of the next block, its data
+// is derived from the seed when mining is deterministic
+func (bc *Blockchain) NewCoinbaseTX(to string) *Transaction {
+	height := bc.GetBestHeight() + 1
+
+	data := ""
+	if d := bc.opts.deterministic; d != nil {
+		data = d.coinbaseData(height)
+	}
+
+	return newCoinbaseTX(to, data, bc.opts.params.BlockSubsidy(height))
+}
+
+// orderTransactions puts the coinbase first and orders the other
+// transactions so parents precede the children spending them. The order is
+// by transaction id when deterministic, otherwise the given order is kept
+// where possible.
+func orderTransactions(transactions []*Transaction, deterministic bool) ([]*Transaction, error) {
+	var coinbase []*Transaction
+	var pending []*Transaction
+
+	for _, tx := range transactions {
+		if tx.IsCoinbase() {
+			coinbase = append(coinbase, tx)
+		} else {
+			pending = append(pending, tx)
+		}
+	}
+
+	if deterministic {
+		sort.SliceStable(pending, func(i, j int) bool {
+			return bytes.Compare(pending[i].ID, pending[j].ID) < 0
+		})
+	}
+
+	inBlock := make(map[string]bool, len(pending))
+	for _, tx := range pending {
+		inBlock[hex.EncodeToString(tx.ID)] = true
+	}
+
+	ordered := coinbase
+	added := make(map[string]bool, len(pending))
+
+	for len(pending) > 0 {
+		var deferred []*Transaction
+
+		for _, tx := range pending {
+			ready := true
+			for _, vin := range tx.VIn {
+				parent := hex.EncodeToString(vin.TxID)
+				if inBlock[parent] && !added[parent] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				ordered = append(ordered, tx)
+				added[hex.EncodeToString(tx.ID)] = true
+			} else {
+				deferred = append(deferred, tx)
+			}
+		}
+
+		if len(deferred) == len(pending) {
+			return nil, fmt.Errorf("%w: transactions spend each other in a cycle", ErrInvalidBlock)
+		}
+
+		pending = deferred
+	}
+
+	return ordered, nil
+}
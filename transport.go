@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// Transport carries the peer connections of a server. The built in
+// transport speaks TCP, Unix sockets and WebSockets, secured by TLS or
+// Noise when configured, package p2p is a libp2p transport.
+type Transport interface {
+	// Listen opens a listener per config, none is left open on error
+	Listen(configs []ListenerConfig) ([]net.Listener, error)
+
+	// Dial connects to the address of a node
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+// Addresser is implemented by transports naming nodes by other addresses
+// than host:port, e.g. the multiaddrs of libp2p
+type Addresser interface {
+	// AdvertisedAddress returns the address other nodes dial the node at
+	// through the address of a listener
+	AdvertisedAddress(listen string) string
+
+	// CheckAddress returns ErrNoExternalAddress when other nodes can't dial
+	// an advertised address
+	CheckAddress(addr string) error
+}
+
+// InvRelay is implemented by transports relaying inventories over a
+// publish-subscribe overlay, e.g. gossipsub. The server publishes the
+// inventories of its new blocks rather than sending them to each peer.
+type InvRelay interface {
+	// PublishInv publishes an inventory message
+	PublishInv(inv []byte) error
+
+	// SubscribeInv calls handle with the inventory messages other nodes
+	// publish until done is closed, from matches the connections to the
+	// node which published each
+	SubscribeInv(done <-chan struct{}, handle func(inv []byte, from func(net.Conn) bool)) error
+}
+
+// streamTransport is the built in transport
+type streamTransport struct {
+	tls   *tls.Config
+	noise *noiseKey
+}
+
+// Listen opens the listeners, see openListeners
+func (t streamTransport) Listen(configs []ListenerConfig) ([]net.Listener, error) {
+	return openListeners(configs, t.tls, t.noise)
+}
+
+// Dial connects to a node over TCP, the Noise handshake runs on the first
+// read or write of the connection
+func (t streamTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := DialNode(addr, timeout, t.tls)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.noise != nil {
+		conn = newNoiseConn(conn, t.noise, true)
+	}
+
+	return conn, nil
+}
@@ -3,19 +3,21 @@ package blockchain
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"strings"
 )
 
 const subsidy = 10
 
+// maxValue is the largest value or sum of values of outputs
+const maxValue = int(^uint(0) >> 1)
+
 const (
 	// TransactionCoinbaseVInVOutDefault is default vout value in first vin for transaction of coinbase
 	TransactionCoinbaseVInVOutDefault = -1
@@ -24,11 +26,37 @@ const (
 	TransactionCoinbaseVInTxIDDefault = 0
 )
 
+const (
+	// TxVersionLegacy is the version of transactions in the legacy format
+	TxVersionLegacy = 0
+
+	// TxVersionCanonical is the version of transactions in the canonical format
+	TxVersionCanonical = 1
+
+	// TxVersionAssets is the version of asset-carrying transactions
+	TxVersionAssets = 2
+
+	// maxKnownTxVersion is the highest version this node knows the rules of,
+	// blocks may carry higher versions which follow its rules
+	maxKnownTxVersion = TxVersionAssets
+)
+
+// txVersionFeatures are the features required by each transaction version
+var txVersionFeatures = map[int]Feature{
+	TxVersionCanonical: FeatureCanonicalTx,
+	TxVersionAssets:    FeatureAssets,
+}
+
 // Transaction represents a transaction
 type Transaction struct {
-	ID   []byte
-	VIn  []TXInput
-	VOut []TXOutput
+	ID      []byte
+	VIn     []TXInput
+	VOut    []TXOutput
+	Version int
+
+	// LockTime is the block height or unix timestamp before which the
+	// transaction can't be included in a block, see IsFinal
+	LockTime uint32
 }
 
 // IsCoinbase checks whether the transaction is coinbase
@@ -52,20 +80,65 @@ func (tx *Transaction) Serialize() []byte {
 
 // DeserializeTransaction deserializes a transaction
 func DeserializeTransaction(data []byte) Transaction {
+	transaction, err := TryDeserializeTransaction(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return *transaction
+}
+
+// TryDeserializeTransaction deserializes a transaction and returns an error
+// for malformed data. Fields added by versions unknown to this node are
+// skipped, so their transactions still decode.
+func TryDeserializeTransaction(data []byte) (*Transaction, error) {
 	var transaction Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	if err := decoder.Decode(&transaction); err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return transaction
+	return &transaction, nil
 }
 
-// Hash returns hash of the transaction
+// Hash returns hash of the transaction, unlocking scripts are not included
+// so the id computed before signing stays valid. Canonical transactions
+// hash their canonical encoding, legacy ones their gob encoding.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
+	if tx.isCanonical() {
+		hash = sha256.Sum256(tx.serializeCanonical(false))
+		return hash[:]
+	}
+
+	txCopy := *tx
+	txCopy.ID = []byte{}
+	if !tx.IsCoinbase() {
+		txCopy.VIn = make([]TXInput, len(tx.VIn))
+		for i, vin := range tx.VIn {
+			txCopy.VIn[i] = vin
+			txCopy.VIn[i].ScriptSig = nil
+		}
+	}
+
+	hash = sha256.Sum256(txCopy.Serialize())
+	return hash[:]
+}
+
+// WitnessHash returns the wtxid of the transaction, it commits to the
+// unlocking scripts the id leaves out, so a malleated transaction keeps its
+// id but not its wtxid. Canonical transactions hash their canonical
+// encoding, legacy ones their gob encoding without id.
+func (tx *Transaction) WitnessHash() []byte {
+	var hash [32]byte
+
+	if tx.isCanonical() {
+		hash = sha256.Sum256(tx.SerializeCanonical())
+		return hash[:]
+	}
+
 	txCopy := *tx
 	txCopy.ID = []byte{}
 
@@ -73,32 +146,143 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
-// Sign signs each input of a Transaction
-func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// Sign signs each input of a Transaction spending outputs of the keys of
+// the signer, multisig inputs collect the signatures of each party signing
+// in turn
+func (tx *Transaction) Sign(signer Signer, prevTXs map[string]Transaction) error {
+	return tx.SignWithHashType(signer, prevTXs, SigHashAll)
+}
+
+// SignWithHashType signs like Sign, the signatures commit to the parts of
+// the transaction selected by the hash type
+func (tx *Transaction) SignWithHashType(signer Signer, prevTXs map[string]Transaction, hashType SigHashType) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	} else if err := tx.validatePrevTXs(prevTXs); err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	txCopy := tx.TrimmedCopy()
+	prevOuts := make([]TXOutput, len(tx.VIn))
+	for inID, vin := range tx.VIn {
+		prevOuts[inID] = prevOutput(vin, prevTXs)
+	}
 
-	for inID, vin := range txCopy.VIn {
-		prevTx := prevTXs[hex.EncodeToString(vin.TxID)]
-		txCopy.VIn[inID].Signature = nil
-		txCopy.VIn[inID].PubKey = prevTx.VOut[vin.VOut].PubKeyHash
+	return tx.signPrevOutputs(signer, prevOuts, hashType)
+}
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+// SignPrevOutputs signs like SignWithHashType without the previous
+// transactions, prevOuts are the outputs spent by each input. It lets an
+// offline machine without the chain sign.
+func (tx *Transaction) SignPrevOutputs(signer Signer, prevOuts []TXOutput, hashType SigHashType) error {
+	if tx.IsCoinbase() {
+		return errors.New("coinbase transactions aren't signed")
+	}
+
+	if len(prevOuts) != len(tx.VIn) {
+		return fmt.Errorf("%d previous outputs for %d inputs", len(prevOuts), len(tx.VIn))
+	}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, []byte(dataToSign))
+	for inID := range tx.VIn {
+		if err := tx.checkHashType(inID, hashType); err != nil {
+			return err
+		}
+	}
+
+	return tx.signPrevOutputs(signer, prevOuts, hashType)
+}
+
+// signPrevOutputs signs the inputs spending outputs of the keys of the signer
+func (tx *Transaction) signPrevOutputs(signer Signer, prevOuts []TXOutput, hashType SigHashType) error {
+	for inID, prevOut := range prevOuts {
+		pubKeyHash := prevOut.PubKeyHash()
+		if pubKeyHash == nil {
+			if err := tx.signMultiSig(inID, prevOut.ScriptPubKey, signer, false, hashType); err != nil {
+				return err
+			}
+			continue
+		}
+
+		address := string(encodeAddress(version, pubKeyHash))
+		pubKey, err := signerKey(signer, address)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		if pubKey == nil {
+			continue
+		}
+
+		signature, err := tx.signInput(signer, address, pubKey, inID, prevOut.ScriptPubKey, hashType)
+		if err != nil {
+			return err
+		}
+		tx.VIn[inID].ScriptSig = NewScriptBuilder().AddData(signature).AddData(pubKey).Script()
+	}
+
+	return nil
+}
+
+// signHash signs a hash with a deterministic nonce, the signature is DER encoded
+func signHash(privateKey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s := signDeterministic(&privateKey, hash)
+
+	return encodeDERSignature(r, s)
+}
+
+// prevOutput returns the output spent by an input
+func prevOutput(vin TXInput, prevTXs map[string]Transaction) TXOutput {
+	return prevTXs[hex.EncodeToString(vin.TxID)].VOut[vin.VOut]
+}
+
+// Fee returns the difference between the values of the inputs and the outputs
+func (tx *Transaction) Fee(prevTXs map[string]Transaction) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	fee := 0
+	for _, vin := range tx.VIn {
+		fee += prevTXs[hex.EncodeToString(vin.TxID)].VOut[vin.VOut].Value
+	}
+
+	return fee - tx.OutputValue()
+}
+
+// OutputValue returns the sum of the values of the outputs
+func (tx *Transaction) OutputValue() int {
+	value := 0
+	for _, out := range tx.VOut {
+		value += out.Value
+	}
+
+	return value
+}
+
+// checkOutputValues checks no output of a transaction has a negative value
+// and the sum of the values doesn't overflow
+func checkOutputValues(tx *Transaction) error {
+	sum := 0
+	for i, out := range tx.VOut {
+		if out.Value < 0 {
+			return fmt.Errorf("%x output %d has a negative value", tx.ID, i)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 
-		tx.VIn[inID].Signature = signature
-		txCopy.VIn[inID].PubKey = nil
+		var ok bool
+		if sum, ok = addValue(sum, out.Value); !ok {
+			return fmt.Errorf("%x output values overflow", tx.ID)
+		}
 	}
+
+	return nil
+}
+
+// addValue adds a non-negative value to a sum, it returns false when the
+// sum overflows
+func addValue(sum, value int) (int, bool) {
+	if value > maxValue-sum {
+		return sum, false
+	}
+
+	return sum + value, true
 }
 
 // validatePrevTXs validates previous transaction are correct
@@ -117,83 +301,139 @@ func (tx *Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	lines = append(lines, fmt.Sprintf("     Version: %d", tx.Version))
+	lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
 
 	for i, input := range tx.VIn {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.TxID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.VOut))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Data:      %x", input.ScriptSig))
+		} else {
+			lines = append(lines, fmt.Sprintf("       Script:    %s", DisasmScript(input.ScriptSig)))
+		}
+		lines = append(lines, fmt.Sprintf("       Sequence:  %d", input.Sequence))
 	}
 
 	for i, output := range tx.VOut {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisasmScript(output.ScriptPubKey)))
 	}
 
 	return strings.Join(lines, "\n")
 }
 
-// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
+// TrimmedCopy creates a copy of Transaction without unlocking scripts
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, vIn := range tx.VIn {
-		inputs = append(inputs, TXInput{TxID: vIn.TxID, VOut: vIn.VOut, Signature: nil, PubKey: nil})
+		inputs = append(inputs, TXInput{TxID: vIn.TxID, VOut: vIn.VOut, ScriptSig: nil, Sequence: vIn.Sequence})
 	}
 
 	for _, vOut := range tx.VOut {
-		outputs = append(outputs, TXOutput{Value: vOut.Value, PubKeyHash: vOut.PubKeyHash})
+		outputs = append(outputs, TXOutput{Value: vOut.Value, ScriptPubKey: vOut.ScriptPubKey})
 	}
 
-	txCopy := Transaction{ID: tx.ID, VIn: inputs, VOut: outputs}
+	txCopy := Transaction{ID: tx.ID, VIn: inputs, VOut: outputs, Version: tx.Version, LockTime: tx.LockTime}
 
 	return txCopy
 }
 
-// Verify verifies signature of Transaction inputs
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+// Verify runs the unlocking script of each input with the locking script
+// of the output it spends, the keys are on the curve of the chain
+func (tx *Transaction) Verify(prevTXs map[string]Transaction, curve KeyCurve) bool {
 	if tx.IsCoinbase() {
 		return true
 	} else if err := tx.validatePrevTXs(prevTXs); err != nil {
 		log.Panic(err)
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for inID, vIn := range tx.VIn {
-		prevTX := prevTXs[hex.EncodeToString(vIn.TxID)]
-		txCopy.VIn[inID].Signature = nil
-		txCopy.VIn[inID].PubKey = prevTX.VOut[vIn.VOut].PubKeyHash
+		prevOut := prevOutput(vIn, prevTXs)
+		checker := &txSigChecker{tx: tx, index: inID, curve: curve}
+
+		if err := VerifyScript(vIn.ScriptSig, prevOut.ScriptPubKey, checker); err != nil {
+			return false
+		}
+	}
 
-		r, s := &big.Int{}, &big.Int{}
-		sigLen := len(vIn.Signature)
-		r.SetBytes(vIn.Signature[:sigLen/2])
-		s.SetBytes(vIn.Signature[sigLen/2:])
+	return true
+}
 
-		x, y := &big.Int{}, &big.Int{}
-		keyLen := len(vIn.PubKey)
-		x.SetBytes(vIn.PubKey[:keyLen/2])
-		y.SetBytes(vIn.PubKey[keyLen/2:])
+// txSigChecker checks the script conditions of an input of a transaction,
+// signatures are checked with keys on curve
+type txSigChecker struct {
+	tx    *Transaction
+	index int
+	curve KeyCurve
+}
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+// checkSig verifies a strictly DER encoded signature followed by its hash
+// type of the input by a compressed secp256k1 or raw X||Y P-256 public key,
+// s must be in the lower half of the curve order
+func (c *txSigChecker) checkSig(sig, pubKey, script []byte) bool {
+	if len(sig) < 2 || len(pubKey) == 0 {
+		return false
+	}
 
-		rawPubKey := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
-		if ecdsa.Verify(rawPubKey, []byte(dataToVerify), r, s) == false {
-			return false
-		}
+	hashType := SigHashType(sig[len(sig)-1])
+	hash := c.tx.signatureHash(c.index, script, hashType)
+	if hash == nil {
+		return false
+	}
 
-		txCopy.VIn[inID].PubKey = nil
+	r, s, err := parseDERSignature(sig[:len(sig)-1])
+	if err != nil {
+		return false
 	}
 
-	return true
+	key, err := parsePublicKey(c.curve, pubKey)
+	if err != nil {
+		return false
+	}
+
+	if isHighS(key.Curve.Params().N, s) {
+		return false
+	}
+
+	return ecdsa.Verify(key, hash, r, s)
+}
+
+// checkLockTime checks the transaction locktime enforces lockTime
+func (c *txSigChecker) checkLockTime(lockTime int64) bool {
+	txLockTime := int64(c.tx.LockTime)
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return false
+	}
+
+	return lockTime <= txLockTime && c.tx.VIn[c.index].Sequence != SequenceFinal
+}
+
+// checkSequence checks the input relative locktime enforces sequence
+func (c *txSigChecker) checkSequence(sequence int64) bool {
+	txSequence := int64(c.tx.VIn[c.index].Sequence)
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return false
+	}
+
+	if (sequence&SequenceLockTimeTypeFlag == 0) != (txSequence&SequenceLockTimeTypeFlag == 0) {
+		return false
+	}
+
+	return sequence&SequenceLockTimeMask <= txSequence&SequenceLockTimeMask
 }
 
 // NewCoinbaseTX creates a new coinbase transaction
 func NewCoinbaseTX(to, data string) *Transaction {
+	return newCoinbaseTX(to, data, subsidy)
+}
+
+// newCoinbaseTX creates a new coinbase transaction paying value
+func newCoinbaseTX(to, data string, value int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -204,48 +444,85 @@ func NewCoinbaseTX(to, data string) *Transaction {
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txIn := TXInput{TxID: []byte{}, VOut: TransactionCoinbaseVInVOutDefault, Signature: nil, PubKey: []byte(data)}
-	txOut := NewTXOutput(subsidy, to)
+	txIn := TXInput{TxID: []byte{}, VOut: TransactionCoinbaseVInVOutDefault, ScriptSig: []byte(data)}
+	txOut := NewTXOutput(value, to)
 	tx := &Transaction{ID: nil, VIn: []TXInput{txIn}, VOut: []TXOutput{*txOut}}
 	tx.ID = tx.Hash()
 
 	return tx
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxoSet *UTXOSet) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+// NewUTXOTransaction creates a new transaction paying amount to the address and
+// leaving fee to the miner
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, utxoSet *UTXOSet) *Transaction {
+	tx, err := NewSendManyTransaction(wallet, []Payment{{Address: to, Amount: amount}}, fee, utxoSet)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return tx
+}
+
+// Payment is an amount paid to an address
+type Payment struct {
+	Address string
+	Amount  int
+}
+
+// NewSendManyTransaction creates a new transaction paying every payment
+// with a single change output back to the wallet and leaving fee to the
+// miner, Wallets.NewTransaction sends the change to a fresh address instead
+func NewSendManyTransaction(wallet *Wallet, payments []Payment, fee int, utxoSet *UTXOSet) (*Transaction, error) {
+	if len(payments) == 0 || fee < 0 {
+		return nil, fmt.Errorf("invalid payments or fee %d", fee)
+	}
+
+	total := fee
+	for _, payment := range payments {
+		if payment.Amount <= 0 {
+			return nil, fmt.Errorf("invalid amount %d to %s", payment.Amount, payment.Address)
+		}
+		total += payment.Amount
+	}
 
 	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := utxoSet.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := utxoSet.FindSpendableOutputs(pubKeyHash, total)
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+	if acc < total {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, acc, total)
 	}
 
+	bc := utxoSet.Blockchain
+	builder := NewTxBuilder(bc.Params(), bc.GetBestHeight()+1)
+
 	// builds a list of inputs
 	for txID, outs := range validOutputs {
 		txIDDecode, err := hex.DecodeString(txID)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
 		for _, out := range outs {
-			input := TXInput{TxID: txIDDecode, VOut: out, Signature: nil, PubKey: wallet.PublicKey}
-			inputs = append(inputs, input)
+			builder.AddInput(txIDDecode, out)
 		}
 	}
 
-	from := string(wallet.GetAddress())
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	for _, payment := range payments {
+		builder.AddOutput(payment.Amount, payment.Address)
 	}
 
-	tx := Transaction{ID: nil, VIn: inputs, VOut: outputs}
-	tx.ID = tx.Hash()
-	utxoSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+	if change := acc - total; change > 0 {
+		builder.AddOutput(change, string(wallet.GetAddress()))
+	}
 
-	return nil
+	tx, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = bc.SignTransaction(tx, KeystoreSigner(wallet)); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
 }
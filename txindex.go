@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+)
+
+// txIndexBucket is the bucket name of the main chain block hashes by transaction id
+const txIndexBucket = "txindex"
+
+// indexBlockTxs points the transactions of a main chain block at it
+func indexBlockTxs(index *bolt.Bucket, block *Block) error {
+	for _, tx := range block.Transactions {
+		if err := index.Put(tx.ID, block.Hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexBlockTxs removes the transactions of a block leaving the main
+// chain, unless the index points them at another block
+func unindexBlockTxs(index *bolt.Bucket, block *Block) error {
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(index.Get(tx.ID), block.Hash) {
+			continue
+		}
+
+		if err := index.Delete(tx.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexHash removes the transactions of the block with a hash from the index
+func unindexHash(tx *bolt.Tx, index *bolt.Bucket, hash []byte) error {
+	data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+	if data == nil {
+		return fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+
+	return unindexBlockTxs(index, DeserializeBlock(data))
+}
+
+// txBlock returns the main chain block of a transaction from the tx index
+func txBlock(tx *bolt.Tx, txID []byte) (*Block, error) {
+	var hash []byte
+	if index := tx.Bucket([]byte(txIndexBucket)); index != nil {
+		hash = index.Get(txID)
+	}
+
+	if hash == nil {
+		return nil, fmt.Errorf("%w: %x", ErrTransactionNotFound, txID)
+	}
+
+	data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+	if data == nil {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+
+	return DeserializeBlock(data), nil
+}
+
+// RepairTxIndex indexes the transactions of the main chain of databases
+// created before the tx index existed
+func (bc *Blockchain) RepairTxIndex() {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(txIndexBucket)) != nil {
+			return nil
+		}
+
+		index, err := tx.CreateBucket([]byte(txIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte(tipDbKey))))
+		for height := 0; height <= tip.Height; height++ {
+			block, err := blockAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			if err = indexBlockTxs(index, block); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// GetTxConfirmations returns the number of confirmations of a main chain
+// transaction, one when it is in the tip, and its block
+func (bc *Blockchain) GetTxConfirmations(txID []byte) (int, *Block, error) {
+	var confirmations int
+	var block *Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if block, err = txBlock(tx, txID); err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte(tipDbKey))))
+		confirmations = tip.Height - block.Height + 1
+
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return confirmations, block, nil
+}
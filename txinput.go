@@ -2,8 +2,12 @@ package blockchain
 
 // TXInput represents a transaction input
 type TXInput struct {
-	TxID      []byte
-	VOut      int
-	Signature []byte
-	PubKey    []byte
+	TxID []byte
+	VOut int
+
+	// ScriptSig is the unlocking script of the spent output, the coinbase data for coinbase inputs
+	ScriptSig []byte
+
+	// Sequence enables the transaction locktime when it isn't SequenceFinal
+	Sequence uint32
 }
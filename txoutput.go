@@ -8,22 +8,37 @@ import (
 
 // TXOutput represents a transaction outpu
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value int
+
+	// ScriptPubKey is the locking script of the output
+	ScriptPubKey []byte
 }
 
+// Lock locks the output to an address, a public key hash or a script hash
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	out.PubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	hash := pubKeyHashFromAddress(string(address))
+
+	if addressVersion(string(address)) == scriptHashVersion {
+		out.ScriptPubKey = PayToScriptHashScript(hash)
+	} else {
+		out.ScriptPubKey = PayToPubKeyHashScript(hash)
+	}
 }
 
+// PubKeyHash returns the public key hash the output is locked to, nil when
+// the output isn't a pay to public key hash
+func (out *TXOutput) PubKeyHash() []byte {
+	return ExtractPubKeyHash(out.ScriptPubKey)
+}
+
+// IsLockedWithKey returns whether the output is locked to the public key hash
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	return bytes.Equal(out.PubKeyHash(), pubKeyHash)
 }
 
 // NewTXOutput creates a new TXOutput
 func NewTXOutput(value int, address string) *TXOutput {
-	txo := &TXOutput{Value: value, PubKeyHash: nil}
+	txo := &TXOutput{Value: value, ScriptPubKey: nil}
 	txo.Lock([]byte(address))
 
 	return txo
@@ -32,6 +47,22 @@ func NewTXOutput(value int, address string) *TXOutput {
 // TXOutputs collects TXOutput
 type TXOutputs struct {
 	Outputs []TXOutput
+
+	// Indexes holds the index of each output in its transaction
+	Indexes []int
+
+	// Height and Timestamp are those of the block confirming the transaction
+	Height    int
+	Timestamp int64
+}
+
+// Index returns the index in its transaction of the i-th output
+func (outs TXOutputs) Index(i int) int {
+	if i < len(outs.Indexes) {
+		return outs.Indexes[i]
+	}
+
+	return i
 }
 
 // DeserializeOutputs deserializes byte slice to TXOutputs
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"sort"
+)
+
+// undoBucket is the bucket of the outputs spent by each block of the UTXO set
+const undoBucket = "undo"
+
+// ErrNoUndoData is returned when a block can't be disconnected from the UTXO set
+var ErrNoUndoData = errors.New("block has no undo data, reindex the utxo set")
+
+// spentOutput is an output spent by a block, with what is needed to restore it
+type spentOutput struct {
+	TxID      []byte
+	Index     int
+	Output    TXOutput
+	Height    int
+	Timestamp int64
+}
+
+// blockUndo are the outputs spent by a block in the order they were spent
+type blockUndo struct {
+	Spent []spentOutput
+}
+
+// serialize serializes the undo data
+func (u *blockUndo) serialize() []byte {
+	var buff bytes.Buffer
+
+	if err := gob.NewEncoder(&buff).Encode(u); err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// putBlockUndo stores the undo data of a block
+func putBlockUndo(tx *bolt.Tx, blockHash []byte, undo *blockUndo) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put(blockHash, undo.serialize())
+}
+
+// getBlockUndo loads the undo data of a block
+func getBlockUndo(tx *bolt.Tx, blockHash []byte) (*blockUndo, error) {
+	var data []byte
+	if b := tx.Bucket([]byte(undoBucket)); b != nil {
+		data = b.Get(blockHash)
+	}
+
+	if data == nil {
+		return nil, fmt.Errorf("%w: %x", ErrNoUndoData, blockHash)
+	}
+
+	var undo blockUndo
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo); err != nil {
+		return nil, err
+	}
+
+	return &undo, nil
+}
+
+// disconnectUTXOSet reverts a block applied by updateUTXOSet: its outputs
+// are removed, the outputs it spent are restored and the UTXO set tip
+// moves back to the previous block
+func disconnectUTXOSet(tx *bolt.Tx, block *Block) error {
+	if !bytes.Equal(utxoTip(tx), block.Hash) {
+		return fmt.Errorf("block %x is not the utxo set tip", block.Hash)
+	}
+
+	undo, err := getBlockUndo(tx, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	b := tx.Bucket([]byte(utxoBucket))
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		if err = b.Delete(block.Transactions[i].ID); err != nil {
+			return err
+		}
+	}
+
+	for i := len(undo.Spent) - 1; i >= 0; i-- {
+		spent := undo.Spent[i]
+
+		outs := TXOutputs{Height: spent.Height, Timestamp: spent.Timestamp}
+		if data := b.Get(spent.TxID); data != nil {
+			outs = DeserializeOutputs(data)
+		}
+		outs.restore(spent.Index, spent.Output)
+
+		if err = b.Put(spent.TxID, outs.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Bucket([]byte(undoBucket)).Delete(block.Hash); err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(blocksBucket)).Put([]byte(utxoTipDbKey), block.PrevBlockHash)
+}
+
+// restore adds back the output at index in its transaction, outputs stay
+// ordered by index
+func (outs *TXOutputs) restore(index int, out TXOutput) {
+	indexes := make([]int, len(outs.Outputs))
+	for i := range outs.Outputs {
+		indexes[i] = outs.Index(i)
+	}
+
+	pos := sort.SearchInts(indexes, index)
+
+	outs.Outputs = append(outs.Outputs, TXOutput{})
+	copy(outs.Outputs[pos+1:], outs.Outputs[pos:])
+	outs.Outputs[pos] = out
+
+	indexes = append(indexes, 0)
+	copy(indexes[pos+1:], indexes[pos:])
+	indexes[pos] = index
+	outs.Indexes = indexes
+}
@@ -1,20 +1,25 @@
 package blockchain
 
 import (
-	"bytes"
 	"encoding/binary"
-	"log"
 )
 
-// IntToHex converts an int64 to a byte array
+// IntToHex converts an int64 to its 8 bytes big endian two's complement
+// encoding, it encodes the integers hashed in block headers
 func IntToHex(num int64) []byte {
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.BigEndian, num)
-	if err != nil {
-		log.Panicln(err)
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(num))
+
+	return buf
+}
+
+// HexToInt converts a byte array produced by IntToHex back to an int64
+func HexToInt(data []byte) int64 {
+	if len(data) != 8 {
+		return 0
 	}
 
-	return buff.Bytes()
+	return int64(binary.BigEndian.Uint64(data))
 }
 
 // ReverseBytes reverses a byte array
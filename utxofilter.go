@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"github.com/boltdb/bolt"
+	"log"
+	"sync"
+)
+
+const (
+	// utxoFilterFPRate is the false positive rate of the UTXO address filter
+	utxoFilterFPRate = 0.001
+
+	// utxoFilterHeadroom is how many times the current addresses the filter is sized for
+	utxoFilterHeadroom = 2
+)
+
+// utxoFilter is a bloom filter of the public key hashes locking pay to
+// public key hash outputs of the UTXO set. Spent outputs are not removed, the filter is rebuilt when it
+// is full or the UTXO set is reindexed.
+type utxoFilter struct {
+	mu       sync.Mutex
+	filter   *BloomFilter
+	items    int
+	capacity int
+}
+
+// reset drops the filter, it is rebuilt on next use
+func (f *utxoFilter) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.filter = nil
+}
+
+// mayContain returns false if no unspent output is locked with the public key hash
+func (f *utxoFilter) mayContain(db *bolt.DB, pubKeyHash []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.filter == nil {
+		if err := f.build(db); err != nil {
+			log.Println(err)
+			return true
+		}
+	}
+
+	return f.filter.MayContain(pubKeyHash)
+}
+
+// addBlock adds the public key hashes of the block's outputs
+func (f *utxoFilter) addBlock(block *Block) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.filter == nil {
+		return
+	}
+
+	for _, tx := range block.Transactions {
+		for _, out := range tx.VOut {
+			if pubKeyHash := out.PubKeyHash(); pubKeyHash != nil {
+				f.filter.Add(pubKeyHash)
+				f.items++
+			}
+		}
+	}
+
+	if f.items > f.capacity {
+		f.filter = nil
+	}
+}
+
+// build scans the chainstate bucket and fills a new filter
+func (f *utxoFilter) build(db *bolt.DB) error {
+	return db.View(func(tx *bolt.Tx) error {
+		var pubKeyHashes [][]byte
+
+		if b := tx.Bucket([]byte(utxoBucket)); b != nil {
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				for _, out := range DeserializeOutputs(v).Outputs {
+					if pubKeyHash := out.PubKeyHash(); pubKeyHash != nil {
+						pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+					}
+				}
+			}
+		}
+
+		f.capacity = (len(pubKeyHashes) + 1) * utxoFilterHeadroom
+		f.items = len(pubKeyHashes)
+		f.filter = NewBloomFilter(f.capacity, utxoFilterFPRate, 0)
+
+		for _, pubKeyHash := range pubKeyHashes {
+			f.filter.Add(pubKeyHash)
+		}
+
+		return nil
+	})
+}
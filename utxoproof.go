@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrUTXONotFound is returned when a transaction has no unspent outputs
+	ErrUTXONotFound = errors.New("transaction has no unspent outputs")
+
+	// ErrInvalidUTXOProof is returned when a UTXO proof doesn't match a commitment
+	ErrInvalidUTXOProof = errors.New("invalid utxo proof")
+)
+
+// UTXOProof proves the unspent outputs of a transaction are in the UTXO set
+// committed by a UTXO commitment
+type UTXOProof struct {
+	TxID    []byte
+	Outputs []byte
+	Proof   MerkleProof
+}
+
+// Verify checks the proof against a UTXO commitment
+func (p *UTXOProof) Verify(commitment []byte) error {
+	if !p.Proof.Verify(commitment, utxoCommitmentLeaf(p.TxID, p.Outputs)) {
+		return fmt.Errorf("%w: outputs of %x", ErrInvalidUTXOProof, p.TxID)
+	}
+
+	return nil
+}
+
+// Output returns the proven unspent output at index vout
+func (p *UTXOProof) Output(vout int) (TXOutput, bool) {
+	outs := DeserializeOutputs(p.Outputs)
+	for i, out := range outs.Outputs {
+		if outs.Index(i) == vout {
+			return out, true
+		}
+	}
+
+	return TXOutput{}, false
+}
+
+// Proofs returns the proofs of the unspent outputs of the transactions
+// together with the commitment and the tip of the UTXO set they prove
+func (u UTXOSet) Proofs(txIDs [][]byte) ([]UTXOProof, []byte, []byte, error) {
+	bc := u.Blockchain
+	bc.proofTreeMu.Lock()
+	defer bc.proofTreeMu.Unlock()
+
+	var proofs []UTXOProof
+	var commitment, tip []byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		tree := bc.proofTree
+		if tree == nil || !bytes.Equal(tree.tip, utxoTip(tx)) {
+			tree = newUTXOProofTree(tx)
+			bc.proofTree = tree
+		}
+
+		b := tx.Bucket([]byte(utxoBucket))
+		for _, txID := range txIDs {
+			pos, ok := tree.positions[string(txID)]
+			if !ok {
+				return fmt.Errorf("%w: %x", ErrUTXONotFound, txID)
+			}
+
+			proofs = append(proofs, UTXOProof{
+				TxID:    txID,
+				Outputs: append([]byte{}, b.Get(txID)...),
+				Proof:   tree.proof(pos),
+			})
+		}
+
+		commitment, tip = tree.commitment, tree.tip
+		return nil
+	})
+
+	return proofs, commitment, tip, err
+}
+
+// utxoProofTree is the Merkle tree of the UTXO set at a tip, it is built
+// once per tip so proofs don't read the whole chainstate
+type utxoProofTree struct {
+	tip        []byte
+	commitment []byte
+
+	// levels are the node hashes from the leaves up, odd levels padded with
+	// their last node
+	levels    [][][]byte
+	positions map[string]int
+}
+
+// newUTXOProofTree builds the Merkle tree of the UTXO set
+func newUTXOProofTree(tx *bolt.Tx) *utxoProofTree {
+	tree := &utxoProofTree{tip: append([]byte{}, utxoTip(tx)...), positions: make(map[string]int)}
+
+	var level [][]byte
+	c := tx.Bucket([]byte(utxoBucket)).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		tree.positions[string(k)] = len(level)
+		level = append(level, NewMerkleNode(nil, nil, utxoCommitmentLeaf(k, v)).Data)
+	}
+
+	if len(level) == 0 {
+		hash := sha256.Sum256(nil)
+		tree.commitment = hash[:]
+		return tree
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		tree.levels = append(tree.levels, level)
+
+		next := make([][]byte, 0, len(level)/2)
+		for j := 0; j < len(level); j += 2 {
+			next = append(next, hashMerklePair(level[j], level[j+1]))
+		}
+		level = next
+	}
+	tree.commitment = level[0]
+
+	return tree
+}
+
+// proof returns the Merkle proof of the leaf at index
+func (t *utxoProofTree) proof(index int) MerkleProof {
+	proof := MerkleProof{Index: index}
+	for _, level := range t.levels {
+		proof.Siblings = append(proof.Siblings, level[index^1])
+		index /= 2
+	}
+
+	return proof
+}
+
+// ValidateBlockStateless validates the transactions of a block committing to
+// a UTXO set, using only proofs of the outputs they spend
+func ValidateBlockStateless(block *Block, proofs []UTXOProof, params *ChainParams) error {
+	if len(block.UTXOCommitment) == 0 {
+		return fmt.Errorf("%w: block %x has no utxo commitment", ErrInvalidBlock, block.Hash)
+	}
+
+	if err := checkProofOfWork(block, params.TargetBits); err != nil {
+		return err
+	}
+
+	if err := checkBlockTransactions(block, params); err != nil {
+		return err
+	}
+
+	proven := make(map[string]*UTXOProof, len(proofs))
+	for i := range proofs {
+		proof := &proofs[i]
+		if err := proof.Verify(block.UTXOCommitment); err != nil {
+			return err
+		}
+
+		proven[hex.EncodeToString(proof.TxID)] = proof
+	}
+
+	fees := 0
+	for _, tx := range block.Transactions[1:] {
+		prevTXs := make(map[string]Transaction)
+
+		for _, vin := range tx.VIn {
+			id := hex.EncodeToString(vin.TxID)
+			proof, ok := proven[id]
+			if !ok {
+				return fmt.Errorf("%w: no proof for %x", ErrInvalidTransaction, vin.TxID)
+			}
+
+			out, ok := proof.Output(vin.VOut)
+			if !ok {
+				return fmt.Errorf("%w: %x spends unknown output %x:%d", ErrInvalidTransaction, tx.ID, vin.TxID, vin.VOut)
+			}
+
+			prevTX := prevTXs[id]
+			prevTX.ID = vin.TxID
+			for len(prevTX.VOut) <= vin.VOut {
+				prevTX.VOut = append(prevTX.VOut, TXOutput{})
+			}
+			prevTX.VOut[vin.VOut] = out
+			prevTXs[id] = prevTX
+		}
+
+		fee := tx.Fee(prevTXs)
+		if fee < 0 {
+			return fmt.Errorf("%w: %x outputs exceed inputs by %d", ErrInvalidTransaction, tx.ID, -fee)
+		}
+		fees += fee
+
+		if !tx.Verify(prevTXs, params.KeyCurve) {
+			return fmt.Errorf("%w: %x has an invalid signature", ErrInvalidTransaction, tx.ID)
+		}
+	}
+
+	if claimed, allowed := block.Transactions[0].OutputValue(), params.BlockSubsidy(block.Height)+fees; claimed > allowed {
+		return fmt.Errorf("%w: coinbase claims %d, allowed %d", ErrInvalidBlock, claimed, allowed)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestUTXOProofsFollowTheTip(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	to := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	var txIDs [][]byte
+	for i := 0; i < 5; i++ {
+		block := bc.MineBlock([]*Transaction{bc.NewCoinbaseTX(to)})
+		txIDs = append(txIDs, block.Transactions[0].ID)
+
+		proofs, commitment, tip, err := UTXOSet{bc}.Proofs(txIDs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tip, block.Hash) {
+			t.Fatalf("proofs at tip %x, want %x", tip, block.Hash)
+		}
+
+		if err := bc.db.View(func(tx *bolt.Tx) error {
+			if want := utxoCommitment(tx); !bytes.Equal(commitment, want) {
+				t.Fatalf("commitment %x, want %x", commitment, want)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, proof := range proofs {
+			if err := proof.Verify(commitment); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if _, _, _, err := (UTXOSet{bc}).Proofs([][]byte{[]byte("missing")}); !errors.Is(err, ErrUTXONotFound) {
+		t.Fatalf("error %v, want %v", err, ErrUTXONotFound)
+	}
+}
@@ -1,13 +1,39 @@
 package blockchain
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/boltdb/bolt"
 	"log"
 )
 
-// utxoBucket is unspent transaction bucket name
-const utxoBucket = "chainstate"
+const (
+	// utxoBucket is unspent transaction bucket name
+	utxoBucket = "chainstate"
+
+	// utxoTipDbKey is the key in the blocks bucket of the block hash the UTXO set is built on
+	utxoTipDbKey = "u"
+
+	// utxoReindexBucket is the bucket the UTXO set is rebuilt in by Reindex
+	utxoReindexBucket = "chainstate_reindex"
+
+	// reindexBatchSize is the number of entries written per transaction by Reindex
+	reindexBatchSize = 1000
+)
+
+var (
+	// ErrUTXOCommitmentMismatch is returned when the UTXO set doesn't match a block's commitment
+	ErrUTXOCommitmentMismatch = errors.New("utxo commitment mismatch")
+
+	// ErrUTXOSetStale is returned when the UTXO set is not built on the tip
+	ErrUTXOSetStale = errors.New("utxo set is not built on the tip, reindex it")
+
+	// ErrDoubleSpend is returned when a transaction spends a spent or unknown output
+	ErrDoubleSpend = errors.New("output is already spent")
+)
 
 // UTXOSet represents UTXO set
 type UTXOSet struct {
@@ -21,11 +47,29 @@ func NewUTXOSet(bc *Blockchain) UTXOSet {
 
 // FindSpendableOutputs finds and returns unspent outputs to reference in inputs
 func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	if !u.Blockchain.utxoFilter.mayContain(u.Blockchain.db, pubKeyHash) {
+		return 0, make(map[string][]int)
+	}
+
+	return u.findSpendableOutputs(amount, func(out TXOutput) bool {
+		return out.IsLockedWithKey(pubKeyHash)
+	})
+}
+
+// FindSpendableScriptOutputs finds unspent outputs locked with the script,
+// e.g. a multisig script, to reference in inputs
+func (u UTXOSet) FindSpendableScriptOutputs(script []byte, amount int) (int, map[string][]int) {
+	return u.findSpendableOutputs(amount, func(out TXOutput) bool {
+		return bytes.Equal(out.ScriptPubKey, script)
+	})
+}
+
+// findSpendableOutputs finds unspent outputs matching until their value reaches amount
+func (u UTXOSet) findSpendableOutputs(amount int, match func(TXOutput) bool) (int, map[string][]int) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
-	db := u.Blockchain.db
 
-	if err := db.View(func(tx *bolt.Tx) error {
+	if err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
@@ -34,9 +78,9 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 			outs := DeserializeOutputs(v)
 
 			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
+				if match(out) && accumulated < amount {
 					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+					unspentOutputs[txID] = append(unspentOutputs[txID], outs.Index(outIdx))
 				}
 			}
 		}
@@ -49,41 +93,304 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 	return accumulated, unspentOutputs
 }
 
-// Reindex rebuilds the UTXO set
+// FindUTXO finds the unspent outputs locked with the public key hash, public
+// key hashes without outputs are mostly answered by a bloom filter without
+// scanning the chainstate
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
+	var utxos []TXOutput
+
+	if !u.Blockchain.utxoFilter.mayContain(u.Blockchain.db, pubKeyHash) {
+		return utxos
+	}
+
+	if err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
+		utxos = findUTXO(tx, pubKeyHash)
+		return nil
+	}); err != nil {
+		log.Panic(err)
+	}
+
+	return utxos
+}
+
+// findUTXO scans the chainstate for the outputs locked with the public key hash
+func findUTXO(tx *bolt.Tx, pubKeyHash []byte) []TXOutput {
+	var utxos []TXOutput
+
+	c := tx.Bucket([]byte(utxoBucket)).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		for _, out := range DeserializeOutputs(v).Outputs {
+			if out.IsLockedWithKey(pubKeyHash) {
+				utxos = append(utxos, out)
+			}
+		}
+	}
+
+	return utxos
+}
+
+// Reindex rebuilds the UTXO set. The new set is written to a temporary
+// bucket and swapped in with the UTXO set tip in a single transaction, an
+// interrupted reindex leaves the previous set in place and is resumed by
+// RepairUTXOSet.
 func (u UTXOSet) Reindex() {
-	bucket := []byte(utxoBucket)
+	tip := u.Blockchain.currentTip()
+	utxo := u.Blockchain.FindUTXO()
 
-	if err := u.Blockchain.db.Update(func(tx *bolt.Tx) error {
-		if err := tx.DeleteBucket(bucket); err != nil && err != bolt.ErrBucketNotFound {
+	keys := make([][]byte, 0, len(utxo))
+	for txID := range utxo {
+		key, err := hex.DecodeString(txID)
+		if err != nil {
 			log.Panic(err)
 		}
+		keys = append(keys, key)
+	}
+
+	if err := u.Blockchain.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(utxoReindexBucket)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		_, err := tx.CreateBucket([]byte(utxoReindexBucket))
+		return err
+	}); err != nil {
+		log.Panic(err)
+	}
+
+	for start := 0; start < len(keys); start += reindexBatchSize {
+		batch := keys[start:]
+		if len(batch) > reindexBatchSize {
+			batch = batch[:reindexBatchSize]
+		}
+
+		if err := u.Blockchain.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(utxoReindexBucket))
+			for _, key := range batch {
+				if err := b.Put(key, utxo[hex.EncodeToString(key)].Serialize()); err != nil {
+					return err
+				}
+			}
 
-		if _, err := tx.CreateBucket(bucket); err != nil {
+			return nil
+		}); err != nil {
 			log.Panic(err)
 		}
+	}
+
+	if err := u.Blockchain.db.Update(func(tx *bolt.Tx) error {
+		return swapReindexedUTXOSet(tx, tip)
+	}); err != nil {
+		log.Panic(err)
+	}
+
+	u.Blockchain.utxoFilter.reset()
+}
+
+// swapReindexedUTXOSet replaces the chainstate bucket with the reindexed
+// one and moves the UTXO set tip
+func swapReindexedUTXOSet(tx *bolt.Tx, tip []byte) error {
+	if err := tx.DeleteBucket([]byte(utxoBucket)); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
 
+	b, err := tx.CreateBucket([]byte(utxoBucket))
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Bucket([]byte(utxoReindexBucket)).ForEach(func(k, v []byte) error {
+		return b.Put(append([]byte{}, k...), append([]byte{}, v...))
+	}); err != nil {
+		return err
+	}
+
+	if err = tx.DeleteBucket([]byte(utxoReindexBucket)); err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(blocksBucket)).Put([]byte(utxoTipDbKey), tip)
+}
+
+// RepairUTXOSet reindexes the UTXO set when a reindex was interrupted or
+// the chainstate bucket is missing, and returns whether it did
+func (u UTXOSet) RepairUTXOSet() bool {
+	incomplete := false
+
+	if err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
+		incomplete = tx.Bucket([]byte(utxoReindexBucket)) != nil || tx.Bucket([]byte(utxoBucket)) == nil
 		return nil
 	}); err != nil {
 		log.Panic(err)
 	}
 
-	utxo := u.Blockchain.FindUTXO()
+	if !incomplete {
+		return false
+	}
+
+	log.Println("UTXO set reindex was interrupted, reindexing")
+	u.Reindex()
+
+	return true
+}
+
+// Update updates the UTXO set with transactions from the block
+func (u UTXOSet) Update(block *Block) {
 	if err := u.Blockchain.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
+		return updateUTXOSet(tx, block)
+	}); err != nil {
+		log.Panic(err)
+	}
+
+	u.Blockchain.utxoFilter.addBlock(block)
+}
+
+// updateUTXOSet removes the outputs spent by the block from the UTXO set,
+// adds the new ones and moves the UTXO set tip to the block. The spent
+// outputs are kept as undo data to disconnect the block in a reorg.
+func updateUTXOSet(tx *bolt.Tx, block *Block) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(utxoBucket))
+	if err != nil {
+		return err
+	}
+
+	undo := &blockUndo{}
 
-		for txID, outs := range utxo {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
+	for _, transaction := range block.Transactions {
+		if !transaction.IsCoinbase() {
+			for _, vin := range transaction.VIn {
+				outsData := b.Get(vin.TxID)
+				if outsData == nil {
+					return fmt.Errorf("input %x:%d is not in the utxo set", vin.TxID, vin.VOut)
+				}
+
+				outs := DeserializeOutputs(outsData)
+				updatedOuts := TXOutputs{Height: outs.Height, Timestamp: outs.Timestamp}
+				found := false
+
+				for outIdx, out := range outs.Outputs {
+					if idx := outs.Index(outIdx); idx != vin.VOut {
+						updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+						updatedOuts.Indexes = append(updatedOuts.Indexes, idx)
+					} else {
+						found = true
+						undo.Spent = append(undo.Spent, spentOutput{
+							TxID:      vin.TxID,
+							Index:     idx,
+							Output:    out,
+							Height:    outs.Height,
+							Timestamp: outs.Timestamp,
+						})
+					}
+				}
+
+				if !found {
+					return fmt.Errorf("input %x:%d is not in the utxo set", vin.TxID, vin.VOut)
+				}
+
+				if len(updatedOuts.Outputs) == 0 {
+					err = b.Delete(vin.TxID)
+				} else {
+					err = b.Put(vin.TxID, updatedOuts.Serialize())
+				}
+
+				if err != nil {
+					return err
+				}
 			}
+		}
 
-			if err = b.Put(key, outs.Serialize()); err != nil {
-				log.Panic(err)
+		newOutputs := TXOutputs{Height: block.Height, Timestamp: block.Timestamp}
+		for outIdx, out := range transaction.VOut {
+			if !out.IsUnspendable() {
+				newOutputs.Outputs = append(newOutputs.Outputs, out)
+				newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
 			}
 		}
 
+		if len(newOutputs.Outputs) == 0 {
+			continue
+		}
+
+		if err = b.Put(transaction.ID, newOutputs.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	if err = putBlockUndo(tx, block.Hash, undo); err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(blocksBucket)).Put([]byte(utxoTipDbKey), block.Hash)
+}
+
+// Commitment returns the commitment to the UTXO set, it is the root of a
+// Merkle tree over the serialized chainstate entries in key order
+func (u UTXOSet) Commitment() []byte {
+	var commitment []byte
+
+	if err := u.Blockchain.db.View(func(tx *bolt.Tx) error {
+		commitment = utxoCommitment(tx)
 		return nil
 	}); err != nil {
 		log.Panic(err)
 	}
+
+	return commitment
+}
+
+// VerifyCommitment checks the UTXO set matches the commitment of a block
+// built on it, e.g. after importing a UTXO snapshot
+func (u UTXOSet) VerifyCommitment(block *Block) error {
+	if commitment := u.Commitment(); !bytes.Equal(commitment, block.UTXOCommitment) {
+		return fmt.Errorf("%w: block %x commits to %x, utxo set is %x",
+			ErrUTXOCommitmentMismatch, block.Hash, block.UTXOCommitment, commitment)
+	}
+
+	return nil
+}
+
+// utxoCommitment computes the UTXO set commitment within a bolt transaction
+func utxoCommitment(tx *bolt.Tx) []byte {
+	var leaves [][]byte
+
+	if b := tx.Bucket([]byte(utxoBucket)); b != nil {
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			leaves = append(leaves, utxoCommitmentLeaf(k, v))
+		}
+	}
+
+	if len(leaves) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	}
+
+	return NewMerkleTree(leaves).RootNode.Data
+}
+
+// utxoCommitmentLeaf returns the Merkle leaf data of a chainstate entry
+func utxoCommitmentLeaf(txID, outs []byte) []byte {
+	return append(append([]byte{}, txID...), outs...)
+}
+
+// hasUnspentOutput returns whether the output is in the chainstate bucket
+func hasUnspentOutput(b *bolt.Bucket, txID []byte, vout int) bool {
+	outsData := b.Get(txID)
+	if outsData == nil {
+		return false
+	}
+
+	outs := DeserializeOutputs(outsData)
+	for i := range outs.Outputs {
+		if outs.Index(i) == vout {
+			return true
+		}
+	}
+
+	return false
+}
+
+// utxoTip returns the hash of the block the UTXO set is built on
+func utxoTip(tx *bolt.Tx) []byte {
+	return tx.Bucket([]byte(blocksBucket)).Get([]byte(utxoTipDbKey))
 }
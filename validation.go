@@ -0,0 +1,274 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ValidationStage is a stage of the block validation pipeline
+type ValidationStage string
+
+const (
+	// StageDeserialize decodes the block
+	StageDeserialize ValidationStage = "deserialize"
+
+	// StagePoW checks the proof of work
+	StagePoW ValidationStage = "pow"
+
+	// StageMerkle checks the block's transactions and their Merkle root
+	StageMerkle ValidationStage = "merkle"
+
+	// StageScripts verifies the transaction signatures against the UTXO
+	// set, while the block is applied
+	StageScripts ValidationStage = "scripts"
+
+	// StageUTXOApply stores the block and applies it to the UTXO set
+	StageUTXOApply ValidationStage = "utxo_apply"
+)
+
+// validationStages are the stages in pipeline order
+var validationStages = []ValidationStage{StageDeserialize, StagePoW, StageMerkle, StageScripts, StageUTXOApply}
+
+var (
+	// ErrInvalidProofOfWork is returned when a block's proof of work is invalid
+	ErrInvalidProofOfWork = errors.New("invalid proof of work")
+
+	// ErrInvalidBlock is returned when a block is malformed
+	ErrInvalidBlock = errors.New("invalid block")
+
+	// ErrInvalidTransaction is returned when a transaction is invalid
+	ErrInvalidTransaction = errors.New("invalid transaction")
+
+	// ErrOrphanBlock is returned when the previous block of a block is
+	// unknown, the block can be sent again once its parent is added
+	ErrOrphanBlock = errors.New("previous block unknown")
+)
+
+// StageStats are the timing statistics of a validation stage
+type StageStats struct {
+	Count int
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Average returns the average duration of the stage
+func (s StageStats) Average() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+
+	return s.Total / time.Duration(s.Count)
+}
+
+// SlowBlockThresholds are the durations above which a block is logged as slow
+type SlowBlockThresholds struct {
+	// Total is the threshold of the whole pipeline
+	Total time.Duration
+
+	// Stages are the thresholds of single stages
+	Stages map[ValidationStage]time.Duration
+}
+
+// DefaultSlowBlockThresholds are the default slow block thresholds
+var DefaultSlowBlockThresholds = SlowBlockThresholds{Total: 2 * time.Second}
+
+// ValidationMetrics records the timings of the block validation pipeline
+type ValidationMetrics struct {
+	mu     sync.Mutex
+	stages map[ValidationStage]StageStats
+	blocks int
+}
+
+// newValidationMetrics creates empty ValidationMetrics
+func newValidationMetrics() *ValidationMetrics {
+	return &ValidationMetrics{stages: make(map[ValidationStage]StageStats)}
+}
+
+// record adds the timings of one block
+func (m *ValidationMetrics) record(timings map[ValidationStage]time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks++
+	for stage, d := range timings {
+		stats := m.stages[stage]
+		stats.Count++
+		stats.Total += d
+		if d > stats.Max {
+			stats.Max = d
+		}
+
+		m.stages[stage] = stats
+	}
+}
+
+// Blocks returns the number of blocks which went through the pipeline
+func (m *ValidationMetrics) Blocks() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.blocks
+}
+
+// Stages returns a copy of the statistics per stage
+func (m *ValidationMetrics) Stages() map[ValidationStage]StageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stages := make(map[ValidationStage]StageStats, len(m.stages))
+	for stage, stats := range m.stages {
+		stages[stage] = stats
+	}
+
+	return stages
+}
+
+// blockTimer times the stages of a single block
+type blockTimer struct {
+	timings map[ValidationStage]time.Duration
+	start   time.Time
+}
+
+// newBlockTimer creates a blockTimer
+func newBlockTimer() *blockTimer {
+	return &blockTimer{timings: make(map[ValidationStage]time.Duration), start: time.Now()}
+}
+
+// time runs fn and records its duration for the stage
+func (t *blockTimer) time(stage ValidationStage, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	t.timings[stage] += time.Since(start)
+
+	return err
+}
+
+// slowStage returns the first stage exceeding its threshold
+func (t *blockTimer) slowStage(thresholds SlowBlockThresholds) (ValidationStage, bool) {
+	for _, stage := range validationStages {
+		limit, ok := thresholds.Stages[stage]
+		if ok && t.timings[stage] > limit {
+			return stage, true
+		}
+	}
+
+	return "", false
+}
+
+// slowestStage returns the stage which took the longest
+func (t *blockTimer) slowestStage() ValidationStage {
+	var slowest ValidationStage
+	for _, stage := range validationStages {
+		if slowest == "" || t.timings[stage] > t.timings[slowest] {
+			slowest = stage
+		}
+	}
+
+	return slowest
+}
+
+// ValidationMetrics returns the timings of the block validation pipeline
+func (bc *Blockchain) ValidationMetrics() *ValidationMetrics {
+	return bc.metrics
+}
+
+// ProcessBlock deserializes, validates and adds a block received from the network
+func (bc *Blockchain) ProcessBlock(data []byte) (*Block, error) {
+	var block *Block
+	timer := newBlockTimer()
+
+	err := timer.time(StageDeserialize, func() (err error) {
+		block, err = TryDeserializeBlock(data)
+		return err
+	})
+	if err == nil {
+		err = timer.time(StagePoW, func() error { return checkProofOfWork(block, bc.opts.params.TargetBits) })
+	}
+	if err == nil {
+		err = timer.time(StageMerkle, func() error {
+			return checkBlockTransactions(block, bc.opts.params)
+		})
+	}
+	if err == nil {
+		err = timer.time(StageUTXOApply, func() error { return bc.addBlock(block, timer) })
+		timer.timings[StageUTXOApply] -= timer.timings[StageScripts]
+	}
+
+	bc.metrics.record(timer.timings)
+	bc.logSlowBlock(block, timer)
+
+	return block, err
+}
+
+// logSlowBlock logs a block exceeding the slow block thresholds
+func (bc *Blockchain) logSlowBlock(block *Block, timer *blockTimer) {
+	var hash []byte
+	if block != nil {
+		hash = block.Hash
+	}
+
+	thresholds := bc.opts.slowBlockThresholds
+	if stage, ok := timer.slowStage(thresholds); ok {
+		log.Printf("slow block %x: stage %s took %s\n", hash, stage, timer.timings[stage])
+	} else if total := time.Since(timer.start); thresholds.Total > 0 && total > thresholds.Total {
+		stage = timer.slowestStage()
+		log.Printf("slow block %x: took %s, stage %s took %s\n", hash, total, stage, timer.timings[stage])
+	}
+}
+
+// checkProofOfWork checks the block hash and its proof of work at a difficulty
+func checkProofOfWork(block *Block, bits int) error {
+	pow := NewProofOfWorkWithBits(block, bits)
+	if !pow.Validate() {
+		return fmt.Errorf("%w: block %x", ErrInvalidProofOfWork, block.Hash)
+	}
+
+	return nil
+}
+
+// checkBlockTransactions checks the block starts with a single coinbase, its
+// transactions have the ids committed by the Merkle root, use active
+// versions, are final, have no negative outputs and fit in the maximum
+// block size
+func checkBlockTransactions(block *Block, params *ChainParams) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return fmt.Errorf("%w: block %x has no coinbase", ErrInvalidBlock, block.Hash)
+	}
+
+	for _, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return fmt.Errorf("%w: block %x has more than one coinbase", ErrInvalidBlock, block.Hash)
+		}
+	}
+
+	size := 0
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fmt.Errorf("%w: transaction %x has a wrong id", ErrInvalidBlock, tx.ID)
+		}
+
+		if err := checkBlockTxVersion(tx.Version, params, block.Height); err != nil {
+			return fmt.Errorf("%w: transaction %x: %s", ErrInvalidBlock, tx.ID, err)
+		}
+
+		if err := checkTxFinal(tx, block.Height, block.Timestamp); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidBlock, err)
+		}
+
+		if err := checkOutputValues(tx); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidBlock, err)
+		}
+
+		size += len(tx.Serialize())
+	}
+
+	if size > params.MaxBlockSize {
+		return fmt.Errorf("%w: block %x has size %d, max %d", ErrInvalidBlock, block.Hash, size, params.MaxBlockSize)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidVanityPrefix is returned for prefixes no address can start with
+var ErrInvalidVanityPrefix = errors.New("invalid vanity prefix")
+
+// VanityResult is a wallet whose address starts with a vanity prefix
+type VanityResult struct {
+	Wallet *Wallet
+
+	// Attempts is the number of key pairs generated, Duration how long it took
+	Attempts int64
+	Duration time.Duration
+}
+
+// AttemptsPerSecond returns the rate key pairs were generated at
+func (r *VanityResult) AttemptsPerSecond() float64 {
+	return attemptsPerSecond(r.Attempts, r.Duration)
+}
+
+// attemptsPerSecond returns the rate of attempts over a duration
+func attemptsPerSecond(attempts int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return float64(attempts) / d.Seconds()
+}
+
+// vanityOptions are the settings of a vanity address search
+type vanityOptions struct {
+	workers  int
+	curve    KeyCurve
+	interval time.Duration
+	progress func(attempts int64, perSecond float64)
+}
+
+// VanityOption configures GenerateVanityAddress
+type VanityOption func(*vanityOptions)
+
+// WithVanityWorkers sets the number of goroutines generating key pairs, one per CPU by default
+func WithVanityWorkers(workers int) VanityOption {
+	return func(o *vanityOptions) {
+		o.workers = workers
+	}
+}
+
+// WithVanityCurve sets the curve of the keys, CurveP256 like NewWallet by default
+func WithVanityCurve(curve KeyCurve) VanityOption {
+	return func(o *vanityOptions) {
+		o.curve = curve
+	}
+}
+
+// WithVanityProgress reports the attempts so far and their rate every interval
+func WithVanityProgress(interval time.Duration, progress func(attempts int64, perSecond float64)) VanityOption {
+	return func(o *vanityOptions) {
+		o.interval = interval
+		o.progress = progress
+	}
+}
+
+// checkVanityPrefix checks a prefix can start a pay to public key hash address
+func checkVanityPrefix(prefix string) error {
+	if !strings.HasPrefix(prefix, "1") {
+		return fmt.Errorf("%w: %q, addresses start with 1", ErrInvalidVanityPrefix, prefix)
+	}
+
+	for i := 0; i < len(prefix); i++ {
+		if bytes.IndexByte(b58Alphabet, prefix[i]) < 0 {
+			return fmt.Errorf("%w: %q isn't a base58 character", ErrInvalidVanityPrefix, prefix[i])
+		}
+	}
+
+	return nil
+}
+
+// GenerateVanityAddress generates key pairs on every CPU until the base58
+// address of one starts with prefix. Each character after the 1 every
+// address starts with makes the search about 58 times longer. It stops with
+// the error of ctx when ctx is done first.
+func GenerateVanityAddress(prefix string, ctx context.Context, opts ...VanityOption) (*VanityResult, error) {
+	o := &vanityOptions{workers: runtime.NumCPU(), curve: CurveP256}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if err := checkVanityPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	if o.workers < 1 {
+		o.workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	var attempts int64
+	found := make(chan *Wallet, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				private, public := newKeyPair(o.curve.Curve())
+				atomic.AddInt64(&attempts, 1)
+
+				w := &Wallet{PrivateKey: private, PublicKey: public}
+				if strings.HasPrefix(string(w.GetAddress()), prefix) {
+					select {
+					case found <- w:
+						cancel()
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	if o.progress != nil && o.interval > 0 {
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					n := atomic.LoadInt64(&attempts)
+					o.progress(n, attemptsPerSecond(n, time.Since(start)))
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	select {
+	case w := <-found:
+		return &VanityResult{Wallet: w, Attempts: atomic.LoadInt64(&attempts), Duration: time.Since(start)}, nil
+	default:
+		return nil, ctx.Err()
+	}
+}
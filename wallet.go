@@ -13,6 +13,9 @@ import (
 // version
 const version = byte(0x00)
 
+// scriptHashVersion is the version of pay to script hash addresses
+const scriptHashVersion = byte(0x05)
+
 // addressChecksumLen is the checking length for address
 const addressChecksumLen = 4
 
@@ -24,7 +27,17 @@ type Wallet struct {
 
 // NewWallet creates and returns a new wallet
 func NewWallet() *Wallet {
-	private, public := newKeyPair()
+	return NewWalletOnCurve(CurveP256)
+}
+
+// NewWalletWithParams creates a new wallet with keys on the curve of the chain
+func NewWalletWithParams(params *ChainParams) *Wallet {
+	return NewWalletOnCurve(params.KeyCurve)
+}
+
+// NewWalletOnCurve creates a new wallet with keys on the curve
+func NewWalletOnCurve(curve KeyCurve) *Wallet {
+	private, public := newKeyPair(curve.Curve())
 	wallet := &Wallet{PrivateKey: private, PublicKey: public}
 
 	return wallet
@@ -45,9 +58,21 @@ func HashPubKey(pubKey []byte) []byte {
 
 // GetAddress returns wallet address
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return encodeAddress(version, HashPubKey(w.PublicKey))
+}
+
+// KeyFor returns the private key of the wallet when it hashes to pubKeyHash
+func (w *Wallet) KeyFor(pubKeyHash []byte) (*ecdsa.PrivateKey, bool) {
+	if !bytes.Equal(HashPubKey(w.PublicKey), pubKeyHash) {
+		return nil, false
+	}
 
-	versionedPayload := append([]byte(version), pubKeyHash...)
+	return &w.PrivateKey, true
+}
+
+// encodeAddress encodes a versioned hash with its checksum in base58
+func encodeAddress(ver byte, hash []byte) []byte {
+	versionedPayload := append([]byte{ver}, hash...)
 	checkSum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checkSum...)
@@ -56,15 +81,32 @@ func (w Wallet) GetAddress() []byte {
 	return address
 }
 
-// ValidateAddress check if address if valid
+// ValidateAddress check if address if valid, in base58 or in bech32 with
+// any human-readable prefix
+//
+// Deprecated: use ParseAddress, which also tells why an address is invalid
+// and which network it is for.
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	ver := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{ver}, pubKeyHash...))
+	_, _, err := decodeAddress(address)
+	return err == nil
+}
+
+// pubKeyHashFromAddress extracts the public key hash from an address, it
+// is nil for invalid addresses
+func pubKeyHashFromAddress(address string) []byte {
+	a, _, err := decodeAddress(address)
+	if err != nil {
+		return nil
+	}
+
+	return a.PubKeyHash
+}
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+// addressVersion returns the base58 version byte of an address, bech32
+// addresses have the version of the base58 address of the same output type
+func addressVersion(address string) byte {
+	a, _, _ := decodeAddress(address)
+	return a.Version
 }
 
 // checksum generates a check sum for a public key
@@ -75,15 +117,14 @@ func checksum(payload []byte) []byte {
 	return secondSHA[:addressChecksumLen]
 }
 
-// newKeyPair creates a new pair of public and private keys
-func newKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
+// newKeyPair creates a new pair of public and private keys on the curve
+func newKeyPair(curve elliptic.Curve) (ecdsa.PrivateKey, []byte) {
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pubKey := encodePublicKey(&private.PublicKey)
 
 	return *private, pubKey
 }
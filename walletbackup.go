@@ -0,0 +1,212 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// walletBackupMagic starts every wallet backup
+	walletBackupMagic = "BCBACKUP"
+
+	// walletBackupVersion is the current wallet backup format version
+	walletBackupVersion = 1
+
+	// DefaultBackupKeep is the number of automatic backups kept by default
+	DefaultBackupKeep = 5
+)
+
+// ErrInvalidBackup is returned for backups which are malformed or corrupted
+var ErrInvalidBackup = errors.New("invalid wallet backup")
+
+// WalletsOption configures Wallets
+type WalletsOption func(*Wallets)
+
+// WithAutoBackup backs the wallets up in dir before destructive operations,
+// removing a key or restoring a backup, only the last keep backups are
+// kept, DefaultBackupKeep when keep isn't positive. They are written like
+// the wallet file, encrypted only when it is.
+func WithAutoBackup(dir string, keep int) WalletsOption {
+	return func(ws *Wallets) {
+		if keep <= 0 {
+			keep = DefaultBackupKeep
+		}
+
+		ws.backupDir, ws.backupKeep = dir, keep
+	}
+}
+
+// Backup writes a backup of the keys, accounts, watch-only addresses and
+// address book of the wallets. The wallet file must be encrypted, the
+// private keys stay sealed with its passphrase in the backup so it is
+// safe to keep elsewhere.
+func (ws *Wallets) Backup(w io.Writer) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encryption == nil {
+		return fmt.Errorf("%w: encrypt the wallets before backing them up", ErrWalletNotEncrypted)
+	}
+
+	return ws.writeBackup(w)
+}
+
+// writeBackup writes a backup of the wallet file, ws.mu must be held. It
+// holds the backup version, when it was taken, the wallet file and a
+// checksum of all of them.
+func (ws *Wallets) writeBackup(w io.Writer) error {
+	data, err := ws.fileData()
+	if err != nil {
+		return err
+	}
+
+	file, err := encodeWalletFile(data)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(walletBackupMagic)
+	if err = binary.Write(&buf, binary.BigEndian, uint32(walletBackupVersion)); err != nil {
+		return err
+	}
+	writeInt64(&buf, time.Now().Unix())
+	writeVarBytes(&buf, file)
+
+	checksum := sha256.Sum256(buf.Bytes())
+	buf.Write(checksum[:])
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// readBackup reads the wallet data of a backup and when it was taken
+func readBackup(r io.Reader) (*walletData, time.Time, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	headerLen := len(walletBackupMagic) + 4
+	if len(raw) < headerLen+sha256.Size || string(raw[:len(walletBackupMagic)]) != walletBackupMagic {
+		return nil, time.Time{}, ErrInvalidBackup
+	}
+
+	version := binary.BigEndian.Uint32(raw[len(walletBackupMagic):headerLen])
+	if version > walletBackupVersion {
+		return nil, time.Time{}, fmt.Errorf("%w: version %d, supported %d", ErrInvalidBackup, version, walletBackupVersion)
+	}
+
+	body := raw[:len(raw)-sha256.Size]
+	if checksum := sha256.Sum256(body); !bytes.Equal(checksum[:], raw[len(body):]) {
+		return nil, time.Time{}, fmt.Errorf("%w: bad checksum", ErrInvalidBackup)
+	}
+
+	br := bytes.NewReader(body[headerLen:])
+	created, err := readInt64(br)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %s", ErrInvalidBackup, err)
+	}
+
+	file, err := readVarBytes(br)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %s", ErrInvalidBackup, err)
+	}
+
+	data, err := decodeWalletFile(file)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return data, time.Unix(created, 0), nil
+}
+
+// Restore replaces the wallets with those of a backup and saves the wallet
+// file, the current wallets are backed up first with WithAutoBackup.
+// Wallets of an encrypted backup are restored locked.
+func (ws *Wallets) Restore(r io.Reader) error {
+	data, _, err := readBackup(r)
+	if err != nil {
+		return err
+	}
+
+	restored := &Wallets{wallets: make(map[string]*Wallet)}
+	if err = restored.load(data); err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if err = ws.autoBackup(); err != nil {
+		return err
+	}
+
+	wallets, addresses, accounts := ws.wallets, ws.addresses, ws.accounts
+	watched, watchAccounts, book, pending := ws.watched, ws.watchAccounts, ws.book, ws.pending
+	encryption, cipherKey := ws.encryption, ws.cipherKey
+
+	ws.wallets, ws.addresses, ws.accounts = restored.wallets, restored.addresses, restored.accounts
+	ws.watched, ws.watchAccounts, ws.book, ws.pending = restored.watched, restored.watchAccounts, restored.book, restored.pending
+	ws.encryption, ws.cipherKey = restored.encryption, nil
+	if err = ws.save(); err != nil {
+		ws.wallets, ws.addresses, ws.accounts = wallets, addresses, accounts
+		ws.watched, ws.watchAccounts, ws.book, ws.pending = watched, watchAccounts, book, pending
+		ws.encryption, ws.cipherKey = encryption, cipherKey
+		return err
+	}
+
+	ws.stopLockTimer()
+	for _, w := range wallets {
+		if w.PrivateKey.D != nil {
+			w.PrivateKey.D.SetInt64(0)
+		}
+	}
+	for _, account := range accounts {
+		account.zero()
+	}
+	for i := range cipherKey {
+		cipherKey[i] = 0
+	}
+
+	return nil
+}
+
+// backupPath returns the path of the nth newest automatic backup
+func (ws *Wallets) backupPath(n int) string {
+	return filepath.Join(ws.backupDir, fmt.Sprintf("%s.backup.%d", filepath.Base(ws.path), n))
+}
+
+// autoBackup backs the wallets up before a destructive operation when
+// WithAutoBackup is set, ws.mu must be held. The backups are rotated, the
+// newest is .backup.1 and the oldest beyond the kept ones is dropped.
+func (ws *Wallets) autoBackup() error {
+	if ws.backupDir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(ws.backupDir, 0700); err != nil {
+		return err
+	}
+
+	for n := ws.backupKeep - 1; n >= 1; n-- {
+		if err := os.Rename(ws.backupPath(n), ws.backupPath(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ws.writeBackup(&buf); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(ws.backupPath(1), buf.Bytes(), walletFileMode)
+}
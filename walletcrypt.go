@@ -0,0 +1,154 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// walletKDF is the key derivation function of encrypted wallet files
+	walletKDF = "scrypt"
+
+	// walletScryptN, walletScryptR and walletScryptP are the scrypt costs of new encrypted wallet files
+	walletScryptN = 1 << 15
+	walletScryptR = 8
+	walletScryptP = 1
+
+	// walletSaltLen is the length of the passphrase salt
+	walletSaltLen = 16
+
+	// walletCipherKeyLen is the length of the AES-256 key derived from the passphrase
+	walletCipherKeyLen = 32
+)
+
+var (
+	// ErrWalletLocked is returned when using the private keys of locked wallets
+	ErrWalletLocked = errors.New("wallet is locked")
+
+	// ErrWrongPassphrase is returned when a passphrase doesn't decrypt the wallets
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+
+	// ErrWalletEncrypted is returned when loading an encrypted wallet file without its passphrase
+	ErrWalletEncrypted = errors.New("wallet file is encrypted")
+
+	// ErrWalletNotEncrypted is returned for operations which need an encrypted wallet file
+	ErrWalletNotEncrypted = errors.New("wallet file isn't encrypted")
+)
+
+// walletEncryption holds the private keys of a wallet file sealed with
+// AES-GCM under a key derived from a passphrase. The public keys of the
+// file are authenticated with them.
+type walletEncryption struct {
+	KDF     string
+	Salt    []byte
+	N, R, P int
+	Nonce   []byte
+	Sealed  []byte
+}
+
+// newWalletEncryption returns the encryption parameters of a new passphrase with a random salt
+func newWalletEncryption() (*walletEncryption, error) {
+	salt := make([]byte, walletSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return &walletEncryption{KDF: walletKDF, Salt: salt, N: walletScryptN, R: walletScryptR, P: walletScryptP}, nil
+}
+
+// deriveKey derives the cipher key of a passphrase
+func (e *walletEncryption) deriveKey(passphrase string) ([]byte, error) {
+	if e.KDF != walletKDF {
+		return nil, fmt.Errorf("%w: key derivation %q", ErrUnknownWalletFormat, e.KDF)
+	}
+
+	return scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, walletCipherKeyLen)
+}
+
+// seal encrypts the private keys of the wallet data, the private keys of
+// its keys followed by the HD master key when there is one. The public
+// parts of data are authenticated with them.
+func (e *walletEncryption) seal(cipherKey []byte, data *walletData, privateKeys [][]byte) error {
+	aead, err := newWalletAEAD(cipherKey)
+	if err != nil {
+		return err
+	}
+
+	var plaintext bytes.Buffer
+	if err = gob.NewEncoder(&plaintext).Encode(privateKeys); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+
+	e.Nonce = nonce
+	e.Sealed = aead.Seal(nil, nonce, plaintext.Bytes(), walletAdditionalData(data))
+	return nil
+}
+
+// open decrypts the private keys of the wallet data in the order of seal
+func (e *walletEncryption) open(cipherKey []byte, data *walletData) ([][]byte, error) {
+	aead, err := newWalletAEAD(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, e.Nonce, e.Sealed, walletAdditionalData(data))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var privateKeys [][]byte
+	if err = gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&privateKeys); err != nil {
+		return nil, err
+	}
+
+	expected := len(data.Keys)
+	if data.HD != nil {
+		expected++
+	}
+
+	if len(privateKeys) != expected {
+		return nil, fmt.Errorf("%w: %d private keys for %d public keys", ErrUnknownWalletFormat, len(privateKeys), expected)
+	}
+
+	return privateKeys, nil
+}
+
+// newWalletAEAD returns the AES-GCM cipher of a key
+func newWalletAEAD(cipherKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// walletAdditionalData returns the curves and public keys, and the HD
+// accounts, authenticated with the private keys
+func walletAdditionalData(data *walletData) []byte {
+	var buf bytes.Buffer
+	for _, key := range data.Keys {
+		writeVarBytes(&buf, []byte(key.Curve))
+		writeVarBytes(&buf, key.PublicKey)
+	}
+
+	if data.HD != nil {
+		for _, hd := range append([]*walletHD{data.HD}, data.HDAccounts...) {
+			writeVarBytes(&buf, []byte(hd.AccountPath))
+			writeVarBytes(&buf, hd.AccountKey)
+		}
+	}
+
+	return buf.Bytes()
+}
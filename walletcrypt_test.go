@@ -0,0 +1,300 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// newEncryptedWallets saves a wallet file of an independent key and an HD
+// address encrypted with testPassphrase, it returns its path and addresses
+func newEncryptedWallets(t *testing.T) (string, []string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wallet.dat")
+	ws, err := OpenWallets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ws.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ws.SetHDSeed(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	derived, err := ws.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ws.Encrypt(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, []string{key, derived}
+}
+
+// checkSigns checks the wallets sign with the keys of the addresses
+func checkSigns(t *testing.T, ws *Wallets, addresses []string) {
+	t.Helper()
+
+	digest := sha256.Sum256([]byte("message"))
+	for _, address := range addresses {
+		sig, err := ws.Sign(address, digest[:])
+		if err != nil {
+			t.Fatalf("%s: %v", address, err)
+		}
+
+		pubKey, err := ws.PublicKey(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := parsePublicKey(publicKeyCurve(pubKey), pubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, s, err := parseDERSignature(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			t.Fatalf("%s: signature doesn't verify", address)
+		}
+	}
+}
+
+// checkLocked checks the wallets refuse to sign with the keys of the addresses
+func checkLocked(t *testing.T, ws *Wallets, addresses []string) {
+	t.Helper()
+
+	if !ws.IsLocked() {
+		t.Fatal("wallets unlocked")
+	}
+	for _, address := range addresses {
+		if _, err := ws.Sign(address, make([]byte, 32)); !errors.Is(err, ErrWalletLocked) {
+			t.Fatalf("%s: error %v, want %v", address, err, ErrWalletLocked)
+		}
+		pubKey, err := ws.PublicKey(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ws.KeyFor(HashPubKey(pubKey)); ok {
+			t.Fatalf("%s: private key available", address)
+		}
+	}
+}
+
+func TestUnlockWallets(t *testing.T) {
+	path, addresses := newEncryptedWallets(t)
+
+	tests := []struct {
+		name       string
+		passphrase string
+		valid      bool
+	}{
+		{"right passphrase", testPassphrase, true},
+		{"wrong passphrase", "wrong horse battery staple", false},
+		{"empty passphrase", "", false},
+		{"prefix of the passphrase", testPassphrase[:len(testPassphrase)-1], false},
+		{"passphrase with a trailing space", testPassphrase + " ", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ws, err := OpenWallets(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ws.IsEncrypted() {
+				t.Fatal("wallet file isn't encrypted")
+			}
+			checkLocked(t, ws, addresses)
+
+			err = ws.Unlock(test.passphrase, 0)
+			if !test.valid {
+				if !errors.Is(err, ErrWrongPassphrase) {
+					t.Fatalf("error %v, want %v", err, ErrWrongPassphrase)
+				}
+				checkLocked(t, ws, addresses)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkSigns(t, ws, addresses)
+			ws.Lock()
+			checkLocked(t, ws, addresses)
+		})
+	}
+}
+
+func TestWalletEncryptionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, ws *Wallets, path string) error
+		err  error
+	}{
+		{
+			"encrypted twice",
+			func(t *testing.T, ws *Wallets, path string) error {
+				return ws.Encrypt("another passphrase")
+			},
+			ErrWalletEncrypted,
+		},
+		{
+			"passphrase changed with a wrong passphrase",
+			func(t *testing.T, ws *Wallets, path string) error {
+				return ws.ChangePassphrase("wrong", "another passphrase")
+			},
+			ErrWrongPassphrase,
+		},
+		{
+			"passphrase of an unencrypted file changed",
+			func(t *testing.T, ws *Wallets, path string) error {
+				plain, err := OpenWallets(filepath.Join(t.TempDir(), "wallet.dat"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return plain.ChangePassphrase("", "another passphrase")
+			},
+			ErrWalletNotEncrypted,
+		},
+		{
+			"encrypted file loaded without passphrase",
+			func(t *testing.T, ws *Wallets, path string) error {
+				_, err := LoadWalletFile(path)
+				return err
+			},
+			ErrWalletEncrypted,
+		},
+		{
+			"public key swapped in the file",
+			func(t *testing.T, ws *Wallets, path string) error {
+				data, err := readWalletFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data.Keys[0].PublicKey = NewWallet().PublicKey
+				if err = writeWalletFile(path, data); err != nil {
+					t.Fatal(err)
+				}
+
+				tampered, err := OpenWallets(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return tampered.Unlock(testPassphrase, 0)
+			},
+			ErrWrongPassphrase,
+		},
+		{
+			"sealed keys corrupted",
+			func(t *testing.T, ws *Wallets, path string) error {
+				data, err := readWalletFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data.Encryption.Sealed[0] ^= 1
+				if err = writeWalletFile(path, data); err != nil {
+					t.Fatal(err)
+				}
+
+				tampered, err := OpenWallets(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return tampered.Unlock(testPassphrase, 0)
+			},
+			ErrWrongPassphrase,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, _ := newEncryptedWallets(t)
+			ws, err := OpenWallets(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := test.run(t, ws, path); !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	path, addresses := newEncryptedWallets(t)
+	ws, err := OpenWallets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ws.Unlock(testPassphrase, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	const newPassphrase = "another passphrase"
+	if err = ws.ChangePassphrase(testPassphrase, newPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, ws, addresses)
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range addresses {
+		w, err := ws.GetWallet(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, w.PrivateKey.D.Bytes()) {
+			t.Fatalf("the private key of %s is in the wallet file", address)
+		}
+	}
+
+	reopened, err := OpenWallets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reopened.Unlock(testPassphrase, 0); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("old passphrase: error %v, want %v", err, ErrWrongPassphrase)
+	}
+	if err = reopened.Unlock(newPassphrase, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, reopened, addresses)
+}
+
+func TestUnlockTimeout(t *testing.T) {
+	path, addresses := newEncryptedWallets(t)
+	ws, err := OpenWallets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ws.Unlock(testPassphrase, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, ws, addresses)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ws.IsLocked() {
+		if time.Now().After(deadline) {
+			t.Fatal("wallets still unlocked after the timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkLocked(t, ws, addresses)
+}
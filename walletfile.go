@@ -0,0 +1,371 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+)
+
+const (
+	// walletFileMagic starts every wallet file
+	walletFileMagic = "BCWALLET"
+
+	// walletFileVersion is the current wallet file format version
+	walletFileVersion = 7
+
+	// walletFileMode is the file mode of wallet files
+	walletFileMode = 0600
+)
+
+var (
+	// ErrUnknownWalletFormat is returned for files which are not wallet files
+	ErrUnknownWalletFormat = errors.New("unknown wallet file format")
+
+	// ErrWalletVersionTooNew is returned for wallet files written by a newer version
+	ErrWalletVersionTooNew = errors.New("wallet file version is too new")
+)
+
+// walletMigration converts the payload of a wallet file version into the
+// payload of the next version
+type walletMigration func(payload []byte) ([]byte, error)
+
+// walletMigrations are the migrations from each version to the next one
+var walletMigrations = map[int]walletMigration{
+	// version 2 may encrypt the private keys, version 1 payloads decode as
+	// unencrypted version 2 payloads
+	1: func(payload []byte) ([]byte, error) { return payload, nil },
+
+	// version 3 may hold an HD wallet, version 2 payloads decode as version
+	// 3 payloads without one
+	2: func(payload []byte) ([]byte, error) { return payload, nil },
+
+	// version 4 may hold more HD accounts, version 3 payloads decode as
+	// version 4 payloads with the default account only
+	3: func(payload []byte) ([]byte, error) { return payload, nil },
+
+	// version 5 may hold watch-only addresses and accounts, version 4
+	// payloads decode as version 5 payloads without them
+	4: func(payload []byte) ([]byte, error) { return payload, nil },
+
+	// version 6 may hold an address book, version 5 payloads decode as
+	// version 6 payloads with an empty one
+	5: func(payload []byte) ([]byte, error) { return payload, nil },
+
+	// version 7 may hold pending transactions, version 6 payloads decode as
+	// version 7 payloads without any
+	6: func(payload []byte) ([]byte, error) { return payload, nil },
+}
+
+// walletKey is a serialized key pair
+type walletKey struct {
+	Curve      string
+	PrivateKey []byte
+	PublicKey  []byte
+}
+
+// walletData is the payload of the current wallet file version
+type walletData struct {
+	Keys []walletKey
+
+	// HD is the default account of the HD wallet with its master key, nil
+	// for wallets of independent keys only
+	HD *walletHD
+
+	// HDAccounts are the other accounts of the HD wallet, without the master key
+	HDAccounts []*walletHD
+
+	// WatchAddresses and WatchAccounts are the addresses and extended public
+	// keys imported to be watched without their private keys
+	WatchAddresses []string
+	WatchAccounts  []*walletHD
+
+	// AddressBook are the labels and categories of addresses
+	AddressBook []AddressEntry
+
+	// PendingTxs are the serialized transactions sent and not confirmed yet
+	PendingTxs [][]byte
+
+	// Encryption holds the private keys when the file is encrypted, the
+	// private keys of Keys and the HD master key are empty then
+	Encryption *walletEncryption
+}
+
+// walletHD is a serialized HD wallet account, its keys are derived again on load
+type walletHD struct {
+	// MasterKey is the serialized master private key
+	MasterKey []byte
+
+	// AccountPath and AccountKey are the path and serialized public key of
+	// the account, they derive the addresses of locked wallets
+	AccountPath string
+	AccountKey  []byte
+
+	// ReceiveIndex and ChangeIndex are the next indices of the account chains
+	ReceiveIndex uint32
+	ChangeIndex  uint32
+}
+
+// newWalletKey serializes the key pair of a wallet
+func newWalletKey(w *Wallet) walletKey {
+	return walletKey{
+		Curve:      w.PrivateKey.Curve.Params().Name,
+		PrivateKey: w.PrivateKey.D.Bytes(),
+		PublicKey:  w.PublicKey,
+	}
+}
+
+// wallet restores the wallet of a serialized key pair
+func (k walletKey) wallet() (*Wallet, error) {
+	curve, err := curveByName(k.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(k.PrivateKey)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(k.PrivateKey)
+
+	return &Wallet{PrivateKey: private, PublicKey: k.PublicKey}, nil
+}
+
+// newWalletHD serializes an HD wallet account, the master key is left out
+// when it is encrypted separately
+func newWalletHD(w *HDWallet, withMasterKey bool) *walletHD {
+	hd := &walletHD{
+		AccountPath:  w.AccountPath().String(),
+		AccountKey:   w.AccountKey().serialize(),
+		ReceiveIndex: w.next[ExternalChain],
+		ChangeIndex:  w.next[InternalChain],
+	}
+
+	if withMasterKey && w.master != nil {
+		hd.MasterKey = w.master.serialize()
+	}
+
+	return hd
+}
+
+// hdAccounts restores the accounts of the HD wallet of the data, from the
+// serialized master key when it is set and from the account public keys
+// otherwise
+func (data *walletData) hdAccounts(masterKey []byte) ([]*HDWallet, error) {
+	if data.HD == nil {
+		return nil, nil
+	}
+
+	var master *ExtendedKey
+	if len(masterKey) > 0 {
+		var err error
+		if master, err = parseExtendedKey(masterKey); err != nil {
+			return nil, err
+		}
+	}
+
+	var accounts []*HDWallet
+	for _, hd := range append([]*walletHD{data.HD}, data.HDAccounts...) {
+		w, err := hd.hdWallet(master)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, w)
+	}
+
+	return accounts, nil
+}
+
+// hdWallet restores the HD wallet account and its derived keys, from the
+// master key when it is known and from the account public key otherwise
+func (hd *walletHD) hdWallet(master *ExtendedKey) (*HDWallet, error) {
+	path, err := ParseDerivationPath(hd.AccountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var w *HDWallet
+	if master != nil {
+		w, err = NewHDAccount(master, path)
+	} else {
+		account, keyErr := parseExtendedKey(hd.AccountKey)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+
+		w, err = newHDWallet(account.Neuter(), path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = w.deriveUpTo(ExternalChain, hd.ReceiveIndex); err != nil {
+		return nil, err
+	}
+
+	if err = w.deriveUpTo(InternalChain, hd.ChangeIndex); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// curveByName returns a supported curve by its name
+func curveByName(name string) (elliptic.Curve, error) {
+	for _, curve := range []KeyCurve{CurveP256, CurveSecp256k1} {
+		if name == curve.String() {
+			return curve.Curve(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported curve %q", name)
+}
+
+// encodeWalletFile encodes the wallet data in the current file version
+func encodeWalletFile(data *walletData) ([]byte, error) {
+	var buff bytes.Buffer
+	buff.WriteString(walletFileMagic)
+
+	if err := binary.Write(&buff, binary.BigEndian, uint32(walletFileVersion)); err != nil {
+		return nil, err
+	}
+
+	if err := gob.NewEncoder(&buff).Encode(data); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// decodeWalletFile decodes a wallet file, migrating older versions forward
+func decodeWalletFile(raw []byte) (*walletData, error) {
+	headerLen := len(walletFileMagic) + 4
+	if len(raw) < headerLen || string(raw[:len(walletFileMagic)]) != walletFileMagic {
+		return nil, ErrUnknownWalletFormat
+	}
+
+	version := int(binary.BigEndian.Uint32(raw[len(walletFileMagic):headerLen]))
+	if version > walletFileVersion {
+		return nil, fmt.Errorf("%w: version %d, supported %d", ErrWalletVersionTooNew, version, walletFileVersion)
+	}
+
+	payload := raw[headerLen:]
+	for ; version < walletFileVersion; version++ {
+		migrate, ok := walletMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no migration from version %d", ErrUnknownWalletFormat, version)
+		}
+
+		var err error
+		if payload, err = migrate(payload); err != nil {
+			return nil, fmt.Errorf("migrating wallet file from version %d: %w", version, err)
+		}
+	}
+
+	var data walletData
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// SaveWalletFile atomically writes the wallets to a wallet file
+func SaveWalletFile(path string, wallets []*Wallet) error {
+	data := &walletData{}
+	for _, w := range wallets {
+		data.Keys = append(data.Keys, newWalletKey(w))
+	}
+
+	return writeWalletFile(path, data)
+}
+
+// writeWalletFile atomically writes wallet data to a wallet file
+func writeWalletFile(path string, data *walletData) error {
+	raw, err := encodeWalletFile(data)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, raw, walletFileMode)
+}
+
+// readWalletFile reads the wallet data of a wallet file
+func readWalletFile(path string) (*walletData, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeWalletFile(raw)
+}
+
+// LoadWalletFile reads the wallets of an unencrypted wallet file, see
+// Wallets for encrypted files
+func LoadWalletFile(path string) ([]*Wallet, error) {
+	data, err := readWalletFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Encryption != nil {
+		return nil, fmt.Errorf("%w: %s", ErrWalletEncrypted, path)
+	}
+
+	var wallets []*Wallet
+	for _, key := range data.Keys {
+		w, keyErr := key.wallet()
+		if keyErr != nil {
+			return nil, keyErr
+		}
+
+		wallets = append(wallets, w)
+	}
+
+	var masterKey []byte
+	if data.HD != nil {
+		masterKey = data.HD.MasterKey
+	}
+
+	accounts, err := data.hdAccounts(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		wallets = append(wallets, account.Keys(ExternalChain)...)
+		wallets = append(wallets, account.Keys(InternalChain)...)
+	}
+
+	return wallets, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
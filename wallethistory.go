@@ -0,0 +1,253 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"sort"
+)
+
+// ErrWalletNotConnected is returned by the chain queries of wallets not connected to a chain
+var ErrWalletNotConnected = errors.New("wallets aren't connected to a chain")
+
+// addressHistory returns the main chain transactions paying to or spending
+// from public key hashes in chain order. Archival nodes only read the blocks
+// listed for them in the balance index, other nodes scan the main chain.
+func (bc *Blockchain) addressHistory(pubKeyHashes [][]byte) ([]WalletTx, error) {
+	if bc.opts.archival {
+		if err := bc.indexBalances(); err != nil {
+			return nil, err
+		}
+	}
+
+	watched := make(map[string]bool, len(pubKeyHashes))
+	for _, pubKeyHash := range pubKeyHashes {
+		watched[hex.EncodeToString(pubKeyHash)] = true
+	}
+
+	var history []WalletTx
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tipHash := b.Get([]byte(tipDbKey))
+		tip := DeserializeBlock(b.Get(tipHash))
+
+		var heights []int
+		if bc.opts.archival && bytes.Equal(b.Get([]byte(balanceTipDbKey)), tipHash) {
+			seen := make(map[int]bool)
+			c := tx.Bucket([]byte(balanceIndexBucket)).Cursor()
+			for _, pubKeyHash := range pubKeyHashes {
+				for k, _ := c.Seek(pubKeyHash); k != nil && len(k) == len(pubKeyHash)+8 && bytes.HasPrefix(k, pubKeyHash); k, _ = c.Next() {
+					if height := int(binary.BigEndian.Uint64(k[len(pubKeyHash):])); !seen[height] {
+						seen[height] = true
+						heights = append(heights, height)
+					}
+				}
+			}
+			sort.Ints(heights)
+		} else {
+			for height := 0; height <= tip.Height; height++ {
+				heights = append(heights, height)
+			}
+		}
+
+		owned := make(map[string]bool)
+		for _, height := range heights {
+			block, err := blockAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			for _, t := range block.Transactions {
+				relevant := false
+				if !t.IsCoinbase() {
+					for _, vin := range t.VIn {
+						relevant = relevant || owned[outpointKey(vin.TxID, vin.VOut)]
+					}
+				}
+
+				for i, out := range t.VOut {
+					if watched[hex.EncodeToString(out.PubKeyHash())] {
+						owned[outpointKey(t.ID, i)] = true
+						relevant = true
+					}
+				}
+
+				if relevant {
+					history = append(history, WalletTx{Tx: t, State: WalletTxConfirmed, BlockHash: block.Hash, Height: block.Height})
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return history, err
+}
+
+// Connect makes the wallets answer balance and history queries from a
+// chain, and from a mempool for unconfirmed transactions when it isn't nil
+func (ws *Wallets) Connect(bc *Blockchain, mempool *Mempool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.bc, ws.mempool = bc, mempool
+}
+
+// chainAddress returns the chain and mempool of the wallets and the public
+// key hash of one of their addresses, watch-only ones included
+func (ws *Wallets) chainAddress(address string) (*Blockchain, *Mempool, []byte, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.bc == nil {
+		return nil, nil, nil, ErrWalletNotConnected
+	}
+
+	for _, known := range ws.allAddresses() {
+		if known == address {
+			return ws.bc, ws.mempool, pubKeyHashFromAddress(address), nil
+		}
+	}
+
+	return nil, nil, nil, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+}
+
+// GetBalance returns the value of the unspent main chain outputs of an address of the wallets
+func (ws *Wallets) GetBalance(address string) (int, error) {
+	bc, _, pubKeyHash, err := ws.chainAddress(address)
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for _, out := range NewUTXOSet(bc).FindUTXO(pubKeyHash) {
+		balance += out.Value
+	}
+
+	return balance, nil
+}
+
+// GetUnconfirmedBalance returns how the mempool transactions change the
+// balance of an address of the wallets, the value they pay to it less the
+// value of its outputs they spend. The balance once they are mined is
+// GetBalance plus GetUnconfirmedBalance.
+func (ws *Wallets) GetUnconfirmedBalance(address string) (int, error) {
+	bc, mempool, pubKeyHash, err := ws.chainAddress(address)
+	if err != nil || mempool == nil {
+		return 0, err
+	}
+
+	entries := mempool.Entries()
+	pending := make(map[string]*Transaction, len(entries))
+	for _, entry := range entries {
+		pending[hex.EncodeToString(entry.Tx.ID)] = entry.Tx
+	}
+
+	balance := 0
+	for _, entry := range entries {
+		prevTXs, err := bc.findPrevTransactions(entry.Tx, pending)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, vin := range entry.Tx.VIn {
+			if prevOut := prevOutput(vin, prevTXs); prevOut.IsLockedWithKey(pubKeyHash) {
+				balance -= prevOut.Value
+			}
+		}
+
+		for _, out := range entry.Tx.VOut {
+			if out.IsLockedWithKey(pubKeyHash) {
+				balance += out.Value
+			}
+		}
+	}
+
+	return balance, nil
+}
+
+// ListTransactions returns the transactions paying to or spending from an
+// address of the wallets, newest first: the pending mempool transactions,
+// then the main chain ones from the tip. The first offset transactions are
+// skipped and at most limit returned, all of them when limit isn't positive.
+// The address book entries of the address and of the addresses the
+// transactions pay to are in their Labels.
+func (ws *Wallets) ListTransactions(address string, limit, offset int) ([]WalletTx, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("offset %d is negative", offset)
+	}
+
+	bc, mempool, pubKeyHash, err := ws.chainAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := bc.addressHistory([][]byte{pubKeyHash})
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []WalletTx
+	if mempool != nil {
+		owned := make(map[string]bool)
+		for _, wtx := range history {
+			for i, out := range wtx.Tx.VOut {
+				if out.IsLockedWithKey(pubKeyHash) {
+					owned[outpointKey(wtx.Tx.ID, i)] = true
+				}
+			}
+		}
+
+		entries := mempool.Entries()
+		sort.Slice(entries, func(i, j int) bool {
+			if !entries[i].Added.Equal(entries[j].Added) {
+				return entries[i].Added.After(entries[j].Added)
+			}
+			return bytes.Compare(entries[i].Tx.ID, entries[j].Tx.ID) < 0
+		})
+
+		for _, entry := range entries {
+			for i, out := range entry.Tx.VOut {
+				if out.IsLockedWithKey(pubKeyHash) {
+					owned[outpointKey(entry.Tx.ID, i)] = true
+				}
+			}
+		}
+
+		for _, entry := range entries {
+			relevant := false
+			for _, vin := range entry.Tx.VIn {
+				relevant = relevant || owned[outpointKey(vin.TxID, vin.VOut)]
+			}
+			for _, out := range entry.Tx.VOut {
+				relevant = relevant || out.IsLockedWithKey(pubKeyHash)
+			}
+
+			if relevant {
+				txs = append(txs, WalletTx{Tx: entry.Tx, State: WalletTxPending})
+			}
+		}
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		txs = append(txs, history[i])
+	}
+
+	if offset >= len(txs) {
+		return []WalletTx{}, nil
+	}
+	txs = txs[offset:]
+
+	if limit > 0 && limit < len(txs) {
+		txs = txs[:limit]
+	}
+
+	for i := range txs {
+		txs[i].Labels = ws.txLabels(txs[i].Tx, address)
+	}
+
+	return txs, nil
+}
@@ -0,0 +1,741 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// walletFileNameFormat is the wallet file name of a node
+const walletFileNameFormat = "wallet_%s.dat"
+
+var (
+	// ErrWalletNotFound is returned for an address without a wallet in Wallets
+	ErrWalletNotFound = errors.New("wallet is not found")
+
+	// ErrHDWalletExists is returned when setting the seed of a wallet file which already has one
+	ErrHDWalletExists = errors.New("wallet file already has an hd wallet")
+)
+
+// Wallets is the collection of the wallets of a node, persisted in its
+// wallet file. Once encrypted with a passphrase, the private keys are only
+// in memory between Unlock and Lock, the addresses stay available. With an
+// HD seed, new addresses are derived from it instead of generated.
+type Wallets struct {
+	mu      sync.Mutex
+	path    string
+	wallets map[string]*Wallet
+
+	// addresses are the addresses of the independent keys, the accounts of
+	// the HD wallet derive the other addresses, the default account first
+	addresses []string
+	accounts  []*HDWallet
+
+	// watched and watchAccounts are the imported addresses and accounts
+	// watched without their private keys
+	watched       []string
+	watchAccounts []*HDWallet
+
+	// book is the address book in the order entries were added
+	book []AddressEntry
+
+	// encryption is nil for an unencrypted wallet file
+	encryption *walletEncryption
+
+	// cipherKey is the key derived from the passphrase while unlocked
+	cipherKey []byte
+	lockTimer *time.Timer
+
+	// bc and mempool answer the balance and history queries once connected
+	bc      *Blockchain
+	mempool *Mempool
+
+	// pending are the transactions sent and not confirmed yet, broadcast
+	// sends them to peers
+	pending   []*Transaction
+	broadcast func(*Transaction)
+
+	// backupDir keeps the last backupKeep backups taken before destructive
+	// operations, none are taken when it is empty
+	backupDir  string
+	backupKeep int
+}
+
+// getWalletFile returns the wallet file name of a node
+func getWalletFile(nodeID string) string {
+	return fmt.Sprintf(walletFileNameFormat, nodeID)
+}
+
+// NewWallets loads the wallets of a node from wallet_<nodeID>.dat, the
+// collection is empty when the file doesn't exist yet
+func NewWallets(nodeID string, opts ...WalletsOption) (*Wallets, error) {
+	return OpenWallets(getWalletFile(nodeID), opts...)
+}
+
+// OpenWallets loads the wallets of a wallet file, the collection is empty
+// when the file doesn't exist yet. Encrypted wallets are loaded locked.
+func OpenWallets(path string, opts ...WalletsOption) (*Wallets, error) {
+	ws := &Wallets{path: path, wallets: make(map[string]*Wallet)}
+	for _, opt := range opts {
+		opt(ws)
+	}
+
+	data, err := readWalletFile(path)
+	if os.IsNotExist(err) {
+		return ws, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ws.load(data); err != nil {
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// load sets the wallets of wallet data, locked when it is encrypted
+func (ws *Wallets) load(data *walletData) error {
+	ws.encryption = data.Encryption
+	for _, key := range data.Keys {
+		if ws.encryption != nil {
+			ws.add(&Wallet{PublicKey: key.PublicKey})
+			continue
+		}
+
+		w, err := key.wallet()
+		if err != nil {
+			return err
+		}
+		ws.add(w)
+	}
+
+	var masterKey []byte
+	if data.HD != nil && ws.encryption == nil {
+		masterKey = data.HD.MasterKey
+	}
+
+	var err error
+	if ws.accounts, err = data.hdAccounts(masterKey); err != nil {
+		return err
+	}
+	ws.addHDKeys()
+
+	ws.watched = data.WatchAddresses
+	for _, hd := range data.WatchAccounts {
+		account, err := hd.hdWallet(nil)
+		if err != nil {
+			return err
+		}
+
+		ws.watchAccounts = append(ws.watchAccounts, account)
+	}
+	ws.book = data.AddressBook
+
+	for _, raw := range data.PendingTxs {
+		tx, err := TryDeserializeTransaction(raw)
+		if err != nil {
+			return err
+		}
+
+		ws.pending = append(ws.pending, tx)
+	}
+
+	return nil
+}
+
+// add adds a wallet unless its address is already in the collection
+func (ws *Wallets) add(w *Wallet) string {
+	address := string(w.GetAddress())
+	if _, ok := ws.wallets[address]; !ok {
+		ws.wallets[address] = w
+		ws.addresses = append(ws.addresses, address)
+	}
+
+	return address
+}
+
+// addHDKeys adds the keys derived by the HD wallet accounts to the collection
+func (ws *Wallets) addHDKeys() {
+	for _, account := range ws.accounts {
+		for _, chain := range []uint32{ExternalChain, InternalChain} {
+			for _, key := range account.Keys(chain) {
+				ws.wallets[string(key.GetAddress())] = key
+			}
+		}
+	}
+}
+
+// allAddresses returns the addresses of the independent keys followed by
+// the receive and change addresses of each HD account, then the watch-only
+// addresses, ws.mu must be held
+func (ws *Wallets) allAddresses() []string {
+	return append(ws.keyAddresses(), ws.watchOnlyAddresses()...)
+}
+
+// keyAddresses returns the addresses the wallets have the keys of, those
+// of the independent keys followed by those of the HD accounts, ws.mu must
+// be held
+func (ws *Wallets) keyAddresses() []string {
+	addresses := append([]string{}, ws.addresses...)
+	for _, account := range ws.accounts {
+		addresses = append(addresses, account.Addresses(ExternalChain)...)
+		addresses = append(addresses, account.Addresses(InternalChain)...)
+	}
+
+	return addresses
+}
+
+// CreateWallet creates a wallet, saves the wallet file and returns the
+// wallet address. With an HD seed it is the next receive address.
+func (ws *Wallets) CreateWallet() (string, error) {
+	ws.mu.Lock()
+	if len(ws.accounts) > 0 {
+		defer ws.mu.Unlock()
+		return ws.newHDAddress(ExternalChain)
+	}
+	ws.mu.Unlock()
+
+	return ws.AddWallet(NewWallet())
+}
+
+// SetHDSeed makes the wallets derive new addresses from the master key of
+// a seed, encrypted wallets must be unlocked
+func (ws *Wallets) SetHDSeed(seed []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if len(ws.accounts) > 0 {
+		return ErrHDWalletExists
+	}
+
+	if ws.isLocked() {
+		return ErrWalletLocked
+	}
+
+	hd, err := NewHDWallet(seed)
+	if err != nil {
+		return err
+	}
+
+	ws.accounts = []*HDWallet{hd}
+	if err = ws.save(); err != nil {
+		ws.accounts = nil
+		return err
+	}
+
+	return nil
+}
+
+// CreateMnemonic sets the HD seed of a new mnemonic of a number of words
+// and an optional passphrase, the mnemonic is returned to be written down
+// as the backup of the wallets
+func (ws *Wallets) CreateMnemonic(words int, passphrase string) (string, error) {
+	mnemonic, err := GenerateMnemonic(words)
+	if err != nil {
+		return "", err
+	}
+
+	if err = ws.RestoreMnemonic(mnemonic, passphrase); err != nil {
+		return "", err
+	}
+
+	return mnemonic, nil
+}
+
+// RestoreMnemonic sets the HD seed of a mnemonic and its passphrase
+func (ws *Wallets) RestoreMnemonic(mnemonic, passphrase string) error {
+	seed, err := MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return ws.SetHDSeed(seed)
+}
+
+// IsHD returns whether the wallets derive new addresses from an HD seed
+func (ws *Wallets) IsHD() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return len(ws.accounts) > 0
+}
+
+// NewReceiveAddress derives the next receive address of the default HD
+// account, locked wallets derive it from the account public key
+func (ws *Wallets) NewReceiveAddress() (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.newHDAddress(ExternalChain)
+}
+
+// NewChangeAddress derives the next change address of the default HD
+// account, locked wallets derive it from the account public key
+func (ws *Wallets) NewChangeAddress() (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.newHDAddress(InternalChain)
+}
+
+// newHDAddress derives the next address of a chain of the default account
+// and saves the wallet file, ws.mu must be held
+func (ws *Wallets) newHDAddress(chain uint32) (string, error) {
+	if len(ws.accounts) == 0 {
+		return "", ErrNoMasterKey
+	}
+
+	key, err := ws.accounts[0].nextKey(chain)
+	if err != nil {
+		return "", err
+	}
+
+	address := string(key.GetAddress())
+	ws.wallets[address] = key
+	if err = ws.save(); err != nil {
+		return "", err
+	}
+
+	return address, nil
+}
+
+// AddWallet adds a wallet, saves the wallet file and returns the wallet
+// address, encrypted wallets must be unlocked
+func (ws *Wallets) AddWallet(w *Wallet) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.isLocked() {
+		return "", ErrWalletLocked
+	}
+
+	address := ws.add(w)
+	if err := ws.save(); err != nil {
+		return "", err
+	}
+
+	return address, nil
+}
+
+// RemoveWallet deletes the key of an independent address or stops watching
+// a watch-only address and saves the wallet file, the addresses of the HD
+// accounts can't be removed. Keys can't be removed from locked wallets.
+func (ws *Wallets) RemoveWallet(address string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for i, watched := range ws.watched {
+		if watched == address {
+			if err := ws.autoBackup(); err != nil {
+				return err
+			}
+
+			previous := ws.watched
+			ws.watched = append(append([]string{}, previous[:i]...), previous[i+1:]...)
+			if err := ws.save(); err != nil {
+				ws.watched = previous
+				return err
+			}
+
+			return nil
+		}
+	}
+
+	for i, known := range ws.addresses {
+		if known != address {
+			continue
+		}
+
+		if ws.isLocked() {
+			return ErrWalletLocked
+		}
+
+		if err := ws.autoBackup(); err != nil {
+			return err
+		}
+
+		w, previous := ws.wallets[address], ws.addresses
+		delete(ws.wallets, address)
+		ws.addresses = append(append([]string{}, previous[:i]...), previous[i+1:]...)
+		if err := ws.save(); err != nil {
+			ws.wallets[address], ws.addresses = w, previous
+			return err
+		}
+
+		w.PrivateKey.D.SetInt64(0)
+		return nil
+	}
+
+	if _, ok := ws.wallets[address]; ok || ws.isWatchOnly(address) {
+		return fmt.Errorf("address %s is derived by an hd account", address)
+	}
+
+	return fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+}
+
+// GetAddresses returns the addresses of the independent keys in creation
+// order, followed by the HD receive and change addresses in derivation
+// order and the watch-only addresses
+func (ws *Wallets) GetAddresses() []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.allAddresses()
+}
+
+// GetWallet returns the wallet of an address, encrypted wallets must be unlocked
+func (ws *Wallets) GetWallet(address string) (*Wallet, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	w, ok := ws.wallets[address]
+	if !ok && ws.isWatchOnly(address) {
+		return nil, fmt.Errorf("%w: %s", ErrWatchOnly, address)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+	}
+
+	if ws.isLocked() {
+		return nil, fmt.Errorf("%w: %s", ErrWalletLocked, address)
+	}
+
+	return w, nil
+}
+
+// KeyFor returns the private key of the wallet whose public key hashes to
+// pubKeyHash, no key is returned while locked
+func (ws *Wallets) KeyFor(pubKeyHash []byte) (*ecdsa.PrivateKey, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.isLocked() {
+		return nil, false
+	}
+
+	for _, address := range ws.allAddresses() {
+		if w, ok := ws.wallets[address]; ok {
+			if key, ok := w.KeyFor(pubKeyHash); ok {
+				return key, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// PublicKey returns the public key of an address of the wallets, also while
+// locked, so the wallets sign transactions as a Signer
+func (ws *Wallets) PublicKey(address string) ([]byte, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	w, ok := ws.wallets[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingKey, address)
+	}
+
+	return append([]byte{}, w.PublicKey...), nil
+}
+
+// Sign signs a digest with the key of an address, encrypted wallets must be unlocked
+func (ws *Wallets) Sign(address string, digest []byte) ([]byte, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	w, ok := ws.wallets[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingKey, address)
+	}
+
+	if ws.isLocked() {
+		return nil, fmt.Errorf("%w: %s", ErrWalletLocked, address)
+	}
+
+	return signHash(w.PrivateKey, digest), nil
+}
+
+// IsEncrypted returns whether the wallet file is encrypted
+func (ws *Wallets) IsEncrypted() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.encryption != nil
+}
+
+// IsLocked returns whether the private keys are unavailable
+func (ws *Wallets) IsLocked() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.isLocked()
+}
+
+// isLocked returns whether the private keys are unavailable, ws.mu must be held
+func (ws *Wallets) isLocked() bool {
+	return ws.encryption != nil && ws.cipherKey == nil
+}
+
+// Encrypt encrypts the wallet file with a passphrase and locks the wallets
+func (ws *Wallets) Encrypt(passphrase string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encryption != nil {
+		return fmt.Errorf("%w: use ChangePassphrase", ErrWalletEncrypted)
+	}
+
+	return ws.setPassphrase(passphrase)
+}
+
+// ChangePassphrase encrypts the wallet file with a new passphrase, the
+// wallets stay locked or unlocked
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encryption == nil {
+		return ErrWalletNotEncrypted
+	}
+
+	locked := ws.isLocked()
+	if err := ws.unlock(oldPassphrase); err != nil {
+		return err
+	}
+
+	if err := ws.setPassphrase(newPassphrase); err != nil {
+		return err
+	}
+
+	if !locked {
+		return ws.unlock(newPassphrase)
+	}
+
+	return nil
+}
+
+// setPassphrase saves the wallets encrypted with a new passphrase and locks
+// them, the private keys must be available, ws.mu must be held
+func (ws *Wallets) setPassphrase(passphrase string) error {
+	encryption, err := newWalletEncryption()
+	if err != nil {
+		return err
+	}
+
+	cipherKey, err := encryption.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	previous, previousKey := ws.encryption, ws.cipherKey
+	ws.encryption, ws.cipherKey = encryption, cipherKey
+	if err = ws.save(); err != nil {
+		ws.encryption, ws.cipherKey = previous, previousKey
+		return err
+	}
+
+	ws.lock()
+	return nil
+}
+
+// Unlock decrypts the private keys with the passphrase, they are locked
+// again after timeout unless it is zero
+func (ws *Wallets) Unlock(passphrase string, timeout time.Duration) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encryption == nil {
+		return nil
+	}
+
+	if err := ws.unlock(passphrase); err != nil {
+		return err
+	}
+
+	if timeout > 0 {
+		ws.lockTimer = time.AfterFunc(timeout, ws.Lock)
+	}
+
+	return nil
+}
+
+// unlock decrypts the private keys, ws.mu must be held
+func (ws *Wallets) unlock(passphrase string) error {
+	cipherKey, err := ws.encryption.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	data := ws.publicData()
+	privateKeys, err := ws.encryption.open(cipherKey, data)
+	if err != nil {
+		return err
+	}
+
+	var accounts []*HDWallet
+	if data.HD != nil {
+		if accounts, err = data.hdAccounts(privateKeys[len(privateKeys)-1]); err != nil {
+			return err
+		}
+	}
+
+	ws.stopLockTimer()
+	for i, address := range ws.addresses {
+		data.Keys[i].PrivateKey = privateKeys[i]
+		w, err := data.Keys[i].wallet()
+		if err != nil {
+			return err
+		}
+
+		ws.wallets[address] = w
+	}
+
+	if accounts != nil {
+		ws.accounts = accounts
+		ws.addHDKeys()
+	}
+	ws.cipherKey = cipherKey
+
+	return nil
+}
+
+// Lock wipes the private keys of encrypted wallets from memory
+func (ws *Wallets) Lock() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encryption != nil {
+		ws.lock()
+	}
+}
+
+// lock wipes the private keys and the cipher key, ws.mu must be held
+func (ws *Wallets) lock() {
+	ws.stopLockTimer()
+
+	for address, w := range ws.wallets {
+		if w.PrivateKey.D != nil {
+			w.PrivateKey.D.SetInt64(0)
+		}
+		ws.wallets[address] = &Wallet{PublicKey: w.PublicKey}
+	}
+
+	for i, account := range ws.accounts {
+		if account.master != nil {
+			ws.accounts[i] = account.Neuter()
+			account.zero()
+		}
+	}
+
+	for i := range ws.cipherKey {
+		ws.cipherKey[i] = 0
+	}
+	ws.cipherKey = nil
+}
+
+// stopLockTimer cancels the pending automatic lock, ws.mu must be held
+func (ws *Wallets) stopLockTimer() {
+	if ws.lockTimer != nil {
+		ws.lockTimer.Stop()
+		ws.lockTimer = nil
+	}
+}
+
+// publicData returns the wallet data without the private keys: the
+// curves and public keys of the independent keys, the HD accounts and the
+// watch-only addresses and accounts
+func (ws *Wallets) publicData() *walletData {
+	data := &walletData{Keys: make([]walletKey, 0, len(ws.addresses))}
+	for _, address := range ws.addresses {
+		w := ws.wallets[address]
+
+		curve := ""
+		if w.PrivateKey.Curve != nil {
+			curve = w.PrivateKey.Curve.Params().Name
+		} else if key, err := parsePublicKey(publicKeyCurve(w.PublicKey), w.PublicKey); err == nil {
+			curve = key.Curve.Params().Name
+		}
+
+		data.Keys = append(data.Keys, walletKey{Curve: curve, PublicKey: w.PublicKey})
+	}
+
+	for i, account := range ws.accounts {
+		if i == 0 {
+			data.HD = newWalletHD(account, false)
+		} else {
+			data.HDAccounts = append(data.HDAccounts, newWalletHD(account, false))
+		}
+	}
+
+	data.WatchAddresses = ws.watched
+	for _, account := range ws.watchAccounts {
+		data.WatchAccounts = append(data.WatchAccounts, newWalletHD(account, false))
+	}
+	data.AddressBook = ws.book
+
+	for _, tx := range ws.pending {
+		data.PendingTxs = append(data.PendingTxs, tx.Serialize())
+	}
+
+	return data
+}
+
+// Save atomically writes the wallets to the wallet file
+func (ws *Wallets) Save() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.save()
+}
+
+// save writes the wallets to the wallet file, ws.mu must be held
+func (ws *Wallets) save() error {
+	data, err := ws.fileData()
+	if err != nil {
+		return err
+	}
+
+	return writeWalletFile(ws.path, data)
+}
+
+// fileData returns the wallet data of the wallet file, ws.mu must be held.
+// The private keys of encrypted wallets are sealed with the cipher key,
+// locked wallets keep the sealed keys of the file as they can't change
+// meanwhile.
+func (ws *Wallets) fileData() (*walletData, error) {
+	data := ws.publicData()
+	if ws.encryption == nil {
+		for i, address := range ws.addresses {
+			data.Keys[i].PrivateKey = ws.wallets[address].PrivateKey.D.Bytes()
+		}
+
+		if len(ws.accounts) > 0 {
+			data.HD.MasterKey = ws.accounts[0].master.serialize()
+		}
+
+		return data, nil
+	}
+
+	if ws.cipherKey != nil {
+		privateKeys := make([][]byte, 0, len(ws.addresses)+1)
+		for _, address := range ws.addresses {
+			privateKeys = append(privateKeys, ws.wallets[address].PrivateKey.D.Bytes())
+		}
+
+		if len(ws.accounts) > 0 {
+			privateKeys = append(privateKeys, ws.accounts[0].master.serialize())
+		}
+
+		if err := ws.encryption.seal(ws.cipherKey, data, privateKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	data.Encryption = ws.encryption
+	return data, nil
+}
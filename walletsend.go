@@ -0,0 +1,270 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultRebroadcastInterval is how often StartRebroadcast broadcasts the pending transactions again by default
+const DefaultRebroadcastInterval = 10 * time.Minute
+
+// ErrTxNotPending is returned when abandoning a transaction the wallets aren't waiting for
+var ErrTxNotPending = errors.New("transaction isn't pending")
+
+// WithBroadcast sets how the transactions sent by the wallets are
+// broadcast to peers, e.g. Server.BroadcastTransaction, they only go to
+// the local mempool without one
+func WithBroadcast(broadcast func(*Transaction)) WalletsOption {
+	return func(ws *Wallets) {
+		ws.broadcast = broadcast
+	}
+}
+
+// NewTransaction creates a transaction paying every payment from the
+// outputs of the keys of the wallets and signs it, leaving fee to the
+// miner. The change goes to a fresh address so it isn't linked to the
+// addresses spent from: the next change address of the default HD account,
+// or a new independent key without an HD seed, which older backups of the
+// wallet file don't have. Encrypted wallets must be unlocked.
+func (ws *Wallets) NewTransaction(payments []Payment, fee int, utxoSet *UTXOSet) (*Transaction, error) {
+	tx, err := ws.newTransaction(payments, fee, utxoSet)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = utxoSet.Blockchain.SignTransaction(tx, ws); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// newTransaction creates the unsigned transaction of NewTransaction and
+// saves its change address
+func (ws *Wallets) newTransaction(payments []Payment, fee int, utxoSet *UTXOSet) (*Transaction, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.isLocked() {
+		return nil, ErrWalletLocked
+	}
+
+	addresses := ws.keyAddresses()
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrWalletNotFound)
+	}
+
+	changeAddress, forget, err := ws.newChangeAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newSpendRequest(addressPubKeyHashes(addresses), payments, fee, changeAddress, utxoSet)
+	if err != nil {
+		forget()
+		return nil, err
+	}
+
+	if err = ws.save(); err != nil {
+		forget()
+		return nil, err
+	}
+
+	return r.Tx, nil
+}
+
+// newChangeAddress adds a fresh change address to the wallets without
+// saving them, forget removes it again when no transaction pays to it,
+// ws.mu must be held
+func (ws *Wallets) newChangeAddress() (string, func(), error) {
+	if len(ws.accounts) == 0 {
+		address := ws.add(NewWallet())
+		return address, func() {
+			delete(ws.wallets, address)
+			ws.addresses = ws.addresses[:len(ws.addresses)-1]
+		}, nil
+	}
+
+	account := ws.accounts[0]
+	next, derived := account.next[InternalChain], len(account.keys[InternalChain])
+	key, err := account.NewChangeKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	address := string(key.GetAddress())
+	ws.wallets[address] = key
+	return address, func() {
+		delete(ws.wallets, address)
+		account.next[InternalChain] = next
+		account.keys[InternalChain] = account.keys[InternalChain][:derived]
+	}, nil
+}
+
+// Send creates a transaction like NewTransaction, submits it to the mempool
+// and broadcasts it. It is kept in the wallet file as pending and broadcast
+// again by Rebroadcast until it confirms or is abandoned. The wallets must
+// be connected to a chain and a mempool.
+func (ws *Wallets) Send(payments []Payment, fee int) (*Transaction, error) {
+	ws.mu.Lock()
+	bc, mempool := ws.bc, ws.mempool
+	ws.mu.Unlock()
+
+	if bc == nil || mempool == nil {
+		return nil, ErrWalletNotConnected
+	}
+
+	utxoSet := NewUTXOSet(bc)
+	tx, err := ws.NewTransaction(payments, fee, &utxoSet)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = mempool.Add(tx); err != nil {
+		return nil, err
+	}
+
+	ws.mu.Lock()
+	ws.pending = append(ws.pending, tx)
+	if err = ws.save(); err != nil {
+		ws.pending = ws.pending[:len(ws.pending)-1]
+		ws.mu.Unlock()
+		mempool.Remove(tx.ID)
+		return nil, err
+	}
+	broadcast := ws.broadcast
+	ws.mu.Unlock()
+
+	if broadcast != nil {
+		broadcast(tx)
+	}
+
+	return tx, nil
+}
+
+// PendingTransactions returns the transactions sent which aren't confirmed
+// nor abandoned yet, in the order they were sent
+func (ws *Wallets) PendingTransactions() []*Transaction {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return append([]*Transaction{}, ws.pending...)
+}
+
+// Rebroadcast submits the pending transactions to the mempool again, in
+// case it dropped them, and broadcasts them. Those confirmed in the main
+// chain stop being pending.
+func (ws *Wallets) Rebroadcast() error {
+	ws.mu.Lock()
+	bc, mempool, broadcast := ws.bc, ws.mempool, ws.broadcast
+	pending := append([]*Transaction{}, ws.pending...)
+	ws.mu.Unlock()
+
+	if bc == nil || mempool == nil {
+		return ErrWalletNotConnected
+	}
+
+	confirmed := make(map[string]bool)
+	for _, tx := range pending {
+		if _, _, err := bc.GetTxConfirmations(tx.ID); err == nil {
+			confirmed[string(tx.ID)] = true
+			continue
+		}
+
+		if err := mempool.Add(tx); err != nil && !errors.Is(err, ErrTxInMempool) {
+			log.Printf("pending transaction %x is rejected: %s\n", tx.ID, err)
+			continue
+		}
+
+		if broadcast != nil {
+			broadcast(tx)
+		}
+	}
+
+	if len(confirmed) == 0 {
+		return nil
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	previous := ws.pending
+	ws.pending = nil
+	for _, tx := range previous {
+		if !confirmed[string(tx.ID)] {
+			ws.pending = append(ws.pending, tx)
+		}
+	}
+
+	if err := ws.save(); err != nil {
+		ws.pending = previous
+		return err
+	}
+
+	return nil
+}
+
+// StartRebroadcast calls Rebroadcast every interval, DefaultRebroadcastInterval
+// when it isn't positive, until stop is called
+func (ws *Wallets) StartRebroadcast(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultRebroadcastInterval
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if err := ws.Rebroadcast(); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// AbandonTransaction stops waiting for a pending transaction, it isn't
+// broadcast anymore and it and its descendants leave the mempool so the
+// outputs it spends can be spent again. It doesn't stop peers which
+// already have it from mining it.
+func (ws *Wallets) AbandonTransaction(txID []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for i, tx := range ws.pending {
+		if !bytes.Equal(tx.ID, txID) {
+			continue
+		}
+
+		previous := ws.pending
+		ws.pending = append(append([]*Transaction{}, previous[:i]...), previous[i+1:]...)
+		if err := ws.save(); err != nil {
+			ws.pending = previous
+			return err
+		}
+
+		if ws.mempool != nil {
+			ws.mempool.RemoveWithDescendants(txID)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: %x", ErrTxNotPending, txID)
+}
@@ -0,0 +1,335 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTxConflicted is returned when waiting for a transaction which can't be confirmed anymore
+	ErrTxConflicted = errors.New("transaction is conflicted")
+
+	// ErrConfirmationTimeout is returned when a transaction isn't confirmed in time
+	ErrConfirmationTimeout = errors.New("confirmation timed out")
+)
+
+// WalletTxState is the state of a wallet transaction
+type WalletTxState int
+
+const (
+	// WalletTxPending is a transaction not in the main chain
+	WalletTxPending WalletTxState = iota
+
+	// WalletTxConfirmed is a transaction in a block of the main chain
+	WalletTxConfirmed
+
+	// WalletTxConflicted is a transaction which can't be confirmed anymore,
+	// its inputs are spent by another transaction of the main chain
+	WalletTxConflicted
+)
+
+// String returns the name of the state
+func (s WalletTxState) String() string {
+	switch s {
+	case WalletTxPending:
+		return "pending"
+	case WalletTxConfirmed:
+		return "confirmed"
+	case WalletTxConflicted:
+		return "conflicted"
+	default:
+		return "unknown"
+	}
+}
+
+// WalletTx is a transaction tracked by a wallet
+type WalletTx struct {
+	Tx        *Transaction
+	State     WalletTxState
+	BlockHash []byte
+	Height    int
+
+	// ConflictedBy is the id of the transaction spending the same inputs
+	ConflictedBy []byte
+
+	// Labels are the address book entries of the addresses involved, set
+	// by Wallets.ListTransactions
+	Labels []AddressEntry
+}
+
+// WalletTracker follows the state of the transactions paying to or
+// spending from a set of public key hashes across chain reorgs
+type WalletTracker struct {
+	bc           *Blockchain
+	mu           sync.Mutex
+	pubKeyHashes [][]byte
+	txs          map[string]*WalletTx
+
+	// changed is closed and replaced on each chain event
+	changed chan struct{}
+}
+
+// NewWalletTracker creates a WalletTracker of the public key hashes and
+// subscribes it to the chain events of bc
+func NewWalletTracker(bc *Blockchain, pubKeyHashes [][]byte) *WalletTracker {
+	t := &WalletTracker{bc: bc, pubKeyHashes: pubKeyHashes, txs: make(map[string]*WalletTx), changed: make(chan struct{})}
+	bc.Subscribe(t.handleChainEvent)
+
+	return t
+}
+
+// AddPending tracks a transaction sent by the wallet before it is confirmed
+func (t *WalletTracker) AddPending(tx *Transaction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := t.txs[id]; !ok {
+		t.txs[id] = &WalletTx{Tx: tx, State: WalletTxPending}
+	}
+}
+
+// Get returns a copy of a tracked transaction
+func (t *WalletTracker) Get(txID []byte) (WalletTx, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wtx, ok := t.txs[hex.EncodeToString(txID)]
+	if !ok {
+		return WalletTx{}, false
+	}
+
+	return *wtx, true
+}
+
+// Transactions returns copies of the tracked transactions
+func (t *WalletTracker) Transactions() []WalletTx {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	txs := make([]WalletTx, 0, len(t.txs))
+	for _, wtx := range t.txs {
+		txs = append(txs, *wtx)
+	}
+
+	return txs
+}
+
+// Confirmations returns the state of a tracked transaction and its number
+// of confirmations, zero unless it is confirmed
+func (t *WalletTracker) Confirmations(txID []byte) (WalletTxState, int, error) {
+	wtx, ok := t.Get(txID)
+	if !ok {
+		return WalletTxPending, 0, fmt.Errorf("%w: %x isn't tracked", ErrTransactionNotFound, txID)
+	}
+
+	if wtx.State != WalletTxConfirmed {
+		return wtx.State, 0, nil
+	}
+
+	confirmations, _, err := t.bc.GetTxConfirmations(txID)
+	if errors.Is(err, ErrTransactionNotFound) {
+		// disconnected by a reorg whose events aren't delivered yet
+		return WalletTxPending, 0, nil
+	}
+	if err != nil {
+		return wtx.State, 0, err
+	}
+
+	return WalletTxConfirmed, confirmations, nil
+}
+
+// WaitConfirmations waits until a tracked transaction has n confirmations
+// and returns them, it fails when the transaction gets conflicted or the
+// timeout expires
+func (t *WalletTracker) WaitConfirmations(txID []byte, n int, timeout time.Duration) (int, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		t.mu.Lock()
+		changed := t.changed
+		t.mu.Unlock()
+
+		state, confirmations, err := t.Confirmations(txID)
+		if err != nil {
+			return 0, err
+		}
+
+		if state == WalletTxConflicted {
+			return 0, fmt.Errorf("%w: %x", ErrTxConflicted, txID)
+		}
+
+		if confirmations >= n {
+			return confirmations, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return confirmations, fmt.Errorf("%w: %x has %d of %d confirmations", ErrConfirmationTimeout, txID, confirmations, n)
+		}
+	}
+}
+
+// handleChainEvent updates the tracked transactions on a chain event
+func (t *WalletTracker) handleChainEvent(event ChainEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch event.Type {
+	case BlockConnected:
+		t.connectBlock(event.Block)
+	case BlockDisconnected:
+		t.disconnectBlock(event.Block)
+	}
+
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// connectBlock confirms the wallet transactions of a block and marks the
+// pending ones spending the same inputs as conflicted
+func (t *WalletTracker) connectBlock(block *Block) {
+	spent := make(map[string][]byte)
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.VIn {
+			spent[outpointKey(vin.TxID, vin.VOut)] = tx.ID
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		id := hex.EncodeToString(tx.ID)
+		wtx, ok := t.txs[id]
+		if !ok {
+			if !t.isRelevant(tx) {
+				continue
+			}
+
+			wtx = &WalletTx{Tx: tx}
+			t.txs[id] = wtx
+		}
+
+		wtx.State = WalletTxConfirmed
+		wtx.BlockHash = block.Hash
+		wtx.Height = block.Height
+		wtx.ConflictedBy = nil
+	}
+
+	for id, wtx := range t.txs {
+		if wtx.State != WalletTxPending || wtx.Tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range wtx.Tx.VIn {
+			if spender, ok := spent[outpointKey(vin.TxID, vin.VOut)]; ok && !bytes.Equal(spender, wtx.Tx.ID) {
+				t.conflict(id, spender)
+				break
+			}
+		}
+	}
+}
+
+// disconnectBlock moves the wallet transactions of a block removed from the
+// main chain back to pending, a coinbase can't be mined again and becomes
+// conflicted together with its descendants. Transactions conflicted by the
+// block become pending again.
+func (t *WalletTracker) disconnectBlock(block *Block) {
+	inBlock := make(map[string]bool, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		inBlock[hex.EncodeToString(tx.ID)] = true
+	}
+
+	for _, wtx := range t.txs {
+		if wtx.State == WalletTxConflicted && inBlock[hex.EncodeToString(wtx.ConflictedBy)] {
+			wtx.State = WalletTxPending
+			wtx.ConflictedBy = nil
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		id := hex.EncodeToString(tx.ID)
+		wtx, ok := t.txs[id]
+		if !ok || !bytes.Equal(wtx.BlockHash, block.Hash) {
+			continue
+		}
+
+		wtx.State = WalletTxPending
+		wtx.BlockHash = nil
+		wtx.Height = 0
+
+		if tx.IsCoinbase() {
+			t.conflict(id, nil)
+		}
+	}
+}
+
+// conflict marks an unconfirmed transaction and its tracked unconfirmed
+// descendants as conflicted
+func (t *WalletTracker) conflict(id string, spender []byte) {
+	wtx, ok := t.txs[id]
+	if !ok || wtx.State == WalletTxConfirmed || wtx.State == WalletTxConflicted {
+		return
+	}
+
+	wtx.State = WalletTxConflicted
+	wtx.ConflictedBy = spender
+
+	for childID, child := range t.txs {
+		if child.Tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range child.Tx.VIn {
+			if bytes.Equal(vin.TxID, wtx.Tx.ID) {
+				t.conflict(childID, spender)
+				break
+			}
+		}
+	}
+}
+
+// isRelevant returns whether a transaction pays to or spends from the
+// tracked public key hashes
+func (t *WalletTracker) isRelevant(tx *Transaction) bool {
+	for _, out := range tx.VOut {
+		if t.isMine(out.PubKeyHash()) {
+			return true
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return false
+	}
+
+	for _, vin := range tx.VIn {
+		if _, ok := t.txs[hex.EncodeToString(vin.TxID)]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isMine returns whether a public key hash is tracked
+func (t *WalletTracker) isMine(pubKeyHash []byte) bool {
+	if pubKeyHash == nil {
+		return false
+	}
+
+	for _, pkh := range t.pubKeyHashes {
+		if bytes.Equal(pkh, pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
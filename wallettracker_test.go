@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// newTestTracker creates a blockchain and a WalletTracker of the wallet the
+// genesis block pays to
+func newTestTracker(t *testing.T) (*Blockchain, *Wallet, *WalletTracker) {
+	t.Helper()
+
+	bc, wallet := newTestBlockchain(t)
+	tracker := NewWalletTracker(bc, [][]byte{HashPubKey(wallet.PublicKey)})
+
+	return bc, wallet, tracker
+}
+
+// addBranch mines n blocks on top of base, connecting each, and returns the
+// last one
+func addBranch(t *testing.T, bc *Blockchain, base *Block, to string, n int, txs ...*Transaction) *Block {
+	t.Helper()
+
+	tip := base
+	for i := 0; i < n; i++ {
+		tip = mineOn(bc, tip, to, txs...)
+		txs = nil
+		if err := bc.AddBlock(tip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return tip
+}
+
+// wantState fails the test unless a transaction is tracked in a state
+func wantState(t *testing.T, tracker *WalletTracker, txID []byte, state WalletTxState) WalletTx {
+	t.Helper()
+
+	wtx, ok := tracker.Get(txID)
+	if !ok {
+		t.Fatalf("transaction %x isn't tracked", txID)
+	}
+	if wtx.State != state {
+		t.Fatalf("transaction %x is %s, want %s", txID, wtx.State, state)
+	}
+
+	return wtx
+}
+
+func TestWalletTrackerDisconnectedTxIsPending(t *testing.T) {
+	bc, wallet, tracker := newTestTracker(t)
+	genesis := genesisOf(t, bc)
+	other := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	spend := NewUTXOTransaction(wallet, other, 10, 0, &UTXOSet{bc})
+	tracker.AddPending(spend)
+
+	block := addBranch(t, bc, genesis, other, 1, spend)
+	if wtx := wantState(t, tracker, spend.ID, WalletTxConfirmed); !bytes.Equal(wtx.BlockHash, block.Hash) || wtx.Height != 1 {
+		t.Fatalf("confirmed in block %x at %d, want %x at 1", wtx.BlockHash, wtx.Height, block.Hash)
+	}
+
+	addBranch(t, bc, genesis, other, 2)
+
+	if wtx := wantState(t, tracker, spend.ID, WalletTxPending); wtx.BlockHash != nil || wtx.Height != 0 {
+		t.Errorf("pending transaction still in block %x at %d", wtx.BlockHash, wtx.Height)
+	}
+	if state, confirmations, err := tracker.Confirmations(spend.ID); err != nil || state != WalletTxPending || confirmations != 0 {
+		t.Errorf("confirmations %s %d %v, want pending 0", state, confirmations, err)
+	}
+}
+
+func TestWalletTrackerRespentInputIsConflicted(t *testing.T) {
+	bc, wallet, tracker := newTestTracker(t)
+	genesis := genesisOf(t, bc)
+	other := string(NewWalletWithParams(bc.Params()).GetAddress())
+	third := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	spend := NewUTXOTransaction(wallet, other, 4, 0, &UTXOSet{bc})
+	respend := NewUTXOTransaction(wallet, third, 6, 0, &UTXOSet{bc})
+	tracker.AddPending(spend)
+
+	addBranch(t, bc, genesis, other, 1, spend)
+	wantState(t, tracker, spend.ID, WalletTxConfirmed)
+
+	addBranch(t, bc, genesis, other, 2, respend)
+
+	if wtx := wantState(t, tracker, spend.ID, WalletTxConflicted); !bytes.Equal(wtx.ConflictedBy, respend.ID) {
+		t.Errorf("conflicted by %x, want %x", wtx.ConflictedBy, respend.ID)
+	}
+	wantState(t, tracker, respend.ID, WalletTxConfirmed)
+}
+
+func TestWalletTrackerDisconnectedCoinbaseIsConflicted(t *testing.T) {
+	bc, wallet, tracker := newTestTracker(t)
+	genesis := genesisOf(t, bc)
+	other := string(NewWalletWithParams(bc.Params()).GetAddress())
+
+	block := addBranch(t, bc, genesis, string(wallet.GetAddress()), 1)
+	coinbase := block.Transactions[0]
+	wantState(t, tracker, coinbase.ID, WalletTxConfirmed)
+
+	// spends the outputs of the genesis and the new coinbase
+	child := NewUTXOTransaction(wallet, other, bc.Params().Subsidy+1, 0, &UTXOSet{bc})
+	spendsCoinbase := false
+	for _, vin := range child.VIn {
+		spendsCoinbase = spendsCoinbase || bytes.Equal(vin.TxID, coinbase.ID)
+	}
+	if !spendsCoinbase {
+		t.Fatal("the child doesn't spend the coinbase")
+	}
+	addBranch(t, bc, block, other, 1, child)
+	wantState(t, tracker, child.ID, WalletTxConfirmed)
+
+	addBranch(t, bc, genesis, other, 3)
+
+	wantState(t, tracker, coinbase.ID, WalletTxConflicted)
+	wantState(t, tracker, child.ID, WalletTxConflicted)
+	if _, err := tracker.WaitConfirmations(child.ID, 1, 0); !errors.Is(err, ErrTxConflicted) {
+		t.Errorf("error %v, want %v", err, ErrTxConflicted)
+	}
+}
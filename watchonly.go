@@ -0,0 +1,184 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrWatchOnly is returned when using the private key of a watch-only address
+var ErrWatchOnly = errors.New("address is watch-only")
+
+// ImportAddress watches an address without its private key, its payments
+// are tracked and spent with unsigned transactions
+func (ws *Wallets) ImportAddress(address string) error {
+	if _, _, err := decodeAddress(address); err != nil {
+		return err
+	}
+
+	if addressVersion(address) != version {
+		return fmt.Errorf("address %s isn't a pay to public key hash address", address)
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for _, known := range ws.allAddresses() {
+		if known == address {
+			return nil
+		}
+	}
+
+	ws.watched = append(ws.watched, address)
+	if err := ws.save(); err != nil {
+		ws.watched = ws.watched[:len(ws.watched)-1]
+		return err
+	}
+
+	return nil
+}
+
+// ImportExtendedKey watches the account of an extended public key, the
+// first lookahead receive and change addresses are derived to be watched,
+// DefaultGapLimit when lookahead isn't positive. Private extended keys are
+// refused so the wallets never hold them.
+func (ws *Wallets) ImportExtendedKey(xpub string, lookahead int) error {
+	key, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return err
+	}
+
+	if key.IsPrivate() {
+		return fmt.Errorf("%w: a watch-only account needs a public key", ErrInvalidExtendedKey)
+	}
+
+	if lookahead <= 0 {
+		lookahead = DefaultGapLimit
+	}
+
+	account, err := newHDWallet(key, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, chain := range []uint32{ExternalChain, InternalChain} {
+		if err = account.deriveUpTo(chain, uint32(lookahead)); err != nil {
+			return err
+		}
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for _, watched := range ws.watchAccounts {
+		if watched.account.String() == key.String() {
+			return nil
+		}
+	}
+
+	ws.watchAccounts = append(ws.watchAccounts, account)
+	if err = ws.save(); err != nil {
+		ws.watchAccounts = ws.watchAccounts[:len(ws.watchAccounts)-1]
+		return err
+	}
+
+	return nil
+}
+
+// ExportAccountKey returns the extended public key of an HD account, a
+// watch-only wallet imports it to watch the account addresses
+func (ws *Wallets) ExportAccountKey(account int) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if account < 0 || account >= len(ws.accounts) {
+		return "", fmt.Errorf("%w: account %d", ErrNoMasterKey, account)
+	}
+
+	return ws.accounts[account].AccountKey().String(), nil
+}
+
+// IsWatchOnly returns whether an address is watched without its private key
+func (ws *Wallets) IsWatchOnly(address string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.isWatchOnly(address)
+}
+
+// isWatchOnly returns whether an address is watched without its private key, ws.mu must be held
+func (ws *Wallets) isWatchOnly(address string) bool {
+	for _, watched := range ws.watchOnlyAddresses() {
+		if watched == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchOnlyAddresses returns the imported addresses followed by the receive
+// and change addresses of the imported accounts, ws.mu must be held
+func (ws *Wallets) watchOnlyAddresses() []string {
+	addresses := append([]string{}, ws.watched...)
+	for _, account := range ws.watchAccounts {
+		addresses = append(addresses, account.Addresses(ExternalChain)...)
+		addresses = append(addresses, account.Addresses(InternalChain)...)
+	}
+
+	return addresses
+}
+
+// PubKeyHashes returns the public key hashes of every address of the
+// wallets, watch-only ones included, e.g. to follow their payments with a
+// WalletTracker
+func (ws *Wallets) PubKeyHashes() [][]byte {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return addressPubKeyHashes(ws.allAddresses())
+}
+
+// addressPubKeyHashes returns the public key hashes of addresses
+func addressPubKeyHashes(addresses []string) [][]byte {
+	hashes := make([][]byte, 0, len(addresses))
+	for _, address := range addresses {
+		hashes = append(hashes, pubKeyHashFromAddress(address))
+	}
+
+	return hashes
+}
+
+// NewWatchOnlySpend prepares an unsigned transaction paying amount to an
+// address from the outputs of the watch-only addresses. The change goes to
+// the next change address of the first watched account, or to the first
+// watched address. The transaction is signed offline, e.g. with
+// PartiallySignedTx.SignKeystore, and finalized before it is sent.
+func (ws *Wallets) NewWatchOnlySpend(to string, amount, fee int, utxoSet *UTXOSet) (*PartiallySignedTx, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	addresses := ws.watchOnlyAddresses()
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("%w: no watch-only addresses", ErrWalletNotFound)
+	}
+
+	changeAddress := addresses[0]
+	if len(ws.watchAccounts) > 0 {
+		key, err := ws.watchAccounts[0].NewChangeKey()
+		if err != nil {
+			return nil, err
+		}
+		changeAddress = string(key.GetAddress())
+	}
+
+	r, err := newSpendRequest(addressPubKeyHashes(addresses), []Payment{{Address: to, Amount: amount}}, fee, changeAddress, utxoSet)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ws.save(); err != nil {
+		return nil, err
+	}
+
+	return NewPartiallySignedTx(r.Tx, r.PrevTXs, utxoSet.Blockchain.opts.params.KeyCurve)
+}
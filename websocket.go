@@ -0,0 +1,330 @@
+package blockchain
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webSocketGUID is appended to the key of a WebSocket handshake
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// WebSocket frame opcodes
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	// wsMaxControlPayload is the largest payload of a control frame
+	wsMaxControlPayload = 125
+
+	// wsCloseUnsupportedData is the close code of text frames, the node
+	// only speaks binary messages
+	wsCloseUnsupportedData = 1003
+)
+
+// ErrWebSocketProtocol is returned when a WebSocket peer breaks RFC 6455
+var ErrWebSocketProtocol = errors.New("websocket protocol error")
+
+// wsListener accepts the WebSocket connections upgraded by an HTTP server,
+// each binary message carries bytes of the framed peer protocol so browser
+// peers speak the protocol of TCP peers
+type wsListener struct {
+	ln     net.Listener
+	server *http.Server
+	conns  chan net.Conn
+	done   chan struct{}
+	errs   chan error
+	once   sync.Once
+}
+
+// newWSListener serves WebSocket upgrades on a path of a listener
+func newWSListener(ln net.Listener, path string) *wsListener {
+	l := &wsListener{
+		ln:    ln,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+		errs:  make(chan error, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, l.upgrade)
+	l.server = &http.Server{Handler: mux, ReadHeaderTimeout: handshakeTimeout}
+
+	go func() {
+		if err := l.server.Serve(ln); err != http.ErrServerClosed {
+			l.errs <- err
+		}
+	}()
+
+	return l
+}
+
+// Accept waits for the next WebSocket connection
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the HTTP server, the accepted connections stay open
+func (l *wsListener) Close() error {
+	err := net.ErrClosed
+	l.once.Do(func() {
+		close(l.done)
+		err = l.server.Close()
+	})
+
+	return err
+}
+
+// Addr returns the address of the listener
+func (l *wsListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// upgrade completes the WebSocket handshake of a request and hands the
+// connection to Accept
+func (l *wsListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("websocket upgrade of %s failed: %s\n", r.RemoteAddr, err)
+		return
+	}
+
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"
+
+	if err = conn.SetDeadline(time.Time{}); err == nil {
+		_, err = conn.Write([]byte(response))
+	}
+	if err != nil {
+		log.Printf("websocket upgrade of %s failed: %s\n", r.RemoteAddr, err)
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case l.conns <- &wsConn{Conn: conn, r: rw.Reader}:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+// headerHasToken returns whether a comma separated header lists a token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// wsConn is the server side of a WebSocket connection, reads return the
+// payload of the binary messages of the client and writes are sent as
+// binary messages
+type wsConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// remaining, mask and offset are the state of the data frame being read
+	remaining uint64
+	mask      [4]byte
+	offset    int
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+// Read reads the payload of the binary messages, ping frames are answered
+// and a close frame ends the stream
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[c.offset%4]
+		c.offset++
+	}
+	c.remaining -= uint64(n)
+
+	return n, err
+}
+
+// nextFrame reads the header of the next frame, control frames are
+// handled entirely
+func (c *wsConn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return err
+	}
+
+	opcode := head[0] & 0x0f
+	if head[1]&0x80 == 0 {
+		return fmt.Errorf("%w: unmasked client frame", ErrWebSocketProtocol)
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+		return err
+	}
+	c.offset = 0
+
+	switch opcode {
+	case wsOpBinary, wsOpContinuation:
+		c.remaining = length
+		return nil
+	case wsOpText:
+		c.writeClose(wsCloseUnsupportedData)
+		return fmt.Errorf("%w: text frame", ErrWebSocketProtocol)
+	case wsOpClose, wsOpPing, wsOpPong:
+	default:
+		return fmt.Errorf("%w: opcode %#x", ErrWebSocketProtocol, opcode)
+	}
+
+	if length > wsMaxControlPayload {
+		return fmt.Errorf("%w: control frame of %d bytes", ErrWebSocketProtocol, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	for i := range payload {
+		payload[i] ^= c.mask[i%4]
+	}
+
+	switch opcode {
+	case wsOpPing:
+		return c.writeFrame(wsOpPong, payload)
+	case wsOpClose:
+		c.closeOnce.Do(func() {
+			_ = c.writeFrame(wsOpClose, payload)
+		})
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+// Write sends p as a binary message
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// writeFrame sends a final unmasked frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch length := len(payload); {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = append(header, byte(length>>8), byte(length))
+	default:
+		header[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		header = append(header, ext[:]...)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if _, err := c.Conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.Conn.Write(payload)
+
+	return err
+}
+
+// writeClose sends a close frame of a status code, once
+func (c *wsConn) writeClose(code uint16) {
+	c.closeOnce.Do(func() {
+		var payload [2]byte
+		binary.BigEndian.PutUint16(payload[:], code)
+		_ = c.writeFrame(wsOpClose, payload[:])
+	})
+}
+
+// Close sends a normal closure frame and closes the connection
+func (c *wsConn) Close() error {
+	_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeClose(1000)
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,107 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// DefaultNetworkMagic starts the message frames of the main chain
+	DefaultNetworkMagic uint32 = 0xf9beb4d9
+
+	// frameHeaderLength is the length of the magic, the command, the
+	// payload length and the checksum of a frame
+	frameHeaderLength = 4 + commandLength + 4 + addressChecksumLen
+
+	// MaxMessagePayload is the largest payload of a message
+	MaxMessagePayload = 32 << 20
+)
+
+var (
+	// ErrMessageTooLarge is returned when a frame holds a payload above the
+	// limit of its command
+	ErrMessageTooLarge = errors.New("message too large")
+
+	// ErrWrongMagic is returned when a frame starts with the magic of
+	// another network
+	ErrWrongMagic = errors.New("wrong network magic")
+)
+
+// WriteMessage writes a message of the main chain as a frame, see
+// WriteNetworkMessage
+func WriteMessage(w io.Writer, message []byte) error {
+	return WriteNetworkMessage(w, DefaultNetworkMagic, message)
+}
+
+// WriteNetworkMessage writes a message, a command and its payload as
+// returned by EncodeMessage, as a frame. The frame header holds the magic
+// of the network, the command, the payload length and a checksum of the
+// payload.
+func WriteNetworkMessage(w io.Writer, magic uint32, message []byte) error {
+	if len(message) < commandLength {
+		return fmt.Errorf("%w: %d bytes", ErrMalformedMessage, len(message))
+	}
+
+	payload := message[commandLength:]
+	if len(payload) > MaxMessagePayload {
+		return fmt.Errorf("%w: payload of %d bytes", ErrMalformedMessage, len(payload))
+	}
+
+	frame := make([]byte, frameHeaderLength, frameHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(frame, magic)
+	copy(frame[4:], message[:commandLength])
+	binary.BigEndian.PutUint32(frame[4+commandLength:], uint32(len(payload)))
+	copy(frame[4+commandLength+4:], checksum(payload))
+
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// ReadMessage reads a frame written by WriteMessage and returns its message
+func ReadMessage(r io.Reader) ([]byte, error) {
+	return ReadNetworkMessage(r, DefaultNetworkMagic)
+}
+
+// ReadNetworkMessage reads a frame of the network of a magic and returns
+// its message, it fails with ErrWrongMagic on frames of other networks
+func ReadNetworkMessage(r io.Reader, magic uint32) ([]byte, error) {
+	return readMessage(r, magic, func(string) uint32 { return MaxMessagePayload })
+}
+
+// readMessage reads a frame of the network of a magic whose payload is at
+// most maxPayload of its command, a larger payload is refused before it is
+// read
+func readMessage(r io.Reader, magic uint32, maxPayload func(command string) uint32) ([]byte, error) {
+	header := make([]byte, frameHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if got := binary.BigEndian.Uint32(header); got != magic {
+		return nil, fmt.Errorf("%w: %#08x instead of %#08x", ErrWrongMagic, got, magic)
+	}
+
+	command := header[4 : 4+commandLength]
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > MaxMessagePayload {
+		return nil, fmt.Errorf("%w: payload of %d bytes", ErrMalformedMessage, length)
+	}
+	if max := maxPayload(bytesToCommand(command)); length > max {
+		return nil, fmt.Errorf("%w: %s payload of %d bytes, the limit is %d", ErrMessageTooLarge, bytesToCommand(command), length, max)
+	}
+
+	message := make([]byte, commandLength+int(length))
+	copy(message, command)
+	if _, err := io.ReadFull(r, message[commandLength:]); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(checksum(message[commandLength:]), header[4+commandLength+4:]) {
+		return nil, fmt.Errorf("%w: bad checksum of %s", ErrMalformedMessage, bytesToCommand(command))
+	}
+
+	return message, nil
+}